@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+)
+
+// ErrAgentDir is returned when the directory of the agent socket could be
+// reached by other users.
+var ErrAgentDir = errors.New("agent directory is not private")
+
+// agentSocket is where neo agent hands out its key, NEO_AGENT_SOCK
+// overrides it.
+func agentSocket() string {
+	if sock := os.Getenv("NEO_AGENT_SOCK"); sock != "" {
+		return sock
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("neo-%d", os.Getuid()))
+	}
+	return filepath.Join(dir, "neo-agent.sock")
+}
+
+// agentKey returns the key held by a running agent, nil when there is none.
+// A socket others could have put there is not trusted.
+func agentKey() []byte {
+	sock := agentSocket()
+	if checkAgentDir(filepath.Dir(sock)) != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	k, err := io.ReadAll(io.LimitReader(conn, sha256.Size+1))
+	if err != nil || len(k) != sha256.Size {
+		return nil
+	}
+	return k
+}
+
+func runAgent(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	ttl := fs.Duration("ttl", 15*time.Minute, "保留密钥的时长，到期后退出")
+	keyfile := fs.String("keyfile", "", "密钥文件，不指定时从终端读取密码")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if agentKey() != nil {
+		return fmt.Errorf("an agent is already running on %s", agentSocket())
+	}
+	var k []byte
+	var err error
+	if *keyfile != "" {
+		k, err = loadKey("", *keyfile)
+	} else {
+		k, err = askKey(false, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	sock := agentSocket()
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		return err
+	}
+	// a directory made by someone else first would let them at the socket
+	// before it is chmodded
+	if err := checkAgentDir(filepath.Dir(sock)); err != nil {
+		return err
+	}
+	os.Remove(sock)
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(sock, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		select {
+		case <-sig:
+		case <-time.After(*ttl):
+		}
+		l.Close()
+	}()
+	log.Printf("密钥代理已启动：%s，%s 后退出，-ask-password 将直接使用此密钥", sock, *ttl)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("密钥代理已退出")
+			return nil
+		}
+		conn.Write(k)
+		conn.Close()
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkAgentDir makes sure no one else can reach a socket in dir: it must be
+// a real directory of the current user that only the user can enter.
+func checkAgentDir(dir string) error {
+	fInfo, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fInfo.Sys().(*syscall.Stat_t)
+	switch {
+	case !fInfo.IsDir():
+		return fmt.Errorf("%w: %s is not a directory", ErrAgentDir, dir)
+	case !ok || int(st.Uid) != os.Getuid():
+		return fmt.Errorf("%w: %s is owned by another user", ErrAgentDir, dir)
+	case fInfo.Mode().Perm() != 0700:
+		return fmt.Errorf("%w: %s has mode %v", ErrAgentDir, dir, fInfo.Mode().Perm())
+	}
+	return nil
+}
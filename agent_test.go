@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAgentKey(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "agent")
+	os.Mkdir(dir, 0700)
+	sock := filepath.Join(dir, "agent.sock")
+	os.Setenv("NEO_AGENT_SOCK", sock)
+	defer os.Unsetenv("NEO_AGENT_SOCK")
+	if agentKey() != nil {
+		t.Fatal("key without an agent")
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	want, _ := loadKey("secret", "")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(want)
+			conn.Close()
+		}
+	}()
+	if k := agentKey(); !bytes.Equal(k, want) {
+		t.Fatalf("agent key = %x, want %x", k, want)
+	}
+}
+
+func TestCheckAgentDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("any directory is accepted")
+	}
+	dir := t.TempDir()
+	private := filepath.Join(dir, "private")
+	os.Mkdir(private, 0700)
+	if err := checkAgentDir(private); err != nil {
+		t.Fatal(err)
+	}
+	shared := filepath.Join(dir, "shared")
+	os.Mkdir(shared, 0700)
+	os.Chmod(shared, 0777)
+	link := filepath.Join(dir, "link")
+	os.Symlink(private, link)
+	for _, d := range []string{shared, link} {
+		if err := checkAgentDir(d); !errors.Is(err, ErrAgentDir) {
+			t.Errorf("%s: except ErrAgentDir, but %v", d, err)
+		}
+	}
+
+	// an agent others could have started is not asked
+	sock := filepath.Join(shared, "agent.sock")
+	os.Setenv("NEO_AGENT_SOCK", sock)
+	defer os.Unsetenv("NEO_AGENT_SOCK")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Write(make([]byte, 32))
+			conn.Close()
+		}
+	}()
+	if agentKey() != nil {
+		t.Fatal("key from a socket in a shared directory")
+	}
+}
@@ -0,0 +1,7 @@
+package main
+
+// checkAgentDir accepts any directory, the temporary directory is per user
+// on Windows.
+func checkAgentDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "errors"
+
+var ErrAssociateNotSupported = errors.New("file association is only supported on Windows")
+
+func runAssociate(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	return ErrAssociateNotSupported
+}
+
+func runUnassociate(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	return ErrAssociateNotSupported
+}
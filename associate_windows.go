@@ -0,0 +1,63 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// assocProgID is the class .neo is associated with.
+const assocProgID = "NEO.File"
+
+// runAssociate makes Explorer decode .neo files with this executable on a
+// double click.
+func runAssociate(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	conflict := fs.String("conflict", ConflictRename, "双击还原时文件名已被占用：rename 加序号另存，overwrite 覆盖，skip 跳过")
+	icon := fs.String("icon", "", ".neo 文件的图标，.ico 文件或含图标的程序，默认为本程序")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	switch *conflict {
+	case ConflictOverwrite, ConflictRename, ConflictSkip:
+	default:
+		return cmd.usageError(fs, "unknown conflict policy: %s", *conflict)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if *icon == "" {
+		*icon = exe + ",0"
+	}
+	for _, e := range []struct{ key, value string }{
+		{`.neo`, assocProgID},
+		{assocProgID, "NEO 文件"},
+		{assocProgID + `\DefaultIcon`, *icon},
+		{assocProgID + `\shell\open\command`, fmt.Sprintf(`"%s" decode -conflict %s "%%1"`, exe, *conflict)},
+	} {
+		if err := regAdd(e.key, "", e.value); err != nil {
+			return err
+		}
+	}
+	log.Printf("已将 .neo 文件关联到 %s", exe)
+	return nil
+}
+
+func runUnassociate(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	for _, key := range []string{`.neo`, assocProgID} {
+		out, err := exec.Command("reg", "delete", shellKeyPrefix+key, "/f").CombinedOutput()
+		if err != nil {
+			log.Printf("删除注册表项：%s 失败，错误：%v %s", key, err, out)
+		}
+	}
+	log.Printf("已取消 .neo 文件的关联")
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const auditDBName = ".neo-audit.json"
+
+var ErrAuditFailed = errors.New("audit found problems")
+
+type auditEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+type auditDB struct {
+	Created time.Time              `json:"created"`
+	Files   map[string]*auditEntry `json:"files"`
+}
+
+func runAudit(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	db := fs.String("db", "", "数据库路径，默认为目录下的 "+auditDBName)
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if len(args) == 0 || (args[0] != "init" && args[0] != "check") {
+		if err := cmd.parse(fs, args); err != nil {
+			return err
+		}
+		return cmd.usageError(fs, "audit needs init or check")
+	}
+	sub := args[0]
+	if err := cmd.parse(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return cmd.usageError(fs, "audit needs exactly one directory")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	dir := fs.Arg(0)
+	if *db == "" {
+		*db = filepath.Join(dir, auditDBName)
+	}
+	if sub == "init" {
+		return auditInit(dir, *db)
+	}
+	_, _, err = auditCheck(dir, *db)
+	return err
+}
+
+// scanAudit records every NEO file under dir.
+func scanAudit(dir string) (map[string]*auditEntry, error) {
+	files := make(map[string]*auditEntry)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || d.Name() == auditDBName {
+			return nil
+		}
+		if ok, err := IsNeoFile(LocalStorage(filepath.Dir(path)), d.Name()); err != nil || !ok {
+			return err
+		}
+		e, err := auditFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = e
+		return nil
+	})
+	return files, err
+}
+
+func auditFile(path string) (*auditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return &auditEntry{
+		Size:    fInfo.Size(),
+		ModTime: fInfo.ModTime(),
+		SHA256:  hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func auditInit(dir, dbPath string) error {
+	files, err := scanAudit(dir)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(&auditDB{Created: time.Now(), Files: files}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dbPath, b, 0644); err != nil {
+		return err
+	}
+	log.Printf("已记录 %d 个文件至：%s", len(files), dbPath)
+	return nil
+}
+
+// auditCheck compares dir with the database at dbPath and returns how many
+// recorded files were checked and how many of them have problems.
+func auditCheck(dir, dbPath string) (checked, problems int, err error) {
+	b, err := os.ReadFile(dbPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	db := new(auditDB)
+	if err := json.Unmarshal(b, db); err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", dbPath, err)
+	}
+	files, err := scanAudit(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	names := make([]string, 0, len(db.Files))
+	for name := range db.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		want, got := db.Files[name], files[name]
+		switch {
+		case got == nil:
+			log.Printf("文件丢失：%s", name)
+			problems++
+		case got.SHA256 == want.SHA256:
+		case got.Size == want.Size && got.ModTime.Equal(want.ModTime):
+			log.Printf("文件损毁：%s，大小与修改时间未变但内容已改变", name)
+			problems++
+		default:
+			log.Printf("文件被修改：%s，修改时间：%s", name, got.ModTime.Format(time.RFC3339))
+			problems++
+		}
+	}
+	for name := range files {
+		if db.Files[name] == nil {
+			log.Printf("未记录的文件：%s", name)
+		}
+	}
+	log.Printf("完成：检查 %d 个文件，发现 %d 个问题", len(names), problems)
+	if problems > 0 {
+		return len(names), problems, ErrAuditFailed
+	}
+	return len(names), 0, nil
+}
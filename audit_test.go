@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := EncodeFile(LocalStorage(dir), "data.bin", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := filepath.Join(dir, auditDBName)
+	if err := auditInit(dir, db); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := auditCheck(dir, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit but keep size and mtime
+	fInfo, _ := os.Stat(res.Output)
+	b, _ := os.ReadFile(res.Output)
+	b[len(b)-1] ^= 1
+	os.WriteFile(res.Output, b, 0644)
+	os.Chtimes(res.Output, time.Now(), fInfo.ModTime())
+	if _, problems, err := auditCheck(dir, db); !errors.Is(err, ErrAuditFailed) || problems != 1 {
+		t.Fatalf("except ErrAuditFailed, but %v", err)
+	}
+}
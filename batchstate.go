@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+)
+
+var ErrStateMismatch = errors.New("state file belongs to another run")
+
+// batchState is the -state file of encode and decode: a first line with
+// the run and its files, then a line for every file finished. Running the
+// same command with it again goes on with the files not done yet, without
+// going over the directories again.
+type batchState struct {
+	path string
+	head batchHead
+	done map[string]string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+type batchHead struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Files   []string `json:"files"`
+}
+
+type batchLine struct {
+	Input  string `json:"input"`
+	Status string `json:"status"`
+}
+
+// openBatchState reads the state file at path left by the run of command
+// on args, head.Files is nil when there is none.
+func openBatchState(path, command string, args []string) (*batchState, error) {
+	st := &batchState{path: path, head: batchHead{Command: command, Args: args}, done: make(map[string]string)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	if !sc.Scan() {
+		// a crash before the first line was written
+		return st, sc.Err()
+	}
+	var head batchHead
+	if err := json.Unmarshal(sc.Bytes(), &head); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if head.Command != command || !reflect.DeepEqual(head.Args, args) {
+		return nil, fmt.Errorf("%w: %s %v", ErrStateMismatch, head.Command, head.Args)
+	}
+	st.head = head
+	for sc.Scan() {
+		var line batchLine
+		if json.Unmarshal(sc.Bytes(), &line) != nil {
+			// the last line may be cut short by a crash
+			continue
+		}
+		st.done[line.Input] = line.Status
+	}
+	return st, sc.Err()
+}
+
+// begin starts the state file anew for files, or opens it to add to when
+// the run is resumed.
+func (st *batchState) begin(files []string) error {
+	var err error
+	if st.head.Files != nil {
+		st.f, err = os.OpenFile(st.path, os.O_WRONLY|os.O_APPEND, 0644)
+		return err
+	}
+	st.head.Files = append([]string{}, files...)
+	b, err := json.Marshal(st.head)
+	if err != nil {
+		return err
+	}
+	if st.f, err = os.Create(st.path); err != nil {
+		return err
+	}
+	return st.write(b)
+}
+
+// pending returns the files of the run that did not finish, failed ones
+// included.
+func (st *batchState) pending() []string {
+	var files []string
+	for _, file := range st.head.Files {
+		src, name := splitSource(file)
+		if s := st.done[displayPath(src, name)]; s == "" || s == "failed" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+func (st *batchState) write(b []byte) error {
+	if _, err := st.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	// what was not synced is done again after a reboot
+	return st.f.Sync()
+}
+
+// record adds the outcome of a file.
+func (st *batchState) record(res Result, err error) {
+	status := "ok"
+	switch {
+	case errors.Is(err, ErrExcluded):
+		status = "excluded"
+	case errors.Is(err, ErrOutputExists):
+		status = "skipped"
+	case err != nil:
+		status = "failed"
+	}
+	b, _ := json.Marshal(batchLine{Input: res.Input, Status: status})
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err := st.write(b); err != nil {
+		log.Printf("写入状态文件：%s 失败，错误：%v", st.path, err)
+	}
+}
+
+// close removes the state file after a run without failures, the next run
+// then starts over.
+func (st *batchState) close(failed bool) {
+	st.f.Close()
+	if !failed {
+		os.Remove(st.path)
+	}
+}
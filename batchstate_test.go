@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchState(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state")
+	var files []string
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, []byte(name), 0644)
+		files = append(files, file)
+	}
+
+	st, err := openBatchState(stateFile, "encode", []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.begin(files); err != nil {
+		t.Fatal(err)
+	}
+	st.record(Result{Input: files[0]}, nil)
+	st.record(Result{Input: files[1]}, errors.New("boom"))
+	// a crash leaves c.txt unfinished
+	st.f.Close()
+
+	if _, err := openBatchState(stateFile, "decode", []string{dir}); !errors.Is(err, ErrStateMismatch) {
+		t.Fatalf("except ErrStateMismatch, but %v", err)
+	}
+	st, err = openBatchState(stateFile, "encode", []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := st.pending()
+	if len(pending) != 2 || pending[0] != files[1] || pending[1] != files[2] {
+		t.Fatalf("unexpected pending files %v", pending)
+	}
+	if err := st.begin(pending); err != nil {
+		t.Fatal(err)
+	}
+	st.record(Result{Input: files[1]}, nil)
+	st.close(true)
+	if st, _ = openBatchState(stateFile, "encode", []string{dir}); len(st.pending()) != 1 {
+		t.Fatalf("unexpected pending files %v", st.pending())
+	}
+	st.begin(nil)
+	st.close(false)
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Fatal("state file kept after a run without failures")
+	}
+}
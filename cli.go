@@ -0,0 +1,771 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// parseFile encodes or decodes filename, action forces the direction, when
+// empty it is detected from the file content.
+func parseFile(filename string, action Action) (Result, error) {
+	return parseFileContext(context.Background(), filename, action)
+}
+
+// parseFileContext is parseFile giving up on the file once ctx is done.
+func parseFileContext(ctx context.Context, filename string, action Action) (Result, error) {
+	src, name := splitSource(filename)
+	dst := outStorage
+	if dst == nil {
+		if isRemote(filename) {
+			dst = LocalStorage(".")
+		} else {
+			dst = src
+		}
+	}
+	if dir, ok := src.(LocalStorage); ok && fileInUse(filepath.Join(string(dir), name)) {
+		return Result{Action: action, Input: filename}, &OpError{Op: "open", Path: filename, Err: ErrFileInUse}
+	}
+	isNeoFile, err := IsNeoFile(src, name)
+	if err != nil {
+		return Result{Input: filename}, &OpError{Op: "detect", Path: filename, Err: err}
+	}
+	opts := defaultOptions()
+	opts.ctx = ctx
+	if _, ok := src.(LocalStorage); ok {
+		pol, err := policyFor(filepath.Dir(filename))
+		if err != nil {
+			return Result{Action: action, Input: filename}, &OpError{Op: "policy", Path: filename, Err: err}
+		}
+		encoding := action == ActionEncode || action != ActionDecode && !isNeoFile
+		if encoding && !pol.allows(filename) {
+			return Result{Action: ActionEncode, Input: filename}, fmt.Errorf("%w by %s", ErrExcluded, policyFile)
+		}
+		opts.encodeSettings = pol.over(opts.encodeSettings)
+	}
+	if plugin != "" {
+		res := Result{Action: ActionEncode, Input: filename, OriginalName: name}
+		if action == ActionDecode || action != ActionEncode && isNeoFile {
+			res.Action, res.OriginalName = ActionDecode, ""
+		}
+		v, err := askPlugin(res, pluginQuery(&res))
+		if err != nil {
+			return res, &OpError{Op: "plugin", Path: filename, Err: err}
+		}
+		if v.skip {
+			return res, fmt.Errorf("%w by plugin: %s", ErrExcluded, v.reason)
+		}
+		opts.name = v.name
+	}
+	var res Result
+	switch {
+	case action == ActionEncode:
+		res, err = encodeFile(opts, src, name, dst)
+	case action == ActionDecode && !isNeoFile && key != nil:
+		return Result{Action: ActionDecode, Input: filename}, &OpError{Op: "detect", Path: filename, Err: ErrKeyMismatch}
+	case action == ActionDecode && !isNeoFile:
+		return Result{Action: ActionDecode, Input: filename}, &OpError{Op: "detect", Path: filename, Err: codec.ErrNotNEOHeader}
+	case isNeoFile:
+		res, err = decodeFileWith(opts, src, name, dst)
+		quarantineFailed(filename, &res, err)
+	default:
+		res, err = encodeFile(opts, src, name, dst)
+	}
+	if err == nil && ctx.Err() != nil {
+		// finished after processFile gave up on it, it was reported as failed
+		err = ctx.Err()
+	}
+	if err == nil && removeSource && res.Output != res.Input {
+		if err := removeInput(src, name); err != nil {
+			log.Printf("删除原文件：%s 失败，错误：%v", res.Input, err)
+		}
+	}
+	return res, err
+}
+
+// encodeFile encodes into the spool directory when there is one and leaves
+// the rest to the spooler.
+func encodeFile(opts *fileOptions, src Storage, name string, dst Storage) (Result, error) {
+	if spool == nil {
+		return encodeFileWith(opts, src, name, dst)
+	}
+	res, err := encodeFileWith(opts, src, name, spool.dir)
+	if err != nil {
+		return res, err
+	}
+	spool.send(spoolMove{res: res, name: filepath.Base(res.Output), src: src, srcName: name, dst: dst})
+	return res, errSpooled
+}
+
+// caseInsensitive is set where file systems usually ignore case.
+var caseInsensitive = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// warnCollisions reads the headers of the local NEO files among files ahead
+// of decoding and logs those that will decode to the same file, -conflict
+// then decides what happens to all but the first.
+func warnCollisions(files []string) {
+	claimed := make(map[string]string)
+	for _, file := range files {
+		if isRemote(file) {
+			continue
+		}
+		hdr, err := headers.header(file)
+		if err != nil || hdr == nil {
+			continue
+		}
+		var out string
+		switch dst := outStorage.(type) {
+		case nil:
+			out = filepath.Join(filepath.Dir(file), hdr.OriginalFilename)
+		case LocalStorage:
+			out = dst.path(hdr.OriginalFilename)
+		default:
+			out = displayPath(dst, hdr.OriginalFilename)
+		}
+		key := out
+		if caseInsensitive {
+			key = strings.ToLower(out)
+		}
+		if first, ok := claimed[key]; ok {
+			log.Printf("文件：%s 与 %s 都将还原为 %s，按 -conflict %s 处理", file, first, out, decodeConflict)
+			continue
+		}
+		claimed[key] = file
+	}
+}
+
+// removeSource deletes the input of every file encoded or decoded
+// successfully, with useTrash it goes to the trash of the system instead,
+// with shredPasses it is overwritten first.
+var removeSource, useTrash bool
+
+func removeInput(src Storage, name string) error {
+	if !useTrash && shredPasses == 0 {
+		return src.Delete(name)
+	}
+	local, ok := src.(LocalStorage)
+	if !ok {
+		return fmt.Errorf("%s: only local files can be shredded or go to the trash", displayPath(src, name))
+	}
+	if shredPasses > 0 {
+		return shredFile(local.path(name), shredPasses)
+	}
+	return moveToTrash(local.path(name))
+}
+
+var (
+	ErrFileTimeout = errors.New("file timed out")
+	ErrFileInUse   = errors.New("file is in use by another process")
+	ErrPanic       = errors.New("panic")
+)
+
+// fileTimeout bounds how long processFile waits for one file, 0 waits forever.
+var fileTimeout time.Duration
+
+// processFile runs parseFile so that a panic or, with fileTimeout, a file
+// stuck on a dead mount only fails that file. A timed out file is cancelled,
+// it stops at its next read, a read blocked on the mount keeps it running in
+// the background until it returns.
+func processFile(filename string, action Action) (Result, error) {
+	type result struct {
+		res Result
+		err error
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- result{Result{Action: action, Input: filename}, fmt.Errorf("%s: %w: %v", filename, ErrPanic, r)}
+			}
+		}()
+		res, err := parseFileContext(ctx, filename, action)
+		done <- result{res, err}
+	}()
+	if fileTimeout <= 0 {
+		r := <-done
+		return r.res, r.err
+	}
+	timer := time.NewTimer(fileTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-timer.C:
+		return Result{Action: action, Input: filename}, &OpError{Op: "timeout", Path: filename, Err: ErrFileTimeout}
+	}
+}
+
+type summary struct {
+	encoded, decoded, failed int
+	verified, skipped        int
+	excluded                 int
+	bytes                    int64
+	json                     *json.Encoder
+	// busy adds up the time spent on files, rate is set for runs that
+	// report their throughput
+	busy time.Duration
+	rate *rateSampler
+	sums *sumsFile
+	// state is the -state file of the run
+	state *batchState
+	// failFast stops the run at the first failure, left is how many files
+	// it left unprocessed
+	failFast bool
+	left     int
+	// mu is held by add, which the spooler calls from its own goroutine
+	mu sync.Mutex
+}
+
+var (
+	// ErrFailFast is returned by runs -fail-fast stopped.
+	ErrFailFast = errors.New("stopped at the first failure")
+	// ErrFilesFailed is returned by batches in which some files failed, so
+	// scripts see it in the exit code.
+	ErrFilesFailed = errors.New("files failed")
+)
+
+// err is what a batch returns once s is reported.
+func (s *summary) err() error {
+	switch {
+	case s.stopped():
+		return ErrFailFast
+	case s.failed > 0:
+		return fmt.Errorf("%w: %d", ErrFilesFailed, s.failed)
+	}
+	return nil
+}
+
+// stopped reports whether -fail-fast ends the run.
+func (s *summary) stopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failFast && s.failed > 0
+}
+
+func (s *summary) add(res Result, err error) {
+	if err == errSpooled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != nil {
+		s.state.record(res, err)
+	}
+	if errors.Is(err, ErrExcluded) {
+		s.excluded++
+		return
+	}
+	metrics.record(res, err)
+	for _, w := range res.Warnings {
+		log.Printf("文件：%s 头部异常：%s", res.Input, w)
+	}
+	if errors.Is(err, ErrOutputExists) {
+		s.skipped++
+		res.Error = err.Error()
+		logError(err)
+	} else if err != nil {
+		s.failed++
+		res.Error = err.Error()
+		logError(err)
+	} else if res.Action == ActionEncode {
+		s.encoded++
+		if s.sums != nil {
+			if err := s.sums.add(res); err != nil {
+				log.Printf("写入校验和文件：%s 失败，错误：%v", s.sums.path, err)
+			}
+		}
+	} else if res.Action == ActionVerify {
+		s.verified++
+	} else {
+		s.decoded++
+	}
+	s.bytes += res.Bytes
+	s.busy += res.Duration
+	if s.json != nil {
+		s.json.Encode(res)
+	}
+	hooks.run(res, err)
+}
+
+func (s *summary) report() {
+	if s.verified > 0 {
+		log.Printf("完成：校验 %d 个，失败 %d 个，共处理 %d 字节", s.verified, s.failed, s.bytes)
+	} else {
+		log.Printf("完成：编码 %d 个，解码 %d 个，失败 %d 个，共处理 %d 字节", s.encoded, s.decoded, s.failed, s.bytes)
+	}
+	if s.skipped > 0 {
+		log.Printf("因还原结果已存在跳过 %d 个", s.skipped)
+	}
+	if s.excluded > 0 {
+		log.Printf("按 %s 或 -plugin 排除 %d 个", policyFile, s.excluded)
+	}
+	if s.left > 0 {
+		log.Printf("因 -fail-fast 在首个错误后停止，%d 个未处理", s.left)
+	}
+	if s.rate == nil {
+		return
+	}
+	elapsed, peak := s.rate.stop()
+	if elapsed <= 0 {
+		return
+	}
+	avg := float64(s.bytes) / elapsed.Seconds()
+	if peak < avg {
+		// runs shorter than a sample
+		peak = avg
+	}
+	log.Printf("耗时 %s，平均 %.1f MB/s，峰值 %.1f MB/s，处理文件占用 %.0f%% 的时间",
+		elapsed.Round(time.Millisecond), avg/(1<<20), peak/(1<<20), 100*s.busy.Seconds()/elapsed.Seconds())
+}
+
+// rateSampler reads metrics.read at a fixed interval to find the peak
+// throughput of a run.
+type rateSampler struct {
+	start time.Time
+	done  chan struct{}
+	peak  chan float64
+}
+
+const rateInterval = time.Second
+
+func startRateSampler() *rateSampler {
+	r := &rateSampler{start: time.Now(), done: make(chan struct{}), peak: make(chan float64)}
+	go func() {
+		ticker := time.NewTicker(rateInterval)
+		defer ticker.Stop()
+		last, peak := atomic.LoadInt64(&metrics.read), 0.0
+		for {
+			select {
+			case <-ticker.C:
+				cur := atomic.LoadInt64(&metrics.read)
+				if rate := float64(cur-last) / rateInterval.Seconds(); rate > peak {
+					peak = rate
+				}
+				last = cur
+			case <-r.done:
+				r.peak <- peak
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// stop returns the time since the start and the highest rate seen in bytes
+// per second.
+func (r *rateSampler) stop() (time.Duration, float64) {
+	elapsed := time.Since(r.start)
+	close(r.done)
+	return elapsed, <-r.peak
+}
+
+// collectFiles expands the command line arguments into the list of files to
+// process, directories contribute their regular files.
+func collectFiles(args []string, recursive bool, sum *summary) []string {
+	var files []string
+	for _, item := range args {
+		if isRemote(item) {
+			files = append(files, item)
+			continue
+		}
+		fInfo, err := os.Stat(item)
+		switch {
+		case err == nil:
+		case os.IsNotExist(err):
+			log.Printf("文件：%s 不存在", item)
+			sum.failed++
+			continue
+		default:
+			log.Printf("获取文件：%s 信息失败，错误：%v", item, err)
+			sum.failed++
+			continue
+		}
+		switch {
+		case fInfo.Mode().IsRegular():
+			files = append(files, item)
+		case fInfo.IsDir():
+			files = append(files, collectDir(item, recursive, sum)...)
+		default:
+			log.Printf("%s 不是一个普通文件，跳过", item)
+		}
+	}
+	return files
+}
+
+func collectDir(dir string, recursive bool, sum *summary) []string {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("读取目录：%s 失败，错误：%v", path, err)
+			sum.failed++
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && d.Name() != policyFile && d.Name() != indexName {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("读取目录：%s 失败，错误：%v", dir, err)
+		sum.failed++
+	}
+	return files
+}
+
+var opErrorFormats = map[string]string{
+	"detect":   "判断文件：%s 类型失败，错误：%v",
+	"checksum": "无法计算文件：%s 校验值，错误：%v",
+	"header":   "读取文件：%s 头部失败，错误：%v",
+	"read":     "读取文件：%s 失败，错误：%v",
+	"timeout":  "处理文件：%s 超时，已跳过，错误：%v",
+	"open":     "无法打开文件：%s，错误：%v",
+	"create":   "无法在目录：%s 中创建文件，请检查写入权限，错误：%v",
+	"write":    "写入文件：%s，错误：%v",
+	"rename":   "重命名文件 %s 失败，错误：%v",
+	"exists":   "文件：%s 已存在，跳过还原，错误：%v",
+	"policy":   "读取文件：%s 所在目录的 .neorc 失败，错误：%v",
+	"plugin":   "插件处理文件：%s 失败，错误：%v",
+}
+
+// promptKey asks for the password of a run of command. Encoding asks for it
+// twice, decoding asks again while it matches none of the stealth files among
+// files, with no files to check it against any password is taken. The key
+// of a running agent is used without asking when it fits.
+func promptKey(command string, files []string) ([]byte, error) {
+	var match func([]byte) bool
+	if command == "decode" || command != "encode" && !encodeStealth {
+		match = matchesAny(stealthHeaders(files))
+	}
+	if k := agentKey(); k != nil && (match == nil || match(k)) {
+		return k, nil
+	}
+	if match != nil {
+		return askKey(false, match)
+	}
+	return askKey(true, nil)
+}
+
+func logError(err error) {
+	var (
+		opErr  *OpError
+		crcErr *CRCError
+		dgErr  *DigestError
+		idErr  *codec.KeyIDError
+	)
+	switch {
+	case errors.As(err, &crcErr):
+		log.Printf("文件：%s CRC校验失败 %d != %d, 文件损毁", crcErr.Path, crcErr.Expected, crcErr.Actual)
+	case errors.As(err, &dgErr):
+		log.Printf("文件：%s %s校验失败 %x != %x, 文件损毁", dgErr.Path, dgErr.Alg, dgErr.Expected, dgErr.Actual)
+	case errors.As(err, &idErr) && errors.As(err, &opErr):
+		log.Printf("文件：%s 需要指纹为 %s 的密码或密钥文件，可用 neo key show 查看密钥文件的指纹", opErr.Path, keyIDList(idErr.KeyIDs))
+	case errors.As(err, &opErr) && opErrorFormats[opErr.Op] != "":
+		log.Printf(opErrorFormats[opErr.Op], opErr.Path, opErr.Err)
+	default:
+		log.Printf("错误：%v", err)
+	}
+}
+
+var outStorage Storage
+
+func runProcess(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	out := fs.String("out", "", "输出位置，支持本地目录、s3://、sftp://、webdav(s)://；s3:// 可附加 ?sse=AES256 或 ?sse=aws:kms&kms-key-id=ID 及 storage-class=CLASS")
+	jsonOut := fs.Bool("json", false, "以 JSON 格式向标准输出打印每个文件的处理结果")
+	recursive := fs.Bool("r", false, "递归处理目录")
+	tarMode := fs.Bool("tar", false, "encode 时读取 tar 流（- 为标准输入）并为其中每个文件生成编码结果，decode 时将还原结果以 tar 流输出至标准输出")
+	fs.BoolVar(&encodeEncryptMeta, "encrypt-meta", false, "编码时同时加密 CRC32 等元数据，旧版本将无法校验这些文件")
+	fs.BoolVar(&encodeStealth, "stealth", false, "编码时使用由密码或密钥文件加盐慢速派生的文件头标识，需要同样的密码才能识别")
+	fs.BoolVar(&encodeKeyed, "keyed", false, "以密码或密钥文件为主密钥，为每个编码结果派生各自的密钥，解码需要同样的密码，可用 rekey 更换")
+	password := fs.String("password", "", "密码，用于 -stealth、-keyed 编码及识别、还原此类文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	askPassword := fs.Bool("ask-password", false, "从终端读取密码且不回显，编码时需输入两次，解码时密码与所有文件都不匹配可重试 3 次")
+	exts := fs.String("ext", ".neo", "编码结果的扩展名，以逗号分隔时随机选取，如 .dat,.bin,.tmp,.bak")
+	fs.StringVar(&nameScheme, "scheme", "random", "编码结果的命名方式：random 随机，hash 取结果内容的 SHA-256")
+	hashes := fs.String("hash", "crc32", "编码时写入的校验值，以逗号分隔：crc32、sha256、xxh64，crc32 总会写入")
+	hdrLen := fs.String("header-len", "8", "编码时移入文件头并加密的开头字节数，可用 K、M、G 后缀，超过 1M 时分块存放")
+	fs.BoolVar(&encodeMedia, "media", false, "编码时记录图片、视频的分辨率、时长与拍摄时间（JPEG、PNG、GIF、MP4/MOV、MKV/WebM），ls -details 可显示")
+	fs.BoolVar(&encodeHint, "hint", false, "编码时另存原文件名的简短提示（前两个字符与扩展名），ls -hint 只显示它")
+	fs.BoolVar(&encodeNoChecksum, "no-checksum", false, "编码时不计算校验值以节省一次读取，文件头将注明没有校验值")
+	fs.BoolVar(&noVerify, "no-verify", false, "解码时不校验内容")
+	limitRate := fs.String("limit-rate", "", "上传至 -out 网络存储的速度上限，每秒字节数，可用 K、M 后缀")
+	partSize := fs.String("part-size", "64M", "上传至 S3 时超过此大小的文件分段上传，每段失败时单独重试，最小 5M")
+	bufSize := fs.String("buffer-size", "", "复制文件内容的缓冲区大小，可用 K、M 后缀，默认本地文件 1M、网络存储 64K")
+	fs.BoolVar(&strictParse, "strict", false, "解码时拒绝任何结构异常的文件头，默认仅给出警告并尽量读取")
+	fs.StringVar(&quarantineDir, "quarantine", "", "将校验或解析失败的 .neo 文件连同报告移至此目录")
+	fs.DurationVar(&fileTimeout, "timeout", 0, "单个文件的处理时限，超时的文件将被跳过，0 为不限制")
+	fs.BoolVar(&preserveOwner, "owner", false, "编码时记录原文件的属主并赋予编码结果，解码时恢复，通常需以 root 运行")
+	fs.StringVar(&decodeConflict, "conflict", ConflictRename, "还原后的文件名已被占用时：rename 加序号另存，overwrite 覆盖，skip 跳过")
+	sandbox := fs.String("sandbox", "", "将还原结果只写入此目录，文件名去除路径与保留字符，已存在的文件不会被覆盖")
+	mode := fs.String("mode", "", "输出文件的权限，八进制如 0600，默认与原文件相同")
+	fs.BoolVar(&removeSource, "remove-source", false, "处理成功后删除原文件")
+	fs.BoolVar(&useTrash, "trash", false, "配合 -remove-source，将原文件移至回收站而非直接删除")
+	fs.IntVar(&shredPasses, "shred", 0, "配合 -remove-source，删除前用随机数据覆盖原文件的次数；SSD 及日志、写时复制文件系统上旧数据仍可能残留")
+	order := fs.String("order", "", "处理顺序：size 由小到大、mtime 由旧到新、name 按路径，默认按列出的顺序，设置 -min-free 时为 size")
+	minFreeSize := fs.String("min-free", "", "输出所在磁盘至少保留的空间，可用 K、M、G 后缀，不足时暂停至空间足够，如原文件被删除后")
+	pack := fs.String("pack", "", "编码时将不超过此大小的本地文件打包为 tar，每满 64M 编码为一个 NEO 文件，可用 K、M 后缀")
+	stateFile := fs.String("state", "", "将待处理文件与每个文件的结果记录至此文件，中断后以相同的命令再次运行时只处理未完成与失败的文件")
+	spoolDir := fs.String("spool", "", "编码结果先写入此本地目录，再在编码下一个文件的同时移至 -out，适用于较慢的输出位置")
+	writeSums := fs.String("write-sums", "", "将编码结果的 SHA-256 以 sha256sum 的格式追加至此文件，如 SHA256SUMS")
+	addHookFlags(fs, false)
+	failFast := fs.Bool("fail-fast", false, "遇到第一个错误即停止，不处理其余文件，并以非零状态退出，适用于脚本；默认继续处理")
+	yes := fs.Bool("yes", false, "不询问确认，如 -r 处理前或拖放的文件中既有待编码又有待还原的文件时")
+	pause := fs.Bool("pause", false, "结束前等待按下回车")
+	noPause := fs.Bool("no-pause", false, "结束前不等待按下回车")
+	// registered first so a window opened by a drop stays open on usage
+	// errors too
+	defer func() {
+		if !*noPause && (*pause || ownsConsole()) {
+			fmt.Println("Press the Enter Key to stop anytime")
+			fmt.Scanln()
+		}
+	}()
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	var err error
+	if encodeSHA256, encodeXXH64, err = parseHashes(*hashes); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if headerLen, err = parseSize(*hdrLen); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if err := checkConflict(decodeConflict); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if *mode != "" {
+		m, err := strconv.ParseUint(*mode, 8, 32)
+		if err != nil || m == 0 || m > 0777 {
+			return cmd.usageError(fs, "bad mode: %q", *mode)
+		}
+		outputMode = os.FileMode(m)
+	}
+	if *bufSize != "" {
+		if bufferSize, err = parseSize(*bufSize); err != nil || bufferSize == 0 {
+			return cmd.usageError(fs, "bad buffer size: %q", *bufSize)
+		}
+	}
+	if *limitRate != "" {
+		if uploadRate, err = parseSize(*limitRate); err != nil {
+			return cmd.usageError(fs, "bad rate: %q", *limitRate)
+		}
+	}
+	if uploadPartSize, err = parseSize(*partSize); err != nil || uploadPartSize < 5<<20 {
+		return cmd.usageError(fs, "bad part size: %q", *partSize)
+	}
+	if (encodeSHA256 || encodeXXH64) && encodeNoChecksum {
+		return cmd.usageError(fs, "-hash %s and -no-checksum exclude each other", *hashes)
+	}
+	if outputExts, err = parseExts(*exts); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if err := checkScheme(nameScheme); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	if *askPassword && key != nil {
+		return cmd.usageError(fs, "-ask-password excludes -password and -keyfile")
+	}
+	if (encodeStealth || encodeKeyed) && key == nil && !*askPassword {
+		return cmd.usageError(fs, "-stealth and -keyed need -password or -keyfile")
+	}
+	if (useTrash || shredPasses != 0) && !removeSource {
+		return cmd.usageError(fs, "-trash and -shred need -remove-source")
+	}
+	if shredPasses < 0 || (useTrash && shredPasses > 0) {
+		return cmd.usageError(fs, "-shred needs a positive count and excludes -trash")
+	}
+	switch {
+	case !*tarMode:
+	case cmd.name == "encode" && fs.NArg() != 1:
+		return cmd.usageError(fs, "encode -tar needs one tar file or -")
+	case cmd.name == "decode" && *jsonOut:
+		return cmd.usageError(fs, "decode -tar writes the archive to stdout and cannot be used with -json")
+	case cmd.name != "encode" && cmd.name != "decode":
+		return cmd.usageError(fs, "-tar needs the encode or decode command")
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return nil
+	}
+
+	sum := &summary{rate: startRateSampler(), failFast: *failFast}
+	if *jsonOut {
+		sum.json = json.NewEncoder(os.Stdout)
+	}
+	if *writeSums != "" {
+		if sum.sums, err = openSums(*writeSums); err != nil {
+			return err
+		}
+		defer sum.sums.Close()
+		hashOutput = true
+	}
+	if *sandbox != "" {
+		if *out != "" || decodeConflict == ConflictOverwrite {
+			return cmd.usageError(fs, "-sandbox excludes -out and -conflict overwrite")
+		}
+		if err := os.MkdirAll(*sandbox, 0700); err != nil {
+			return err
+		}
+		outStorage, sandboxed = LocalStorage(*sandbox), true
+	}
+	if *out != "" {
+		storage, err := NewStorage(*out)
+		if err != nil {
+			return fmt.Errorf("output %s: %w", *out, err)
+		}
+		outStorage = storage
+	}
+
+	if *minFreeSize != "" {
+		n, err := parseSize(*minFreeSize)
+		if err != nil {
+			return cmd.usageError(fs, "bad free space: %q", *minFreeSize)
+		}
+		minFree = int64(n)
+		if *order == "" {
+			*order = "size"
+		}
+	}
+	if *order != "" {
+		// sorting nothing checks the order
+		if err := sortFiles(nil, *order); err != nil {
+			return cmd.usageError(fs, "%v", err)
+		}
+	}
+	if *stateFile != "" && *tarMode {
+		return cmd.usageError(fs, "-state excludes -tar")
+	}
+	if *pack != "" {
+		n, err := parseSize(*pack)
+		if err != nil || n == 0 {
+			return cmd.usageError(fs, "bad pack size: %q", *pack)
+		}
+		if cmd.name != "encode" || *tarMode || *stateFile != "" {
+			return cmd.usageError(fs, "-pack only applies to encode, without -tar and -state")
+		}
+		packSize = int64(n)
+		defer func() { packSize = 0 }()
+	}
+	if *spoolDir != "" {
+		if *tarMode || cmd.name == "decode" {
+			return cmd.usageError(fs, "-spool only applies to encoding files")
+		}
+		if spool, err = newSpooler(*spoolDir, sum.add); err != nil {
+			return err
+		}
+		defer func() { spool = nil }()
+	}
+
+	if *askPassword && *tarMode {
+		if key, err = promptKey(cmd.name, nil); err != nil {
+			return err
+		}
+	}
+	if *tarMode && cmd.name == "decode" {
+		if err := decodeTar(os.Stdout, fs.Args(), *recursive, sum); err != nil {
+			return err
+		}
+		sum.report()
+		return sum.err()
+	}
+	if *tarMode {
+		dst := outStorage
+		if dst == nil {
+			dst = LocalStorage(".")
+		}
+		r, err := openTarInput(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if err := encodeTar(r, dst, sum); err != nil {
+			return fmt.Errorf("tar %s: %w", fs.Arg(0), err)
+		}
+		sum.report()
+		return sum.err()
+	}
+
+	var files []string
+	if *stateFile != "" {
+		if sum.state, err = openBatchState(*stateFile, cmd.name, fs.Args()); err != nil {
+			return err
+		}
+		if files = sum.state.pending(); sum.state.head.Files != nil {
+			log.Printf("从状态文件：%s 继续，剩余 %d 个", *stateFile, len(files))
+		}
+	}
+	if sum.state == nil || sum.state.head.Files == nil {
+		files = collectFiles(fs.Args(), *recursive, sum)
+	}
+	ask := !*yes && stdinIsTerminal()
+	if *recursive && len(files) > 0 {
+		encode, decode := planFiles(files, Action(cmd.name))
+		reportPlan(encode, decode)
+		if ask && !askYes("是否继续？", os.Stdin) {
+			log.Printf("已取消")
+			return nil
+		}
+	} else if cmd.name == "" && ask && !confirmMixed(files, os.Stdin) {
+		log.Printf("已取消")
+		return nil
+	}
+	if sum.state != nil {
+		if err := sum.state.begin(files); err != nil {
+			return err
+		}
+		defer func() { sum.state.close(sum.failed > 0) }()
+	}
+	if *askPassword {
+		if key, err = promptKey(cmd.name, files); err != nil {
+			return err
+		}
+	}
+	if *order != "" {
+		sortFiles(files, *order)
+	}
+	if cmd.name != "encode" {
+		warnCollisions(files)
+	}
+	if packSize > 0 {
+		var small []string
+		small, files = splitPack(files)
+		packFiles(small, outStorage, sum)
+	}
+	for i, item := range files {
+		if sum.stopped() {
+			sum.left = len(files) - i
+			break
+		}
+		waitFreeSpace(item)
+		sum.add(processFile(item, Action(cmd.name)))
+	}
+	if spool != nil {
+		spool.wait()
+	}
+	sum.report()
+	return sum.err()
+}
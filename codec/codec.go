@@ -0,0 +1,1334 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	VersionV1 uint8 = 1
+
+	// DefaultHeaderLen is how many leading bytes of the original file are moved
+	// into the NEO header.
+	DefaultHeaderLen = 8
+
+	FlagVersion = 0b00001111
+	// FlagEncryptedMeta means the CRC32 and the extension fields are stored
+	// as one blob encrypted with the filename method instead of in clear.
+	FlagEncryptedMeta = 0b00010000
+	// FlagXorStream means the XOR blobs use NewXorStream, files without it
+	// were written with NewLegacyXorStream.
+	FlagXorStream = 0b00100000
+	// FlagNoChecksum means the file was written without checksums, the
+	// CRC32 field is kept for the layout but holds 0 and means nothing.
+	FlagNoChecksum = 0b01000000
+	// FlagKeyed means the salt and the key slots follow the flag, each as a
+	// vuint length and bytes, and the keys stored in the header are mixed
+	// with the random file key before use. Every slot holds the file key
+	// wrapped with one master key, see KeySlot.
+	FlagKeyed = 0b10000000
+
+	XorEnc uint8 = 1
+	// XorRecords is only valid for the original header. The field holds the
+	// key alone and the content follows the header as records of a big
+	// endian uint32 length and that many bytes, XORed as one stream. A zero
+	// length record ends them.
+	XorRecords uint8 = 2
+
+	// Extension fields follow the CRC32 inside the header as type, vuint
+	// length and value. Readers that predate them stop at the CRC32 and
+	// ignore the rest of the header.
+	ExtSHA256 uint8 = 1
+	// ExtXXH64 is the big endian XXH64 digest, see NewXXH64.
+	ExtXXH64 uint8 = 2
+	// ExtOwner is the big endian uint32 uid and gid of the original file.
+	ExtOwner uint8 = 3
+	// ExtHint is a short UTF-8 hint at the original filename that can be
+	// shown instead of it.
+	ExtHint uint8 = 4
+	// ExtMedia is the big endian uint32 width and height, uint64 duration in
+	// milliseconds and int64 Unix time of capture of a picture or video,
+	// zero where unknown.
+	ExtMedia uint8 = 5
+	// ExtHeaderCRC is the big endian IEEE CRC32 of the header fields, see
+	// headerCRC. It is the last extension.
+	ExtHeaderCRC uint8 = 6
+)
+
+var (
+	NeoMagicNumber = []byte{0xFF, 0x4E, 0x45, 0x4F}
+
+	ErrCRCCheckFailed      = errors.New("crc check failed")
+	ErrNotNEOHeader        = errors.New("not a NEO header")
+	ErrBadVersion          = errors.New("bad version")
+	ErrUnknownCryptoMethod = errors.New("unknown crypto method")
+	ErrDigestCheckFailed   = errors.New("digest check failed")
+	ErrMalformed           = errors.New("malformed header")
+	ErrHeaderCorrupt       = errors.New("header corrupt")
+)
+
+type NeoHeader struct {
+	Version                   uint8
+	OriginalHeaderEncMethod   uint8
+	OriginalHeader            []byte
+	OriginalFilenameEncMethod uint8
+	OriginalFilename          string
+	Crc32                     uint32
+	SHA256                    []byte
+	XXH64                     []byte
+	EncryptedMeta             bool
+	// Magic replaces NeoMagicNumber when set, see StealthMagic.
+	Magic []byte
+	// Warnings lists the anomalies UnMarshall tolerated.
+	Warnings []string
+	// Legacy is set for headers without FlagXorStream, Marshall keeps them
+	// in the legacy format.
+	Legacy bool
+	// NoChecksum means there is nothing to verify the content against, Crc32
+	// and the digests are not stored.
+	NoChecksum bool
+	// Owner is the owner of the original file, nil when not recorded.
+	Owner *Owner
+	// Hint is shown in place of the original filename when set.
+	Hint string
+	// Media describes a picture or video, nil when not recorded.
+	Media *Media
+	// HeaderCRC adds a checksum of the header itself, a header read with
+	// one passed it.
+	HeaderCRC bool
+	// MasterKey makes Marshall write a keyed header with a new file key
+	// and a slot for it, see FlagKeyed. One of the master keys of a keyed
+	// header must be set before it is parsed.
+	MasterKey []byte
+	// Salt is the salt of a keyed header, Marshall picks a new one when it
+	// is nil.
+	Salt []byte
+	// KeySlots are the slots of a keyed header, see AddKeySlot.
+	KeySlots []KeySlot
+	// KDFSalt and KDFCost are stored in stealth and keyed headers, the
+	// Magic and the MasterKey are derived from a secret with them, see
+	// DeriveKey.
+	KDFSalt []byte
+	KDFCost uint8
+
+	keyFunc KeyFunc
+
+	alternate *NeoHeader
+	recordKey []byte
+	fileKey   []byte
+	// outsideLen is the length of an original header readLargeHeader left
+	// in the input, the header it parses holds 0 in its place
+	outsideLen int
+}
+
+type Owner struct {
+	UID, GID uint32
+}
+
+type Media struct {
+	Width, Height uint32
+	Duration      time.Duration
+	// Taken is the Unix time the picture or video was taken
+	Taken int64
+}
+
+// StealthMagic derives the magic number of stealth files from key, only
+// readers that know the key can tell them apart from random data. The key
+// is derived with the salt and the cost stored after the flag, see
+// DeriveKey, so the magic differs between salts and confirming a guessed
+// password costs a derivation per file.
+func StealthMagic(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("neo stealth magic"))
+	return mac.Sum(nil)[:len(NeoMagicNumber)]
+}
+
+func encodeVUint(u uint) []byte {
+	buf := new(bytes.Buffer)
+	for i := 0; i < int(u)/0xFF; i++ {
+		buf.WriteByte(0xFF)
+	}
+	buf.WriteByte(byte(u % 0xFF))
+	return buf.Bytes()
+}
+
+func decodeVUint(p []byte) (res uint, surplus []byte) {
+	for idx, v := range p {
+		if v == 0xFF {
+			res += 0xFF
+			continue
+		}
+		res += uint(v)
+		surplus = p[idx+1:]
+		break
+	}
+	return
+}
+
+func newXorEncStream(key []byte, legacy bool) cipher.Stream {
+	if legacy {
+		return NewLegacyXorStream(key)
+	}
+	return NewXorStream(key)
+}
+
+func writeContentWithXorEnc(buf *bytes.Buffer, content, key, fileKey []byte, legacy bool) {
+	buf.WriteByte(XorEnc)
+	buf.Write(encodeVUint(uint(len(key))))
+	buf.Write(key)
+	buf.Write(encodeVUint(uint(len(content))))
+	dst := make([]byte, len(content))
+	newXorEncStream(xorKey(fileKey, key), legacy).XORKeyStream(dst, content)
+	buf.Write(dst)
+}
+
+// newXorKey returns a random key without zero bytes, a zero byte would leave
+// the content in clear.
+func newXorKey() ([]byte, error) {
+	key := make([]byte, 4)
+	if _, err := rand.Reader.Read(key); err != nil {
+		return nil, err
+	}
+	for i := range key {
+		if key[i] == 0 {
+			key[i] = 0xA5
+		}
+	}
+	return key, nil
+}
+
+func (h NeoHeader) Marshall() ([]byte, error) {
+	return h.marshall(newXorKey)
+}
+
+// marshall takes the source of the XOR keys so that test vectors can be
+// reproduced.
+func (h NeoHeader) marshall(newKey func() ([]byte, error)) ([]byte, error) {
+	if h.OriginalHeaderEncMethod == XorRecords {
+		// the records are not part of the header, see NeoWriter
+		return nil, ErrUnknownCryptoMethod
+	}
+	prefix, suffix, stream, err := h.marshallAround(len(h.OriginalHeader), newKey)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]byte, len(prefix)+len(h.OriginalHeader)+len(suffix))
+	copy(res, prefix)
+	stream.XORKeyStream(res[len(prefix):], h.OriginalHeader)
+	copy(res[len(prefix)+len(h.OriginalHeader):], suffix)
+	return res, nil
+}
+
+// marshallAround returns the header up to the encrypted original header of
+// hdrLen bytes and after it, along with the stream to encrypt it with. With
+// XorRecords the header is complete without the original header, hdrLen is
+// ignored.
+func (h NeoHeader) marshallAround(hdrLen int, newKey func() ([]byte, error)) (prefix, suffix []byte, stream cipher.Stream, err error) {
+	if h.Version != VersionV1 {
+		return nil, nil, nil, ErrBadVersion
+	}
+
+	buf := new(bytes.Buffer)
+
+	var flag byte = 0
+	flag |= h.Version & FlagVersion
+	if h.EncryptedMeta {
+		flag |= FlagEncryptedMeta
+	}
+	if !h.Legacy {
+		flag |= FlagXorStream
+	}
+	if h.NoChecksum {
+		flag |= FlagNoChecksum
+	}
+	keyed := h.MasterKey != nil || len(h.KeySlots) > 0
+	if keyed {
+		if h.Legacy {
+			return nil, nil, nil, ErrUnknownCryptoMethod
+		}
+		flag |= FlagKeyed
+		if h.fileKey == nil {
+			// a new file, h is a copy
+			if len(h.KeySlots) > 0 {
+				return nil, nil, nil, ErrNoMasterKey
+			}
+			if err := h.newFileKey(); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	buf.WriteByte(flag)
+	if keyed || h.Magic != nil && !bytes.Equal(h.Magic, NeoMagicNumber) {
+		buf.Write(encodeVUint(uint(len(h.KDFSalt))))
+		buf.Write(h.KDFSalt)
+		buf.WriteByte(h.KDFCost)
+	}
+	if keyed {
+		buf.Write(encodeVUint(uint(len(h.Salt))))
+		buf.Write(h.Salt)
+		slots := marshallSlots(h.KeySlots)
+		buf.Write(encodeVUint(uint(len(slots))))
+		buf.Write(slots)
+	}
+	fileKey := h.fileKey
+
+	// encode originalHeader, its content is left to the caller
+	switch h.OriginalHeaderEncMethod {
+	case XorEnc, XorRecords:
+		key, err := newKey()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		buf.WriteByte(h.OriginalHeaderEncMethod)
+		buf.Write(encodeVUint(uint(len(key))))
+		buf.Write(key)
+		if h.OriginalHeaderEncMethod == XorEnc {
+			buf.Write(encodeVUint(uint(hdrLen)))
+		} else {
+			hdrLen = 0
+		}
+		stream = newXorEncStream(xorKey(fileKey, key), h.Legacy)
+	default:
+		return nil, nil, nil, ErrUnknownCryptoMethod
+	}
+	before := buf.Len()
+
+	switch h.OriginalFilenameEncMethod {
+	case XorEnc:
+		key, err := newKey()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		writeContentWithXorEnc(buf, []byte(h.OriginalFilename), key, fileKey, h.Legacy)
+	default:
+		return nil, nil, nil, ErrUnknownCryptoMethod
+	}
+
+	meta := new(bytes.Buffer)
+	crc := make([]byte, 4)
+	if !h.NoChecksum {
+		binary.BigEndian.PutUint32(crc, h.Crc32)
+	}
+	meta.Write(crc)
+
+	if len(h.SHA256) > 0 && !h.NoChecksum {
+		writeExtension(meta, ExtSHA256, h.SHA256)
+	}
+	if len(h.XXH64) > 0 && !h.NoChecksum {
+		writeExtension(meta, ExtXXH64, h.XXH64)
+	}
+	if h.Owner != nil {
+		owner := make([]byte, 8)
+		binary.BigEndian.PutUint32(owner, h.Owner.UID)
+		binary.BigEndian.PutUint32(owner[4:], h.Owner.GID)
+		writeExtension(meta, ExtOwner, owner)
+	}
+	if h.Hint != "" {
+		writeExtension(meta, ExtHint, []byte(h.Hint))
+	}
+	if h.Media != nil {
+		media := make([]byte, 24)
+		binary.BigEndian.PutUint32(media, h.Media.Width)
+		binary.BigEndian.PutUint32(media[4:], h.Media.Height)
+		binary.BigEndian.PutUint64(media[8:], uint64(h.Media.Duration/time.Millisecond))
+		binary.BigEndian.PutUint64(media[16:], uint64(h.Media.Taken))
+		writeExtension(meta, ExtMedia, media)
+	}
+
+	if h.HeaderCRC {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, headerCRC(buf.Bytes()[:before], []byte(h.OriginalFilename), meta.Bytes()))
+		writeExtension(meta, ExtHeaderCRC, sum)
+	}
+
+	if h.EncryptedMeta {
+		// same method as the filename, checked above
+		key, err := newKey()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		writeContentWithXorEnc(buf, meta.Bytes(), key, fileKey, h.Legacy)
+	} else {
+		buf.Write(meta.Bytes())
+	}
+
+	magic := NeoMagicNumber
+	if h.Magic != nil {
+		if len(h.Magic) != len(NeoMagicNumber) {
+			return nil, nil, nil, ErrNotNEOHeader
+		}
+		magic = h.Magic
+	}
+	contentLenVint := encodeVUint(uint(buf.Len() + hdrLen))
+	prefix = make([]byte, 0, 4+len(contentLenVint)+before)
+	prefix = append(prefix, magic...)
+	prefix = append(prefix, contentLenVint...)
+	prefix = append(prefix, buf.Bytes()[:before]...)
+	return prefix, buf.Bytes()[before:], stream, nil
+}
+
+// UnMarshall parses a header leniently, anomalies that do not prevent
+// reading it are recorded in Warnings.
+func (h *NeoHeader) UnMarshall(p []byte) error {
+	return h.unmarshall(p, false)
+}
+
+// UnMarshallStrict parses a header and fails with ErrMalformed on anything
+// UnMarshall would only warn about.
+func (h *NeoHeader) UnMarshallStrict(p []byte) error {
+	return h.unmarshall(p, true)
+}
+
+func (h *NeoHeader) unmarshall(p []byte, strict bool) error {
+	err := h.parse(p, strict, true)
+	if !h.Legacy {
+		return err
+	}
+	// files without FlagXorStream were meant to use the legacy stream, but
+	// some writers cycled the key, so both readings are kept
+	alt := new(NeoHeader)
+	altErr := alt.parse(p, strict, false)
+	switch {
+	case err != nil && altErr != nil:
+		return err
+	case err != nil:
+		*h = *alt
+	case altErr != nil || h.sameContent(alt):
+	case !plausibleFilename(h.OriginalFilename) && plausibleFilename(alt.OriginalFilename):
+		*h, *alt = *alt, *h
+		h.alternate = alt
+	default:
+		h.alternate = alt
+	}
+	return nil
+}
+
+// Alternate returns the other reading of a header without FlagXorStream when
+// it parses and differs, it is the right one if the checksums of this one
+// fail.
+func (h *NeoHeader) Alternate() *NeoHeader {
+	return h.alternate
+}
+
+func (h *NeoHeader) sameContent(o *NeoHeader) bool {
+	return bytes.Equal(h.OriginalHeader, o.OriginalHeader) && h.OriginalFilename == o.OriginalFilename &&
+		h.Crc32 == o.Crc32 && bytes.Equal(h.SHA256, o.SHA256) && bytes.Equal(h.XXH64, o.XXH64) &&
+		(h.Owner == nil) == (o.Owner == nil) && (h.Owner == nil || *h.Owner == *o.Owner) && h.Hint == o.Hint &&
+		(h.Media == nil) == (o.Media == nil) && (h.Media == nil || *h.Media == *o.Media)
+}
+
+// plausibleFilename tells a filename from the noise the wrong XOR stream
+// leaves.
+func plausibleFilename(name string) bool {
+	if name == "" || !utf8.ValidString(name) {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7F || r == '/' || r == '\\' || r == utf8.RuneError {
+			return false
+		}
+	}
+	return true
+}
+
+// parse reads the header with the stream its flags ask for, legacy selects
+// the stream when FlagXorStream is not set.
+func (h *NeoHeader) parse(p []byte, strict, legacy bool) error {
+	if len(p) <= 4 {
+		return ErrNotNEOHeader
+	}
+	if !bytes.Equal(p[:4], NeoMagicNumber) {
+		h.Magic = append([]byte(nil), p[:4]...)
+	}
+	hp := &headerParser{p: p[4:], strict: strict}
+	neoHdrlen, err := hp.vuint()
+	if err != nil {
+		return err
+	}
+	switch {
+	case neoHdrlen > uint(len(hp.p)):
+		return ErrNotNEOHeader
+	case neoHdrlen < uint(len(hp.p)):
+		if err := hp.anomaly("%d bytes after the header", uint(len(hp.p))-neoHdrlen); err != nil {
+			return err
+		}
+		hp.p = hp.p[:neoHdrlen]
+	}
+	fields := hp.p
+	flag, err := hp.byte()
+	if err != nil {
+		return err
+	}
+	h.Version = flag & FlagVersion
+	if h.Version != VersionV1 {
+		return ErrBadVersion
+	}
+	if flag&FlagXorStream != 0 {
+		legacy = false
+	}
+	h.Legacy = legacy
+	hp.legacy = legacy
+	h.NoChecksum = flag&FlagNoChecksum != 0
+	if unknown := flag &^ (FlagVersion | FlagEncryptedMeta | FlagXorStream | FlagNoChecksum | FlagKeyed); unknown != 0 {
+		if err := hp.anomaly("unknown flags %08b", unknown); err != nil {
+			return err
+		}
+	}
+	if h.Magic != nil || flag&FlagKeyed != 0 {
+		if err := h.parseKDF(hp, flag&FlagKeyed != 0); err != nil {
+			return err
+		}
+	}
+	if flag&FlagKeyed != 0 {
+		if err := h.parseKeyed(hp); err != nil {
+			return err
+		}
+		hp.fileKey = h.fileKey
+	}
+	if len(hp.p) > 0 && hp.p[0] == XorRecords {
+		hp.p = hp.p[1:]
+		h.OriginalHeaderEncMethod = XorRecords
+		if h.recordKey, err = hp.key(); err != nil {
+			return err
+		}
+	} else if h.OriginalHeaderEncMethod, h.OriginalHeader, err = hp.encrypted(); err != nil {
+		return err
+	}
+	fields = fields[:len(fields)-len(hp.p)-len(h.OriginalHeader)]
+	if h.outsideLen > 0 && len(fields) > 0 {
+		// the header checksum covers the length as stored
+		fields = append(fields[:len(fields)-1:len(fields)-1], encodeVUint(uint(h.outsideLen))...)
+	}
+	var filename []byte
+	if h.OriginalFilenameEncMethod, filename, err = hp.encrypted(); err != nil {
+		return err
+	}
+	h.OriginalFilename = string(filename)
+
+	meta := hp
+	if flag&FlagEncryptedMeta != 0 {
+		h.EncryptedMeta = true
+		method, p, err := hp.encrypted()
+		if err != nil {
+			return err
+		}
+		if method != h.OriginalFilenameEncMethod {
+			return ErrUnknownCryptoMethod
+		}
+		if len(hp.p) > 0 {
+			if err := hp.anomaly("%d bytes after the encrypted meta", len(hp.p)); err != nil {
+				return err
+			}
+		}
+		meta = &headerParser{p: p, strict: strict}
+	}
+
+	metaFields := meta.p
+	crc, err := meta.take(4)
+	if err != nil {
+		return err
+	}
+	h.Crc32 = binary.BigEndian.Uint32(crc)
+	sum, sumAt, err := h.readExtensions(meta)
+	if err != nil {
+		return err
+	}
+	if sum != nil {
+		if len(sum) != 4 || binary.BigEndian.Uint32(sum) != headerCRC(fields, filename, metaFields[:sumAt]) {
+			return ErrHeaderCorrupt
+		}
+		h.HeaderCRC = true
+	}
+	if h.NoChecksum && (h.Crc32 != 0 || h.SHA256 != nil || h.XXH64 != nil) {
+		if err := meta.anomaly("checksums in a header without checksum"); err != nil {
+			return err
+		}
+		h.Crc32, h.SHA256, h.XXH64 = 0, nil, nil
+	}
+	h.Warnings = hp.warnings
+	if meta != hp {
+		h.Warnings = append(h.Warnings, meta.warnings...)
+	}
+	return nil
+}
+
+// readExtensions returns the value of ExtHeaderCRC and how many bytes of
+// the meta, the 4 of the crc32 taken before included, came before it.
+func (h *NeoHeader) readExtensions(hp *headerParser) (sum []byte, sumAt int, err error) {
+	seen := make(map[byte]bool)
+	start := len(hp.p) + 4
+	for len(hp.p) > 0 {
+		at := start - len(hp.p)
+		typ, _ := hp.byte()
+		extLen, err := hp.vuint()
+		if err == nil && extLen > uint(len(hp.p)) {
+			err = ErrNotNEOHeader
+		}
+		if err != nil {
+			// a cut extension loses only the optional fields
+			if err := hp.anomaly("truncated extension %d", typ); err != nil {
+				return nil, 0, err
+			}
+			hp.p = nil
+			return sum, sumAt, nil
+		}
+		ext, _ := hp.take(extLen)
+		if seen[typ] {
+			if err := hp.anomaly("duplicate extension %d", typ); err != nil {
+				return nil, 0, err
+			}
+		}
+		seen[typ] = true
+		switch typ {
+		case ExtSHA256:
+			h.SHA256 = ext
+		case ExtXXH64:
+			h.XXH64 = ext
+		case ExtOwner:
+			if len(ext) != 8 {
+				if err := hp.anomaly("bad owner extension length %d", len(ext)); err != nil {
+					return nil, 0, err
+				}
+				continue
+			}
+			h.Owner = &Owner{UID: binary.BigEndian.Uint32(ext), GID: binary.BigEndian.Uint32(ext[4:])}
+		case ExtHint:
+			h.Hint = string(ext)
+		case ExtMedia:
+			if len(ext) != 24 {
+				if err := hp.anomaly("bad media extension length %d", len(ext)); err != nil {
+					return nil, 0, err
+				}
+				continue
+			}
+			h.Media = &Media{
+				Width:    binary.BigEndian.Uint32(ext),
+				Height:   binary.BigEndian.Uint32(ext[4:]),
+				Duration: time.Duration(binary.BigEndian.Uint64(ext[8:])) * time.Millisecond,
+				Taken:    int64(binary.BigEndian.Uint64(ext[16:])),
+			}
+		case ExtHeaderCRC:
+			sum, sumAt = ext, at
+			if len(hp.p) > 0 {
+				// nothing after the checksum is covered by it
+				if err := hp.anomaly("%d bytes after the header checksum", len(hp.p)); err != nil {
+					return nil, 0, err
+				}
+				hp.p = nil
+			}
+		}
+	}
+	return sum, sumAt, nil
+}
+
+// headerCRC sums the header as stored from the flag up to the encrypted
+// original header, without it, then the original filename and the crc32
+// and extensions before ExtHeaderCRC in clear. What it leaves out is
+// covered by the checksums of the content.
+func headerCRC(fields, filename, meta []byte) uint32 {
+	h := crc32.NewIEEE()
+	h.Write(fields)
+	h.Write(filename)
+	h.Write(meta)
+	return h.Sum32()
+}
+
+func writeExtension(buf *bytes.Buffer, typ uint8, value []byte) {
+	buf.WriteByte(typ)
+	buf.Write(encodeVUint(uint(len(value))))
+	buf.Write(value)
+}
+
+// chunkSize bounds the buffers used to encrypt and decrypt large original
+// headers.
+const chunkSize = 32 << 10
+
+// recordSize is the largest record NeoWriter writes with XorRecords.
+const recordSize = 1 << 20
+
+type NeoWriter struct {
+	originHdrLen int
+	hdr          *NeoHeader
+	w            io.Writer
+	buf          *bytes.Buffer
+	size         int64
+	newKey       func() ([]byte, error)
+
+	started bool
+	left    int
+	stream  cipher.Stream
+	suffix  []byte
+	chunk   []byte
+}
+
+func NewNeoWriter(w io.Writer, hdrLen int, filename string, crc32 uint32) *NeoWriter {
+	return NewNeoWriterWithHeader(w, hdrLen, &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          filename,
+		Crc32:                     crc32,
+	})
+}
+
+// NewNeoWriterWithHeader is NewNeoWriter with every header field but
+// OriginalHeader supplied by the caller. An hdrLen over 1 MiB switches XorEnc
+// to XorRecords, which needs no buffering and no length up front.
+func NewNeoWriterWithHeader(w io.Writer, hdrLen int, hdr *NeoHeader) *NeoWriter {
+	if hdr.OriginalHeaderEncMethod == XorEnc && hdrLen > maxInlineHeader {
+		hdr.OriginalHeaderEncMethod = XorRecords
+	}
+	return &NeoWriter{
+		originHdrLen: hdrLen,
+		hdr:          hdr,
+		w:            w,
+		buf:          new(bytes.Buffer),
+		size:         -1,
+		newKey:       newXorKey,
+	}
+}
+
+func (w *NeoWriter) records() bool {
+	return w.hdr.OriginalHeaderEncMethod == XorRecords
+}
+
+// SetSize gives the length of the content before the first Write. The header
+// then goes out at once and the original header is encrypted as it arrives,
+// without it up to hdrLen bytes are held in memory.
+func (w *NeoWriter) SetSize(size int64) {
+	w.size = size
+}
+
+func (w *NeoWriter) Write(p []byte) (n int, err error) {
+	if !w.started {
+		if w.size < 0 && !w.records() {
+			k := w.originHdrLen - w.buf.Len()
+			if k > len(p) {
+				k = len(p)
+			}
+			w.buf.Write(p[:k])
+			n, p = k, p[k:]
+			if w.buf.Len() < w.originHdrLen {
+				return n, nil
+			}
+		}
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+	}
+	if w.left > 0 && len(p) > 0 {
+		k := w.left
+		if k > len(p) {
+			k = len(p)
+		}
+		write := w.writeOriginalHeader
+		if w.records() {
+			write = w.writeRecords
+		}
+		if err := write(p[:k]); err != nil {
+			return n, err
+		}
+		n, p = n+k, p[k:]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	m, err := w.w.Write(p)
+	return n + m, err
+}
+
+// Close writes the header if the content was shorter than hdrLen, it does not
+// close the underlying writer.
+func (w *NeoWriter) Close() error {
+	if !w.started {
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if w.records() {
+		if w.left > 0 {
+			// content shorter than hdrLen
+			w.left = 0
+			return w.writeRecords(nil)
+		}
+		return nil
+	}
+	if w.left > 0 {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+// start writes the header up to the original header, then whatever was
+// buffered.
+func (w *NeoWriter) start() error {
+	hdrLen := w.buf.Len()
+	if w.size >= 0 || w.records() {
+		hdrLen = w.originHdrLen
+		if w.size >= 0 && int64(hdrLen) > w.size {
+			hdrLen = int(w.size)
+		}
+	}
+	prefix, suffix, stream, err := w.hdr.marshallAround(hdrLen, w.newKey)
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(prefix); err != nil {
+		return err
+	}
+	w.started, w.left, w.stream, w.suffix = true, hdrLen, stream, suffix
+	if w.records() {
+		_, err := w.w.Write(suffix)
+		if err == nil && hdrLen == 0 {
+			err = w.writeRecords(nil)
+		}
+		return err
+	}
+	buffered := w.buf.Bytes()
+	w.buf = nil
+	if hdrLen == 0 {
+		_, err := w.w.Write(w.suffix)
+		return err
+	}
+	if len(buffered) > 0 {
+		return w.writeOriginalHeader(buffered)
+	}
+	return nil
+}
+
+// writeRecords collects p into records of up to recordSize, the last record
+// and the end mark go out once w.left reaches 0.
+func (w *NeoWriter) writeRecords(p []byte) error {
+	if w.chunk == nil {
+		n := w.left
+		if n > recordSize {
+			n = recordSize
+		}
+		// room for the length in front
+		w.chunk = make([]byte, 4, 4+n)
+	}
+	for len(p) > 0 {
+		k := cap(w.chunk) - len(w.chunk)
+		if k > len(p) {
+			k = len(p)
+		}
+		end := len(w.chunk)
+		w.chunk = w.chunk[:end+k]
+		w.stream.XORKeyStream(w.chunk[end:], p[:k])
+		w.left -= k
+		p = p[k:]
+		if len(w.chunk) == cap(w.chunk) {
+			if err := w.flushRecord(); err != nil {
+				return err
+			}
+		}
+	}
+	if w.left > 0 {
+		return nil
+	}
+	if err := w.flushRecord(); err != nil {
+		return err
+	}
+	_, err := w.w.Write([]byte{0, 0, 0, 0})
+	return err
+}
+
+func (w *NeoWriter) flushRecord() error {
+	if len(w.chunk) == 4 {
+		return nil
+	}
+	binary.BigEndian.PutUint32(w.chunk, uint32(len(w.chunk)-4))
+	_, err := w.w.Write(w.chunk)
+	w.chunk = w.chunk[:4]
+	return err
+}
+
+func (w *NeoWriter) writeOriginalHeader(p []byte) error {
+	if w.chunk == nil {
+		n := w.left
+		if n > chunkSize {
+			n = chunkSize
+		}
+		w.chunk = make([]byte, n)
+	}
+	for len(p) > 0 {
+		k := copy(w.chunk, p)
+		w.stream.XORKeyStream(w.chunk[:k], p[:k])
+		if _, err := w.w.Write(w.chunk[:k]); err != nil {
+			return err
+		}
+		w.left -= k
+		p = p[k:]
+	}
+	if w.left == 0 {
+		w.chunk = nil
+		_, err := w.w.Write(w.suffix)
+		return err
+	}
+	return nil
+}
+
+// maxInlineHeader is the largest original header NeoReader keeps in memory
+// when the input can seek, larger ones are decrypted as they are read.
+const maxInlineHeader = 1 << 20
+
+type NeoReader struct {
+	// Strict rejects headers with anomalies, see UnMarshallStrict.
+	Strict bool
+
+	n         int
+	src       io.Reader
+	rd        *bufio.Reader
+	NeoHeader *NeoHeader
+	buf       []byte
+	magics    [][]byte
+	master    []byte
+	keyFunc   KeyFunc
+	hdrSize   int
+	origLen   int
+	// set when the original header is left in the input
+	origKey  []byte
+	origPos  int64
+	bodyPos  int64
+	origRead cipher.Stream
+	// XorRecords state
+	recPos   int64
+	recLeft  int
+	recDone  bool
+	recBytes int
+	// offset in the decoded content, for Seek
+	pos int64
+}
+
+func NewNeoReader(r io.Reader) *NeoReader {
+	return &NeoReader{
+		src:    r,
+		rd:     bufio.NewReader(r),
+		buf:    make([]byte, 1024),
+		magics: [][]byte{NeoMagicNumber},
+	}
+}
+
+// NewStealthNeoReader reads stealth files made with key as well as
+// regular ones.
+func NewStealthNeoReader(r io.Reader, key []byte) *NeoReader {
+	rd := NewNeoReader(r)
+	rd.magics = append([][]byte{StealthMagic(key)}, rd.magics...)
+	return rd
+}
+
+// SetMasterKey gives the master key keyed headers are read with, it must be
+// called before the header is read.
+func (r *NeoReader) SetMasterKey(key []byte) {
+	r.master = key
+}
+
+// SetKeyFunc lets the reader derive the key of stealth and keyed headers
+// from the salt and the cost they store, it must be called before the
+// header is read. A key given to SetMasterKey is used as it is instead.
+func (r *NeoReader) SetKeyFunc(f KeyFunc) {
+	r.keyFunc = f
+}
+
+// SetBufferSize replaces the 4 KiB buffer used on the input, it must be
+// called before the first read.
+func (r *NeoReader) SetBufferSize(size int) {
+	r.rd = bufio.NewReaderSize(r.src, size)
+}
+
+func (r *NeoReader) Read(p []byte) (n int, err error) {
+	n, err = r.read(p)
+	r.pos += int64(n)
+	return
+}
+
+func (r *NeoReader) read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return
+	}
+	if r.NeoHeader != nil {
+		if r.NeoHeader.OriginalHeaderEncMethod == XorRecords && !r.recDone {
+			if n, err = r.readRecords(p); n > 0 || err != nil || !r.recDone {
+				return n, err
+			}
+			return r.rd.Read(p)
+		}
+		if r.n < r.origLen {
+			if r.origKey != nil {
+				return r.readOriginalHeader(p)
+			}
+			n = copy(p, r.NeoHeader.OriginalHeader[r.n:])
+			r.n += n
+			n_, err_ := r.read(p[n:])
+			return n_ + n, err_
+		}
+		return r.rd.Read(p)
+	}
+	if _, err := r.Header(); err != nil {
+		return 0, err
+	}
+	return r.read(p)
+}
+
+// readOriginalHeader decrypts the original header from the input and seeks
+// to the body once it is done.
+func (r *NeoReader) readOriginalHeader(p []byte) (int, error) {
+	if r.origRead == nil {
+		r.origRead = nopStream{}
+		if len(r.origKey) > 0 {
+			r.origRead = newXorEncStream(r.origKey, r.NeoHeader.Legacy)
+		}
+		if err := r.seek(r.origPos); err != nil {
+			return 0, err
+		}
+	}
+	if left := r.origLen - r.n; len(p) > left {
+		p = p[:left]
+	}
+	n, err := r.rd.Read(p)
+	r.origRead.XORKeyStream(p[:n], p[:n])
+	r.n += n
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == nil && r.n == r.origLen {
+		err = r.seek(r.bodyPos)
+	}
+	return n, err
+}
+
+// readRecords decrypts the next bytes of the XorRecords after the header, it
+// returns 0 and sets recDone at the end mark.
+func (r *NeoReader) readRecords(p []byte) (int, error) {
+	if r.origRead == nil {
+		r.origRead = nopStream{}
+		if key := r.NeoHeader.recordKey; len(key) > 0 {
+			r.origRead = newXorEncStream(key, r.NeoHeader.Legacy)
+		}
+	}
+	for r.recLeft == 0 {
+		var l [4]byte
+		if _, err := io.ReadFull(r.rd, l[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		r.recBytes += 4
+		if r.recLeft = int(binary.BigEndian.Uint32(l[:])); r.recLeft == 0 {
+			r.recDone = true
+			if r.origLen < 0 {
+				r.origLen, r.hdrSize = r.n, r.hdrSize+r.recBytes
+			}
+			return 0, nil
+		}
+	}
+	if len(p) > r.recLeft {
+		p = p[:r.recLeft]
+	}
+	n, err := r.rd.Read(p)
+	r.origRead.XORKeyStream(p[:n], p[:n])
+	r.n += n
+	r.recLeft -= n
+	r.recBytes += n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// scanRecords finds the length of the XorRecords by seeking over them and
+// returns to where they start.
+func (r *NeoReader) scanRecords(start int64) error {
+	s := r.src.(io.Seeker)
+	pos, total := start, 0
+	for {
+		if _, err := s.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		var l [4]byte
+		if _, err := io.ReadFull(r.src, l[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		n := binary.BigEndian.Uint32(l[:])
+		pos += 4 + int64(n)
+		if n == 0 {
+			break
+		}
+		total += int(n)
+	}
+	r.recPos, r.origLen, r.hdrSize = start, total, r.hdrSize+int(pos-start)
+	return r.seek(start)
+}
+
+func (r *NeoReader) seek(pos int64) error {
+	if _, err := r.src.(io.Seeker).Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	r.rd.Reset(r.src)
+	return nil
+}
+
+type nopStream struct{}
+
+func (nopStream) XORKeyStream(dst, src []byte) { copy(dst, src) }
+
+// ReadHeader parses the NEO header at the start of r.
+func ReadHeader(r io.Reader) (*NeoHeader, error) {
+	return NewNeoReader(r).Header()
+}
+
+// Header parses the NEO header if it has not been read yet and returns it.
+func (r *NeoReader) Header() (*NeoHeader, error) {
+	if r.NeoHeader == nil {
+		if err := r.readHeader(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return r.NeoHeader, nil
+}
+
+func (r *NeoReader) readHeader() error {
+	if _, err := io.ReadFull(r.rd, r.buf[:len(NeoMagicNumber)]); err != nil {
+		return err
+	}
+	var magic []byte
+	for _, m := range r.magics {
+		if bytes.Equal(r.buf[:len(NeoMagicNumber)], m) {
+			magic = m
+			break
+		}
+	}
+	if magic == nil && r.keyFunc == nil {
+		return ErrNotNEOHeader
+	}
+	hdrLen, n_, err := readVUint(r.rd)
+	if magic == nil {
+		// a stealth header made with a key of r.keyFunc, or not a header
+		if err != nil {
+			return ErrNotNEOHeader
+		}
+		salt, cost, ok := peekKDF(r.rd)
+		if !ok || !bytes.Equal(r.buf[:len(NeoMagicNumber)], StealthMagic(r.keyFunc(salt, cost))) {
+			return ErrNotNEOHeader
+		}
+		magic = append([]byte(nil), r.buf[:len(NeoMagicNumber)]...)
+	}
+	if err != nil {
+		return err
+	}
+	r.hdrSize = len(NeoMagicNumber) + n_ + hdrLen
+	if hdrLen > maxInlineHeader {
+		if pos, ok := r.tell(); ok {
+			return r.readLargeHeader(magic, hdrLen, pos)
+		}
+	}
+	hdr := append(append([]byte(nil), magic...), encodeVUint(uint(hdrLen))...)
+	if hdrLen > maxInlineHeader {
+		// grows with what is actually there instead of trusting hdrLen
+		b, err := io.ReadAll(io.LimitReader(r.rd, int64(hdrLen)))
+		if err != nil {
+			return err
+		}
+		if len(b) < hdrLen {
+			return io.ErrUnexpectedEOF
+		}
+		hdr = append(hdr, b...)
+	} else {
+		if len(r.buf) >= r.hdrSize {
+			hdr = append(r.buf[:0], hdr...)[:r.hdrSize]
+		} else {
+			hdr = append(hdr, make([]byte, hdrLen)...)
+		}
+		if _, err := io.ReadFull(r.rd, hdr[len(NeoMagicNumber)+n_:]); err != nil {
+			return err
+		}
+	}
+	neoHdr := &NeoHeader{MasterKey: r.master, keyFunc: r.keyFunc}
+	if err := neoHdr.unmarshall(hdr, r.Strict); err != nil {
+		return err
+	}
+	r.NeoHeader = neoHdr
+	r.origLen = len(neoHdr.OriginalHeader)
+	if neoHdr.OriginalHeaderEncMethod == XorRecords {
+		r.origLen = -1
+		if pos, ok := r.tell(); ok {
+			return r.scanRecords(pos)
+		}
+	}
+	return nil
+}
+
+// tell returns the position of the next byte of r.rd in the input, if the
+// input can seek.
+func (r *NeoReader) tell() (int64, bool) {
+	s, ok := r.src.(io.Seeker)
+	if !ok {
+		return 0, false
+	}
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, false
+	}
+	return pos - int64(r.rd.Buffered()), true
+}
+
+// readLargeHeader parses a header whose original header is left in the
+// input, pos is where the header after its length starts. The rest of the
+// header is parsed as if the original header were empty.
+func (r *NeoReader) readLargeHeader(magic []byte, hdrLen int, pos int64) error {
+	before := new(bytes.Buffer)
+	flag, err := r.rd.ReadByte()
+	if err != nil {
+		return err
+	}
+	before.WriteByte(flag)
+	keyedSize := 0
+	kdf := flag&FlagKeyed != 0 || !bytes.Equal(magic, NeoMagicNumber)
+	sections := 0
+	if flag&FlagKeyed != 0 {
+		// the salt and the key slots
+		sections = 2
+	}
+	if kdf {
+		// the key derivation salt, the cost follows it
+		sections++
+	}
+	for i := 0; i < sections; i++ {
+		l, n, err := readVUint(r.rd)
+		if err != nil {
+			return err
+		}
+		if l > hdrLen {
+			return ErrNotNEOHeader
+		}
+		b := make([]byte, l)
+		if _, err := io.ReadFull(r.rd, b); err != nil {
+			return err
+		}
+		before.Write(encodeVUint(uint(l)))
+		before.Write(b)
+		keyedSize += n + l
+		if i == 0 && kdf {
+			cost, err := r.rd.ReadByte()
+			if err != nil {
+				return err
+			}
+			before.WriteByte(cost)
+			keyedSize++
+		}
+	}
+	method, err := r.rd.ReadByte()
+	if err != nil {
+		return err
+	}
+	if method != XorEnc {
+		return ErrUnknownCryptoMethod
+	}
+	before.WriteByte(method)
+	keyLen, n, err := readVUint(r.rd)
+	if err != nil {
+		return err
+	}
+	if keyLen > hdrLen {
+		return ErrNotNEOHeader
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(r.rd, key); err != nil {
+		return err
+	}
+	origLen, m, err := readVUint(r.rd)
+	if err != nil {
+		return err
+	}
+	before.Write(encodeVUint(uint(keyLen)))
+	before.Write(key)
+	before.Write(encodeVUint(0))
+	rest := hdrLen - (2 + keyedSize + n + keyLen + m) - origLen
+	if rest < 0 {
+		return ErrNotNEOHeader
+	}
+	r.origPos = pos + int64(2+keyedSize+n+keyLen+m)
+	r.bodyPos = r.origPos + int64(origLen) + int64(rest)
+	if err := r.seek(r.origPos + int64(origLen)); err != nil {
+		return err
+	}
+	after, err := io.ReadAll(io.LimitReader(r.rd, int64(rest)))
+	if err != nil {
+		return err
+	}
+	if len(after) < rest {
+		return io.ErrUnexpectedEOF
+	}
+
+	hdr := append([]byte(nil), magic...)
+	hdr = append(hdr, encodeVUint(uint(before.Len()+len(after)))...)
+	hdr = append(hdr, before.Bytes()...)
+	hdr = append(hdr, after...)
+	neoHdr := &NeoHeader{MasterKey: r.master, keyFunc: r.keyFunc, outsideLen: origLen}
+	if err := neoHdr.unmarshall(hdr, r.Strict); err != nil {
+		return err
+	}
+	neoHdr.OriginalHeader = nil
+	if neoHdr.alternate != nil {
+		neoHdr.alternate.OriginalHeader = nil
+	}
+	r.NeoHeader = neoHdr
+	r.origLen, r.origKey = origLen, xorKey(neoHdr.fileKey, key)
+	return nil
+}
+
+func readVUint(rd io.ByteReader) (v, n int, err error) {
+	for {
+		b, err := rd.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		v += int(b)
+		n++
+		if b != 0xFF {
+			return v, n, nil
+		}
+	}
+}
+
+// UseAlternate switches to the Alternate reading of the header, it must be
+// called before any content is read and reports whether there is one.
+func (r *NeoReader) UseAlternate() bool {
+	if r.NeoHeader == nil || r.NeoHeader.alternate == nil || r.n > 0 {
+		return false
+	}
+	r.NeoHeader = r.NeoHeader.alternate
+	return true
+}
+
+// OriginalHeaderLen returns the length of the original header. Original
+// headers over 1 MiB in an input that can seek and XorRecords are decrypted
+// while reading and are not in NeoHeader.OriginalHeader. It is -1 for
+// XorRecords in an input that cannot seek until they have been read.
+func (r *NeoReader) OriginalHeaderLen() int {
+	return r.origLen
+}
+
+// HeaderSize returns how many bytes the NEO header takes at the start of the
+// input, the original content follows it. XorRecords count as part of the
+// header. It is 0 before Header is read.
+func (r *NeoReader) HeaderSize() int {
+	return r.hdrSize
+}
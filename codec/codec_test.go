@@ -0,0 +1,657 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestVint(t *testing.T) {
+	for i := 0; i <= 1<<16; i++ {
+		v := encodeVUint(uint(i))
+		ui, p := decodeVUint(v)
+		if len(p) != 0 {
+			t.Fatal("len(p) != 0")
+		}
+		if ui != uint(i) {
+			t.Fatalf("except %d, but %d", i, ui)
+		}
+	}
+}
+
+func TestNeoHeader_Marshall(t *testing.T) {
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21, 0x1a, 0x07, 0x01, 0x00},
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "这是压缩文件❤️.rar",
+		Crc32:                     6655,
+	}
+	b, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%x", b)
+	hdr_ := new(NeoHeader)
+	if err := hdr_.UnMarshall(b); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%+#v", hdr_)
+}
+
+func TestNeoHeader_Extensions(t *testing.T) {
+	sum := sha256.Sum256([]byte("neo"))
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21},
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.rar",
+		Crc32:                     6655,
+		SHA256:                    sum[:],
+		XXH64:                     []byte{1, 2, 3, 4, 5, 6, 7, 8},
+		Owner:                     &Owner{UID: 1000, GID: 100},
+		Hint:                      "a….rar",
+		Media:                     &Media{Width: 1920, Height: 1080, Duration: 90 * time.Second, Taken: 1700000000},
+	}
+	b, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr_ := new(NeoHeader)
+	if err := hdr_.UnMarshall(b); err != nil {
+		t.Fatal(err)
+	}
+	if hdr_.Crc32 != hdr.Crc32 || !bytes.Equal(hdr_.SHA256, sum[:]) || !bytes.Equal(hdr_.XXH64, hdr.XXH64) || *hdr_.Owner != *hdr.Owner || hdr_.Hint != hdr.Hint || *hdr_.Media != *hdr.Media {
+		t.Fatalf("unexpected header %+v", hdr_)
+	}
+
+	// unknown extensions are skipped
+	hdr.SHA256, hdr.XXH64, hdr.Owner, hdr.Hint, hdr.Media = nil, nil, nil, "", nil
+	b, _ = hdr.Marshall()
+	buf := bytes.NewBuffer(b[:4])
+	body := append(append([]byte{}, b[5:]...), 0x7F, 2, 0xAA, 0xBB)
+	buf.Write(encodeVUint(uint(len(body))))
+	buf.Write(body)
+	hdr_ = new(NeoHeader)
+	if err := hdr_.UnMarshall(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if hdr_.OriginalFilename != "a.rar" || hdr_.SHA256 != nil {
+		t.Fatalf("unexpected header %+v", hdr_)
+	}
+}
+
+func TestNeoHeader_EncryptedMeta(t *testing.T) {
+	sum := sha256.Sum256([]byte("neo"))
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21},
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.rar",
+		Crc32:                     0xDEADBEEF,
+		SHA256:                    sum[:],
+		EncryptedMeta:             true,
+	}
+	b, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte{0xDE, 0xAD, 0xBE, 0xEF}) || bytes.Contains(b, sum[:]) {
+		t.Fatal("metadata stored in clear")
+	}
+	hdr_ := new(NeoHeader)
+	if err := hdr_.UnMarshall(b); err != nil {
+		t.Fatal(err)
+	}
+	if !hdr_.EncryptedMeta || hdr_.Crc32 != hdr.Crc32 || !bytes.Equal(hdr_.SHA256, sum[:]) {
+		t.Fatalf("unexpected header %+v", hdr_)
+	}
+}
+
+func TestNeoHeader_HeaderCRC(t *testing.T) {
+	for _, meta := range []bool{false, true} {
+		hdr := &NeoHeader{
+			Version:                   VersionV1,
+			OriginalHeaderEncMethod:   XorEnc,
+			OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21},
+			OriginalFilenameEncMethod: XorEnc,
+			OriginalFilename:          "a.rar",
+			Crc32:                     0xDEADBEEF,
+			Hint:                      "a….rar",
+			EncryptedMeta:             meta,
+			HeaderCRC:                 true,
+		}
+		b, err := hdr.Marshall()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr_ := new(NeoHeader)
+		if err := hdr_.UnMarshallStrict(b); err != nil || !hdr_.HeaderCRC {
+			t.Fatalf("meta %v: %v %+v", meta, err, hdr_)
+		}
+		for _, i := range []int{5, len(b) - 1} {
+			bad := append([]byte(nil), b...)
+			bad[i] ^= 0x40
+			if err := new(NeoHeader).UnMarshall(bad); !errors.Is(err, ErrHeaderCorrupt) {
+				t.Errorf("meta %v, byte %d: except ErrHeaderCorrupt, but %v", meta, i, err)
+			}
+		}
+	}
+
+	content := make([]byte, maxInlineHeader+100)
+	rand.Read(content)
+	buf := new(bytes.Buffer)
+	w := NewNeoWriterWithHeader(buf, maxInlineHeader+1, &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.bin",
+		Crc32:                     crc32.ChecksumIEEE(content),
+		HeaderCRC:                 true,
+	})
+	w.SetSize(int64(len(content)))
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	rd := NewNeoReader(bytes.NewReader(buf.Bytes()))
+	if hdr, err := rd.Header(); err != nil || !hdr.HeaderCRC {
+		t.Fatalf("records: %v %+v", err, hdr)
+	}
+
+	// an original header over 1 MiB is left in the input
+	b, err := (&NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            content[:maxInlineHeader+1],
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.bin",
+		HeaderCRC:                 true,
+	}).Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd = NewNeoReader(bytes.NewReader(b))
+	if hdr, err := rd.Header(); err != nil || !hdr.HeaderCRC || rd.OriginalHeaderLen() != maxInlineHeader+1 {
+		t.Fatalf("large: %v %+v", err, hdr)
+	}
+}
+
+func TestNeoHeader_Keyed(t *testing.T) {
+	master := []byte("master key")
+	content := make([]byte, maxInlineHeader+100)
+	rand.Read(content)
+	encode := func(hdrLen int, marshall bool) []byte {
+		hdr := &NeoHeader{
+			Version:                   VersionV1,
+			OriginalHeaderEncMethod:   XorEnc,
+			OriginalFilenameEncMethod: XorEnc,
+			OriginalFilename:          "a.bin",
+			Crc32:                     crc32.ChecksumIEEE(content),
+			EncryptedMeta:             true,
+			HeaderCRC:                 true,
+			MasterKey:                 master,
+		}
+		if marshall {
+			// an original header over 1 MiB in one XorEnc field
+			hdr.OriginalHeader = content[:hdrLen]
+			b, err := hdr.Marshall()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return append(b, content[hdrLen:]...)
+		}
+		buf := new(bytes.Buffer)
+		w := NewNeoWriterWithHeader(buf, hdrLen, hdr)
+		w.Write(content)
+		w.Close()
+		return buf.Bytes()
+	}
+	for name, b := range map[string][]byte{
+		"inline":  encode(8, false),
+		"records": encode(maxInlineHeader+1, false),
+		"large":   encode(maxInlineHeader+1, true),
+	} {
+		var idErr *KeyIDError
+		if _, err := NewNeoReader(bytes.NewReader(b)).Header(); !errors.Is(err, ErrNoMasterKey) ||
+			!errors.As(err, &idErr) || !bytes.Equal(idErr.KeyIDs[0], KeyID(master)) {
+			t.Fatalf("%s: except ErrNoMasterKey with the key id, but %v", name, err)
+		}
+		rd := NewNeoReader(bytes.NewReader(b))
+		rd.SetMasterKey([]byte("another key"))
+		if _, err := rd.Header(); !errors.Is(err, ErrWrongMasterKey) {
+			t.Fatalf("%s: except ErrWrongMasterKey, but %v", name, err)
+		}
+		rd = NewNeoReader(bytes.NewReader(b))
+		rd.SetMasterKey(master)
+		got, err := ioutil.ReadAll(rd)
+		if err != nil || !bytes.Equal(got, content) || rd.NeoHeader.OriginalFilename != "a.bin" || len(rd.NeoHeader.Salt) != SaltSize {
+			t.Fatalf("%s: unexpected result %v %+v", name, err, rd.NeoHeader)
+		}
+		if bytes.Contains(b[:100], []byte(master)) {
+			t.Fatalf("%s: master key in the header", name)
+		}
+	}
+	if bytes.Equal(SlotKey(master, []byte("a")), SlotKey(master, []byte("b"))) {
+		t.Fatal("except files with other salts to get other keys")
+	}
+
+	// a second slot, the header is rewritten with the same file key
+	b := encode(8, false)
+	rd := NewNeoReader(bytes.NewReader(b))
+	rd.SetMasterKey(master)
+	hdr, err := rd.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := []byte("second key")
+	if err := hdr.AddKeySlot(second); err != nil {
+		t.Fatal(err)
+	}
+	hdr.OriginalHeader = content[:8]
+	nb, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range [][]byte{master, second} {
+		rd := NewNeoReader(bytes.NewReader(append(nb, content[8:]...)))
+		rd.SetMasterKey(k)
+		if got, err := ioutil.ReadAll(rd); err != nil || !bytes.Equal(got, content) {
+			t.Fatalf("key %q: %v", k, err)
+		}
+	}
+	if ok, err := hdr.RemoveKeySlot(KeyID(master)); !ok || err != nil {
+		t.Fatalf("RemoveKeySlot() = %v, %v", ok, err)
+	}
+	if _, err := hdr.RemoveKeySlot(KeyID(second)); err != ErrLastKeySlot {
+		t.Fatalf("except ErrLastKeySlot, but %v", err)
+	}
+}
+
+func TestNeoReader_HeaderSize(t *testing.T) {
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21},
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.rar",
+	}
+	b, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := NewNeoReader(bytes.NewReader(append(b, "payload"...)))
+	if _, err := rd.Header(); err != nil {
+		t.Fatal(err)
+	}
+	if rd.HeaderSize() != len(b) {
+		t.Fatalf("except %d, but %d", len(b), rd.HeaderSize())
+	}
+}
+
+func TestStealthMagic(t *testing.T) {
+	key := []byte("secret")
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.rar",
+		Magic:                     StealthMagic(key),
+	}
+	b, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(b, NeoMagicNumber) {
+		t.Fatal("stealth header starts with the NEO magic")
+	}
+	if _, err := ReadHeader(bytes.NewReader(b)); err != ErrNotNEOHeader {
+		t.Fatalf("except ErrNotNEOHeader, but %v", err)
+	}
+	if _, err := NewStealthNeoReader(bytes.NewReader(b), []byte("wrong")).Header(); err != ErrNotNEOHeader {
+		t.Fatalf("except ErrNotNEOHeader, but %v", err)
+	}
+	hdr_, err := NewStealthNeoReader(bytes.NewReader(b), key).Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr_.OriginalFilename != "a.rar" {
+		t.Fatalf("unexpected header %+v", hdr_)
+	}
+	// regular files still work with a key
+	hdr.Magic = nil
+	b, _ = hdr.Marshall()
+	if _, err := NewStealthNeoReader(bytes.NewReader(b), key).Header(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewNeoWriter(t *testing.T) {
+	testFilename := path.Join(t.TempDir(), "test.bin")
+	var crc32_ uint32
+	func() {
+		fd, err := os.OpenFile(testFilename, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		h := crc32.NewIEEE()
+		if _, err := io.CopyN(fd, io.TeeReader(rand.Reader, h), 128); err != nil {
+			t.Fatal(err)
+		}
+		crc32_ = h.Sum32()
+	}()
+	buf := new(bytes.Buffer)
+	func() {
+		fd, err := os.Open(testFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		w := NewNeoWriter(buf, 32, path.Base(testFilename), crc32_)
+		if _, err := io.Copy(w, fd); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("%x", buf.Bytes())
+	}()
+
+	func() {
+		rd := NewNeoReader(buf)
+		b, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crc32_ := crc32.ChecksumIEEE(b)
+		if crc32_ != rd.NeoHeader.Crc32 {
+			t.Fatalf("crc check failed, except: %d but: %d", rd.NeoHeader.Crc32, crc32_)
+		}
+		t.Logf("%#v", rd.NeoHeader)
+	}()
+
+}
+
+// onlyReader hides the Seek of a bytes.Reader.
+type onlyReader struct{ io.Reader }
+
+func TestNeoWriter_Short(t *testing.T) {
+	for _, n := range []int{0, 1, 8, 9, 100} {
+		content := make([]byte, n)
+		rand.Read(content)
+		for _, size := range []int64{-1, int64(n)} {
+			buf := new(bytes.Buffer)
+			w := NewNeoWriter(buf, 8, "a.bin", crc32.ChecksumIEEE(content))
+			if size >= 0 {
+				w.SetSize(size)
+			}
+			// one byte at a time
+			for i := range content {
+				if _, err := w.Write(content[i : i+1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(NewNeoReader(buf))
+			if err != nil {
+				t.Fatalf("len %d: %v", n, err)
+			}
+			if !bytes.Equal(b, content) {
+				t.Fatalf("len %d, size %d: content mismatch", n, size)
+			}
+		}
+	}
+
+	w := NewNeoWriter(new(bytes.Buffer), 8, "a.bin", 0)
+	w.SetSize(8)
+	w.Write([]byte{1, 2, 3})
+	if err := w.Close(); err != io.ErrShortWrite {
+		t.Fatalf("except ErrShortWrite, but %v", err)
+	}
+}
+
+func TestNeoReader_LargeHeader(t *testing.T) {
+	hdrLen := maxInlineHeader + 12345
+	content := make([]byte, hdrLen+chunkSize+7)
+	rand.Read(content)
+	b, err := (&NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            content[:hdrLen],
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.bin",
+		Crc32:                     crc32.ChecksumIEEE(content),
+	}).Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := append(b, content[hdrLen:]...)
+
+	rd := NewNeoReader(bytes.NewReader(encoded))
+	hdr, err := rd.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.OriginalHeader != nil || rd.OriginalHeaderLen() != hdrLen || rd.HeaderSize() != len(b) {
+		t.Fatalf("original header kept in memory, len %d", len(hdr.OriginalHeader))
+	}
+	if hdr.OriginalFilename != "a.bin" {
+		t.Fatalf("unexpected header %+v", hdr)
+	}
+	got, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("content mismatch")
+	}
+
+	// without Seek the original header is read into memory
+	rd = NewNeoReader(onlyReader{bytes.NewReader(encoded)})
+	got, err = ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) || len(rd.NeoHeader.OriginalHeader) != hdrLen {
+		t.Fatal("content mismatch")
+	}
+
+	// cut inside the original header
+	rd = NewNeoReader(bytes.NewReader(encoded[:len(b)-hdrLen/2]))
+	if _, err := rd.Header(); err == nil {
+		t.Fatal("truncated header parsed")
+	}
+}
+
+func TestNeoWriter_Records(t *testing.T) {
+	hdrLen := maxInlineHeader + 12345
+	content := make([]byte, hdrLen+recordSize+7)
+	rand.Read(content)
+	for _, n := range []int{len(content), hdrLen, 100} {
+		content := content[:n]
+		for _, size := range []int64{-1, int64(n)} {
+			buf := new(bytes.Buffer)
+			w := NewNeoWriter(buf, hdrLen, "a.bin", crc32.ChecksumIEEE(content))
+			if size >= 0 {
+				w.SetSize(size)
+			}
+			if _, err := io.Copy(w, bytes.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			encoded := buf.Bytes()
+			origLen := hdrLen
+			if n < hdrLen {
+				origLen = n
+			}
+
+			rd := NewNeoReader(bytes.NewReader(encoded))
+			hdr, err := rd.Header()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.OriginalHeaderEncMethod != XorRecords || rd.OriginalHeaderLen() != origLen ||
+				rd.HeaderSize() != len(encoded)-n+origLen {
+				t.Fatalf("len %d: unexpected header %+v, %d", n, hdr, rd.OriginalHeaderLen())
+			}
+			got, err := ioutil.ReadAll(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("len %d: content mismatch", n)
+			}
+
+			// without Seek the length is known once the records are read
+			rd = NewNeoReader(onlyReader{bytes.NewReader(encoded)})
+			if _, err := rd.Header(); err != nil || rd.OriginalHeaderLen() != -1 {
+				t.Fatalf("len %d: %d, %v", n, rd.OriginalHeaderLen(), err)
+			}
+			got, err = ioutil.ReadAll(rd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) || rd.OriginalHeaderLen() != origLen || rd.HeaderSize() != len(encoded)-n+origLen {
+				t.Fatalf("len %d: content mismatch", n)
+			}
+		}
+	}
+
+	// cut inside the records
+	buf := new(bytes.Buffer)
+	w := NewNeoWriter(buf, hdrLen, "a.bin", 0)
+	w.Write(content)
+	w.Close()
+	cut := buf.Bytes()[:hdrLen/2]
+	if _, err := NewNeoReader(bytes.NewReader(cut)).Header(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("except ErrUnexpectedEOF, but %v", err)
+	}
+	if _, err := ioutil.ReadAll(NewNeoReader(onlyReader{bytes.NewReader(cut)})); err != io.ErrUnexpectedEOF {
+		t.Fatalf("except ErrUnexpectedEOF, but %v", err)
+	}
+}
+
+func TestNeoHeader_Strict(t *testing.T) {
+	build := func(flag byte, tail ...byte) []byte {
+		body := []byte{flag, XorEnc, 0, 4, 'R', 'a', 'r', '!', XorEnc, 1, 0x20, 5, 'A', 0x0E, 'R', 'A', 'R', 0, 0, 0x1A, 0x07}
+		body = append(body, tail...)
+		return append(append(append([]byte{}, NeoMagicNumber...), encodeVUint(uint(len(body)))...), body...)
+	}
+	for name, b := range map[string][]byte{
+		"zero length key":    build(VersionV1),
+		"truncated ext":      build(VersionV1, ExtSHA256, 32, 1, 2, 3),
+		"duplicate ext":      build(VersionV1, 0x7F, 1, 0, 0x7F, 1, 0),
+		"bytes after header": append(build(VersionV1), 0xAA),
+	} {
+		hdr := new(NeoHeader)
+		if err := hdr.UnMarshall(b); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if hdr.OriginalFilename != "a.rar" || hdr.Crc32 != 0x1A07 || len(hdr.Warnings) == 0 {
+			t.Fatalf("%s: unexpected header %+v", name, hdr)
+		}
+		if err := new(NeoHeader).UnMarshallStrict(b); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("%s: except ErrMalformed, but %v", name, err)
+		}
+	}
+
+	// every truncation fails cleanly instead of panicking
+	b, _ := (&NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21},
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.rar",
+		EncryptedMeta:             true,
+	}).Marshall()
+	for i := 0; i < len(b); i++ {
+		if _, err := ReadHeader(bytes.NewReader(b[:i])); err == nil {
+			t.Fatalf("truncated at %d: except an error", i)
+		}
+	}
+}
+
+func TestNeoHeader_LegacyXor(t *testing.T) {
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21},
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "data.rar",
+		Crc32:                     6655,
+	}
+	b, _ := hdr.Marshall()
+	hdr_ := new(NeoHeader)
+	if err := hdr_.UnMarshall(b); err != nil || hdr_.Legacy || hdr_.Alternate() != nil || hdr_.OriginalFilename != "data.rar" {
+		t.Fatalf("unexpected header %+v %v", hdr_, err)
+	}
+	hdr.Legacy = true
+	b, _ = hdr.Marshall()
+	hdr_ = new(NeoHeader)
+	if err := hdr_.UnMarshall(b); err != nil || !hdr_.Legacy || hdr_.OriginalFilename != "data.rar" {
+		t.Fatalf("unexpected header %+v %v", hdr_, err)
+	}
+
+	// the cycling stream without FlagXorStream, as some old writers did
+	hdr.Legacy = false
+	for _, c := range []struct {
+		key       []byte
+		ambiguous bool
+	}{
+		{[]byte{0x40, 0x41, 0x42, 0x43}, true},
+		{[]byte{0x40, 0xC1, 0x92, 0xE3}, false},
+	} {
+		b, _ = hdr.marshall(func() ([]byte, error) { return c.key, nil })
+		b[5] &^= FlagXorStream
+		hdr_ = new(NeoHeader)
+		if err := hdr_.UnMarshall(b); err != nil || hdr_.Alternate() == nil {
+			t.Fatalf("%x: unexpected header %+v %v", c.key, hdr_, err)
+		}
+		// a plausible filename can only be told apart by the checksums
+		got := hdr_
+		if c.ambiguous {
+			got = hdr_.Alternate()
+		}
+		if got.OriginalFilename != "data.rar" || !bytes.Equal(got.OriginalHeader, hdr.OriginalHeader) {
+			t.Fatalf("%x: unexpected header %+v", c.key, got)
+		}
+	}
+}
+
+func TestNeoHeader_NoChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("neo"))
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21},
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.rar",
+		Crc32:                     6655,
+		SHA256:                    sum[:],
+		NoChecksum:                true,
+	}
+	b, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr_ := new(NeoHeader)
+	if err := hdr_.UnMarshallStrict(b); err != nil {
+		t.Fatal(err)
+	}
+	if !hdr_.NoChecksum || hdr_.Crc32 != 0 || hdr_.SHA256 != nil {
+		t.Fatalf("unexpected header %+v", hdr_)
+	}
+}
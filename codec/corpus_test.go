@@ -0,0 +1,67 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCorpus decodes the files every released format version produced, new
+// ones are added with neo spec -corpus codec/testdata/corpus.
+func TestCorpus(t *testing.T) {
+	manifests, err := filepath.Glob(filepath.Join("testdata", "corpus", "v*", "corpus.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) == 0 {
+		t.Fatal("no corpus found")
+	}
+	for _, manifest := range manifests {
+		b, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var entries []struct {
+			File     string `json:"file"`
+			Filename string `json:"filename"`
+			SHA256   string `json:"sha256"`
+		}
+		if err := json.Unmarshal(b, &entries); err != nil {
+			t.Fatalf("%s: %v", manifest, err)
+		}
+		for _, e := range entries {
+			path := filepath.Join(filepath.Dir(manifest), e.File)
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rd := NewNeoReader(f)
+			hdr, err := rd.Header()
+			if err != nil {
+				f.Close()
+				t.Fatalf("%s: %v", path, err)
+			}
+			content, err := ioutil.ReadAll(rd)
+			f.Close()
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			sum := sha256.Sum256(content)
+			if hdr.OriginalFilename != e.Filename || hex.EncodeToString(sum[:]) != e.SHA256 {
+				t.Fatalf("%s: unexpected result %q %x", path, hdr.OriginalFilename, sum)
+			}
+			if crc32.ChecksumIEEE(content) != hdr.Crc32 {
+				t.Fatalf("%s: crc32 mismatch", path)
+			}
+			if hdr.SHA256 != nil && !bytes.Equal(hdr.SHA256, sum[:]) {
+				t.Fatalf("%s: sha256 mismatch", path)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+const (
+	// KDFSaltSize is the length of the salt DeriveKey is used with, readers
+	// only look for stealth headers with salts of this length.
+	KDFSaltSize = 16
+	// DefaultKDFCost makes DeriveKey take about a tenth of a second.
+	DefaultKDFCost = 19
+	// MaxKDFCost is the largest cost read from a header, it bounds the work
+	// a crafted file can ask for.
+	MaxKDFCost = 22
+)
+
+// KeyFunc derives the key of a header from the salt and the cost of its
+// key derivation section, see DeriveKey.
+type KeyFunc func(salt []byte, cost uint8) []byte
+
+// DeriveKey stretches secret with PBKDF2-HMAC-SHA256 over 1<<cost
+// iterations into the key stealth magics and keyed headers are made with.
+// The salt is stored in the header, so every guess at a password costs the
+// iterations for each file it is tested on.
+func DeriveKey(secret, salt []byte, cost uint8) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < 1<<cost; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// parseKDF reads the key derivation section and derives the master key of
+// keyed headers with h.keyFunc if none is set.
+func (h *NeoHeader) parseKDF(hp *headerParser, keyed bool) error {
+	saltLen, err := hp.vuint()
+	if err != nil {
+		return err
+	}
+	salt, err := hp.take(saltLen)
+	if err != nil {
+		return err
+	}
+	if h.KDFCost, err = hp.byte(); err != nil {
+		return err
+	}
+	h.KDFSalt = append([]byte(nil), salt...)
+	if keyed && h.MasterKey == nil && h.keyFunc != nil {
+		if h.KDFCost > MaxKDFCost {
+			return fmt.Errorf("%w: key derivation cost %d", ErrMalformed, h.KDFCost)
+		}
+		h.MasterKey = h.keyFunc(h.KDFSalt, h.KDFCost)
+	}
+	return nil
+}
+
+// peekKDF returns the key derivation parameters of a stealth header
+// without consuming them, rd is past the length of the header. Salts of
+// other lengths and costs above MaxKDFCost are not from a stealth header
+// this package wrote.
+func peekKDF(rd *bufio.Reader) (salt []byte, cost uint8, ok bool) {
+	// flag, salt length, salt and cost
+	b, err := rd.Peek(3 + KDFSaltSize)
+	if err != nil || b[0]&FlagVersion != VersionV1 || b[1] != KDFSaltSize || b[2+KDFSaltSize] > MaxKDFCost {
+		return nil, 0, false
+	}
+	return append([]byte(nil), b[2:2+KDFSaltSize]...), b[2+KDFSaltSize], true
+}
+
+// Stealth is the start of a header that may be a stealth one: its magic
+// number and key derivation parameters.
+type Stealth struct {
+	Magic []byte
+	Salt  []byte
+	Cost  uint8
+}
+
+// ReadStealth reads the start of a header with another magic number than
+// NeoMagicNumber, it returns ErrNotNEOHeader for regular headers and for
+// data that cannot be a stealth header.
+func ReadStealth(r io.Reader) (*Stealth, error) {
+	rd := bufio.NewReader(r)
+	magic := make([]byte, len(NeoMagicNumber))
+	if _, err := io.ReadFull(rd, magic); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(magic, NeoMagicNumber) {
+		return nil, ErrNotNEOHeader
+	}
+	if _, _, err := readVUint(rd); err != nil {
+		return nil, err
+	}
+	salt, cost, ok := peekKDF(rd)
+	if !ok {
+		return nil, ErrNotNEOHeader
+	}
+	return &Stealth{Magic: magic, Salt: salt, Cost: cost}, nil
+}
+
+// Match reports whether the header was made with the key f derives.
+func (s *Stealth) Match(f KeyFunc) bool {
+	return bytes.Equal(s.Magic, StealthMagic(f(s.Salt, s.Cost)))
+}
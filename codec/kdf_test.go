@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 with 4096 iterations
+	want := "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"
+	if got := hex.EncodeToString(DeriveKey([]byte("password"), []byte("salt"), 12)); got != want {
+		t.Fatalf("except %s, but %s", want, got)
+	}
+}
+
+func TestNeoReader_KeyFunc(t *testing.T) {
+	secret := []byte("secret")
+	salt := bytes.Repeat([]byte{7}, KDFSaltSize)
+	keyFunc := func(secret []byte) KeyFunc {
+		return func(salt []byte, cost uint8) []byte { return DeriveKey(secret, salt, cost) }
+	}
+	k := DeriveKey(secret, salt, 4)
+	hdr := &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          "a.rar",
+		Magic:                     StealthMagic(k),
+		MasterKey:                 k,
+		KDFSalt:                   salt,
+		KDFCost:                   4,
+	}
+	b, err := hdr.Marshall()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := NewNeoReader(bytes.NewReader(b))
+	rd.SetKeyFunc(keyFunc([]byte("wrong")))
+	if _, err := rd.Header(); err != ErrNotNEOHeader {
+		t.Fatalf("except ErrNotNEOHeader, but %v", err)
+	}
+	rd = NewNeoReader(bytes.NewReader(b))
+	rd.SetKeyFunc(keyFunc(secret))
+	hdr_, err := rd.Header()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr_.OriginalFilename != "a.rar" || !bytes.Equal(hdr_.KDFSalt, salt) || hdr_.KDFCost != 4 {
+		t.Fatalf("unexpected header %+v", hdr_)
+	}
+
+	s, err := ReadStealth(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Match(keyFunc(secret)) || s.Match(keyFunc([]byte("wrong"))) {
+		t.Fatal("except the stealth header to match only its secret")
+	}
+
+	// keyed files with the regular magic derive the master key too
+	hdr.Magic = nil
+	b, _ = hdr.Marshall()
+	if _, err := ReadStealth(bytes.NewReader(b)); err != ErrNotNEOHeader {
+		t.Fatalf("except ErrNotNEOHeader, but %v", err)
+	}
+	rd = NewNeoReader(bytes.NewReader(b))
+	rd.SetKeyFunc(keyFunc([]byte("wrong")))
+	if _, err := rd.Header(); !errors.Is(err, ErrWrongMasterKey) {
+		t.Fatalf("except ErrWrongMasterKey, but %v", err)
+	}
+	rd = NewNeoReader(bytes.NewReader(b))
+	rd.SetKeyFunc(keyFunc(secret))
+	if _, err := rd.Header(); err != nil {
+		t.Fatal(err)
+	}
+}
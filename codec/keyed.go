@@ -0,0 +1,199 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+const (
+	// SaltSize is the length of the salt Marshall picks for keyed headers.
+	SaltSize = 16
+	// keySize is the length of file keys, slot keys and wrapped keys.
+	keySize   = sha256.Size
+	keyIDSize = 8
+)
+
+var (
+	// ErrNoMasterKey is returned for keyed headers read without a master
+	// key.
+	ErrNoMasterKey = errors.New("keyed header needs a master key")
+	// ErrWrongMasterKey is returned for keyed headers read with a master
+	// key none of their slots is for.
+	ErrWrongMasterKey = errors.New("keyed header needs another master key")
+	// ErrLastKeySlot is returned by RemoveKeySlot for the only slot.
+	ErrLastKeySlot = errors.New("last key slot")
+)
+
+// KeyIDError tells which master keys a keyed header can be read with.
+type KeyIDError struct {
+	KeyIDs [][]byte
+	Err    error
+}
+
+func (e *KeyIDError) Error() string {
+	return fmt.Sprintf("%v (key ids %x)", e.Err, e.KeyIDs)
+}
+
+func (e *KeyIDError) Unwrap() error {
+	return e.Err
+}
+
+// KeySlot is the file key of a keyed header XORed with the SlotKey of one
+// master key.
+type KeySlot struct {
+	KeyID   []byte
+	wrapped []byte
+}
+
+// KeyID is the fingerprint of a master key keyed headers record, it tells
+// which key a file needs without giving the key away.
+func KeyID(master []byte) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("neo key id"))
+	return mac.Sum(nil)[:keyIDSize]
+}
+
+// SlotKey derives the key that wraps the file key in the slot of a master
+// key with HKDF-SHA256 and the salt of the header, knowing it tells nothing
+// about the master key or the slot keys of other files.
+func SlotKey(master, salt []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(master)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte("neo file key"))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+func xorBytes(a, b []byte) []byte {
+	res := make([]byte, len(a))
+	for i := range a {
+		res[i] = a[i] ^ b[i]
+	}
+	return res
+}
+
+// newFileKey gives a header a new salt and file key with a slot for
+// h.MasterKey.
+func (h *NeoHeader) newFileKey() error {
+	if h.Salt == nil {
+		h.Salt = make([]byte, SaltSize)
+		if _, err := rand.Read(h.Salt); err != nil {
+			return err
+		}
+	}
+	h.fileKey = make([]byte, keySize)
+	if _, err := rand.Read(h.fileKey); err != nil {
+		return err
+	}
+	h.KeySlots = nil
+	return h.AddKeySlot(h.MasterKey)
+}
+
+// AddKeySlot lets master read the header too, the header must have been
+// read with one of its master keys.
+func (h *NeoHeader) AddKeySlot(master []byte) error {
+	if h.fileKey == nil {
+		return ErrNoMasterKey
+	}
+	id := KeyID(master)
+	for _, slot := range h.KeySlots {
+		if bytes.Equal(slot.KeyID, id) {
+			return nil
+		}
+	}
+	h.KeySlots = append(h.KeySlots, KeySlot{KeyID: id, wrapped: xorBytes(h.fileKey, SlotKey(master, h.Salt))})
+	return nil
+}
+
+// RemoveKeySlot removes the slot of the master key with the key id, it
+// reports whether there was one.
+func (h *NeoHeader) RemoveKeySlot(id []byte) (bool, error) {
+	for i, slot := range h.KeySlots {
+		if !bytes.Equal(slot.KeyID, id) {
+			continue
+		}
+		if len(h.KeySlots) == 1 {
+			return false, ErrLastKeySlot
+		}
+		h.KeySlots = append(h.KeySlots[:i:i], h.KeySlots[i+1:]...)
+		return true, nil
+	}
+	return false, nil
+}
+
+func marshallSlots(slots []KeySlot) []byte {
+	b := make([]byte, 0, len(slots)*(keyIDSize+keySize))
+	for _, slot := range slots {
+		b = append(append(b, slot.KeyID...), slot.wrapped...)
+	}
+	return b
+}
+
+// parseKeyed reads the salt and the key slots and unwraps the file key with
+// h.MasterKey.
+func (h *NeoHeader) parseKeyed(hp *headerParser) error {
+	saltLen, err := hp.vuint()
+	if err != nil {
+		return err
+	}
+	salt, err := hp.take(saltLen)
+	if err != nil {
+		return err
+	}
+	slotsLen, err := hp.vuint()
+	if err != nil {
+		return err
+	}
+	slots, err := hp.take(slotsLen)
+	if err != nil {
+		return err
+	}
+	if len(slots) == 0 || len(slots)%(keyIDSize+keySize) != 0 {
+		return fmt.Errorf("%w: bad key slots length %d", ErrMalformed, len(slots))
+	}
+	h.Salt = append([]byte(nil), salt...)
+	h.KeySlots = nil
+	var ids [][]byte
+	for ; len(slots) > 0; slots = slots[keyIDSize+keySize:] {
+		slot := KeySlot{
+			KeyID:   append([]byte(nil), slots[:keyIDSize]...),
+			wrapped: append([]byte(nil), slots[keyIDSize:keyIDSize+keySize]...),
+		}
+		h.KeySlots = append(h.KeySlots, slot)
+		ids = append(ids, slot.KeyID)
+	}
+	if h.MasterKey == nil {
+		return &KeyIDError{KeyIDs: ids, Err: ErrNoMasterKey}
+	}
+	id := KeyID(h.MasterKey)
+	for _, slot := range h.KeySlots {
+		if bytes.Equal(slot.KeyID, id) {
+			h.fileKey = xorBytes(slot.wrapped, SlotKey(h.MasterKey, h.Salt))
+			return nil
+		}
+	}
+	return &KeyIDError{KeyIDs: ids, Err: ErrWrongMasterKey}
+}
+
+// xorKey returns the key the XOR stream of a stored key uses. In keyed
+// headers the stored keys are only nonces mixed with the file key, without
+// a file key they are used as they are.
+func xorKey(fileKey, stored []byte) []byte {
+	if fileKey == nil || len(stored) == 0 {
+		return stored
+	}
+	mac := hmac.New(sha256.New, fileKey)
+	mac.Write(stored)
+	key := mac.Sum(nil)
+	for i := range key {
+		if key[i] == 0 {
+			key[i] = 0xA5
+		}
+	}
+	return key
+}
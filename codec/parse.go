@@ -0,0 +1,114 @@
+package codec
+
+import "fmt"
+
+// headerParser reads header fields from p, anomalies are errors in strict
+// mode and warnings otherwise. Data that cannot be read at all is always an
+// error.
+type headerParser struct {
+	p      []byte
+	strict bool
+	legacy bool
+	// fileKey is set for keyed headers, see xorKey
+	fileKey  []byte
+	warnings []string
+}
+
+func (hp *headerParser) anomaly(format string, a ...interface{}) error {
+	msg := fmt.Sprintf(format, a...)
+	if hp.strict {
+		return fmt.Errorf("%w: %s", ErrMalformed, msg)
+	}
+	hp.warnings = append(hp.warnings, msg)
+	return nil
+}
+
+func (hp *headerParser) byte() (byte, error) {
+	if len(hp.p) == 0 {
+		return 0, ErrNotNEOHeader
+	}
+	b := hp.p[0]
+	hp.p = hp.p[1:]
+	return b, nil
+}
+
+func (hp *headerParser) take(n uint) ([]byte, error) {
+	if n > uint(len(hp.p)) {
+		return nil, ErrNotNEOHeader
+	}
+	b := hp.p[:n]
+	hp.p = hp.p[n:]
+	return b, nil
+}
+
+func (hp *headerParser) vuint() (uint, error) {
+	var res uint
+	for {
+		v, err := hp.byte()
+		if err != nil {
+			return 0, err
+		}
+		res += uint(v)
+		if v != 0xFF {
+			return res, nil
+		}
+	}
+}
+
+// encrypted reads a method byte followed by content encrypted with it and
+// returns the decrypted content.
+func (hp *headerParser) encrypted() (method byte, content []byte, err error) {
+	if method, err = hp.byte(); err != nil {
+		return
+	}
+	if method != XorEnc {
+		return method, nil, ErrUnknownCryptoMethod
+	}
+	keyLen, err := hp.vuint()
+	if err != nil {
+		return
+	}
+	key, err := hp.take(keyLen)
+	if err != nil {
+		return
+	}
+	contentLen, err := hp.vuint()
+	if err != nil {
+		return
+	}
+	secContent, err := hp.take(contentLen)
+	if err != nil {
+		return
+	}
+	content = make([]byte, contentLen)
+	if len(key) == 0 {
+		// nothing to XOR with, the content was stored in clear
+		if err = hp.anomaly("zero length key"); err != nil {
+			return
+		}
+		copy(content, secContent)
+		return
+	}
+	newXorEncStream(xorKey(hp.fileKey, key), hp.legacy).XORKeyStream(content, secContent)
+	return
+}
+
+// key reads the key of XorRecords and returns the one the records are
+// XORed with.
+func (hp *headerParser) key() ([]byte, error) {
+	keyLen, err := hp.vuint()
+	if err != nil {
+		return nil, err
+	}
+	key, err := hp.take(keyLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		// nothing to XOR with, the records are in clear
+		if err := hp.anomaly("zero length key"); err != nil {
+			return nil, err
+		}
+	}
+	return xorKey(hp.fileKey, key), nil
+}
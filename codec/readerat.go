@@ -0,0 +1,196 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+)
+
+// NeoReaderAt reads the decoded content of a NEO file at any offset, it is
+// safe for concurrent use. The header is parsed on first use.
+type NeoReaderAt struct {
+	// Strict rejects headers with anomalies, see UnMarshallStrict. It must
+	// be set before the first call.
+	Strict bool
+
+	src     io.ReaderAt
+	size    int64
+	magics  [][]byte
+	keyFunc KeyFunc
+
+	once      sync.Once
+	err       error
+	NeoHeader *NeoHeader
+	hdrSize   int64
+	origLen   int
+	origKey   []byte
+	origPos   int64
+	records   []record
+}
+
+// record is one of the XorRecords, off is where it starts in the original
+// header and pos where its data starts in the input.
+type record struct {
+	off int
+	pos int64
+	n   int
+}
+
+// NewNeoReaderAt reads the NEO file held in the first size bytes of r.
+func NewNeoReaderAt(r io.ReaderAt, size int64) *NeoReaderAt {
+	return &NeoReaderAt{src: r, size: size, magics: [][]byte{NeoMagicNumber}}
+}
+
+// NewStealthNeoReaderAt reads stealth files made with key as well as
+// regular ones.
+func NewStealthNeoReaderAt(r io.ReaderAt, size int64, key []byte) *NeoReaderAt {
+	rd := NewNeoReaderAt(r, size)
+	rd.magics = append([][]byte{StealthMagic(key)}, rd.magics...)
+	return rd
+}
+
+// SetKeyFunc is NeoReader.SetKeyFunc, it must be called before the first
+// call.
+func (r *NeoReaderAt) SetKeyFunc(f KeyFunc) {
+	r.keyFunc = f
+}
+
+// Header parses the NEO header if it has not been read yet and returns it.
+func (r *NeoReaderAt) Header() (*NeoHeader, error) {
+	r.once.Do(func() { r.err = r.readHeader() })
+	return r.NeoHeader, r.err
+}
+
+func (r *NeoReaderAt) readHeader() error {
+	rd := NewNeoReader(io.NewSectionReader(r.src, 0, r.size))
+	rd.Strict, rd.magics = r.Strict, r.magics
+	rd.SetKeyFunc(r.keyFunc)
+	hdr, err := rd.Header()
+	if err != nil {
+		return err
+	}
+	r.hdrSize, r.origLen = int64(rd.HeaderSize()), rd.OriginalHeaderLen()
+	r.origKey, r.origPos = rd.origKey, rd.origPos
+	if hdr.OriginalHeaderEncMethod == XorRecords {
+		if err := r.indexRecords(rd.recPos); err != nil {
+			return err
+		}
+	}
+	r.NeoHeader = hdr
+	return nil
+}
+
+func (r *NeoReaderAt) indexRecords(pos int64) error {
+	off := 0
+	for {
+		var l [4]byte
+		if err := readFullAt(r.src, l[:], pos); err != nil {
+			return err
+		}
+		n := int(binary.BigEndian.Uint32(l[:]))
+		if n == 0 {
+			return nil
+		}
+		r.records = append(r.records, record{off: off, pos: pos + 4, n: n})
+		off += n
+		pos += 4 + int64(n)
+	}
+}
+
+// Size returns the length of the decoded content.
+func (r *NeoReaderAt) Size() (int64, error) {
+	if _, err := r.Header(); err != nil {
+		return 0, err
+	}
+	return r.size - r.hdrSize + int64(r.origLen), nil
+}
+
+func (r *NeoReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	size, err := r.Size()
+	if err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errors.New("codec.NeoReaderAt.ReadAt: negative offset")
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	if left := size - off; int64(len(p)) > left {
+		p = p[:left]
+		defer func() {
+			if err == nil {
+				err = io.EOF
+			}
+		}()
+	}
+	if off < int64(r.origLen) {
+		k := len(p)
+		if int64(k) > int64(r.origLen)-off {
+			k = int(int64(r.origLen) - off)
+		}
+		if err := r.readOriginalHeaderAt(p[:k], int(off)); err != nil {
+			return 0, err
+		}
+		n, off = k, off+int64(k)
+	}
+	if n == len(p) {
+		return n, nil
+	}
+	k, err := r.src.ReadAt(p[n:], r.hdrSize+off-int64(r.origLen))
+	if err == io.EOF && n+k == len(p) {
+		err = nil
+	}
+	return n + k, err
+}
+
+// readOriginalHeaderAt fills p from the original header at off, p does not go
+// past its end.
+func (r *NeoReaderAt) readOriginalHeaderAt(p []byte, off int) error {
+	hdr := r.NeoHeader
+	switch {
+	case hdr.OriginalHeaderEncMethod == XorRecords:
+		i := sort.Search(len(r.records), func(i int) bool { return r.records[i].off+r.records[i].n > off })
+		for done := 0; done < len(p); i++ {
+			if i >= len(r.records) {
+				return io.ErrUnexpectedEOF
+			}
+			rec := r.records[i]
+			at := off + done - rec.off
+			k := rec.n - at
+			if k > len(p)-done {
+				k = len(p) - done
+			}
+			if err := readFullAt(r.src, p[done:done+k], rec.pos+int64(at)); err != nil {
+				return err
+			}
+			done += k
+		}
+		// the records are one stream
+		xorStreamAt(hdr.recordKey, hdr.Legacy, off).XORKeyStream(p, p)
+		return nil
+	case r.origKey != nil:
+		if err := readFullAt(r.src, p, r.origPos+int64(off)); err != nil {
+			return err
+		}
+		xorStreamAt(r.origKey, hdr.Legacy, off).XORKeyStream(p, p)
+		return nil
+	default:
+		copy(p, hdr.OriginalHeader[off:])
+		return nil
+	}
+}
+
+// readFullAt is ReadAt where running into the end is an error.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
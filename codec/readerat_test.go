@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	mrand "math/rand"
+	"sync"
+	"testing"
+)
+
+func TestNeoReaderAt(t *testing.T) {
+	content, files := testEncodings(t)
+	for name, encoded := range files {
+		rd := NewNeoReaderAt(bytes.NewReader(encoded), int64(len(encoded)))
+		if size, err := rd.Size(); err != nil || size != int64(len(content)) {
+			t.Fatalf("%s: except %d, but %d %v", name, len(content), size, err)
+		}
+		var wg sync.WaitGroup
+		errs := make(chan string, 8)
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				rnd := mrand.New(mrand.NewSource(seed))
+				p := make([]byte, 3*chunkSize)
+				for i := 0; i < 30; i++ {
+					off := rnd.Int63n(int64(len(content)))
+					n, err := rd.ReadAt(p[:rnd.Intn(len(p))], off)
+					if err != nil && err != io.EOF {
+						errs <- err.Error()
+						return
+					}
+					if !bytes.Equal(p[:n], content[off:off+int64(n)]) {
+						errs <- "content mismatch"
+						return
+					}
+				}
+			}(int64(g))
+		}
+		wg.Wait()
+		close(errs)
+		for msg := range errs {
+			t.Fatalf("%s: %s", name, msg)
+		}
+
+		p := make([]byte, 10)
+		if n, err := rd.ReadAt(p, int64(len(content)-4)); n != 4 || err != io.EOF {
+			t.Fatalf("%s: except 4 and EOF at the end, but %d %v", name, n, err)
+		}
+	}
+
+	rd := NewNeoReaderAt(bytes.NewReader([]byte("not a neo file")), 14)
+	if _, err := rd.ReadAt(make([]byte, 1), 0); err != ErrNotNEOHeader {
+		t.Fatalf("except ErrNotNEOHeader, but %v", err)
+	}
+}
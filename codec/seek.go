@@ -0,0 +1,103 @@
+package codec
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrNotSeekable = errors.New("underlying reader cannot seek")
+
+// Seek moves to offset in the decoded content, the underlying reader must be
+// an io.Seeker. The header is read first if needed.
+func (r *NeoReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := r.src.(io.Seeker)
+	if !ok {
+		return 0, ErrNotSeekable
+	}
+	if _, err := r.Header(); err != nil {
+		return 0, err
+	}
+	if r.origLen < 0 {
+		// XorRecords whose length was not found, the input did not seek then
+		return 0, ErrNotSeekable
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		end, err := s.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		offset += end - int64(r.hdrSize) + int64(r.origLen)
+	default:
+		return 0, errors.New("codec.NeoReader.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("codec.NeoReader.Seek: negative position")
+	}
+	var err error
+	switch {
+	case offset >= int64(r.origLen):
+		r.n, r.recDone = r.origLen, true
+		err = r.seek(int64(r.hdrSize) + offset - int64(r.origLen))
+	case r.NeoHeader.OriginalHeaderEncMethod == XorRecords:
+		err = r.seekRecords(int(offset))
+	case r.origKey != nil:
+		r.n = int(offset)
+		r.origRead = xorStreamAt(r.origKey, r.NeoHeader.Legacy, r.n)
+		err = r.seek(r.origPos + offset)
+	default:
+		// in memory, the input continues with the body
+		r.n = int(offset)
+		err = r.seek(int64(r.hdrSize))
+	}
+	if err != nil {
+		return 0, err
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// seekRecords finds the record holding offset of the original header.
+func (r *NeoReader) seekRecords(offset int) error {
+	pos, n := r.recPos, 0
+	for {
+		if err := r.seek(pos); err != nil {
+			return err
+		}
+		var l [4]byte
+		if _, err := io.ReadFull(r.rd, l[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		size := int(binary.BigEndian.Uint32(l[:]))
+		if size == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if offset < n+size {
+			r.n, r.recDone = offset, false
+			r.recLeft = n + size - offset
+			r.origRead = xorStreamAt(r.NeoHeader.recordKey, r.NeoHeader.Legacy, offset)
+			return r.seek(pos + 4 + int64(offset-n))
+		}
+		pos += 4 + int64(size)
+		n += size
+	}
+}
+
+// xorStreamAt returns the XOR stream of key advanced to offset.
+func xorStreamAt(key []byte, legacy bool, offset int) cipher.Stream {
+	if len(key) == 0 {
+		return nopStream{}
+	}
+	stream := newXorEncStream(key, legacy)
+	skip := make([]byte, offset%len(key))
+	stream.XORKeyStream(skip, skip)
+	return stream
+}
@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	mrand "math/rand"
+	"testing"
+)
+
+// testEncodings stores the same content in every layout of the original
+// header.
+func testEncodings(t *testing.T) ([]byte, map[string][]byte) {
+	content := make([]byte, maxInlineHeader+3*chunkSize)
+	rand.Read(content)
+	marshall := func(hdrLen int, legacy bool) []byte {
+		b, err := (&NeoHeader{
+			Version:                   VersionV1,
+			OriginalHeaderEncMethod:   XorEnc,
+			OriginalHeader:            content[:hdrLen],
+			OriginalFilenameEncMethod: XorEnc,
+			OriginalFilename:          "a.bin",
+			Legacy:                    legacy,
+		}).Marshall()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(b, content[hdrLen:]...)
+	}
+	buf := new(bytes.Buffer)
+	w := NewNeoWriter(buf, maxInlineHeader+chunkSize, "a.bin", 0)
+	w.Write(content)
+	w.Close()
+	files := map[string][]byte{
+		"inline":  marshall(DefaultHeaderLen, false),
+		"legacy":  marshall(DefaultHeaderLen, true),
+		"large":   marshall(maxInlineHeader+chunkSize, false),
+		"records": buf.Bytes(),
+	}
+	return content, files
+}
+
+func TestNeoReader_Seek(t *testing.T) {
+	content, files := testEncodings(t)
+	for name, encoded := range files {
+		rd := NewNeoReader(bytes.NewReader(encoded))
+		if end, err := rd.Seek(0, io.SeekEnd); err != nil || end != int64(len(content)) {
+			t.Fatalf("%s: except %d, but %d %v", name, len(content), end, err)
+		}
+		rnd := mrand.New(mrand.NewSource(1))
+		p := make([]byte, 3*chunkSize)
+		for i := 0; i < 50; i++ {
+			off := rnd.Int63n(int64(len(content)))
+			whence := i % 3
+			arg := off
+			switch whence {
+			case io.SeekCurrent:
+				cur, _ := rd.Seek(0, io.SeekCurrent)
+				arg = off - cur
+			case io.SeekEnd:
+				arg = off - int64(len(content))
+			}
+			if got, err := rd.Seek(arg, whence); err != nil || got != off {
+				t.Fatalf("%s: seek to %d: %d %v", name, off, got, err)
+			}
+			n, err := io.ReadFull(rd, p[:rnd.Intn(len(p))])
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatalf("%s: read at %d: %v", name, off, err)
+			}
+			if !bytes.Equal(p[:n], content[off:off+int64(n)]) {
+				t.Fatalf("%s: content mismatch at %d", name, off)
+			}
+		}
+		rd.Seek(int64(len(content)), io.SeekStart)
+		if n, err := rd.Read(p); n != 0 || err != io.EOF {
+			t.Fatalf("%s: except EOF at the end, but %d %v", name, n, err)
+		}
+	}
+
+	rd := NewNeoReader(onlyReader{bytes.NewReader(files["inline"])})
+	if _, err := rd.Seek(0, io.SeekStart); err != ErrNotSeekable {
+		t.Fatalf("except ErrNotSeekable, but %v", err)
+	}
+}
+
+func TestNeoReader_SetBufferSize(t *testing.T) {
+	content, files := testEncodings(t)
+	for name, encoded := range files {
+		rd := NewNeoReader(bytes.NewReader(encoded))
+		rd.SetBufferSize(16)
+		if b, err := io.ReadAll(rd); err != nil || !bytes.Equal(b, content) {
+			t.Fatalf("%s: content mismatch %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+)
+
+// FieldKind is how a header field is stored.
+type FieldKind string
+
+const (
+	// KindBytes is a fixed number of raw bytes.
+	KindBytes FieldKind = "bytes"
+	KindUint8 FieldKind = "uint8"
+	// KindUint32BE is a big endian 32 bit unsigned integer.
+	KindUint32BE FieldKind = "uint32be"
+	// KindVUint is a run of 0xFF bytes ended by a byte below 0xFF, the value
+	// is the sum of all bytes.
+	KindVUint FieldKind = "vuint"
+	// KindVBytes is a vuint length and that many raw bytes.
+	KindVBytes FieldKind = "vbytes"
+	// KindEncrypted is the method byte, a vuint key length, the key, a vuint
+	// content length and the content encrypted with the method.
+	KindEncrypted FieldKind = "encrypted"
+	// KindExtensions is a list of type byte, vuint length and value up to
+	// the end of the data holding it, unknown types are skipped.
+	KindExtensions FieldKind = "extensions"
+)
+
+// Field describes one field of the NEO header in the order they are stored.
+type Field struct {
+	Name string    `json:"name"`
+	Kind FieldKind `json:"kind"`
+	Size int       `json:"size,omitempty"`
+	// When names the flag that must be set, or with a leading ! unset, for
+	// the field to be present. kdf stands for a stealth magic or keyed.
+	When string `json:"when,omitempty"`
+	Doc  string `json:"doc"`
+}
+
+// HeaderFields is the layout written by Marshall and read by UnMarshall.
+var HeaderFields = []Field{
+	{Name: "magic", Kind: KindBytes, Size: len(NeoMagicNumber), Doc: "magic, or stealth_magic for files made with a key"},
+	{Name: "length", Kind: KindVUint, Doc: "number of header bytes that follow"},
+	{Name: "flag", Kind: KindUint8, Doc: "version in the bits of flags.version plus the other flags"},
+	{Name: "kdf_salt", Kind: KindVBytes, When: "kdf", Doc: "salt of the key derivation, see kdf"},
+	{Name: "kdf_cost", Kind: KindUint8, When: "kdf", Doc: "the key derivation runs 2^kdf_cost iterations, see kdf"},
+	{Name: "salt", Kind: KindVBytes, When: "keyed", Doc: "salt of the file key, see keyed"},
+	{Name: "key_slots", Kind: KindVBytes, When: "keyed", Doc: "one or more slots of 8 bytes key id and 32 bytes wrapped file key, see keyed"},
+	{Name: "original_header", Kind: KindEncrypted, Doc: "leading bytes of the original file, with method xor_records only the method byte, vuint key length and key, the bytes follow the header as records"},
+	{Name: "original_filename", Kind: KindEncrypted, Doc: "UTF-8 name of the original file"},
+	{Name: "crc32", Kind: KindUint32BE, When: "!encrypted_meta", Doc: "IEEE CRC32 of the original file, 0 and meaningless with no_checksum"},
+	{Name: "extensions", Kind: KindExtensions, When: "!encrypted_meta", Doc: "optional fields, types are listed in extensions"},
+	{Name: "meta", Kind: KindEncrypted, When: "encrypted_meta", Doc: "crc32 followed by extensions, encrypted with the filename method"},
+}
+
+// Vector is an encoded file made with fixed keys, implementations must decode
+// Encoded to Content and Filename.
+type Vector struct {
+	Name     string `json:"name"`
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+	Key      string `json:"key"`
+	Encoded  string `json:"encoded"`
+}
+
+// Spec is a machine readable description of the NEO format.
+type Spec struct {
+	Version          uint8            `json:"version"`
+	Magic            string           `json:"magic"`
+	StealthMagic     string           `json:"stealth_magic"`
+	DefaultHeaderLen int              `json:"default_header_len"`
+	Flags            map[string]uint8 `json:"flags"`
+	Methods          map[string]uint8 `json:"methods"`
+	XorEnc           string           `json:"xor_enc"`
+	Extensions       map[string]uint8 `json:"extensions"`
+	HeaderCRC        string           `json:"header_crc"`
+	Keyed            string           `json:"keyed"`
+	KDF              string           `json:"kdf"`
+	Fields           []Field          `json:"fields"`
+	Body             string           `json:"body"`
+	Vectors          []Vector         `json:"vectors"`
+}
+
+// FormatSpec describes the format this package writes.
+func FormatSpec() (*Spec, error) {
+	vectors, err := Vectors()
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{
+		Version:          VersionV1,
+		Magic:            hex.EncodeToString(NeoMagicNumber),
+		StealthMagic:     `first 4 bytes of HMAC-SHA256(key, "neo stealth magic") with the key made by kdf`,
+		DefaultHeaderLen: DefaultHeaderLen,
+		Flags:            map[string]uint8{"version": FlagVersion, "encrypted_meta": FlagEncryptedMeta, "xor_stream": FlagXorStream, "no_checksum": FlagNoChecksum, "keyed": FlagKeyed},
+		Methods:          map[string]uint8{"xor": XorEnc, "xor_records": XorRecords},
+		XorEnc:           "with xor_stream the content is XORed with the key repeated, without it every byte is XORed with the first byte of the key",
+		Extensions:       map[string]uint8{"sha256": ExtSHA256, "xxh64": ExtXXH64, "owner": ExtOwner, "hint": ExtHint, "media": ExtMedia, "header_crc": ExtHeaderCRC},
+		HeaderCRC:        "IEEE CRC32 of the header from flag up to the content of original_header, then the original filename and the crc32 and extensions before header_crc in clear, header_crc is the last extension",
+		Keyed:            `the file key is 32 random bytes, every key stored in the header is replaced by HMAC-SHA256(file key, stored key) with 0 bytes turned into 0xA5 before use. A slot holds the first 8 bytes of HMAC-SHA256(master key, "neo key id") and the file key XORed with HKDF-SHA256 of the master key with the salt and info "neo file key"`,
+		KDF:              "the key of stealth_magic and the master keys of keyed are PBKDF2-HMAC-SHA256 of the password or keyfile secret with kdf_salt, 2^kdf_cost iterations and 32 bytes of output",
+		Fields:           HeaderFields,
+		Body:             "with xor_records first the original header as records of a big endian uint32 length and that many bytes, XORed as one stream with the key of the field and ended by a zero length record, then the original file without its leading original_header bytes, unchanged",
+		Vectors:          vectors,
+	}, nil
+}
+
+var vectorKey = []byte{0x5A, 0xC3, 0x3C, 0xA5}
+
+// Vectors encodes a few fixed files with vectorKey, in the current format and
+// as legacy files without FlagXorStream, and one whole file as XorRecords.
+func Vectors() ([]Vector, error) {
+	rar := []byte{0x52, 0x61, 0x72, 0x21, 0x1a, 0x07, 0x01, 0x00, 0xCF, 0x90, 0x73, 0x00}
+	cases := []struct {
+		name, filename string
+		content        []byte
+		sha256, meta   bool
+	}{
+		{"plain", "hello.txt", []byte("Hello, NEO! This is a test vector."), false, false},
+		{"sha256", "hello.txt", []byte("Hello, NEO! This is a test vector."), true, false},
+		{"encrypted-meta", "hello.txt", []byte("Hello, NEO! This is a test vector."), true, true},
+		{"unicode-filename", "这是压缩文件❤️.rar", rar, false, false},
+	}
+	var vectors []Vector
+	for _, c := range cases {
+		for _, legacy := range []bool{false, true} {
+			v, err := newVector(c.name, c.filename, c.content, c.sha256, c.meta, legacy)
+			if err != nil {
+				return nil, err
+			}
+			vectors = append(vectors, v)
+		}
+	}
+	content := []byte("Hello, NEO! This file is kept in the header as records.")
+	v, err := newRecordsVector("records", "records.txt", content)
+	if err != nil {
+		return nil, err
+	}
+	return append(vectors, v), nil
+}
+
+func newVector(name, filename string, content []byte, withSHA256, meta, legacy bool) (Vector, error) {
+	hdr := NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorEnc,
+		OriginalHeader:            content[:DefaultHeaderLen],
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          filename,
+		Crc32:                     crc32.ChecksumIEEE(content),
+		EncryptedMeta:             meta,
+		Legacy:                    legacy,
+	}
+	if withSHA256 {
+		sum := sha256.Sum256(content)
+		hdr.SHA256 = sum[:]
+	}
+	b, err := hdr.marshall(func() ([]byte, error) { return vectorKey, nil })
+	if err != nil {
+		return Vector{}, err
+	}
+	if legacy {
+		name = "legacy-" + name
+	}
+	return Vector{
+		Name:     name,
+		Filename: filename,
+		Content:  hex.EncodeToString(content),
+		Key:      hex.EncodeToString(vectorKey),
+		Encoded:  hex.EncodeToString(append(b, content[DefaultHeaderLen:]...)),
+	}, nil
+}
+
+// newRecordsVector moves all of content into XorRecords.
+func newRecordsVector(name, filename string, content []byte) (Vector, error) {
+	buf := new(bytes.Buffer)
+	w := NewNeoWriterWithHeader(buf, len(content), &NeoHeader{
+		Version:                   VersionV1,
+		OriginalHeaderEncMethod:   XorRecords,
+		OriginalFilenameEncMethod: XorEnc,
+		OriginalFilename:          filename,
+		Crc32:                     crc32.ChecksumIEEE(content),
+	})
+	w.newKey = func() ([]byte, error) { return vectorKey, nil }
+	if _, err := w.Write(content); err != nil {
+		return Vector{}, err
+	}
+	if err := w.Close(); err != nil {
+		return Vector{}, err
+	}
+	return Vector{
+		Name:     name,
+		Filename: filename,
+		Content:  hex.EncodeToString(content),
+		Key:      hex.EncodeToString(vectorKey),
+		Encoded:  hex.EncodeToString(buf.Bytes()),
+	}, nil
+}
@@ -0,0 +1,106 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// walkFields parses a header using HeaderFields only and returns the values
+// of the fixed size fields and the unencrypted blobs.
+func walkFields(t *testing.T, p []byte) map[string][]byte {
+	values := make(map[string][]byte)
+	var (
+		flag byte
+		err  error
+	)
+	for _, f := range HeaderFields {
+		if f.When != "" {
+			bit := map[string]byte{"encrypted_meta": FlagEncryptedMeta, "keyed": FlagKeyed}[strings.TrimPrefix(f.When, "!")]
+			set := flag&bit != 0
+			if f.When == "kdf" {
+				set = flag&FlagKeyed != 0 || !bytes.Equal(values["magic"], NeoMagicNumber)
+			}
+			if strings.HasPrefix(f.When, "!") == set {
+				continue
+			}
+		}
+		switch f.Kind {
+		case KindBytes:
+			values[f.Name], p = p[:f.Size], p[f.Size:]
+		case KindUint8:
+			values[f.Name], p = p[:1], p[1:]
+			if f.Name == "flag" {
+				flag = values[f.Name][0]
+			}
+		case KindUint32BE:
+			values[f.Name], p = p[:4], p[4:]
+		case KindVUint:
+			var n uint
+			n, p = decodeVUint(p)
+			if n != uint(len(p)) {
+				t.Fatalf("%s: except %d, but %d", f.Name, len(p), n)
+			}
+		case KindVBytes:
+			var n uint
+			n, p = decodeVUint(p)
+			values[f.Name], p = p[:n], p[n:]
+		case KindEncrypted:
+			hp := &headerParser{p: p, strict: true, legacy: flag&FlagXorStream == 0}
+			if p[0] == XorRecords {
+				hp.p = hp.p[1:]
+				if values[f.Name], err = hp.key(); err != nil {
+					t.Fatalf("%s: %v", f.Name, err)
+				}
+			} else if _, values[f.Name], err = hp.encrypted(); err != nil {
+				t.Fatalf("%s: %v", f.Name, err)
+			}
+			p = hp.p
+		case KindExtensions:
+			values[f.Name], p = p, nil
+		default:
+			t.Fatalf("unknown kind %s", f.Kind)
+		}
+	}
+	if len(p) != 0 {
+		t.Fatalf("%d bytes left after the last field", len(p))
+	}
+	return values
+}
+
+func TestVectors(t *testing.T) {
+	vectors, err := Vectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		encoded, _ := hex.DecodeString(v.Encoded)
+		content, _ := hex.DecodeString(v.Content)
+		rd := NewNeoReader(bytes.NewReader(encoded))
+		hdr, err := rd.Header()
+		if err != nil {
+			t.Fatalf("%s: %v", v.Name, err)
+		}
+		got, err := ioutil.ReadAll(rd)
+		if err != nil || !bytes.Equal(got, content) || hdr.OriginalFilename != v.Filename {
+			t.Fatalf("%s: unexpected result %q %+v %v", v.Name, got, hdr, err)
+		}
+
+		// the header alone, without XorRecords
+		n, rest := decodeVUint(encoded[4:])
+		values := walkFields(t, encoded[:len(encoded)-len(rest)+int(n)])
+		if string(values["original_filename"]) != v.Filename {
+			t.Fatalf("%s: unexpected filename %q", v.Name, values["original_filename"])
+		}
+		crc := values["crc32"]
+		if hdr.EncryptedMeta {
+			crc = values["meta"][:4]
+		}
+		if binary.BigEndian.Uint32(crc) != hdr.Crc32 {
+			t.Fatalf("%s: unexpected crc32 %x", v.Name, crc)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package codec
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+)
+
+// XorStream XORs the data with the key repeated, continuing where the last
+// call stopped.
+type XorStream struct {
+	idx uint
+	// ext is key repeated to a whole number of keys of at least 8 bytes,
+	// plus 8 more, so the 8 key bytes from any idx are ext[idx:idx+8]
+	ext []byte
+}
+
+// NewXorStream panics on an empty key, there is nothing to XOR with.
+func NewXorStream(key []byte) cipher.Stream {
+	if len(key) == 0 {
+		panic("xor: empty key")
+	}
+	period := len(key)
+	for period < 8 {
+		period += len(key)
+	}
+	ext := make([]byte, period+8)
+	for i := range ext {
+		ext[i] = key[i%len(key)]
+	}
+	return &XorStream{ext: ext}
+}
+
+func (s *XorStream) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("xor: len(dst) < len(src)")
+	}
+	// idx runs over the period, not the key, so it wraps by a subtraction
+	ext, idx := s.ext, s.idx
+	period := uint(len(ext) - 8)
+	i := 0
+	// 8 bytes at a time with the key rotated by idx, like legacyXorStream
+	for ; len(src)-i >= 8; i += 8 {
+		w := binary.LittleEndian.Uint64(ext[idx : idx+8])
+		binary.LittleEndian.PutUint64(dst[i:i+8], binary.LittleEndian.Uint64(src[i:i+8])^w)
+		if idx += 8; idx >= period {
+			idx -= period
+		}
+	}
+	for ; i < len(src); i++ {
+		dst[i] = src[i] ^ ext[idx]
+		if idx++; idx == period {
+			idx = 0
+		}
+	}
+	s.idx = idx
+}
+
+// legacyXorStream is the stream files without FlagXorStream were written
+// with, its index never advanced so every byte is XORed with key[0].
+type legacyXorStream struct {
+	key []byte
+}
+
+// NewLegacyXorStream reads and writes the XOR blobs of files made before
+// FlagXorStream.
+func NewLegacyXorStream(key []byte) cipher.Stream {
+	if len(key) == 0 {
+		panic("xor: empty key")
+	}
+	return &legacyXorStream{key: key}
+}
+
+func (s *legacyXorStream) XORKeyStream(dst, src []byte) {
+	if len(src) == 0 {
+		return
+	}
+	if len(dst) < len(src) {
+		panic("xor: len(dst) < len(src)")
+	}
+	k := s.key[0]
+	// 8 bytes at a time, the compiler turns these into single word loads and stores
+	w := uint64(k) * 0x0101010101010101
+	i := 0
+	for ; len(src)-i >= 8; i += 8 {
+		binary.LittleEndian.PutUint64(dst[i:], binary.LittleEndian.Uint64(src[i:])^w)
+	}
+	for ; i < len(src); i++ {
+		dst[i] = src[i] ^ k
+	}
+}
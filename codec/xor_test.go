@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"testing"
+)
+
+func xorBytewise(dst, src, key []byte) {
+	for i, v := range src {
+		dst[i] = v ^ key[0]
+	}
+}
+
+func TestLegacyXorStream(t *testing.T) {
+	key := []byte{0x5A, 0x13, 0xC7}
+	for _, n := range []int{0, 1, 7, 8, 9, 15, 16, 1000} {
+		src := make([]byte, n)
+		rand.Read(src)
+		want := make([]byte, n)
+		xorBytewise(want, src, key)
+		got := make([]byte, n)
+		NewLegacyXorStream(key).XORKeyStream(got, src)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("len %d: mismatch", n)
+		}
+		// in place
+		NewLegacyXorStream(key).XORKeyStream(src, src)
+		if !bytes.Equal(src, want) {
+			t.Fatalf("len %d: in place mismatch", n)
+		}
+	}
+}
+
+func TestXorStream(t *testing.T) {
+	key := []byte{0x5A, 0x13, 0xC7}
+	src := []byte{0, 0, 0, 0, 0, 0, 0}
+	want := []byte{0x5A, 0x13, 0xC7, 0x5A, 0x13, 0xC7, 0x5A}
+	got := make([]byte, len(src))
+	NewXorStream(key).XORKeyStream(got, src)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("except %x, but %x", want, got)
+	}
+	// split calls continue the key stream
+	s := NewXorStream(key)
+	s.XORKeyStream(got[:2], src[:2])
+	s.XORKeyStream(got[2:], src[2:])
+	if !bytes.Equal(got, want) {
+		t.Fatalf("except %x, but %x", want, got)
+	}
+}
+
+func TestXorStream_EmptyKey(t *testing.T) {
+	for name, f := range map[string]func([]byte) cipher.Stream{
+		"xor":    NewXorStream,
+		"legacy": NewLegacyXorStream,
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: except panic on empty key", name)
+				}
+			}()
+			f(nil)
+		}()
+	}
+}
+
+func TestXorStream_Words(t *testing.T) {
+	for _, keyLen := range []int{1, 3, 8, 13, 32} {
+		key := make([]byte, keyLen)
+		rand.Read(key)
+		src := make([]byte, 1000)
+		rand.Read(src)
+		want := make([]byte, len(src))
+		for i, v := range src {
+			want[i] = v ^ key[i%keyLen]
+		}
+		// calls of odd lengths leave idx anywhere in the key
+		s := NewXorStream(key)
+		got := make([]byte, len(src))
+		for i, n := 0, 1; i < len(src); i, n = i+n, n+3 {
+			end := i + n
+			if end > len(src) {
+				end = len(src)
+			}
+			s.XORKeyStream(got[i:end], src[i:end])
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("key len %d: mismatch", keyLen)
+		}
+	}
+}
+
+func benchmarkXor(b *testing.B, fn func(dst, src, key []byte)) {
+	key := []byte{0x5A, 0x13, 0xC7}
+	buf := make([]byte, 1<<20)
+	b.SetBytes(int64(len(buf)))
+	for i := 0; i < b.N; i++ {
+		fn(buf, buf, key)
+	}
+}
+
+func BenchmarkXorStream(b *testing.B) {
+	benchmarkXor(b, func(dst, src, key []byte) { NewXorStream(key).XORKeyStream(dst, src) })
+}
+
+func BenchmarkLegacyXorStream(b *testing.B) {
+	benchmarkXor(b, func(dst, src, key []byte) { NewLegacyXorStream(key).XORKeyStream(dst, src) })
+}
+
+func BenchmarkXorBytewise(b *testing.B) {
+	benchmarkXor(b, xorBytewise)
+}
@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+// xxh64 is XXH64 with seed 0.
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	mem            [32]byte
+	n              int
+}
+
+// NewXXH64 returns a new XXH64 hash with seed 0, Sum appends the digest in
+// big endian order.
+func NewXXH64() hash.Hash64 {
+	h := new(xxh64)
+	h.Reset()
+	return h
+}
+
+func (h *xxh64) Reset() {
+	// the constants would overflow at compile time
+	p1, p2 := xxhPrime1, xxhPrime2
+	h.v1 = p1 + p2
+	h.v2 = p2
+	h.v3 = 0
+	h.v4 = -p1
+	h.total = 0
+	h.n = 0
+}
+
+func (h *xxh64) Size() int      { return 8 }
+func (h *xxh64) BlockSize() int { return 32 }
+
+func (h *xxh64) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n+len(p) < 32 {
+		h.n += copy(h.mem[h.n:], p)
+		return n, nil
+	}
+	if h.n > 0 {
+		c := copy(h.mem[h.n:], p)
+		h.block(h.mem[:])
+		p = p[c:]
+		h.n = 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		h.block(p)
+	}
+	h.n = copy(h.mem[:], p)
+	return n, nil
+}
+
+func (h *xxh64) block(p []byte) {
+	h.v1 = xxhRound(h.v1, binary.LittleEndian.Uint64(p))
+	h.v2 = xxhRound(h.v2, binary.LittleEndian.Uint64(p[8:]))
+	h.v3 = xxhRound(h.v3, binary.LittleEndian.Uint64(p[16:]))
+	h.v4 = xxhRound(h.v4, binary.LittleEndian.Uint64(p[24:]))
+}
+
+func (h *xxh64) Sum64() uint64 {
+	var acc uint64
+	if h.total >= 32 {
+		acc = bits.RotateLeft64(h.v1, 1) + bits.RotateLeft64(h.v2, 7) +
+			bits.RotateLeft64(h.v3, 12) + bits.RotateLeft64(h.v4, 18)
+		acc = xxhMerge(acc, h.v1)
+		acc = xxhMerge(acc, h.v2)
+		acc = xxhMerge(acc, h.v3)
+		acc = xxhMerge(acc, h.v4)
+	} else {
+		acc = xxhPrime5
+	}
+	acc += h.total
+	p := h.mem[:h.n]
+	for ; len(p) >= 8; p = p[8:] {
+		acc ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		acc = bits.RotateLeft64(acc, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		acc ^= uint64(binary.LittleEndian.Uint32(p)) * xxhPrime1
+		acc = bits.RotateLeft64(acc, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		acc ^= uint64(b) * xxhPrime5
+		acc = bits.RotateLeft64(acc, 11) * xxhPrime1
+	}
+	acc ^= acc >> 33
+	acc *= xxhPrime2
+	acc ^= acc >> 29
+	acc *= xxhPrime3
+	acc ^= acc >> 32
+	return acc
+}
+
+func (h *xxh64) Sum(b []byte) []byte {
+	var sum [8]byte
+	binary.BigEndian.PutUint64(sum[:], h.Sum64())
+	return append(b, sum[:]...)
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxhPrime1
+}
+
+func xxhMerge(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*xxhPrime1 + xxhPrime4
+}
@@ -0,0 +1,43 @@
+package codec
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+func TestXXH64(t *testing.T) {
+	for in, want := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition":     0xfbcea83c8a378bf1,
+		"The quick brown fox jumps over the lazy dog": 0x0b242d361fda71bc,
+	} {
+		h := NewXXH64()
+		h.Write([]byte(in))
+		if got := h.Sum64(); got != want {
+			t.Fatalf("%q: except %016x, but %016x", in, want, got)
+		}
+	}
+}
+
+func TestXXH64_Chunked(t *testing.T) {
+	src := make([]byte, 1000)
+	rand.Read(src)
+	whole := NewXXH64()
+	whole.Write(src)
+	for _, step := range []int{1, 3, 7, 31, 32, 33, 100} {
+		h := NewXXH64()
+		for p := src; len(p) > 0; {
+			n := step
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p = p[n:]
+		}
+		if h.Sum64() != whole.Sum64() {
+			t.Fatalf("step %d: mismatch", step)
+		}
+	}
+}
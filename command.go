@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// errUsage is returned after the usage of a command has been printed because
+// of bad arguments.
+var errUsage = errors.New("usage error")
+
+// rootCommand handles plain file arguments, which is how files dropped on the
+// executable arrive.
+var rootCommand = &command{
+	usage: "[选项] 文件或目录...",
+	short: "自动判断编码或还原",
+	run:   runProcess,
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "agent", usage: "[选项]", short: "在一段时间内保留密码，供之后的 -ask-password 使用", run: runAgent},
+		{name: "associate", usage: "[选项]", short: "将 .neo 文件关联到本程序，在资源管理器中双击即可还原", run: runAssociate},
+		{name: "attach", usage: "[选项] .body 文件或目录...", short: "将 detach 分离的文件头与内容重新合并", run: runAttach},
+		{name: "audit", usage: "init|check [选项] 目录", short: "记录并检查目录中 NEO 文件的完整性", run: runAudit},
+		{name: "detach", usage: "[选项] 文件或目录...", short: "将 NEO 文件的文件头（含原文件名与密钥）分离为单独的 .hdr 文件", run: runDetach},
+		{name: "diff", usage: "[选项] NEO 文件 原文件", short: "在内存中解码 NEO 文件并与原文件逐字节比较", run: runDiff},
+		{name: "encode", usage: "[选项] 文件或目录...", short: "编码文件", run: runProcess},
+		{name: "decode", usage: "[选项] 文件或目录...", short: "还原 .neo 文件", run: runProcess},
+		{name: "grep", usage: "[选项] 模式 文件或目录...", short: "在内存中解码 NEO 文件并搜索其内容", run: runGrep},
+		{name: "gui", usage: "[选项]", short: "启动浏览器图形界面", run: runGUI},
+		{name: "help", usage: "[命令]", short: "显示帮助", run: runHelp},
+		{name: "index", usage: "build|ls [选项] 目录", short: "为目录中的 NEO 文件建立加密索引，或从索引列出它们", run: runIndex},
+		{name: "install-shell", short: "添加右键菜单", run: installShell},
+		{name: "key", usage: "generate|show|export|import [选项] 文件...", short: "生成随机密钥文件，显示密钥文件、密码及 -keyed 编码的文件所用密钥的指纹，或以文本及二维码导出、导入密钥文件", run: runKey},
+		{name: "ls", usage: "[选项] 文件或目录...", short: "列出 NEO 文件及其原始文件名", run: runLs},
+		{name: "rekey", usage: "[选项] 文件或目录...", short: "为 -keyed 编码的文件更换主密钥，只改写文件头", run: runRekey},
+		{name: "rename", usage: "[选项] 目录或文件...", short: "按新的命名方式重命名已编码的文件", run: runRename},
+		{name: "selftest", usage: "[选项]", short: "在临时目录中以各种设置编码并还原随机文件，检查本程序在当前平台上是否正常", run: runSelftest},
+		{name: "self-update", usage: "[选项]", short: "更新到最新版本，仅以同一发布中的校验和检查下载是否完整，不验证签名", run: runSelfUpdate},
+		{name: "service", usage: "install|uninstall|start|stop|status [选项] [命令 参数...]", short: "将 watch 等命令安装为后台服务", run: runService},
+		{name: "share", usage: "[选项] NEO 文件", short: "将文件名提示、大小、SHA-256 及所需密钥的指纹复制到剪贴板或显示为二维码，便于与他人核对", run: runShare},
+		{name: "spec", short: "以 JSON 输出文件格式说明及测试向量", run: runSpec},
+		{name: "sync", usage: "[选项] 源目录 目标目录", short: "将目录同步为编码后的镜像，或反向还原", run: runSync},
+		{name: "unassociate", short: "取消 .neo 文件与本程序的关联", run: runUnassociate},
+		{name: "tray", usage: "[选项] 目录...", short: "在通知区域显示图标并监视目录，可从图标菜单暂停、继续，选项同 watch", run: runTray},
+		{name: "uninstall-shell", short: "移除右键菜单", run: uninstallShell},
+		{name: "version", short: "显示版本信息", run: runVersion},
+		{name: "watch", usage: "[选项] 目录...", short: "监视目录并自动处理新文件", run: runWatch},
+		{name: "zip", usage: "export|import [选项] 输入 输出", short: "将 NEO 文件（-pack 的打包文件按其中的文件）导出为 zip，或将 zip 导入为一个打包文件", run: runZip},
+	}
+}
+
+// command is a subcommand of neo, run receives the arguments after its name.
+type command struct {
+	name  string
+	usage string
+	short string
+	run   func(cmd *command, args []string) error
+}
+
+// flagSet returns a flag set whose errors and -h print the usage of cmd.
+func (cmd *command) flagSet() *flag.FlagSet {
+	name := "neo"
+	if cmd.name != "" {
+		name += " " + cmd.name
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() { cmd.printUsage(fs) }
+	return fs
+}
+
+func (cmd *command) parse(fs *flag.FlagSet, args []string) error {
+	addPasswordSources(fs)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	return passwordFromEnv(fs)
+}
+
+// usageError reports bad positional arguments the way fs reports bad flags.
+func (cmd *command) usageError(fs *flag.FlagSet, format string, a ...interface{}) error {
+	fmt.Fprintf(fs.Output(), format+"\n", a...)
+	fs.Usage()
+	return errUsage
+}
+
+func (cmd *command) printUsage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "用法：%s %s\n", fs.Name(), cmd.usage)
+	if cmd.name == "" {
+		printCommands(w)
+	} else {
+		fmt.Fprintf(w, "\n%s\n", cmd.short)
+	}
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(w, "\n选项：\n")
+		fs.PrintDefaults()
+	}
+}
+
+func printCommands(w io.Writer) {
+	width := 0
+	for _, cmd := range commands {
+		if len(cmd.name) > width {
+			width = len(cmd.name)
+		}
+	}
+	fmt.Fprintf(w, "      neo <命令> [参数]\n\n命令：\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s%s  %s\n", cmd.name, strings.Repeat(" ", width-len(cmd.name)), cmd.short)
+	}
+	fmt.Fprintf(w, "\n使用 neo help <命令> 查看命令的帮助。\n")
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func runHelp(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	target := rootCommand
+	if fs.NArg() > 0 {
+		if target = findCommand(fs.Arg(0)); target == nil {
+			return cmd.usageError(fs, "unknown command: %s", fs.Arg(0))
+		}
+	}
+	return target.run(target, []string{"-h"})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// confirmListed is how many files of each kind confirmMixed names.
+const confirmListed = 5
+
+// formatSize formats a byte count with the suffixes parseSize takes.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// stdinIsTerminal reports whether there is someone to ask, the null device
+// scripts and services read from is a character device too.
+func stdinIsTerminal() bool {
+	fInfo, err := os.Stdin.Stat()
+	if err != nil || fInfo.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fInfo, null)
+}
+
+// askYes prints prompt to stderr and reports whether the answer read from r
+// is yes.
+func askYes(prompt string, r io.Reader) bool {
+	fmt.Fprint(os.Stderr, prompt+" [y/N] ")
+	line, _ := readLine(r)
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes", "是":
+		return true
+	}
+	return false
+}
+
+// batchPlan is the files of a run that are encoded or decoded, with their
+// size and the estimated size of their output. Sizes are only known for
+// local files.
+type batchPlan struct {
+	files        []string
+	size, output int64
+}
+
+func (p *batchPlan) add(file string, size, output int64) {
+	p.files = append(p.files, file)
+	p.size += size
+	p.output += output
+}
+
+// planFiles sorts files by whether action, or the automatic mode, encodes or
+// decodes them and estimates their output.
+func planFiles(files []string, action Action) (encode, decode batchPlan) {
+	for _, file := range files {
+		if isRemote(file) {
+			src, name := splitSource(file)
+			if ok, _ := IsNeoFile(src, name); action == ActionDecode || action != ActionEncode && ok {
+				decode.add(file, 0, 0)
+			} else {
+				encode.add(file, 0, 0)
+			}
+			continue
+		}
+		e, err := headers.lookup(file)
+		switch {
+		case err != nil:
+			encode.add(file, 0, 0)
+		case action == ActionDecode || action != ActionEncode && e.Header != nil:
+			decode.add(file, e.Size, e.Content)
+		default:
+			encode.add(file, e.Size, e.Size+encodeOverhead(filepath.Base(file), e.Size))
+		}
+	}
+	return
+}
+
+// encodeOverhead estimates how much larger than the original its encoded
+// file is, the header less the bytes moved into it. NEO files are neither
+// padded nor compressed. The start of the file, up to 1M, is encoded with
+// the current settings to measure it.
+func encodeOverhead(name string, size int64) int64 {
+	n := int64(headerLen)
+	if size < n {
+		n = size
+	}
+	if n > 1<<20 {
+		n = 1 << 20
+	}
+	var hdr codec.NeoHeader
+	hdr.Version = codec.VersionV1
+	hdr.OriginalHeaderEncMethod, hdr.OriginalFilenameEncMethod = codec.XorEnc, codec.XorEnc
+	hdr.OriginalFilename = name
+	hdr.EncryptedMeta, hdr.NoChecksum, hdr.HeaderCRC = encodeEncryptMeta, encodeNoChecksum, encodeHeaderCRC
+	if encodeSHA256 && !encodeNoChecksum {
+		hdr.SHA256 = make([]byte, sha256.Size)
+	}
+	if encodeXXH64 && !encodeNoChecksum {
+		hdr.XXH64 = make([]byte, 8)
+	}
+	if encodeHint {
+		hdr.Hint = nameHint(name)
+	}
+	if (encodeStealth || encodeKeyed) && key != nil {
+		k := writeKey(key)
+		if encodeStealth {
+			hdr.Magic = codec.StealthMagic(k)
+		}
+		if encodeKeyed {
+			hdr.MasterKey = k
+		}
+		hdr.KDFSalt, hdr.KDFCost = writeSalt, codec.DefaultKDFCost
+	}
+	cw := new(countingWriter)
+	w := codec.NewNeoWriterWithHeader(cw, int(n), &hdr)
+	w.SetSize(n)
+	if _, err := w.Write(make([]byte, n)); err != nil {
+		return 0
+	}
+	if err := w.Close(); err != nil {
+		return 0
+	}
+	return cw.n - n
+}
+
+type countingWriter struct{ n int64 }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// reportPlan logs how many files a run encodes and decodes, their size and
+// the estimated size of the output.
+func reportPlan(encode, decode batchPlan) {
+	if len(encode.files) > 0 {
+		log.Printf("将编码 %d 个文件，共 %s 字节，预计输出 %s 字节", len(encode.files), formatSize(encode.size), formatSize(encode.output))
+	}
+	if len(decode.files) > 0 {
+		log.Printf("将还原 %d 个文件，共 %s 字节，预计输出 %s 字节", len(decode.files), formatSize(decode.size), formatSize(decode.output))
+	}
+}
+
+// confirmMixed lists what will be encoded and what decoded when files holds
+// both and asks whether to go on, so a wrong folder dropped among NEO files
+// is not encoded by accident.
+func confirmMixed(files []string, r io.Reader) bool {
+	encode, decode := planFiles(files, "")
+	if len(decode.files) == 0 || len(encode.files) == 0 {
+		return true
+	}
+	list := func(title string, p batchPlan) {
+		fmt.Fprintf(os.Stderr, "%s %d 个文件，共 %s 字节：\n", title, len(p.files), formatSize(p.size))
+		for i, file := range p.files {
+			if i == confirmListed {
+				fmt.Fprintf(os.Stderr, "  ……等 %d 个\n", len(p.files)-i)
+				break
+			}
+			fmt.Fprintf(os.Stderr, "  %s\n", file)
+		}
+	}
+	list("将编码", encode)
+	list("将还原", decode)
+	return askYes("同时编码与还原，是否继续？", r)
+}
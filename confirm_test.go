@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfirmMixed(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("plain"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.txt"), []byte("to encode"), 0644)
+	res, err := EncodeFile(LocalStorage(dir), "b.txt", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(dir, "a.txt")
+
+	if !confirmMixed([]string{plain}, strings.NewReader("")) {
+		t.Error("except files of one kind to need no confirmation")
+	}
+	mixed := []string{plain, res.Output}
+	encode, decode := planFiles(mixed, "")
+	if len(decode.files) != 1 || decode.files[0] != res.Output || decode.output != 9 ||
+		len(encode.files) != 1 || encode.size != 5 {
+		t.Fatalf("unexpected plan %+v %+v", encode, decode)
+	}
+	if encode.output <= encode.size {
+		t.Errorf("except an estimate larger than the file, but %d", encode.output)
+	}
+	if encode, decode := planFiles(mixed, ActionEncode); len(encode.files) != 2 || len(decode.files) != 0 {
+		t.Errorf("except encode to encode both files, but %+v %+v", encode, decode)
+	}
+	for answer, want := range map[string]bool{"y\n": true, "是\n": true, "n\n": false, "": false} {
+		if got := confirmMixed(mixed, strings.NewReader(answer)); got != want {
+			t.Errorf("confirmMixed() with %q = %v, want %v", answer, got, want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for n, want := range map[int64]string{100: "100", 1536: "1.5K", 3 << 20: "3.0M", 5 << 30: "5.0G"} {
+		if got := formatSize(n); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
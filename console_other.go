@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+func ownsConsole() bool {
+	return false
+}
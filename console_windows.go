@@ -0,0 +1,16 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetConsoleProcessList = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleProcessList")
+
+// ownsConsole reports whether no other process shares our console, which is
+// the case when neo was started from Explorer rather than from a shell.
+func ownsConsole() bool {
+	pids := make([]uint32, 2)
+	n, _, _ := procGetConsoleProcessList.Call(uintptr(unsafe.Pointer(&pids[0])), uintptr(len(pids)))
+	return n == 1
+}
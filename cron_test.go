@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2024, 1, 31, 3, 30, 20, 0, time.UTC) // Wednesday
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2024, 2, 1, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 3, 45, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// either day field matches when both are restricted
+		{"0 0 15 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseCron(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+		if got := s.next(from); !got.Equal(c.want) {
+			t.Fatalf("%s: except %v, but %v", c.spec, c.want, got)
+		}
+	}
+	if s, _ := parseCron("0 0 30 2 *"); !s.next(from).IsZero() {
+		t.Fatal("Feb 30 should never match")
+	}
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Fatalf("%q: except error", spec)
+		}
+	}
+}
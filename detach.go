@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// neo detach splits a NEO file into its header, saved as name+sidecarExt,
+// and the rest, saved as name+payloadExt. The payload is the original
+// content without its first bytes, neo attach puts the two back together.
+const (
+	sidecarExt = ".hdr"
+	payloadExt = ".body"
+)
+
+var errSidecar = errors.New("sidecar does not hold a whole NEO header")
+
+func runDetach(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	recursive := fs.Bool("r", false, "递归处理目录")
+	hdrDir := fs.String("hdr-dir", "", "存放文件头的目录，默认与原文件相同")
+	keep := fs.Bool("keep", false, "保留原文件")
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return cmd.usageError(fs, "no file to detach")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	sum := new(summary)
+	detached := 0
+	for _, file := range collectFiles(fs.Args(), *recursive, sum) {
+		if isRemote(file) || strings.HasSuffix(file, sidecarExt) || strings.HasSuffix(file, payloadExt) {
+			continue
+		}
+		dir, name := filepath.Split(file)
+		if ok, err := IsNeoFile(LocalStorage(dir), name); err != nil || !ok {
+			continue
+		}
+		hdrPath, err := detachFile(file, *hdrDir, *keep)
+		if err != nil {
+			log.Printf("分离文件：%s 失败，错误：%v", file, err)
+			sum.failed++
+			continue
+		}
+		log.Printf("分离：%s → %s + %s", file, hdrPath, file+payloadExt)
+		detached++
+	}
+	log.Printf("完成：分离 %d 个，失败 %d 个", detached, sum.failed)
+	return sum.err()
+}
+
+func runAttach(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	recursive := fs.Bool("r", false, "递归处理目录")
+	hdrDir := fs.String("hdr-dir", "", "文件头所在的目录，默认与 .body 文件相同")
+	keep := fs.Bool("keep", false, "保留文件头及 .body 文件")
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return cmd.usageError(fs, "no file to attach")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	sum := new(summary)
+	attached := 0
+	for _, file := range collectFiles(fs.Args(), *recursive, sum) {
+		if isRemote(file) || !strings.HasSuffix(file, payloadExt) {
+			continue
+		}
+		file = strings.TrimSuffix(file, payloadExt)
+		if err := attachFile(file, *hdrDir, *keep); err != nil {
+			log.Printf("合并文件：%s 失败，错误：%v", file, err)
+			sum.failed++
+			continue
+		}
+		log.Printf("合并：%s", file)
+		attached++
+	}
+	log.Printf("完成：合并 %d 个，失败 %d 个", attached, sum.failed)
+	return sum.err()
+}
+
+// sidecarPath is where the header of file goes, in hdrDir when it is set.
+func sidecarPath(file, hdrDir string) string {
+	if hdrDir == "" {
+		return file + sidecarExt
+	}
+	return filepath.Join(hdrDir, filepath.Base(file)+sidecarExt)
+}
+
+// detachFile splits file and removes it unless keep is set, it returns the
+// path of the header.
+func detachFile(file, hdrDir string, keep bool) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	rd := newNeoReader(f)
+	if _, err := rd.Header(); err != nil {
+		return "", err
+	}
+	size := int64(rd.HeaderSize())
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hdrPath := sidecarPath(file, hdrDir)
+	if err := writeNew(hdrPath, io.LimitReader(f, size)); err != nil {
+		return "", err
+	}
+	if err := writeNew(file+payloadExt, f); err != nil {
+		os.Remove(hdrPath)
+		return "", err
+	}
+	f.Close()
+	if !keep {
+		if err := os.Remove(file); err != nil {
+			return hdrPath, err
+		}
+	}
+	return hdrPath, nil
+}
+
+// attachFile puts file back together from its header and payload and
+// removes them unless keep is set.
+func attachFile(file, hdrDir string, keep bool) error {
+	hdrPath := sidecarPath(file, hdrDir)
+	hdr, err := os.Open(hdrPath)
+	if err != nil {
+		return err
+	}
+	defer hdr.Close()
+	fInfo, err := hdr.Stat()
+	if err != nil {
+		return err
+	}
+	rd := newNeoReader(hdr)
+	if _, err := rd.Header(); err != nil {
+		return fmt.Errorf("%s: %w", hdrPath, err)
+	}
+	if int64(rd.HeaderSize()) != fInfo.Size() {
+		return fmt.Errorf("%s: %w", hdrPath, errSidecar)
+	}
+	body, err := os.Open(file + payloadExt)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if _, err := hdr.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeNew(file, io.MultiReader(hdr, body)); err != nil {
+		return err
+	}
+	hdr.Close()
+	body.Close()
+	if !keep {
+		os.Remove(hdrPath)
+		os.Remove(file + payloadExt)
+	}
+	return nil
+}
+
+// writeNew writes r to path, which must not exist yet, and removes what was
+// written when it fails.
+func writeNew(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffer(f, r, bufferFor())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetachAttach(t *testing.T) {
+	dir := t.TempDir()
+	hdrDir := t.TempDir()
+	content := bytes.Repeat([]byte("neo"), 1000)
+	defer func(n int) { headerLen = n }(headerLen)
+	headerLen = 64
+	os.WriteFile(filepath.Join(dir, "a.txt"), content, 0644)
+	res, err := parseFile(filepath.Join(dir, "a.txt"), ActionEncode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	neoFile := res.Output
+	orig, _ := os.ReadFile(neoFile)
+
+	hdrPath, err := detachFile(neoFile, hdrDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(neoFile); !os.IsNotExist(err) {
+		t.Fatal("detached file not removed")
+	}
+	body, _ := os.ReadFile(neoFile + payloadExt)
+	if !bytes.Equal(body, content[headerLen:]) {
+		t.Fatalf("payload is %d bytes, want the content after the first %d", len(body), headerLen)
+	}
+	if err := attachFile(neoFile, "", false); err == nil {
+		t.Fatal("attached without the header")
+	}
+	if err := attachFile(neoFile, hdrDir, false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(neoFile); !bytes.Equal(b, orig) {
+		t.Fatal("attached file differs from the original")
+	}
+	if _, err := os.Stat(hdrPath); !os.IsNotExist(err) {
+		t.Fatal("header not removed")
+	}
+
+	// a truncated header must not be attached
+	os.WriteFile(hdrPath, orig[:len(orig)-len(body)-1], 0644)
+	os.WriteFile(neoFile+payloadExt, body, 0644)
+	os.Remove(neoFile)
+	if err := attachFile(neoFile, hdrDir, true); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("truncated header attached: %v", err)
+	}
+}
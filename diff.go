@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+var ErrFilesDiffer = errors.New("files differ")
+
+// diffChunk is the size of the pieces neo diff compares.
+const diffChunk = 1 << 20
+
+func runDiff(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return cmd.usageError(fs, "diff needs a NEO file and the original")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	neoFile, origFile := fs.Arg(0), fs.Arg(1)
+	f, err := os.Open(neoFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	orig, err := os.Open(origFile)
+	if err != nil {
+		return err
+	}
+	defer orig.Close()
+	rd := newNeoReader(f)
+	if _, err := rd.Header(); err != nil {
+		return &OpError{Op: "header", Path: neoFile, Err: err}
+	}
+	d, err := diffReaders(rd, orig)
+	if err != nil {
+		return err
+	}
+	switch {
+	case d.same():
+		log.Printf("文件：%s 与 %s 内容相同，共 %d 字节", neoFile, origFile, d.offset)
+		return nil
+	case d.aEnded:
+		log.Printf("文件：%s 的内容在第 %d 字节结束，%s 更长", neoFile, d.offset, origFile)
+	case d.bEnded:
+		log.Printf("文件：%s 在第 %d 字节结束，%s 的内容更长", origFile, d.offset, neoFile)
+	default:
+		log.Printf("文件：%s 与 %s 自第 %d 字节起不同", neoFile, origFile, d.offset)
+	}
+	return fmt.Errorf("%w at byte %d", ErrFilesDiffer, d.offset)
+}
+
+// diffResult is where two streams first differ, or their length when they
+// do not. aEnded and bEnded tell which stream ended there.
+type diffResult struct {
+	offset         int64
+	aEnded, bEnded bool
+	differ         bool
+}
+
+func (d diffResult) same() bool {
+	return !d.differ && d.aEnded == d.bEnded
+}
+
+type diffRead struct {
+	buf []byte
+	err error
+}
+
+// readChunks reads r in diffChunk pieces ahead of the comparison, so both
+// streams are read at the same time.
+func readChunks(r io.Reader) <-chan diffRead {
+	ch := make(chan diffRead, 1)
+	go func() {
+		defer close(ch)
+		for {
+			buf := make([]byte, diffChunk)
+			n, err := io.ReadFull(r, buf)
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			ch <- diffRead{buf[:n], err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// diffReaders compares a and b, stopping at the first difference.
+func diffReaders(a, b io.Reader) (diffResult, error) {
+	var d diffResult
+	ac, bc := readChunks(a), readChunks(b)
+	// drain whatever is still read ahead on return
+	defer func() {
+		go func() {
+			for range ac {
+			}
+		}()
+		go func() {
+			for range bc {
+			}
+		}()
+	}()
+	for {
+		ar, br := <-ac, <-bc
+		for _, r := range []diffRead{ar, br} {
+			if r.err != nil && r.err != io.EOF {
+				return d, r.err
+			}
+		}
+		n := len(ar.buf)
+		if len(br.buf) < n {
+			n = len(br.buf)
+		}
+		if i := firstDiff(ar.buf[:n], br.buf[:n]); i >= 0 {
+			d.offset += int64(i)
+			d.differ = true
+			return d, nil
+		}
+		d.offset += int64(n)
+		if len(ar.buf) != len(br.buf) || ar.err != nil || br.err != nil {
+			d.aEnded = len(ar.buf) == n && ar.err != nil
+			d.bEnded = len(br.buf) == n && br.err != nil
+			return d, nil
+		}
+	}
+}
+
+// firstDiff returns the index of the first byte a and b differ in, -1 for
+// none.
+func firstDiff(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return -1
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDiffReaders(t *testing.T) {
+	a := bytes.Repeat([]byte("neo"), diffChunk)
+	b := append([]byte(nil), a...)
+	b[diffChunk+5] ^= 1
+	for _, c := range []struct {
+		name string
+		a, b []byte
+		want diffResult
+	}{
+		{"same", a, a, diffResult{offset: int64(len(a)), aEnded: true, bEnded: true}},
+		{"differ", a, b, diffResult{offset: diffChunk + 5, differ: true}},
+		{"a shorter", a[:diffChunk], a, diffResult{offset: diffChunk, aEnded: true}},
+		{"b shorter", a, a[:10], diffResult{offset: 10, bEnded: true}},
+		{"empty", nil, nil, diffResult{aEnded: true, bEnded: true}},
+	} {
+		got, err := diffReaders(bytes.NewReader(c.a), bytes.NewReader(c.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("%s: except %+v, but %+v", c.name, c.want, got)
+		}
+		if got.same() != (c.name == "same" || c.name == "empty") {
+			t.Errorf("%s: same is %v", c.name, got.same())
+		}
+	}
+}
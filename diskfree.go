@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// minFree is the free space, in bytes, to keep on the disk written to.
+// Processing waits before a file that would leave less, 0 does not check.
+var minFree int64
+
+// freeSpacePoll is how often a paused run looks at the free space again.
+var freeSpacePoll = 5 * time.Second
+
+// sortFiles orders files by order: size puts the smallest first, mtime the
+// oldest first, name by path. Remote files are left at the end.
+func sortFiles(files []string, order string) error {
+	type file struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	entries := make([]file, len(files))
+	for i, path := range files {
+		entries[i].path = path
+		if isRemote(path) {
+			entries[i].size = -1
+			continue
+		}
+		if fInfo, err := os.Stat(path); err == nil {
+			entries[i].size, entries[i].mtime = fInfo.Size(), fInfo.ModTime()
+		}
+	}
+	var less func(a, b file) bool
+	switch order {
+	case "size":
+		less = func(a, b file) bool { return a.size < b.size }
+	case "mtime":
+		less = func(a, b file) bool { return a.mtime.Before(b.mtime) }
+	case "name":
+		less = func(a, b file) bool { return a.path < b.path }
+	default:
+		return fmt.Errorf("unknown order: %s", order)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if remote := entries[i].size < 0; remote != (entries[j].size < 0) {
+			return !remote
+		}
+		return less(entries[i], entries[j])
+	})
+	for i := range entries {
+		files[i] = entries[i].path
+	}
+	return nil
+}
+
+// waitFreeSpace returns once the disk file is written to has room for it
+// with minFree to spare, the disk is checked again every freeSpacePoll while
+// it does not. Remote files, remote outputs and disks whose free space
+// cannot be told are not waited for.
+func waitFreeSpace(file string) {
+	if minFree <= 0 || isRemote(file) {
+		return
+	}
+	dir := filepath.Dir(file)
+	switch out := outStorage.(type) {
+	case nil:
+	case LocalStorage:
+		dir = string(out)
+	default:
+		return
+	}
+	fInfo, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+	need := minFree + fInfo.Size()
+	for paused := false; ; paused = true {
+		free, err := freeSpace(dir)
+		if err != nil || free >= need {
+			if paused {
+				log.Printf("磁盘：%s 剩余 %d 字节，继续处理", dir, free)
+			}
+			return
+		}
+		if !paused {
+			log.Printf("磁盘：%s 剩余 %d 字节，不足以处理 %s 并保留 %d 字节，暂停至空间足够", dir, free, file, minFree)
+		}
+		time.Sleep(freeSpacePoll)
+	}
+}
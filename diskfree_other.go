@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package main
+
+import "errors"
+
+func freeSpace(dir string) (int64, error) {
+	return 0, errors.New("free space is not known on this platform")
+}
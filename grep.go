@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"unicode/utf8"
+)
+
+// grepMaxLine is the longest line neo grep matches as a whole, longer ones
+// are matched in pieces of this size.
+const grepMaxLine = 1 << 20
+
+// grepMatch is a line of a decoded file that matches, Offset is where it
+// starts in the original content.
+type grepMatch struct {
+	Offset int64
+	Line   []byte
+}
+
+func runGrep(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	recursive := fs.Bool("r", false, "递归搜索目录")
+	ignoreCase := fs.Bool("i", false, "忽略大小写")
+	fixed := fs.Bool("F", false, "将模式视为普通字符串而非正则表达式")
+	filesOnly := fs.Bool("l", false, "只列出包含匹配内容的文件及其原文件名")
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return cmd.usageError(fs, "grep needs a pattern and a file or directory")
+	}
+	pattern := fs.Arg(0)
+	if *fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+
+	sum := new(summary)
+	found := 0
+	for _, file := range collectFiles(fs.Args()[1:], *recursive, sum) {
+		if isRemote(file) {
+			continue
+		}
+		dir, name := filepath.Split(file)
+		if ok, err := IsNeoFile(LocalStorage(dir), name); err != nil || !ok {
+			continue
+		}
+		matched := false
+		origName, err := grepFile(file, re, func(m grepMatch) bool {
+			matched = true
+			if *filesOnly {
+				return false
+			}
+			if utf8.Valid(m.Line) {
+				fmt.Printf("%s:%d:%s\n", file, m.Offset, m.Line)
+			} else {
+				fmt.Printf("%s:%d:（二进制内容匹配）\n", file, m.Offset)
+			}
+			return true
+		})
+		if err != nil {
+			log.Printf("读取文件：%s 失败，错误：%v", file, err)
+			sum.failed++
+			continue
+		}
+		if matched {
+			found++
+			if *filesOnly {
+				fmt.Printf("%s\t%s\n", file, origName)
+			}
+		}
+	}
+	log.Printf("完成：%d 个文件匹配，失败 %d 个", found, sum.failed)
+	return nil
+}
+
+// grepFile decodes file in memory and calls match for every line of the
+// content that re matches, until it returns false. It returns the original
+// filename.
+func grepFile(file string, re *regexp.Regexp, match func(grepMatch) bool) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	rd := newNeoReader(f)
+	hdr, err := rd.Header()
+	if err != nil {
+		return "", err
+	}
+	br := bufio.NewReaderSize(rd, grepMaxLine)
+	var offset int64
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 && re.Match(line) {
+			if !match(grepMatch{Offset: offset, Line: bytes.TrimRight(line, "\r\n")}) {
+				return hdr.OriginalFilename, nil
+			}
+		}
+		offset += int64(len(line))
+		switch err {
+		case nil, bufio.ErrBufferFull:
+		case io.EOF:
+			return hdr.OriginalFilename, nil
+		default:
+			return hdr.OriginalFilename, err
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestGrepFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, []byte("first line\nthe needle is here\nlast needle\n"), 0644)
+	res, err := parseFile(file, ActionEncode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []grepMatch
+	name, err := grepFile(res.Output, regexp.MustCompile("needle"), func(m grepMatch) bool {
+		got = append(got, m)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "notes.txt" || len(got) != 2 {
+		t.Fatalf("name %q, matches %+v", name, got)
+	}
+	if got[0].Offset != 11 || string(got[0].Line) != "the needle is here" || got[1].Offset != 30 {
+		t.Fatalf("unexpected matches %+v", got)
+	}
+}
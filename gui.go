@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+//go:embed gui.html
+var guiPage []byte
+
+var ErrBadUploadName = errors.New("bad upload name")
+
+// guiServer is a small local web frontend, files dropped into the page are
+// uploaded and encoded or decoded into dir.
+type guiServer struct {
+	dir string
+	// token is embedded in the page and required by the API, other sites
+	// can send requests to it but cannot read the page
+	token string
+}
+
+func runGUI(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	addr := fs.String("addr", "127.0.0.1:0", "监听地址")
+	dir := fs.String("dir", ".", "处理结果的保存目录")
+	noBrowser := fs.Bool("no-browser", false, "不自动打开浏览器")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+
+	absDir, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	srv := &guiServer{dir: absDir, token: hex.EncodeToString(token)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", srv.index)
+	mux.HandleFunc("/api/inspect", srv.api(srv.inspect))
+	mux.HandleFunc("/api/process", srv.api(srv.process))
+	mux.Handle("/metrics", &metrics)
+
+	u := "http://" + ln.Addr().String() + "/"
+	log.Printf("图形界面已启动：%s，结果保存至：%s", u, absDir)
+	if !*noBrowser {
+		if err := openBrowser(u); err != nil {
+			log.Printf("无法打开浏览器，错误：%v", err)
+		}
+	}
+	return http.Serve(ln, mux)
+}
+
+func (s *guiServer) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(bytes.Replace(guiPage, []byte("{{token}}"), []byte(s.token), 1))
+}
+
+// api refuses requests made by other sites, they come with an Origin of
+// their own or without the token of the page.
+func (s *guiServer) api(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Neo-Token")), []byte(s.token)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+type inspectResponse struct {
+	Neo          bool   `json:"neo"`
+	OriginalName string `json:"original_name,omitempty"`
+}
+
+// inspect receives the first bytes of a file and tells whether it is a NEO
+// file and what name it will be restored to.
+func (s *guiServer) inspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var resp inspectResponse
+	b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if bytes.HasPrefix(b, codec.NeoMagicNumber) {
+		resp.Neo = true
+		if hdr, err := codec.ReadHeader(bytes.NewReader(b)); err == nil {
+			resp.OriginalName = hdr.OriginalFilename
+		}
+	}
+	writeJSON(w, resp)
+}
+
+func (s *guiServer) process(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	atomic.AddInt64(&metrics.queue, 1)
+	res, err := s.processUpload(r.URL.Query().Get("name"), r.Body)
+	atomic.AddInt64(&metrics.queue, -1)
+	metrics.record(res, err)
+	if err != nil {
+		res.Error = err.Error()
+		logError(err)
+	}
+	writeJSON(w, res)
+}
+
+func (s *guiServer) processUpload(name string, body io.Reader) (Result, error) {
+	res := Result{Input: name}
+	name = path.Clean("/" + strings.ReplaceAll(name, `\`, "/"))[1:]
+	if name == "" {
+		return res, ErrBadUploadName
+	}
+	dst := filepath.Join(s.dir, filepath.FromSlash(path.Dir(name)))
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return res, err
+	}
+	stageDir, err := os.MkdirTemp(dst, ".neo-upload-")
+	if err != nil {
+		return res, err
+	}
+	defer os.RemoveAll(stageDir)
+	staged := LocalStorage(stageDir)
+	fd, err := staged.Create(path.Base(name))
+	if err != nil {
+		return res, err
+	}
+	_, err = io.Copy(fd, body)
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, err
+	}
+	isNeoFile, err := IsNeoFile(staged, path.Base(name))
+	if err != nil {
+		return res, err
+	}
+	if isNeoFile {
+		res, err = DecodeFile(staged, path.Base(name), LocalStorage(dst))
+	} else {
+		res, err = EncodeFile(staged, path.Base(name), LocalStorage(dst))
+	}
+	res.Input = name
+	return res, err
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func openBrowser(u string) error {
+	switch runtime.GOOS {
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Start()
+	case "darwin":
+		return exec.Command("open", u).Start()
+	default:
+		return exec.Command("xdg-open", u).Start()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGUIServer_API(t *testing.T) {
+	s := &guiServer{dir: t.TempDir(), token: "0123456789abcdef"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.index)
+	mux.HandleFunc("/api/inspect", s.api(s.inspect))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), `content="0123456789abcdef"`) {
+		t.Fatal("token not in the page")
+	}
+
+	for _, c := range []struct {
+		token, origin string
+		want          int
+	}{
+		{"", "", http.StatusForbidden},
+		{"wrong", "", http.StatusForbidden},
+		{s.token, "http://evil.example", http.StatusForbidden},
+		{s.token, srv.URL, http.StatusOK},
+		{s.token, "", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/inspect", strings.NewReader("plain"))
+		if c.token != "" {
+			req.Header.Set("X-Neo-Token", c.token)
+		}
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("token %q origin %q: except %d, but %d", c.token, c.origin, c.want, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// headerCacheFile keeps the parsed headers across runs, empty keeps them in
+// memory only.
+var headerCacheFile string
+
+// headers caches the parsed headers of NEO files by path, size and
+// modification time, so going over a big directory again does not reopen
+// and parse every file.
+var headers = newHeaderCache()
+
+// headerEntry is a cached header. Header is nil for files that are not NEO
+// files, it never holds the original header. Content is the length of the
+// decoded content. Strict and KeyID are the settings it was parsed with,
+// entries made with others are not used.
+type headerEntry struct {
+	Size    int64            `json:"size"`
+	ModTime time.Time        `json:"mtime"`
+	Strict  bool             `json:"strict"`
+	KeyID   string           `json:"key"`
+	Header  *codec.NeoHeader `json:"header,omitempty"`
+	Content int64            `json:"content,omitempty"`
+	// private is set for headers only the key reads: stealth and keyed
+	// files and those with encrypted metadata, they are not saved
+	private bool
+}
+
+// headerCacheSaltSize is the size of the salt keyIDs are made with.
+const headerCacheSaltSize = 16
+
+type headerCache struct {
+	mu    sync.Mutex
+	salt  []byte
+	files map[string]*headerEntry
+}
+
+func newHeaderCache() *headerCache {
+	salt := make([]byte, headerCacheSaltSize)
+	rand.Read(salt)
+	return &headerCache{salt: salt, files: make(map[string]*headerEntry)}
+}
+
+// headerCacheData is what a header cache file holds.
+type headerCacheData struct {
+	Salt  []byte                  `json:"salt"`
+	Files map[string]*headerEntry `json:"files"`
+}
+
+// keyID tells apart the keys headers were parsed with. It is derived from
+// the key with the salt of the cache like the keys of files, so a saved
+// cache is no quicker to check guessed passwords against than a file.
+func (c *headerCache) keyID() string {
+	if key == nil {
+		return ""
+	}
+	c.mu.Lock()
+	salt := c.salt
+	c.mu.Unlock()
+	return hex.EncodeToString(codec.KeyID(deriveKey(key, salt, codec.DefaultKDFCost)))
+}
+
+// header returns the header of the NEO file at path, or nil if it is not a
+// NEO file. The result is shared and must not be modified.
+func (c *headerCache) header(path string) (*codec.NeoHeader, error) {
+	e, err := c.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return e.Header, nil
+}
+
+func (c *headerCache) lookup(path string) (*headerEntry, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	keyID := c.keyID()
+	c.mu.Lock()
+	e := c.files[path]
+	c.mu.Unlock()
+	if e != nil && e.Size == fInfo.Size() && e.ModTime.Equal(fInfo.ModTime()) && e.Strict == strictParse && e.KeyID == keyID {
+		return e, nil
+	}
+
+	e = &headerEntry{Size: fInfo.Size(), ModTime: fInfo.ModTime(), Strict: strictParse, KeyID: keyID}
+	dir, name := filepath.Split(path)
+	ok, err := IsNeoFile(LocalStorage(dir), name)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if err := e.read(path); err != nil {
+			return nil, err
+		}
+	}
+	c.mu.Lock()
+	c.files[path] = e
+	c.mu.Unlock()
+	return e, nil
+}
+
+func (e *headerEntry) read(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd := newNeoReader(f)
+	hdr, err := rd.Header()
+	if err != nil {
+		return err
+	}
+	cp := *hdr
+	cp.OriginalHeader = nil
+	e.Header = &cp
+	e.private = hdr.Magic != nil || hdr.KeySlots != nil || hdr.EncryptedMeta
+	e.Content = e.Size - int64(rd.HeaderSize()) + int64(rd.OriginalHeaderLen())
+	return nil
+}
+
+// load adds the entries saved in file and takes over its salt, a missing
+// file is not an error. Files of older versions are ignored.
+func (c *headerCache) load(file string) error {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var data headerCacheData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if len(data.Salt) != headerCacheSaltSize {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.salt = data.Salt
+	for path, e := range data.Files {
+		if _, ok := c.files[path]; !ok {
+			c.files[path] = e
+		}
+	}
+	return nil
+}
+
+// save writes the entries of files that still exist to file, but for
+// private ones.
+func (c *headerCache) save(file string) error {
+	c.mu.Lock()
+	data := headerCacheData{Salt: c.salt, Files: make(map[string]*headerEntry)}
+	for path, e := range c.files {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			delete(c.files, path)
+			continue
+		}
+		if !e.private {
+			data.Files[path] = e
+		}
+	}
+	b, err := json.Marshal(data)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0600)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHeaderCache_Save(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("plain"), 0644)
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+	key, _ = loadKey("secret", "")
+	defer func() { key, encodeEncryptMeta = nil, false }()
+	plain, err := EncodeFile(LocalStorage(dir), "plain.txt", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodeEncryptMeta = true
+	secret, err := EncodeFile(LocalStorage(dir), "secret.txt", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newHeaderCache()
+	for _, file := range []string{plain.Output, secret.Output} {
+		if hdr, err := c.header(file); err != nil || hdr == nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+	cache := filepath.Join(dir, "cache.json")
+	if err := c.save(cache); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(cache)
+	sum := sha256.Sum256(key)
+	if bytes.Contains(b, []byte(hex.EncodeToString(sum[:8]))) {
+		t.Fatal("cache holds an unsalted hash of the key")
+	}
+	if bytes.Contains(b, []byte(filepath.Base(secret.Output))) {
+		t.Fatal("cache holds the header of a file with encrypted metadata")
+	}
+
+	loaded := newHeaderCache()
+	if err := loaded.load(cache); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.keyID() != c.keyID() {
+		t.Fatal("salt not loaded")
+	}
+	abs, _ := filepath.Abs(plain.Output)
+	e := loaded.files[abs]
+	if e == nil {
+		t.Fatal("entry not loaded")
+	}
+	if got, _ := loaded.lookup(plain.Output); got != e {
+		t.Fatal("loaded entry parsed again")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// fileHooks run after every processed file.
+type fileHooks struct {
+	onSuccess string
+	onFailure string
+	webhook   string
+	auditLog  *remoteLog
+}
+
+var hooks fileHooks
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func addHookFlags(fs *flag.FlagSet, webhook bool) {
+	fs.StringVar(&hooks.onSuccess, "on-success", "", "每个文件处理成功后执行的命令，处理结果通过 NEO_* 环境变量传入")
+	fs.StringVar(&hooks.onFailure, "on-failure", "", "每个文件处理失败后执行的命令，处理结果通过 NEO_* 环境变量传入")
+	fs.StringVar(&plugin, "plugin", "", "处理每个文件前执行的命令，可输出 skip [原因] 跳过该文件，或 name 文件名 指定输出文件名")
+	if webhook {
+		fs.StringVar(&hooks.webhook, "webhook", "", "每个文件处理后以 POST 发送 JSON 结果的地址")
+		fs.Func("audit-log", "将每个文件的校验值与处理结果以前后相连的哈希链追加至远程日志：http(s):// 地址，或 syslog://主机[:端口]（UDP）、syslog+tcp://主机[:端口]", func(s string) (err error) {
+			hooks.auditLog, err = newRemoteLog(s)
+			return err
+		})
+	}
+}
+
+func hookEnv(res Result, err error) []string {
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	return append(os.Environ(),
+		"NEO_STATUS="+status,
+		"NEO_ACTION="+string(res.Action),
+		"NEO_INPUT="+res.Input,
+		"NEO_OUTPUT="+res.Output,
+		"NEO_ORIGINAL_NAME="+res.OriginalName,
+		fmt.Sprintf("NEO_BYTES=%d", res.Bytes),
+		fmt.Sprintf("NEO_CRC32=%08x", res.Checksum),
+		"NEO_SHA256="+res.SHA256,
+		"NEO_XXH64="+res.XXH64,
+		"NEO_ERROR="+res.Error,
+	)
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// run executes the hooks for one result, their failures are only logged.
+func (h *fileHooks) run(res Result, err error) {
+	if err != nil && res.Error == "" {
+		res.Error = err.Error()
+	}
+	command := h.onSuccess
+	if err != nil {
+		command = h.onFailure
+	}
+	if command != "" {
+		cmd := shellCommand(command)
+		cmd.Env = hookEnv(res, err)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("执行命令：%s 失败，错误：%v", command, err)
+		}
+	}
+	if h.webhook != "" {
+		if err := postJSON(h.webhook, res); err != nil {
+			log.Printf("发送 webhook：%s 失败，错误：%v", h.webhook, err)
+		}
+	}
+	if h.auditLog != nil {
+		h.auditLog.record(res, err)
+	}
+}
+
+func postJSON(u string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(u, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s", u, resp.Status)
+	}
+	return nil
+}
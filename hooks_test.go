@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+	var got Result
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	h := &fileHooks{
+		onSuccess: `echo "$NEO_STATUS $NEO_ACTION $NEO_OUTPUT" > ` + out,
+		onFailure: `echo "$NEO_STATUS $NEO_ERROR" > ` + out,
+		webhook:   srv.URL,
+	}
+
+	h.run(Result{Action: ActionEncode, Input: "a", Output: "b.neo"}, nil)
+	if b, _ := os.ReadFile(out); strings.TrimSpace(string(b)) != "success encode b.neo" {
+		t.Fatalf("unexpected hook output %q", b)
+	}
+	if got.Output != "b.neo" {
+		t.Fatalf("unexpected webhook body %+v", got)
+	}
+	h.run(Result{Action: ActionDecode, Input: "a"}, errors.New("boom"))
+	if b, _ := os.ReadFile(out); strings.TrimSpace(string(b)) != "failure boom" {
+		t.Fatalf("unexpected hook output %q", b)
+	}
+	if got.Error != "boom" {
+		t.Fatalf("unexpected webhook body %+v", got)
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("keep"), 0644)
+	os.WriteFile(filepath.Join(dir, "drop.txt"), []byte("drop"), 0644)
+	plugin = `case "$NEO_ACTION $NEO_ORIGINAL_NAME" in
+"encode drop.txt") echo skip not wanted ;;
+"encode keep.txt") echo name "kept-$NEO_SIZE.neo" ;;
+decode*) echo name "restored-$NEO_ORIGINAL_NAME" ;;
+esac`
+	defer func() { plugin = "" }()
+
+	if _, err := parseFile(filepath.Join(dir, "drop.txt"), ActionEncode); !errors.Is(err, ErrExcluded) {
+		t.Fatalf("except ErrExcluded, but %v", err)
+	}
+	res, err := parseFile(filepath.Join(dir, "keep.txt"), ActionEncode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Output != filepath.Join(dir, "kept-4.neo") {
+		t.Fatalf("encoded to %s", res.Output)
+	}
+	if res, err = parseFile(res.Output, ActionDecode); err != nil {
+		t.Fatal(err)
+	}
+	if res.Output != filepath.Join(dir, "restored-keep.txt") {
+		t.Fatalf("decoded to %s", res.Output)
+	}
+}
+
+func TestRemoteLog(t *testing.T) {
+	var records []logRecord
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var rec logRecord
+		json.NewDecoder(r.Body).Decode(&rec)
+		records = append(records, rec)
+	}))
+	defer srv.Close()
+
+	l, err := newRemoteLog(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail = false
+	l.record(Result{Action: ActionEncode, Input: "a.txt", SHA256: "ab"}, nil)
+	l.record(Result{Action: ActionEncode, Input: "b.txt", Error: "boom"}, errors.New("boom"))
+	if len(records) != 3 || records[0].Action != "start" || records[2].Status != "failure" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	prev := ""
+	for i, r := range records {
+		hash := r.Hash
+		r.seal()
+		if r.Seq != int64(i) || r.Prev != prev || r.Hash != hash {
+			t.Fatalf("record %d breaks the chain: %+v", i, r)
+		}
+		prev = hash
+	}
+
+	if _, err := newRemoteLog("ftp://example.com"); err == nil {
+		t.Fatal("unknown scheme accepted")
+	}
+}
+
+func TestSendSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	if err := sendSyslog("udp", conn.LocalAddr().String(), []byte(`{"seq":0}`)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<133>1 ") || !strings.HasSuffix(msg, ` - - {"seq":0}`) {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// indexName is the default name of the index neo index build writes into
+// the directory it indexes.
+const indexName = ".neo-index"
+
+// indexMagic starts every index file, it is followed by the key derivation
+// salt and cost, the GCM nonce and the sealed JSON of the entries.
+var indexMagic = []byte("NEOIDX2\n")
+
+var ErrBadIndex = errors.New("not a neo index")
+
+// ErrIndexKey is returned when the index does not open with the key, which
+// is the wrong one or the index has been damaged.
+var ErrIndexKey = errors.New("wrong password, or the index is damaged")
+
+func runIndex(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	index := fs.String("index", "", "索引文件路径，默认为目录下的 "+indexName)
+	jsonOut := fs.Bool("json", false, "ls 时以 JSON 格式打印每个文件")
+	jobs := fs.Int("j", runtime.NumCPU(), "build 时同时读取的文件数")
+	password := fs.String("password", "", "密码，用于加密索引及识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if len(args) == 0 || (args[0] != "build" && args[0] != "ls") {
+		if err := cmd.parse(fs, args); err != nil {
+			return err
+		}
+		return cmd.usageError(fs, "index needs build or ls")
+	}
+	sub := args[0]
+	if err := cmd.parse(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return cmd.usageError(fs, "index needs exactly one directory")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	if key == nil {
+		return cmd.usageError(fs, "index needs -password or -keyfile")
+	}
+	dir := fs.Arg(0)
+	if *index == "" {
+		*index = filepath.Join(dir, indexName)
+	}
+	if sub == "build" {
+		return buildIndex(dir, *index, *jobs)
+	}
+	return listIndex(*index, *jsonOut)
+}
+
+// buildIndex indexes the NEO files under dir, files whose size and
+// modification time match their entry in the index at path are not opened.
+func buildIndex(dir, path string, jobs int) error {
+	entries, err := readIndex(path)
+	if os.IsNotExist(err) {
+		entries = make(map[string]*headerEntry)
+	} else if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	// the index only opens with the key, so its entries were read with it
+	keyID := headers.keyID()
+	headers.mu.Lock()
+	for rel, e := range entries {
+		e.KeyID = keyID
+		headers.files[filepath.Join(abs, filepath.FromSlash(rel))] = e
+	}
+	headers.mu.Unlock()
+
+	sum := new(summary)
+	failed := 0
+	indexed := make(map[string]*headerEntry)
+	for res := range walkHeaders([]string{dir}, true, jobs, sum) {
+		if res.Err != nil {
+			log.Printf("读取文件：%s 头部失败，错误：%v", res.Path, res.Err)
+			failed++
+			continue
+		}
+		if res.Entry.Header == nil {
+			continue
+		}
+		file, err := filepath.Abs(res.Path)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(abs, file)
+		if err != nil {
+			continue
+		}
+		indexed[filepath.ToSlash(rel)] = res.Entry
+	}
+	if err := writeIndex(path, indexed); err != nil {
+		return err
+	}
+	log.Printf("已索引 %d 个文件至：%s，失败 %d 个", len(indexed), path, failed+sum.failed)
+	return nil
+}
+
+func listIndex(path string, jsonOut bool) error {
+	entries, err := readIndex(path)
+	if err != nil {
+		return err
+	}
+	rels := make([]string, 0, len(entries))
+	for rel := range entries {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	enc := json.NewEncoder(os.Stdout)
+	for _, rel := range rels {
+		e := entries[rel]
+		if jsonOut {
+			enc.Encode(newLsEntry(rel, e))
+			continue
+		}
+		fmt.Printf("%s\t%d\t%s\n", rel, e.Content, e.Header.OriginalFilename)
+	}
+	return nil
+}
+
+// indexCipher derives the key of the index from key with the salt and the
+// cost the index stores, apart from the one stealth files use.
+func indexCipher(salt []byte, cost uint8) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, deriveKey(key, salt, cost))
+	mac.Write([]byte("neo index"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func readIndex(path string) (map[string]*headerEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	prefix := len(indexMagic) + codec.KDFSaltSize + 1
+	if !bytes.HasPrefix(b, indexMagic) || len(b) < prefix || b[prefix-1] > codec.MaxKDFCost {
+		return nil, fmt.Errorf("%s: %w", path, ErrBadIndex)
+	}
+	aead, err := indexCipher(b[len(indexMagic):prefix-1], b[prefix-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < prefix+aead.NonceSize() {
+		return nil, fmt.Errorf("%s: %w", path, ErrBadIndex)
+	}
+	ad, b := b[:prefix], b[prefix:]
+	plain, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrIndexKey)
+	}
+	entries := make(map[string]*headerEntry)
+	if err := json.Unmarshal(plain, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// writeIndex seals entries and replaces the index at path with them.
+func writeIndex(path string, entries map[string]*headerEntry) error {
+	plain, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	aead, err := indexCipher(writeSalt, codec.DefaultKDFCost)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ad := append(append(append([]byte(nil), indexMagic...), writeSalt...), codec.DefaultKDFCost)
+	b := append(append(append([]byte(nil), ad...), nonce...), aead.Seal(nil, nonce, plain, ad)...)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
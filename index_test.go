@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	key, _ = loadKey("secret", "")
+	defer func() { key = nil }()
+	var neoFiles []string
+	for _, name := range []string{"a.txt", "b.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		res, err := parseFile(filepath.Join(dir, name), ActionEncode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(filepath.Join(dir, name))
+		neoFiles = append(neoFiles, res.Output)
+	}
+	index := filepath.Join(dir, indexName)
+	if err := buildIndex(dir, index, 2); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := filepath.Base(neoFiles[0])
+	if len(entries) != 2 || entries[rel] == nil || entries[rel].Header.OriginalFilename != "a.txt" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+
+	// unchanged files are taken from the index, not parsed again
+	headers = newHeaderCache()
+	fInfo, _ := os.Stat(neoFiles[0])
+	os.WriteFile(neoFiles[0], make([]byte, fInfo.Size()), 0644)
+	os.Chtimes(neoFiles[0], time.Now(), fInfo.ModTime())
+	os.Remove(neoFiles[1])
+	if err := buildIndex(dir, index, 2); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = readIndex(index); len(entries) != 1 || entries[rel] == nil {
+		t.Fatalf("unexpected entries after update %v", entries)
+	}
+
+	key, _ = loadKey("wrong", "")
+	if _, err := readIndex(index); !errors.Is(err, ErrIndexKey) {
+		t.Fatalf("except ErrIndexKey, but %v", err)
+	}
+}
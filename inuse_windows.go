@@ -0,0 +1,20 @@
+package main
+
+import "syscall"
+
+const errSharingViolation syscall.Errno = 32
+
+// fileInUse reports whether another process holds path open, opening it
+// without sharing fails then.
+func fileInUse(path string) bool {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return err == errSharingViolation
+	}
+	syscall.CloseHandle(h)
+	return false
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+var errNoJournal = errors.New("no change journal on this platform")
+
+// journalMark is where the change journal of the source volume stood at the
+// last sync, kept in the sync database.
+type journalMark struct {
+	Volume string `json:"volume"`
+	ID     uint64 `json:"id"`
+	USN    int64  `json:"usn"`
+}
+
+// journalChanges are the file names the change journal saw changing since
+// a journalMark. They are names, not paths, so a change to any file of
+// the same name counts for all of them.
+type journalChanges struct {
+	names map[string]bool
+	// mark is where the journal stands now, saved for the next sync
+	mark *journalMark
+}
+
+// changed reports whether the file at path may have changed since the mark.
+func (c *journalChanges) changed(path string) bool {
+	return c == nil || c.names[strings.ToLower(filepath.Base(path))]
+}
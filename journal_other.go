@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// readJournal has no journal to read, macOS FSEvents are not reachable
+// without cgo. Sync then hashes every file as before.
+func readJournal(dir string, last *journalMark) (*journalChanges, *journalMark, error) {
+	return nil, nil, errNoJournal
+}
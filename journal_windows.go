@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unicode/utf16"
+)
+
+const (
+	fsctlQueryUSNJournal = 0x000900f4
+	fsctlReadUSNJournal  = 0x000900bb
+)
+
+// readJournal reads the USN journal of the NTFS volume holding dir. The
+// changes are nil when there is no usable last mark, the journal was
+// recreated or has wrapped around since; every file then counts as
+// changed. The returned mark is where the journal stands now. Opening a
+// volume needs administrator rights.
+func readJournal(dir string, last *journalMark) (*journalChanges, *journalMark, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	vol := filepath.VolumeName(abs)
+	if len(vol) != 2 || vol[1] != ':' {
+		return nil, nil, fmt.Errorf("%s: %w", abs, errNoJournal)
+	}
+	p, err := syscall.UTF16PtrFromString(`\\.\` + vol)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ, syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer syscall.CloseHandle(h)
+
+	// USN_JOURNAL_DATA_V0
+	var data [56]byte
+	var n uint32
+	if err := syscall.DeviceIoControl(h, fsctlQueryUSNJournal, nil, 0, &data[0], uint32(len(data)), &n, nil); err != nil {
+		return nil, nil, err
+	}
+	id := binary.LittleEndian.Uint64(data[0:])
+	first := int64(binary.LittleEndian.Uint64(data[8:]))
+	next := int64(binary.LittleEndian.Uint64(data[16:]))
+	mark := &journalMark{Volume: strings.ToUpper(vol), ID: id, USN: next}
+	if last == nil || last.Volume != mark.Volume || last.ID != id || last.USN < first || last.USN > next {
+		return nil, mark, nil
+	}
+
+	changes := &journalChanges{names: make(map[string]bool), mark: mark}
+	buf := make([]byte, 64<<10)
+	// READ_USN_JOURNAL_DATA_V0
+	var in [40]byte
+	binary.LittleEndian.PutUint32(in[8:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint64(in[32:], id)
+	for usn := last.USN; usn < next; {
+		binary.LittleEndian.PutUint64(in[0:], uint64(usn))
+		if err := syscall.DeviceIoControl(h, fsctlReadUSNJournal, &in[0], uint32(len(in)), &buf[0], uint32(len(buf)), &n, nil); err != nil {
+			return nil, nil, err
+		}
+		if n <= 8 {
+			break
+		}
+		usn = int64(binary.LittleEndian.Uint64(buf))
+		for rec := buf[8:n]; len(rec) >= 60; {
+			size := binary.LittleEndian.Uint32(rec)
+			if size < 60 || int(size) > len(rec) {
+				break
+			}
+			// USN_RECORD_V2 and V3 differ in the size of the file reference
+			// numbers before the name
+			var nameLen, nameOff int
+			switch major := binary.LittleEndian.Uint16(rec[4:]); {
+			case major == 2:
+				nameLen, nameOff = int(binary.LittleEndian.Uint16(rec[56:])), int(binary.LittleEndian.Uint16(rec[58:]))
+			case major == 3 && size >= 76:
+				nameLen, nameOff = int(binary.LittleEndian.Uint16(rec[72:])), int(binary.LittleEndian.Uint16(rec[74:]))
+			}
+			if nameLen > 0 && nameOff+nameLen <= int(size) {
+				u := make([]uint16, nameLen/2)
+				for i := range u {
+					u[i] = binary.LittleEndian.Uint16(rec[nameOff+2*i:])
+				}
+				changes.names[strings.ToLower(string(utf16.Decode(u)))] = true
+			}
+			rec = rec[size:]
+		}
+	}
+	return changes, mark, nil
+}
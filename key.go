@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+var ErrNoKey = errors.New("no password or keyfile given")
+
+// loadKey turns a password or the content of a keyfile into the secret the
+// keys of files are derived from, nil when neither is given. The secret is
+// never stored: stealth magics and key ids are made with deriveKey, which
+// salts and stretches it per file.
+func loadKey(password, keyfile string) ([]byte, error) {
+	switch {
+	case password != "" && keyfile != "":
+		return nil, errors.New("password and keyfile are mutually exclusive")
+	case password != "":
+		sum := sha256.Sum256([]byte(password))
+		return sum[:], nil
+	case keyfile != "":
+		b, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		return sum[:], nil
+	}
+	return nil, nil
+}
+
+// writeSalt is the key derivation salt of the files encoded by this run,
+// so one derivation serves all of them.
+var writeSalt = func() []byte {
+	salt := make([]byte, codec.KDFSaltSize)
+	rand.Read(salt)
+	return salt
+}()
+
+// derivedKey is a derivation done once for all who need it.
+type derivedKey struct {
+	once sync.Once
+	key  []byte
+}
+
+// derivedKeys caches deriveKey by secret, salt and cost.
+var derivedKeys = struct {
+	sync.Mutex
+	m map[string]*derivedKey
+}{m: make(map[string]*derivedKey)}
+
+// deriveKey derives the key of a file from secret with the salt and the
+// cost stored in its header, see codec.DeriveKey. Files encoded in one run
+// share the salt, so the result is kept.
+func deriveKey(secret, salt []byte, cost uint8) []byte {
+	// the secret has a fixed size
+	id := string(secret) + string(salt) + string([]byte{cost})
+	derivedKeys.Lock()
+	d := derivedKeys.m[id]
+	if d == nil {
+		d = new(derivedKey)
+		derivedKeys.m[id] = d
+	}
+	derivedKeys.Unlock()
+	d.once.Do(func() { d.key = codec.DeriveKey(secret, salt, cost) })
+	return d.key
+}
+
+// keyFunc lets codec readers derive the keys of headers from secret.
+func keyFunc(secret []byte) codec.KeyFunc {
+	return func(salt []byte, cost uint8) []byte {
+		return deriveKey(secret, salt, cost)
+	}
+}
+
+// writeKey is the key files encoded with secret are made with.
+func writeKey(secret []byte) []byte {
+	return deriveKey(secret, writeSalt, codec.DefaultKDFCost)
+}
+
+// headerKeyID is the key id secret has in a stealth or keyed header, "" for
+// other headers.
+func headerKeyID(hdr *codec.NeoHeader, secret []byte) string {
+	if secret == nil || hdr.KDFSalt == nil {
+		return ""
+	}
+	return hex.EncodeToString(codec.KeyID(deriveKey(secret, hdr.KDFSalt, hdr.KDFCost)))
+}
+
+// ErrKeyMismatch is returned for files that neither are NEO files nor carry
+// the stealth magic of the key, with a key the two cannot be told apart.
+var ErrKeyMismatch = fmt.Errorf("%w, or encoded with another password", codec.ErrNotNEOHeader)
+
+// ErrWrongPassword is returned when the password was not confirmed or did
+// not match any file after passwordTries attempts.
+var ErrWrongPassword = errors.New("wrong password")
+
+// passwordTries is how many passwords askKey reads before giving up.
+const passwordTries = 3
+
+// askKey reads a password from the terminal without echo and turns it into
+// key material. With confirm the password is read twice, with match it is
+// asked again while match rejects the key.
+func askKey(confirm bool, match func([]byte) bool) ([]byte, error) {
+	for i := 0; i < passwordTries; i++ {
+		password, err := readPassword("密码：")
+		if err != nil {
+			return nil, err
+		}
+		if password == "" {
+			fmt.Fprintln(os.Stderr, "密码不能为空")
+			continue
+		}
+		if confirm {
+			again, err := readPassword("再次输入密码：")
+			if err != nil {
+				return nil, err
+			}
+			if again != password {
+				fmt.Fprintln(os.Stderr, "两次输入的密码不一致")
+				continue
+			}
+		}
+		k, _ := loadKey(password, "")
+		if match != nil && !match(k) {
+			fmt.Fprintln(os.Stderr, "密码错误：没有文件由此密码编码")
+			continue
+		}
+		return k, nil
+	}
+	return nil, ErrWrongPassword
+}
+
+// stealthHeaders returns the start of the local files that are not NEO
+// files by their magic number but may be stealth files made with any key.
+// Files that cannot be read are left out, decoding reports them.
+func stealthHeaders(files []string) []*codec.Stealth {
+	var stealths []*codec.Stealth
+	for _, file := range files {
+		if isRemote(file) {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		s, err := codec.ReadStealth(f)
+		f.Close()
+		if err == nil {
+			stealths = append(stealths, s)
+		}
+	}
+	return stealths
+}
+
+// matchesAny reports whether k is the key of one of the stealth files whose
+// headers are given, or there are no such files to check it against.
+func matchesAny(stealths []*codec.Stealth) func([]byte) bool {
+	return func(k []byte) bool {
+		if len(stealths) == 0 {
+			return true
+		}
+		for _, s := range stealths {
+			if s.Match(keyFunc(k)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// readLine reads up to the next newline a byte at a time, so nothing after
+// it is taken from r.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// keyfileSize is how many random bytes neo key generate writes.
+const keyfileSize = 32
+
+// keyFingerprint tells keyfiles apart. Files record key ids derived with
+// their own salt instead, see headerKeyID.
+func keyFingerprint(k []byte) string {
+	return hex.EncodeToString(codec.KeyID(k))
+}
+
+func runKey(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	size := fs.Int("size", keyfileSize, "generate 时密钥文件的字节数")
+	password := fs.String("password", "", "show 时以此密码识别 -stealth 编码的文件，并显示其在各文件中的指纹")
+	wrapPassword := fs.String("wrap-password", "", "export 时以此密码加密导出的密钥，import 时以此密码解密")
+	qr := fs.Bool("qr", false, "export 时以二维码显示在终端中")
+	invert := fs.Bool("invert", false, "反色显示二维码，适用于浅色背景的终端")
+	if len(args) == 0 || (args[0] != "generate" && args[0] != "show" && args[0] != "export" && args[0] != "import") {
+		if err := cmd.parse(fs, args); err != nil {
+			return err
+		}
+		return cmd.usageError(fs, "key needs generate, show, export or import")
+	}
+	sub := args[0]
+	if err := cmd.parse(fs, args[1:]); err != nil {
+		return err
+	}
+	switch sub {
+	case "export":
+		if fs.NArg() != 1 {
+			return cmd.usageError(fs, "export needs one keyfile")
+		}
+		s, err := exportKeyfile(fs.Arg(0), *wrapPassword)
+		if err != nil {
+			return err
+		}
+		if !*qr {
+			fmt.Println(s)
+			return nil
+		}
+		q, err := encodeQR([]byte(s))
+		if err != nil {
+			return err
+		}
+		return q.writeTerminal(os.Stdout, *invert)
+	case "import":
+		if fs.NArg() != 2 {
+			return cmd.usageError(fs, "import needs a key string, or - to read it, and a keyfile")
+		}
+		s := fs.Arg(0)
+		if s == "-" {
+			var err error
+			if s, err = readLine(os.Stdin); err != nil && err != io.EOF {
+				return err
+			}
+		}
+		k, err := importKeyfile(strings.TrimSpace(s), *wrapPassword, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", keyFingerprint(k), fs.Arg(1))
+		return nil
+	}
+	if sub == "generate" {
+		if fs.NArg() == 0 || *size < 16 {
+			return cmd.usageError(fs, "generate needs a file and a size of at least 16")
+		}
+		for _, file := range fs.Args() {
+			k, err := generateKeyfile(file, *size)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\n", keyFingerprint(k), file)
+		}
+		return nil
+	}
+	if fs.NArg() == 0 {
+		return cmd.usageError(fs, "show needs files")
+	}
+	key, _ = loadKey(*password, "")
+	for _, file := range fs.Args() {
+		id, own, err := fileKeyID(file)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", id, file)
+		if own != "" {
+			fmt.Printf("%s\t-password\n", own)
+		}
+	}
+	return nil
+}
+
+// generateKeyfile writes size random bytes to a new keyfile.
+func generateKeyfile(file string, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return writeKeyfile(file, b)
+}
+
+// writeKeyfile writes b to a new file readable only by its owner and returns
+// its key material.
+func writeKeyfile(file string, b []byte) ([]byte, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(file)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return loadKey("", file)
+}
+
+// fileKeyID returns the key ids of the slots of a NEO file, "-" for files
+// encoded without -keyed, or the fingerprint of a keyfile. own is the key
+// id of key in a stealth or keyed file it reads.
+func fileKeyID(file string) (id, own string, err error) {
+	dir, name := filepath.Split(file)
+	if ok, err := IsNeoFile(LocalStorage(dir), name); err != nil || !ok {
+		k, err := loadKey("", file)
+		if err != nil {
+			return "", "", err
+		}
+		return keyFingerprint(k), "", nil
+	}
+	hdr, err := readNeoHeader(file)
+	var idErr *codec.KeyIDError
+	switch {
+	case errors.As(err, &idErr):
+		return keyIDList(idErr.KeyIDs), "", nil
+	case err != nil:
+		return "", "", &OpError{Op: "header", Path: file, Err: err}
+	case hdr.KeySlots != nil:
+		var ids [][]byte
+		for _, slot := range hdr.KeySlots {
+			ids = append(ids, slot.KeyID)
+		}
+		return keyIDList(ids), headerKeyID(hdr, key), nil
+	}
+	return "-", headerKeyID(hdr, key), nil
+}
+
+func keyIDList(ids [][]byte) string {
+	var list []string
+	for _, id := range ids {
+		list = append(list, hex.EncodeToString(id))
+	}
+	return strings.Join(list, ",")
+}
+
+// passwordEnv is the environment variable a -password that was not given is
+// taken from, unless -keyfile is.
+const passwordEnv = "NEO_PASSWORD"
+
+// addPasswordSources adds -password-file and -password-fd to flag sets with
+// a -password, so scripts need not put the password in the arguments.
+func addPasswordSources(fs *flag.FlagSet) {
+	password := fs.Lookup("password")
+	if password == nil || fs.Lookup("password-file") != nil {
+		return
+	}
+	fs.Func("password-file", "从此文件的第一行读取 -password，- 为标准输入", func(v string) error {
+		f := os.Stdin
+		if v != "-" {
+			var err error
+			if f, err = os.Open(v); err != nil {
+				return err
+			}
+			defer f.Close()
+		}
+		return setPasswordFrom(password, f)
+	})
+	fs.Func("password-fd", "从此文件描述符读取一行作为 -password，如 3", func(v string) error {
+		fd, err := strconv.Atoi(v)
+		if err != nil || fd < 0 {
+			return fmt.Errorf("bad file descriptor: %q", v)
+		}
+		// the descriptor stays open, it belongs to the caller
+		return setPasswordFrom(password, fdReader(fd))
+	})
+}
+
+func setPasswordFrom(password *flag.Flag, r io.Reader) error {
+	line, err := readLine(r)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if line == "" {
+		return errors.New("empty password")
+	}
+	return password.Value.Set(line)
+}
+
+// passwordFromEnv sets -password from passwordEnv when neither it nor
+// -keyfile or -ask-password was given.
+func passwordFromEnv(fs *flag.FlagSet) error {
+	password, env := fs.Lookup("password"), os.Getenv(passwordEnv)
+	if password == nil || env == "" || password.Value.String() != "" {
+		return nil
+	}
+	if keyfile := fs.Lookup("keyfile"); keyfile != nil && keyfile.Value.String() != "" {
+		return nil
+	}
+	if ask := fs.Lookup("ask-password"); ask != nil && ask.Value.String() == "true" {
+		return nil
+	}
+	return password.Value.Set(env)
+}
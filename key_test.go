@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestKeyFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	keyfile := filepath.Join(dir, "a.key")
+	k, err := generateKeyfile(keyfile, keyfileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fInfo, _ := os.Stat(keyfile); fInfo.Size() != keyfileSize {
+		t.Fatalf("unexpected keyfile %+v", fInfo)
+	}
+	if _, err := generateKeyfile(keyfile, keyfileSize); err == nil {
+		t.Fatal("except an existing keyfile to be kept")
+	}
+	if id, _, err := fileKeyID(keyfile); err != nil || id != keyFingerprint(k) {
+		t.Fatalf("fileKeyID(keyfile) = %s, %v", id, err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content"), 0644)
+	key, encodeKeyed = k, true
+	res, err := EncodeFile(LocalStorage(dir), "a.txt", LocalStorage(dir))
+	encodeKeyed = false
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := readNeoHeader(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := headerKeyID(hdr, k)
+	if id, own, err := fileKeyID(res.Output); err != nil || id != want || own != want {
+		t.Fatalf("fileKeyID(neo file) = %s, %s, %v, want %s", id, own, err, want)
+	}
+	key = nil
+	if id, own, err := fileKeyID(res.Output); err != nil || id != want || own != "" {
+		t.Fatalf("fileKeyID(neo file) without key = %s, %s, %v, want %s", id, own, err, want)
+	}
+	if want == keyFingerprint(k) {
+		t.Fatal("except the key id of a file to be salted")
+	}
+	res, _ = EncodeFile(LocalStorage(dir), "a.txt", LocalStorage(dir))
+	if id, _, err := fileKeyID(res.Output); err != nil || id != "-" {
+		t.Fatalf("fileKeyID(plain neo file) = %s, %v", id, err)
+	}
+}
+
+func TestPasswordSources(t *testing.T) {
+	cmd := &command{name: "test"}
+	parse := func(args ...string) (string, error) {
+		fs := cmd.flagSet()
+		fs.SetOutput(io.Discard)
+		password := fs.String("password", "", "")
+		fs.String("keyfile", "", "")
+		err := cmd.parse(fs, args)
+		return *password, err
+	}
+	file := filepath.Join(t.TempDir(), "password")
+	os.WriteFile(file, []byte("from file\nsecond line\n"), 0600)
+	if pw, err := parse("-password-file", file); err != nil || pw != "from file" {
+		t.Fatalf("-password-file: %q %v", pw, err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.WriteString("from fd\n")
+	if pw, err := parse("-password-fd", strconv.Itoa(int(r.Fd()))); err != nil || pw != "from fd" {
+		t.Fatalf("-password-fd: %q %v", pw, err)
+	}
+	// the descriptor belongs to the caller and is still open
+	w.WriteString("after")
+	w.Close()
+	if b, err := io.ReadAll(r); err != nil || string(b) != "after" {
+		t.Fatalf("except the descriptor to stay usable, but %q %v", b, err)
+	}
+
+	os.Setenv(passwordEnv, "from env")
+	defer os.Unsetenv(passwordEnv)
+	if pw, err := parse(); err != nil || pw != "from env" {
+		t.Fatalf("env: %q %v", pw, err)
+	}
+	if pw, err := parse("-keyfile", file); err != nil || pw != "" {
+		t.Fatalf("except -keyfile to win over the environment, but %q %v", pw, err)
+	}
+	if pw, _ := parse("-password", "given"); pw != "given" {
+		t.Fatalf("except -password to win over the environment, but %q", pw)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+var (
+	// ErrBadKeyString is returned by importKeyfile for strings not made by
+	// exportKeyfile, or changed since.
+	ErrBadKeyString = errors.New("bad key string")
+	// ErrWrapPassword is returned by importKeyfile when a sealed key string
+	// is imported without its password or with another one.
+	ErrWrapPassword = errors.New("wrong or missing -wrap-password")
+)
+
+const (
+	keyStringPrefix = "neo:key?"
+	// maxExportSize keeps exported keyfiles small enough to be pasted.
+	maxExportSize = 1 << 10
+	wrapSaltSize  = 16
+)
+
+// exportKeyfile turns a keyfile into a key string, sealed with wrapPassword
+// when it is given. The string carries the fingerprint of the key so a
+// mistyped or truncated paste is caught on import.
+func exportKeyfile(file, wrapPassword string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxExportSize {
+		return "", fmt.Errorf("%s: keyfile larger than %d bytes", file, maxExportSize)
+	}
+	k, _ := loadKey("", file)
+	v := url.Values{}
+	v.Set("id", keyFingerprint(k))
+	if wrapPassword == "" {
+		v.Set("k", base64.RawURLEncoding.EncodeToString(b))
+		return keyStringPrefix + v.Encode(), nil
+	}
+	salt := make([]byte, wrapSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := wrapCipher(wrapPassword, salt, codec.DefaultKDFCost)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := append(append(append(salt, codec.DefaultKDFCost), nonce...), aead.Seal(nil, nonce, b, []byte(keyStringPrefix))...)
+	v.Set("sealed", base64.RawURLEncoding.EncodeToString(sealed))
+	return keyStringPrefix + v.Encode(), nil
+}
+
+// importKeyfile writes the keyfile of a key string to a new file and returns
+// its key material.
+func importKeyfile(s, wrapPassword, file string) ([]byte, error) {
+	if !strings.HasPrefix(s, keyStringPrefix) {
+		return nil, ErrBadKeyString
+	}
+	v, err := url.ParseQuery(strings.TrimPrefix(s, keyStringPrefix))
+	if err != nil {
+		return nil, ErrBadKeyString
+	}
+	var b []byte
+	switch {
+	case v.Get("k") != "":
+		if b, err = base64.RawURLEncoding.DecodeString(v.Get("k")); err != nil {
+			return nil, ErrBadKeyString
+		}
+	case v.Get("sealed") != "":
+		sealed, err := base64.RawURLEncoding.DecodeString(v.Get("sealed"))
+		if err != nil || len(sealed) <= wrapSaltSize || sealed[wrapSaltSize] > codec.MaxKDFCost {
+			return nil, ErrBadKeyString
+		}
+		if wrapPassword == "" {
+			return nil, ErrWrapPassword
+		}
+		aead, err := wrapCipher(wrapPassword, sealed[:wrapSaltSize], sealed[wrapSaltSize])
+		if err != nil {
+			return nil, err
+		}
+		sealed = sealed[wrapSaltSize+1:]
+		if len(sealed) < aead.NonceSize() {
+			return nil, ErrBadKeyString
+		}
+		if b, err = aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyStringPrefix)); err != nil {
+			return nil, ErrWrapPassword
+		}
+	default:
+		return nil, ErrBadKeyString
+	}
+	sum := sha256.Sum256(b)
+	if keyFingerprint(sum[:]) != v.Get("id") {
+		return nil, ErrBadKeyString
+	}
+	return writeKeyfile(file, b)
+}
+
+// wrapCipher derives the key sealing an exported keyfile from the password
+// with the salt and the cost stored before the nonce, see codec.DeriveKey,
+// apart from the keys files are encoded with.
+func wrapCipher(password string, salt []byte, cost uint8) (cipher.AEAD, error) {
+	k, _ := loadKey(password, "")
+	mac := hmac.New(sha256.New, codec.DeriveKey(k, salt, cost))
+	mac.Write([]byte("neo key export"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportKeyfile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "key")
+	k, err := generateKeyfile(file, keyfileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(file)
+
+	for i, wrap := range []string{"", "wrap"} {
+		s, err := exportKeyfile(file, wrap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := encodeQR([]byte(s)); err != nil {
+			t.Fatalf("export %q does not fit a QR code: %v", s, err)
+		}
+		if wrap != "" {
+			if _, err := importKeyfile(s, "", filepath.Join(dir, "none")); !errors.Is(err, ErrWrapPassword) {
+				t.Errorf("except ErrWrapPassword without a password, but %v", err)
+			}
+			if _, err := importKeyfile(s, "other", filepath.Join(dir, "none")); !errors.Is(err, ErrWrapPassword) {
+				t.Errorf("except ErrWrapPassword for another password, but %v", err)
+			}
+		}
+		out := filepath.Join(dir, "imported"+string(rune('0'+i)))
+		got, err := importKeyfile(s, wrap, out)
+		if err != nil || !bytes.Equal(got, k) {
+			t.Fatalf("importKeyfile() = %x, %v, want %x", got, err, k)
+		}
+		if b, _ := os.ReadFile(out); !bytes.Equal(b, content) {
+			t.Errorf("imported keyfile differs")
+		}
+		if _, err := importKeyfile(s, wrap, out); err == nil {
+			t.Errorf("except an existing keyfile not to be overwritten")
+		}
+	}
+
+	s, _ := exportKeyfile(file, "")
+	for _, bad := range []string{"", "neo:key?", strings.Replace(s, "id=", "id=00", 1), s[:len(s)-2]} {
+		if _, err := importKeyfile(bad, "", filepath.Join(dir, "bad")); !errors.Is(err, ErrBadKeyString) {
+			t.Errorf("importKeyfile(%q) = %v, want ErrBadKeyString", bad, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+//go:build !windows
+// +build !windows
+
+// Command libneo builds NEO as a C shared library:
+//
+//	go build -buildmode=c-shared -o libneo.so ./libneo
+//
+// which also writes libneo.h. File descriptors stay owned by the caller, every
+// function returns NEO_OK or a negative NEO_ERR_* code.
+package main
+
+/*
+#include <stddef.h>
+#include <stdint.h>
+
+#define NEO_OK                  0
+#define NEO_ERR_IO             -1
+#define NEO_ERR_NOT_NEO        -2
+#define NEO_ERR_BAD_VERSION    -3
+#define NEO_ERR_UNKNOWN_METHOD -4
+#define NEO_ERR_CRC            -5
+*/
+import "C"
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"unsafe"
+
+	"github.com/hr3lxphr6j/neo/codec"
+	"github.com/hr3lxphr6j/neo/mobile"
+)
+
+func errCode(err error) C.int {
+	switch {
+	case err == nil:
+		return C.NEO_OK
+	case errors.Is(err, codec.ErrNotNEOHeader), errors.Is(err, io.ErrUnexpectedEOF):
+		return C.NEO_ERR_NOT_NEO
+	case errors.Is(err, codec.ErrBadVersion):
+		return C.NEO_ERR_BAD_VERSION
+	case errors.Is(err, codec.ErrUnknownCryptoMethod):
+		return C.NEO_ERR_UNKNOWN_METHOD
+	case errors.Is(err, codec.ErrCRCCheckFailed):
+		return C.NEO_ERR_CRC
+	default:
+		return C.NEO_ERR_IO
+	}
+}
+
+// dup returns copies of fds, the mobile package closes what it is given.
+func dup(fds ...C.int) ([]int, error) {
+	dups := make([]int, 0, len(fds))
+	for _, fd := range fds {
+		d, err := syscall.Dup(int(fd))
+		if err != nil {
+			for _, d := range dups {
+				syscall.Close(d)
+			}
+			return nil, err
+		}
+		dups = append(dups, d)
+	}
+	return dups, nil
+}
+
+// copyString copies s into buf as a NUL terminated string, truncating it if
+// buf is too small.
+func copyString(buf *C.char, size C.size_t, s string) {
+	if buf == nil || size == 0 {
+		return
+	}
+	b := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(size))
+	n := copy(b[:len(b)-1], s)
+	b[n] = 0
+}
+
+// neo_encode_fd encodes in_fd into out_fd, in_fd must be seekable.
+//
+//export neo_encode_fd
+func neo_encode_fd(inFd, outFd C.int, filename *C.char) C.int {
+	fds, err := dup(inFd, outFd)
+	if err != nil {
+		return errCode(err)
+	}
+	return errCode(mobile.EncodeFD(fds[0], fds[1], C.GoString(filename)))
+}
+
+// neo_decode_fd decodes in_fd into out_fd and stores the original filename in
+// filename, which may be NULL.
+//
+//export neo_decode_fd
+func neo_decode_fd(inFd, outFd C.int, filename *C.char, size C.size_t) C.int {
+	fds, err := dup(inFd, outFd)
+	if err != nil {
+		return errCode(err)
+	}
+	hdr, err := mobile.DecodeFD(fds[0], fds[1])
+	if err != nil {
+		return errCode(err)
+	}
+	copyString(filename, size, hdr.Filename)
+	return C.NEO_OK
+}
+
+// neo_inspect_header reads the header from fd, version and crc32 may be NULL.
+//
+//export neo_inspect_header
+func neo_inspect_header(fd C.int, version *C.int, filename *C.char, size C.size_t, crc32 *C.uint32_t) C.int {
+	fds, err := dup(fd)
+	if err != nil {
+		return errCode(err)
+	}
+	hdr, err := mobile.InspectFD(fds[0])
+	if err != nil {
+		return errCode(err)
+	}
+	if version != nil {
+		*version = C.int(hdr.Version)
+	}
+	if crc32 != nil {
+		*crc32 = C.uint32_t(hdr.Crc32)
+	}
+	copyString(filename, size, hdr.Filename)
+	return C.NEO_OK
+}
+
+func main() {}
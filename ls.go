@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// headerResult is a file seen by walkHeaders, Entry.Header is nil for files
+// that are not NEO files.
+type headerResult struct {
+	Path  string
+	Entry *headerEntry
+	Err   error
+}
+
+// walkHeaders parses the headers of the files under args, up to jobs at a
+// time. Results are sent as they are ready, not in the order of the files,
+// and the channel is closed after the last one. sum is only safe to read then.
+func walkHeaders(args []string, recursive bool, jobs int, sum *summary) <-chan headerResult {
+	if jobs < 1 {
+		jobs = 1
+	}
+	paths := make(chan string)
+	results := make(chan headerResult, jobs)
+	go func() {
+		defer close(paths)
+		for _, file := range collectFiles(args, recursive, sum) {
+			if isRemote(file) {
+				log.Printf("文件：%s 为远程文件，跳过", file)
+				continue
+			}
+			paths <- file
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				e, err := headers.lookup(path)
+				results <- headerResult{Path: path, Entry: e, Err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// lsEntry is what ls -json prints for a NEO file.
+type lsEntry struct {
+	Path          string   `json:"path"`
+	Name          string   `json:"name,omitempty"`
+	Hint          string   `json:"hint,omitempty"`
+	Size          int64    `json:"size"`
+	Version       uint8    `json:"version"`
+	CRC32         string   `json:"crc32,omitempty"`
+	SHA256        string   `json:"sha256,omitempty"`
+	XXH64         string   `json:"xxh64,omitempty"`
+	EncryptedMeta bool     `json:"encrypted_meta,omitempty"`
+	Warnings      []string `json:"warnings,omitempty"`
+	Media         *lsMedia `json:"media,omitempty"`
+}
+
+// lsMedia is what ls -details -json prints of codec.Media.
+type lsMedia struct {
+	Width    uint32     `json:"width,omitempty"`
+	Height   uint32     `json:"height,omitempty"`
+	Duration float64    `json:"duration,omitempty"`
+	Taken    *time.Time `json:"taken,omitempty"`
+}
+
+func newLsMedia(m *codec.Media) *lsMedia {
+	lm := &lsMedia{Width: m.Width, Height: m.Height, Duration: m.Duration.Seconds()}
+	if m.Taken != 0 {
+		t := time.Unix(m.Taken, 0).UTC()
+		lm.Taken = &t
+	}
+	return lm
+}
+
+// mediaColumns formats m for the -details columns: resolution, duration and
+// time taken, "-" for what is unknown.
+func mediaColumns(m *codec.Media) string {
+	res, dur, taken := "-", "-", "-"
+	if m != nil {
+		if m.Width != 0 && m.Height != 0 {
+			res = fmt.Sprintf("%dx%d", m.Width, m.Height)
+		}
+		if m.Duration > 0 {
+			dur = m.Duration.Round(time.Second).String()
+		}
+		if m.Taken != 0 {
+			taken = time.Unix(m.Taken, 0).UTC().Format("2006-01-02 15:04:05")
+		}
+	}
+	return res + "\t" + dur + "\t" + taken
+}
+
+// newLsEntry describes the NEO file at path whose header is in e.
+func newLsEntry(path string, e *headerEntry) *lsEntry {
+	hdr := e.Header
+	le := &lsEntry{
+		Path:          path,
+		Name:          hdr.OriginalFilename,
+		Hint:          hdr.Hint,
+		Size:          e.Content,
+		Version:       hdr.Version,
+		SHA256:        hex.EncodeToString(hdr.SHA256),
+		XXH64:         hex.EncodeToString(hdr.XXH64),
+		EncryptedMeta: hdr.EncryptedMeta,
+		Warnings:      hdr.Warnings,
+	}
+	if !hdr.NoChecksum {
+		le.CRC32 = fmt.Sprintf("%08x", hdr.Crc32)
+	}
+	return le
+}
+
+func runLs(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	recursive := fs.Bool("r", false, "递归列出目录")
+	jsonOut := fs.Bool("json", false, "以 JSON 格式打印每个文件")
+	hint := fs.Bool("hint", false, "以编码时记录的提示代替原文件名，没有提示的文件显示为 -")
+	details := fs.Bool("details", false, "另外列出编码时以 -media 记录的分辨率、时长与拍摄时间")
+	jobs := fs.Int("j", runtime.NumCPU(), "同时读取的文件数，大于 1 时不保证输出顺序")
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	fs.StringVar(&headerCacheFile, "header-cache", "", "将解析过的文件头缓存至此文件，目录较大时可加快之后的列出")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return cmd.usageError(fs, "no file or directory to list")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	if headerCacheFile != "" {
+		if err := headers.load(headerCacheFile); err != nil {
+			log.Printf("读取文件头缓存：%s 失败，错误：%v", headerCacheFile, err)
+		}
+	}
+
+	sum := new(summary)
+	failed := 0
+	enc := json.NewEncoder(os.Stdout)
+	for res := range walkHeaders(fs.Args(), *recursive, *jobs, sum) {
+		if res.Err != nil {
+			log.Printf("读取文件：%s 头部失败，错误：%v", res.Path, res.Err)
+			failed++
+			continue
+		}
+		hdr := res.Entry.Header
+		if hdr == nil {
+			continue
+		}
+		name := hdr.OriginalFilename
+		if *hint {
+			name = hdr.Hint
+		}
+		if !*jsonOut {
+			if name == "" {
+				name = "-"
+			}
+			if *details {
+				name += "\t" + mediaColumns(hdr.Media)
+			}
+			fmt.Printf("%s\t%d\t%s\n", res.Path, res.Entry.Content, name)
+			continue
+		}
+		e := newLsEntry(res.Path, res.Entry)
+		if *hint {
+			e.Name = ""
+		}
+		if *details && hdr.Media != nil {
+			e.Media = newLsMedia(hdr.Media)
+		}
+		enc.Encode(e)
+	}
+	if headerCacheFile != "" {
+		if err := headers.save(headerCacheFile); err != nil {
+			log.Printf("保存文件头缓存：%s 失败，错误：%v", headerCacheFile, err)
+		}
+	}
+	if failed += sum.failed; failed > 0 {
+		log.Printf("完成：失败 %d 个", failed)
+	}
+	return nil
+}
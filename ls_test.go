@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkHeaders(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0777)
+	want := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("%d.txt", i)
+		sub := dir
+		if i%2 == 0 {
+			sub = filepath.Join(dir, "sub")
+		}
+		os.WriteFile(filepath.Join(sub, name), []byte("content of "+name), 0644)
+		if _, err := EncodeFile(LocalStorage(sub), name, LocalStorage(sub)); err != nil {
+			t.Fatal(err)
+		}
+		want[name] = true
+	}
+	os.WriteFile(filepath.Join(dir, "plain.txt"), []byte("plain"), 0644)
+
+	sum := new(summary)
+	seen := 0
+	for res := range walkHeaders([]string{dir}, true, 4, sum) {
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		hdr := res.Entry.Header
+		if hdr == nil {
+			continue
+		}
+		if !want[hdr.OriginalFilename] {
+			t.Fatalf("unexpected file %s", hdr.OriginalFilename)
+		}
+		if size := int64(len("content of " + hdr.OriginalFilename)); res.Entry.Content != size {
+			t.Fatalf("%s: except size %d, but %d", hdr.OriginalFilename, size, res.Entry.Content)
+		}
+		delete(want, hdr.OriginalFilename)
+		seen++
+	}
+	if seen != 20 || sum.failed != 0 {
+		t.Fatalf("except 20 NEO files, but %d, %d failed", seen, sum.failed)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	cmd, args := rootCommand, os.Args[1:]
+	if len(args) > 0 {
+		if c := findCommand(args[0]); c != nil {
+			cmd, args = c, args[1:]
+		}
+	}
+	err := cmd.run(cmd, args)
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		log.Fatalf("%s 失败，错误：%v", cmd.flagSet().Name(), err)
+	}
+}
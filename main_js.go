@@ -0,0 +1,204 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"syscall/js"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+const jsChunkSize = 64 << 10
+
+// await blocks until promise settles, it must not be called on the event loop.
+func await(promise js.Value) (js.Value, error) {
+	done := make(chan struct{})
+	var (
+		value js.Value
+		err   error
+	)
+	onResolve := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		value = args[0]
+		close(done)
+		return nil
+	})
+	onReject := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		err = js.Error{Value: args[0]}
+		close(done)
+		return nil
+	})
+	defer onResolve.Release()
+	defer onReject.Release()
+	promise.Call("then", onResolve, onReject)
+	<-done
+	return value, err
+}
+
+// newPromise runs fn in a goroutine and settles the returned promise with its result.
+func newPromise(fn func() (interface{}, error)) js.Value {
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go func() {
+			defer executor.Release()
+			v, err := fn()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(v)
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(executor)
+}
+
+// jsStreamReader reads a JS ReadableStream.
+type jsStreamReader struct {
+	reader js.Value
+	buf    []byte
+}
+
+func newJSStreamReader(stream js.Value) *jsStreamReader {
+	return &jsStreamReader{reader: stream.Call("getReader")}
+}
+
+func (r *jsStreamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		res, err := await(r.reader.Call("read"))
+		if err != nil {
+			return 0, err
+		}
+		if res.Get("done").Bool() {
+			return 0, io.EOF
+		}
+		chunk := res.Get("value")
+		r.buf = make([]byte, chunk.Get("length").Int())
+		js.CopyBytesToGo(r.buf, chunk)
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// newReadableStream exposes r as a JS ReadableStream, finish is called at EOF
+// and an error from it errors the stream.
+func newReadableStream(r io.Reader, finish func() error) js.Value {
+	pull := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		controller := args[0]
+		return newPromise(func() (interface{}, error) {
+			buf := make([]byte, jsChunkSize)
+			n, err := r.Read(buf)
+			if n > 0 {
+				chunk := js.Global().Get("Uint8Array").New(n)
+				js.CopyBytesToJS(chunk, buf[:n])
+				controller.Call("enqueue", chunk)
+			}
+			switch {
+			case err == io.EOF:
+				if finish != nil {
+					if err := finish(); err != nil {
+						controller.Call("error", js.Global().Get("Error").New(err.Error()))
+						return nil, nil
+					}
+				}
+				controller.Call("close")
+			case err != nil:
+				controller.Call("error", js.Global().Get("Error").New(err.Error()))
+			}
+			return nil, nil
+		})
+	})
+	return js.Global().Get("ReadableStream").New(map[string]interface{}{"pull": pull})
+}
+
+// jsEncode(blob, filename) returns {name, stream}, the blob is read twice, once
+// for the checksum and once for the content.
+func jsEncode(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return js.Global().Get("Error").New("encode(blob, filename)")
+	}
+	blob, filename := args[0], args[1].String()
+	pr, pw := io.Pipe()
+	go func() {
+		h := crc32.NewIEEE()
+		size, err := io.Copy(h, newJSStreamReader(blob.Call("stream")))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		w := codec.NewNeoWriter(pw, codec.DefaultHeaderLen, filename, h.Sum32())
+		w.SetSize(size)
+		_, err = io.Copy(w, newJSStreamReader(blob.Call("stream")))
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return map[string]interface{}{
+		"name":   RandStringRunes(8) + ".neo",
+		"stream": newReadableStream(pr, nil),
+	}
+}
+
+// jsDecode(stream) resolves to {filename, stream} once the header is parsed,
+// the returned stream errors at the end if the checksum does not match.
+func jsDecode(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return js.Global().Get("Error").New("decode(stream)")
+	}
+	src := args[0]
+	return newPromise(func() (interface{}, error) {
+		rd := codec.NewNeoReader(newJSStreamReader(src))
+		if _, err := rd.Header(); err != nil {
+			return nil, err
+		}
+		h := crc32.NewIEEE()
+		stream := newReadableStream(io.TeeReader(rd, h), func() error {
+			if !rd.NeoHeader.NoChecksum && h.Sum32() != rd.NeoHeader.Crc32 {
+				return codec.ErrCRCCheckFailed
+			}
+			return nil
+		})
+		return map[string]interface{}{
+			"filename": rd.NeoHeader.OriginalFilename,
+			"stream":   stream,
+		}, nil
+	})
+}
+
+// jsInspect(blob) resolves to the header fields of a NEO file.
+func jsInspect(this js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return js.Global().Get("Error").New("inspect(blob)")
+	}
+	blob := args[0]
+	return newPromise(func() (interface{}, error) {
+		hdr, err := codec.ReadHeader(newJSStreamReader(blob.Call("stream")))
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = codec.ErrNotNEOHeader
+			}
+			return nil, err
+		}
+		return map[string]interface{}{
+			"version":  int(hdr.Version),
+			"filename": hdr.OriginalFilename,
+			"crc32":    int(hdr.Crc32),
+		}, nil
+	})
+}
+
+func main() {
+	js.Global().Set("neo", map[string]interface{}{
+		"encode":  js.FuncOf(jsEncode),
+		"decode":  js.FuncOf(jsDecode),
+		"inspect": js.FuncOf(jsInspect),
+		"version": version(),
+	})
+	select {}
+}
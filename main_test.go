@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain_ExitCode runs main in a child process, which is the test binary
+// started again with NEO_TEST_MAIN set.
+func TestMain_ExitCode(t *testing.T) {
+	if os.Getenv("NEO_TEST_MAIN") != "" {
+		os.Args = append([]string{"neo"}, filepath.SplitList(os.Getenv("NEO_TEST_MAIN"))...)
+		main()
+		os.Exit(0)
+	}
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	os.WriteFile(a, []byte("a"), 0644)
+	run := func(args ...string) int {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestMain_ExitCode$")
+		cmd.Env = append(os.Environ(), "NEO_TEST_MAIN="+strings.Join(args, string(os.PathListSeparator)))
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return 0
+	}
+	if code := run("encode", filepath.Join(dir, "missing.txt"), a); code != 1 {
+		t.Errorf("except exit code 1 with a failed file, but %d", code)
+	}
+	os.WriteFile(a, []byte("a"), 0644)
+	if code := run("encode", a); code != 0 {
+		t.Errorf("except exit code 0, but %d", code)
+	}
+	if code := run("encode", "-no-such-flag"); code != 2 {
+		t.Errorf("except exit code 2 for a usage error, but %d", code)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// encodeMedia records the media details of pictures and videos in the
+// header of encoded files.
+var encodeMedia bool
+
+var errNoMedia = errors.New("not a known picture or video")
+
+// probeMedia reads the resolution, duration and time of capture of the
+// local file at path from its container headers, without decoding any
+// frame. It returns nil for files it does not know.
+func probeMedia(path string) *codec.Media {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	head := make([]byte, 12)
+	if _, err := io.ReadFull(f, head); err != nil {
+		return nil
+	}
+	var m *codec.Media
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8}):
+		m, err = probeImage(f)
+		if m != nil {
+			m.Taken = exifTaken(f)
+		}
+	case bytes.HasPrefix(head, []byte("\x89PNG")), bytes.HasPrefix(head, []byte("GIF8")):
+		m, err = probeImage(f)
+	case string(head[4:8]) == "ftyp":
+		m, err = probeMP4(f)
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		m, err = probeMatroska(f)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+func probeImage(f *os.File) (*codec.Media, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	return &codec.Media{Width: uint32(cfg.Width), Height: uint32(cfg.Height)}, nil
+}
+
+// exifTaken returns the DateTimeOriginal of a JPEG file, or its DateTime,
+// as a Unix time. EXIF has no time zone, the time is taken as UTC so that
+// it prints as it was recorded.
+func exifTaken(f *os.File) int64 {
+	if _, err := f.Seek(2, io.SeekStart); err != nil {
+		return 0
+	}
+	seg := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(f, seg); err != nil || seg[0] != 0xFF {
+			return 0
+		}
+		marker, size := seg[1], int(binary.BigEndian.Uint16(seg[2:]))
+		if marker == 0xDA || size < 2 {
+			// start of scan, the metadata is before it
+			return 0
+		}
+		if marker != 0xE1 {
+			if _, err := f.Seek(int64(size-2), io.SeekCurrent); err != nil {
+				return 0
+			}
+			continue
+		}
+		app1 := make([]byte, size-2)
+		if _, err := io.ReadFull(f, app1); err != nil {
+			return 0
+		}
+		if bytes.HasPrefix(app1, []byte("Exif\x00\x00")) {
+			return tiffTaken(app1[6:])
+		}
+	}
+}
+
+func tiffTaken(tiff []byte) int64 {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if tiff[0] == 'I' {
+		order = binary.LittleEndian
+	}
+	// ifd returns the value offset or value of tag in the IFD at off
+	ifd := func(off uint32, tag uint16) (typ uint16, count, value uint32, ok bool) {
+		if int(off)+2 > len(tiff) {
+			return 0, 0, 0, false
+		}
+		n := int(order.Uint16(tiff[off:]))
+		for i := 0; i < n; i++ {
+			e := int(off) + 2 + 12*i
+			if e+12 > len(tiff) {
+				break
+			}
+			if order.Uint16(tiff[e:]) == tag {
+				return order.Uint16(tiff[e+2:]), order.Uint32(tiff[e+4:]), order.Uint32(tiff[e+8:]), true
+			}
+		}
+		return 0, 0, 0, false
+	}
+	date := func(off uint32, tag uint16) int64 {
+		typ, count, value, ok := ifd(off, tag)
+		if !ok || typ != 2 || count < 19 || int(value)+19 > len(tiff) {
+			return 0
+		}
+		t, err := time.Parse("2006:01:02 15:04:05", string(tiff[value:value+19]))
+		if err != nil {
+			return 0
+		}
+		return t.Unix()
+	}
+	ifd0 := order.Uint32(tiff[4:])
+	if _, _, exif, ok := ifd(ifd0, 0x8769); ok {
+		if t := date(exif, 0x9003); t != 0 {
+			return t
+		}
+	}
+	return date(ifd0, 0x0132)
+}
+
+// mp4Epoch is the Unix time of the epoch of MP4 timestamps, 1904-01-01.
+const mp4Epoch = -2082844800
+
+// probeMP4 reads the movie header and the first video track header of an
+// MP4 or QuickTime file, the moov box may be anywhere in the file.
+func probeMP4(f *os.File) (*codec.Media, error) {
+	fInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	moov, err := findBox(f, 0, fInfo.Size(), "moov")
+	if err != nil {
+		return nil, err
+	}
+	m := new(codec.Media)
+	b := make([]byte, 8)
+	for pos := moov.start; pos < moov.end; {
+		box, err := readBox(f, pos, moov.end)
+		if err != nil {
+			return nil, err
+		}
+		pos = box.end
+		switch box.typ {
+		case "mvhd":
+			hdr := make([]byte, 32)
+			n, _ := f.ReadAt(hdr, box.start)
+			if n < 20 {
+				return nil, errNoMedia
+			}
+			var created, scale, duration uint64
+			if hdr[0] == 1 && n >= 32 {
+				created, scale, duration = binary.BigEndian.Uint64(hdr[4:]), uint64(binary.BigEndian.Uint32(hdr[20:])), binary.BigEndian.Uint64(hdr[24:])
+			} else {
+				created, scale, duration = uint64(binary.BigEndian.Uint32(hdr[4:])), uint64(binary.BigEndian.Uint32(hdr[12:])), uint64(binary.BigEndian.Uint32(hdr[16:]))
+			}
+			if scale > 0 {
+				m.Duration = time.Duration(float64(duration) / float64(scale) * float64(time.Second))
+			}
+			if created > 0 {
+				m.Taken = int64(created) + mp4Epoch
+			}
+		case "trak":
+			if m.Width != 0 {
+				continue
+			}
+			tkhd, err := findBox(f, box.start, box.end, "tkhd")
+			if err != nil {
+				continue
+			}
+			// width and height are the last 8 bytes, 16.16 fixed point
+			if _, err := f.ReadAt(b, tkhd.end-8); err != nil {
+				continue
+			}
+			m.Width, m.Height = binary.BigEndian.Uint32(b)>>16, binary.BigEndian.Uint32(b[4:])>>16
+		}
+	}
+	return m, nil
+}
+
+// mp4Box is the content of a box, from start to end in the file.
+type mp4Box struct {
+	typ        string
+	start, end int64
+}
+
+func readBox(f *os.File, pos, limit int64) (mp4Box, error) {
+	hdr := make([]byte, 16)
+	if n, _ := f.ReadAt(hdr, pos); n < 8 {
+		return mp4Box{}, errNoMedia
+	}
+	size, start := int64(binary.BigEndian.Uint32(hdr)), pos+8
+	switch size {
+	case 0:
+		size = limit - pos
+	case 1:
+		size, start = int64(binary.BigEndian.Uint64(hdr[8:])), pos+16
+	}
+	if size < start-pos || pos+size > limit {
+		return mp4Box{}, errNoMedia
+	}
+	return mp4Box{typ: string(hdr[4:8]), start: start, end: pos + size}, nil
+}
+
+func findBox(f *os.File, pos, limit int64, typ string) (mp4Box, error) {
+	for pos < limit {
+		box, err := readBox(f, pos, limit)
+		if err != nil {
+			return mp4Box{}, err
+		}
+		if box.typ == typ {
+			return box, nil
+		}
+		pos = box.end
+	}
+	return mp4Box{}, errNoMedia
+}
+
+// Matroska element IDs probeMatroska looks at.
+const (
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549A966
+	mkvTimecodeScale = 0x2AD7B1
+	mkvDuration      = 0x4489
+	mkvDateUTC       = 0x4461
+	mkvTracks        = 0x1654AE6B
+	mkvTrackEntry    = 0xAE
+	mkvVideo         = 0xE0
+	mkvPixelWidth    = 0xB0
+	mkvPixelHeight   = 0xBA
+	mkvCluster       = 0x1F43B675
+)
+
+// mkvEpoch is the Unix time of the epoch of Matroska dates, 2001-01-01.
+const mkvEpoch = 978307200
+
+// probeMatroska reads the segment info and tracks of a Matroska or WebM
+// file, they come before the first cluster.
+func probeMatroska(f *os.File) (*codec.Media, error) {
+	fInfo, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	m := new(codec.Media)
+	scale := uint64(1000000)
+	var duration float64
+	var walk func(pos, end int64, depth int) error
+	walk = func(pos, end int64, depth int) error {
+		for pos < end {
+			id, size, start, err := readElement(f, pos, end)
+			if err != nil {
+				return err
+			}
+			pos = start + size
+			switch id {
+			case mkvSegment, mkvInfo, mkvTracks, mkvTrackEntry, mkvVideo:
+				if depth < 5 {
+					if err := walk(start, start+size, depth+1); err != nil {
+						return err
+					}
+				}
+			case mkvCluster:
+				return io.EOF
+			case mkvTimecodeScale:
+				scale = readUint(f, start, size)
+			case mkvDuration:
+				duration = readFloat(f, start, size)
+			case mkvDateUTC:
+				m.Taken = int64(readUint(f, start, size))/int64(time.Second) + mkvEpoch
+			case mkvPixelWidth:
+				if m.Width == 0 {
+					m.Width = uint32(readUint(f, start, size))
+				}
+			case mkvPixelHeight:
+				if m.Height == 0 {
+					m.Height = uint32(readUint(f, start, size))
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(0, fInfo.Size(), 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	m.Duration = time.Duration(duration * float64(scale))
+	return m, nil
+}
+
+// readElement reads the ID and size of the EBML element at pos, an unknown
+// size runs to end.
+func readElement(f *os.File, pos, end int64) (id uint32, size, start int64, err error) {
+	b := make([]byte, 12)
+	n, _ := f.ReadAt(b, pos)
+	b = b[:n]
+	idLen := vintLen(b)
+	if idLen == 0 || idLen > 4 || idLen >= len(b) {
+		return 0, 0, 0, errNoMedia
+	}
+	for _, c := range b[:idLen] {
+		id = id<<8 | uint32(c)
+	}
+	sizeLen := vintLen(b[idLen:])
+	if sizeLen == 0 || idLen+sizeLen > len(b) {
+		return 0, 0, 0, errNoMedia
+	}
+	raw := b[idLen : idLen+sizeLen]
+	v := uint64(raw[0] & (0xFF >> sizeLen))
+	unknown := v == uint64(0xFF>>sizeLen)
+	for _, c := range raw[1:] {
+		v = v<<8 | uint64(c)
+		unknown = unknown && c == 0xFF
+	}
+	start = pos + int64(idLen+sizeLen)
+	if unknown || start+int64(v) > end {
+		return id, end - start, start, nil
+	}
+	return id, int64(v), start, nil
+}
+
+// vintLen returns the length of the EBML variable length integer at the
+// start of b from its leading zero bits, 0 when it is not valid.
+func vintLen(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	for i := 0; i < 8; i++ {
+		if b[0]&(0x80>>i) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func readUint(f *os.File, pos, size int64) uint64 {
+	if size < 1 || size > 8 {
+		return 0
+	}
+	b := make([]byte, size)
+	if _, err := f.ReadAt(b, pos); err != nil {
+		return 0
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func readFloat(f *os.File, pos, size int64) float64 {
+	v := readUint(f, pos, size)
+	switch size {
+	case 4:
+		return float64(math.Float32frombits(uint32(v)))
+	case 8:
+		return math.Float64frombits(v)
+	}
+	return 0
+}
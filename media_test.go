@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+func box(typ string, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+func ebml(id uint32, content ...[]byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	body := bytes.Join(content, nil)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(body)))
+	size[0] = 0x01
+	return append(append(b, size...), body...)
+}
+
+func TestProbeMedia(t *testing.T) {
+	dir := t.TempDir()
+
+	// JPEG with an EXIF DateTimeOriginal
+	var jpg bytes.Buffer
+	jpeg.Encode(&jpg, image.NewGray(image.Rect(0, 0, 64, 48)), nil)
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = append(tiff, 0, 1, 0x87, 0x69, 0, 4, 0, 0, 0, 1, 0, 0, 0, 26, 0, 0, 0, 0)
+	tiff = append(tiff, 0, 1, 0x90, 0x03, 0, 2, 0, 0, 0, 20, 0, 0, 0, 44, 0, 0, 0, 0)
+	tiff = append(tiff, "2024:05:01 10:20:30\x00"...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(app1)+2))
+	withExif := append(append(append([]byte{0xFF, 0xD8}, seg...), app1...), jpg.Bytes()[2:]...)
+
+	// MP4 with a 90 s movie and a 1280x720 video track
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[4:], uint32(1700000000-mp4Epoch))
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 90000)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 1280<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 720<<16)
+	mp4 := append(box("ftypisom", make([]byte, 8)), box("mdat", make([]byte, 32))...)
+	mp4 = append(mp4, box("moov", box("mvhd", mvhd), box("trak", box("tkhd", tkhd)))...)
+
+	// Matroska with a 2.5 s segment of 640x360 video
+	dur := make([]byte, 8)
+	binary.BigEndian.PutUint64(dur, math.Float64bits(2500))
+	mkv := append(ebml(0x1A45DFA3, ebml(0x4282, []byte("webm"))),
+		ebml(mkvSegment,
+			ebml(mkvInfo, ebml(mkvTimecodeScale, []byte{0x0F, 0x42, 0x40}), ebml(mkvDuration, dur)),
+			ebml(mkvTracks, ebml(mkvTrackEntry, ebml(mkvVideo, ebml(mkvPixelWidth, []byte{0x02, 0x80}), ebml(mkvPixelHeight, []byte{0x01, 0x68})))),
+			ebml(mkvCluster, make([]byte, 16)))...)
+
+	taken := time.Date(2024, 5, 1, 10, 20, 30, 0, time.UTC).Unix()
+	for name, c := range map[string]struct {
+		content []byte
+		want    codec.Media
+	}{
+		"a.jpg": {withExif, codec.Media{Width: 64, Height: 48, Taken: taken}},
+		"b.mp4": {mp4, codec.Media{Width: 1280, Height: 720, Duration: 90 * time.Second, Taken: 1700000000}},
+		"c.mkv": {mkv, codec.Media{Width: 640, Height: 360, Duration: 2500 * time.Millisecond}},
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, c.content, 0644)
+		m := probeMedia(path)
+		if m == nil || *m != c.want {
+			t.Errorf("%s: got %+v, want %+v", name, m, c.want)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "d.txt"), []byte("plain text file"), 0644)
+	if m := probeMedia(filepath.Join(dir, "d.txt")); m != nil {
+		t.Errorf("text file probed as %+v", m)
+	}
+}
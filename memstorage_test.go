@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoSpace = errors.New("no space left on device")
+
+// MemStorage keeps files in memory. Quota limits the total size of all files
+// and FailOn, when set, is consulted before every operation, so tests can
+// simulate full disks, permission errors and failing renames.
+type MemStorage struct {
+	Quota  int64
+	FailOn func(op, name string) error
+
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: make(map[string]*memFile)}
+}
+
+func (s *MemStorage) fail(op, name string) error {
+	if s.FailOn == nil {
+		return nil
+	}
+	if err := s.FailOn(op, name); err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+func (s *MemStorage) size() (n int64) {
+	for _, f := range s.files {
+		n += int64(len(f.data))
+	}
+	return
+}
+
+// WriteFile stores content under name, bypassing quota and FailOn.
+func (s *MemStorage) WriteFile(name string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path.Clean(name)] = &memFile{data: content, modTime: time.Now()}
+}
+
+func (s *MemStorage) ReadFile(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[path.Clean(name)]
+	if !ok {
+		return nil, false
+	}
+	return f.data, true
+}
+
+func (s *MemStorage) Open(name string) (io.ReadCloser, error) {
+	if err := s.fail("open", name); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[path.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (s *MemStorage) Create(name string) (io.WriteCloser, error) {
+	if err := s.fail("create", name); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := &memFile{modTime: time.Now()}
+	s.files[path.Clean(name)] = f
+	return &memWriter{s: s, f: f, name: name}, nil
+}
+
+func (s *MemStorage) List(dir string) ([]Entry, error) {
+	if err := s.fail("list", dir); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := path.Clean(dir) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+	seen := make(map[string]bool)
+	var entries []Entry
+	for name, f := range s.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rel := name[len(prefix):]
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			if !seen[rel[:i]] {
+				seen[rel[:i]] = true
+				entries = append(entries, Entry{Name: rel[:i], IsDir: true})
+			}
+			continue
+		}
+		entries = append(entries, Entry{Name: rel, Size: int64(len(f.data)), ModTime: f.modTime})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func (s *MemStorage) Rename(oldname, newname string) error {
+	if err := s.fail("rename", oldname); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[path.Clean(oldname)]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrNotExist}
+	}
+	delete(s.files, path.Clean(oldname))
+	s.files[path.Clean(newname)] = f
+	return nil
+}
+
+func (s *MemStorage) Delete(name string) error {
+	if err := s.fail("delete", name); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[path.Clean(name)]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(s.files, path.Clean(name))
+	return nil
+}
+
+func (s *MemStorage) String() string {
+	return "mem:"
+}
+
+type memWriter struct {
+	s    *MemStorage
+	f    *memFile
+	name string
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	if err := w.s.fail("write", w.name); err != nil {
+		return 0, err
+	}
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	n := len(p)
+	if w.s.Quota > 0 {
+		if free := w.s.Quota - w.s.size(); int64(n) > free {
+			n = int(free)
+		}
+	}
+	w.f.data = append(w.f.data, p[:n]...)
+	w.f.modTime = time.Now()
+	if n < len(p) {
+		return n, &os.PathError{Op: "write", Path: w.name, Err: ErrNoSpace}
+	}
+	return n, nil
+}
+
+func (w *memWriter) Close() error {
+	return nil
+}
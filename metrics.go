@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// processMetrics counts what this process has done, daemon modes serve them
+// in the Prometheus text format.
+type processMetrics struct {
+	encoded, decoded, verified int64
+	failures                   int64
+	checksumErrors             int64
+	bytes                      int64
+	queue                      int64
+	// read counts content bytes as they go through, files in progress
+	// included
+	read int64
+}
+
+var metrics processMetrics
+
+func (m *processMetrics) record(res Result, err error) {
+	atomic.AddInt64(&m.bytes, res.Bytes)
+	switch {
+	case err != nil:
+		atomic.AddInt64(&m.failures, 1)
+		if isChecksumError(err) {
+			atomic.AddInt64(&m.checksumErrors, 1)
+		}
+	case res.Action == ActionEncode:
+		atomic.AddInt64(&m.encoded, 1)
+	case res.Action == ActionVerify:
+		atomic.AddInt64(&m.verified, 1)
+	default:
+		atomic.AddInt64(&m.decoded, 1)
+	}
+}
+
+// metricsReader adds what is read from it to metrics.read.
+type metricsReader struct {
+	io.Reader
+}
+
+func (r metricsReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(&metrics.read, int64(n))
+	return n, err
+}
+
+func isChecksumError(err error) bool {
+	return errors.Is(err, codec.ErrCRCCheckFailed) || errors.Is(err, codec.ErrDigestCheckFailed)
+}
+
+func (m *processMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP neo_files_total Files processed successfully.\n# TYPE neo_files_total counter\n")
+	fmt.Fprintf(w, "neo_files_total{action=\"encode\"} %d\n", atomic.LoadInt64(&m.encoded))
+	fmt.Fprintf(w, "neo_files_total{action=\"decode\"} %d\n", atomic.LoadInt64(&m.decoded))
+	fmt.Fprintf(w, "neo_files_total{action=\"verify\"} %d\n", atomic.LoadInt64(&m.verified))
+	fmt.Fprintf(w, "# HELP neo_failures_total Files that failed to process.\n# TYPE neo_failures_total counter\n")
+	fmt.Fprintf(w, "neo_failures_total %d\n", atomic.LoadInt64(&m.failures))
+	fmt.Fprintf(w, "# HELP neo_checksum_errors_total Files whose content did not match the stored checksum.\n# TYPE neo_checksum_errors_total counter\n")
+	fmt.Fprintf(w, "neo_checksum_errors_total %d\n", atomic.LoadInt64(&m.checksumErrors))
+	fmt.Fprintf(w, "# HELP neo_bytes_total Bytes of file content processed.\n# TYPE neo_bytes_total counter\n")
+	fmt.Fprintf(w, "neo_bytes_total %d\n", atomic.LoadInt64(&m.bytes))
+	fmt.Fprintf(w, "# HELP neo_read_bytes_total Bytes of file content read, files in progress included.\n# TYPE neo_read_bytes_total counter\n")
+	fmt.Fprintf(w, "neo_read_bytes_total %d\n", atomic.LoadInt64(&m.read))
+	fmt.Fprintf(w, "# HELP neo_queue_depth Files waiting to be processed.\n# TYPE neo_queue_depth gauge\n")
+	fmt.Fprintf(w, "neo_queue_depth %d\n", atomic.LoadInt64(&m.queue))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	var m processMetrics
+	m.record(Result{Action: ActionEncode, Bytes: 100}, nil)
+	m.record(Result{Action: ActionDecode, Bytes: 50}, &CRCError{})
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`neo_files_total{action="encode"} 1`,
+		`neo_files_total{action="decode"} 0`,
+		"neo_failures_total 1",
+		"neo_checksum_errors_total 1",
+		"neo_bytes_total 150",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in\n%s", line, body)
+		}
+	}
+}
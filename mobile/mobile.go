@@ -0,0 +1,241 @@
+// Package mobile exposes NEO to Android and iOS through gomobile:
+//
+//	gomobile bind -target android github.com/hr3lxphr6j/neo/mobile
+//
+// Only types gomobile can bind are used in the API. Functions taking file
+// descriptors take ownership of them and close them when done, on Android
+// pass ParcelFileDescriptor.detachFd().
+package mobile
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+type Header struct {
+	Version  int
+	Filename string
+	Crc32    int64
+}
+
+type Decoded struct {
+	Filename string
+	Data     []byte
+}
+
+func newHeader(hdr *codec.NeoHeader) *Header {
+	return &Header{
+		Version:  int(hdr.Version),
+		Filename: hdr.OriginalFilename,
+		Crc32:    int64(hdr.Crc32),
+	}
+}
+
+// OutputName returns a random name for an encoded file.
+func OutputName() string {
+	b := make([]byte, 8)
+	for i := range b {
+		b[i] = letters[rnd.Intn(len(letters))]
+	}
+	return string(b) + ".neo"
+}
+
+// IsNeo reports whether data starts with the NEO magic number.
+func IsNeo(data []byte) bool {
+	return bytes.HasPrefix(data, codec.NeoMagicNumber)
+}
+
+// Inspect parses the header from the leading bytes of a NEO file.
+func Inspect(data []byte) (*Header, error) {
+	hdr, err := codec.ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return newHeader(hdr), nil
+}
+
+func EncodeBytes(data []byte, filename string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := codec.NewNeoWriter(buf, codec.DefaultHeaderLen, filename, crc32.ChecksumIEEE(data))
+	w.SetSize(int64(len(data)))
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func DecodeBytes(data []byte) (*Decoded, error) {
+	rd := codec.NewNeoReader(bytes.NewReader(data))
+	out, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
+	}
+	if !rd.NeoHeader.NoChecksum && crc32.ChecksumIEEE(out) != rd.NeoHeader.Crc32 {
+		return nil, codec.ErrCRCCheckFailed
+	}
+	return &Decoded{Filename: rd.NeoHeader.OriginalFilename, Data: out}, nil
+}
+
+// InspectFD parses the header of the NEO file open as fd.
+func InspectFD(fd int) (*Header, error) {
+	f := os.NewFile(uintptr(fd), "neo")
+	defer f.Close()
+	hdr, err := codec.ReadHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	return newHeader(hdr), nil
+}
+
+// EncodeFD encodes inFd into outFd, inFd must be seekable since it is read
+// twice, once for the checksum.
+func EncodeFD(inFd, outFd int, filename string) error {
+	in := os.NewFile(uintptr(inFd), "in")
+	defer in.Close()
+	out := os.NewFile(uintptr(outFd), "out")
+	defer out.Close()
+	h := crc32.NewIEEE()
+	size, err := io.Copy(h, in)
+	if err != nil {
+		return err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w := codec.NewNeoWriter(out, codec.DefaultHeaderLen, filename, h.Sum32())
+	w.SetSize(size)
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// DecodeFD decodes inFd into outFd and returns the header, so the caller can
+// name the output after Header.Filename.
+func DecodeFD(inFd, outFd int) (*Header, error) {
+	in := os.NewFile(uintptr(inFd), "in")
+	defer in.Close()
+	out := os.NewFile(uintptr(outFd), "out")
+	defer out.Close()
+	rd := codec.NewNeoReader(in)
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(io.MultiWriter(out, h), rd); err != nil {
+		return nil, err
+	}
+	if !rd.NeoHeader.NoChecksum && h.Sum32() != rd.NeoHeader.Crc32 {
+		return nil, codec.ErrCRCCheckFailed
+	}
+	return newHeader(rd.NeoHeader), out.Sync()
+}
+
+// MimeType and Extension are what the app registers its intent filters for,
+// content:// URIs of NEO files seldom carry a better type.
+const (
+	MimeType  = "application/x-neo"
+	Extension = ".neo"
+)
+
+// ErrCanceled is returned by DecodeStream when Progress asked to stop.
+var ErrCanceled = errors.New("canceled")
+
+// Source is implemented by the app around the InputStream of a content://
+// URI. ReadChunk returns up to max bytes, an empty result at the end.
+type Source interface {
+	ReadChunk(max int) ([]byte, error)
+}
+
+// Sink is implemented by the app around the OutputStream the decoded
+// content goes to.
+type Sink interface {
+	WriteChunk(data []byte) error
+}
+
+// Progress is told how many bytes of the input were read, total is the size
+// passed to DecodeStream. Returning false cancels the decode.
+type Progress interface {
+	OnProgress(done, total int64) bool
+}
+
+const chunkSize = 64 << 10
+
+// sourceReader reads a Source as an io.Reader, counting what was read.
+type sourceReader struct {
+	src  Source
+	buf  []byte
+	read int64
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		b, err := r.src.ReadChunk(chunkSize)
+		if err != nil {
+			return 0, err
+		}
+		if len(b) == 0 {
+			return 0, io.EOF
+		}
+		r.buf = b
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.read += int64(n)
+	return n, nil
+}
+
+// InspectStream parses the header at the start of src, only the chunks the
+// header spans are read.
+func InspectStream(src Source) (*Header, error) {
+	hdr, err := codec.ReadHeader(&sourceReader{src: src})
+	if err != nil {
+		return nil, err
+	}
+	return newHeader(hdr), nil
+}
+
+// DecodeStream decodes src into dst and returns the header, size is the
+// size of the input for progress, -1 when unknown. progress may be nil.
+func DecodeStream(src Source, dst Sink, size int64, progress Progress) (*Header, error) {
+	r := &sourceReader{src: src}
+	rd := codec.NewNeoReader(r)
+	h := crc32.NewIEEE()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := rd.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			if werr := dst.WriteChunk(buf[:n]); werr != nil {
+				return nil, werr
+			}
+			if progress != nil && !progress.OnProgress(r.read, size) {
+				return nil, ErrCanceled
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !rd.NeoHeader.NoChecksum && h.Sum32() != rd.NeoHeader.Crc32 {
+		return nil, codec.ErrCRCCheckFailed
+	}
+	return newHeader(rd.NeoHeader), nil
+}
@@ -0,0 +1,119 @@
+package mobile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodeDecodeBytes(t *testing.T) {
+	data := make([]byte, 10000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncodeBytes(data, "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsNeo(enc) {
+		t.Fatal("encoded data has no NEO magic")
+	}
+	hdr, err := Inspect(enc[:64])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Filename != "photo.jpg" {
+		t.Fatalf("except photo.jpg, but %s", hdr.Filename)
+	}
+	dec, err := DecodeBytes(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.Filename != "photo.jpg" || !bytes.Equal(dec.Data, data) {
+		t.Fatal("decoded content mismatch")
+	}
+}
+
+func TestEncodeDecodeFD(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 10000)
+	rand.Read(data)
+	if err := os.WriteFile(filepath.Join(dir, "in"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	open := func(name string, flag int) int {
+		f, err := os.OpenFile(filepath.Join(dir, name), flag, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return int(f.Fd())
+	}
+	if err := EncodeFD(open("in", os.O_RDONLY), open("enc", os.O_CREATE|os.O_WRONLY), "doc.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := DecodeFD(open("enc", os.O_RDONLY), open("dec", os.O_CREATE|os.O_WRONLY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Filename != "doc.pdf" {
+		t.Fatalf("except doc.pdf, but %s", hdr.Filename)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "dec")); !bytes.Equal(b, data) {
+		t.Fatal("decoded content mismatch")
+	}
+}
+
+type chunkSource struct{ r *bytes.Reader }
+
+func (s chunkSource) ReadChunk(max int) ([]byte, error) {
+	b := make([]byte, max/3)
+	n, _ := s.r.Read(b)
+	return b[:n], nil
+}
+
+type bufferSink struct{ bytes.Buffer }
+
+func (s *bufferSink) WriteChunk(data []byte) error {
+	_, err := s.Write(data)
+	return err
+}
+
+type countProgress struct {
+	calls int
+	stop  int
+}
+
+func (p *countProgress) OnProgress(done, total int64) bool {
+	p.calls++
+	return p.calls != p.stop
+}
+
+func TestDecodeStream(t *testing.T) {
+	data := make([]byte, 300000)
+	rand.Read(data)
+	enc, err := EncodeBytes(data, "video.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := InspectStream(chunkSource{bytes.NewReader(enc)})
+	if err != nil || hdr.Filename != "video.mp4" {
+		t.Fatalf("InspectStream() = %+v, %v", hdr, err)
+	}
+
+	sink, progress := new(bufferSink), new(countProgress)
+	hdr, err = DecodeStream(chunkSource{bytes.NewReader(enc)}, sink, int64(len(enc)), progress)
+	if err != nil || hdr.Filename != "video.mp4" || !bytes.Equal(sink.Bytes(), data) {
+		t.Fatalf("DecodeStream() = %+v, %v", hdr, err)
+	}
+	if progress.calls < 2 {
+		t.Errorf("except several progress calls, but %d", progress.calls)
+	}
+	if _, err := DecodeStream(chunkSource{bytes.NewReader(enc)}, new(bufferSink), -1, &countProgress{stop: 2}); err != ErrCanceled {
+		t.Errorf("except ErrCanceled, but %v", err)
+	}
+	if _, err := DecodeStream(chunkSource{bytes.NewReader(enc)}, new(bufferSink), -1, nil); err != nil {
+		t.Errorf("decode without progress: %v", err)
+	}
+}
@@ -1,419 +1,684 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
-	"crypto/rand"
-	"encoding/binary"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
-	"log"
-	"os"
-	"path/filepath"
-	"runtime"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
 )
 
-const (
-	VersionV1 uint8 = 1
+// OpError records the step of an encode/decode that failed.
+type OpError struct {
+	Op   string
+	Path string
+	Err  error
+}
 
-	FlagVersion = 0b00001111
+func (e *OpError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
 
-	XorEnc uint8 = 1
-)
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
 
-var (
-	NeoMagicNumber = []byte{0xFF, 0x4E, 0x45, 0x4F}
+type CRCError struct {
+	Path     string
+	Expected uint32
+	Actual   uint32
+}
 
-	ErrCRCCheckFailed      = errors.New("crc check failed")
-	ErrNotNEOHeader        = errors.New("not a NEO header")
-	ErrBadVersion          = errors.New("bad version")
-	ErrUnknownCryptoMethod = errors.New("unknown crypto method")
-)
+func (e *CRCError) Error() string {
+	return fmt.Sprintf("%s: %v, %d != %d", e.Path, codec.ErrCRCCheckFailed, e.Expected, e.Actual)
+}
 
-type NeoHeader struct {
-	Version                   uint8
-	OriginalHeaderEncMethod   uint8
-	OriginalHeader            []byte
-	OriginalFilenameEncMethod uint8
-	OriginalFilename          string
-	Crc32                     uint32
+func (e *CRCError) Is(target error) bool {
+	return target == codec.ErrCRCCheckFailed
 }
 
-func encodeVUint(u uint) []byte {
-	buf := new(bytes.Buffer)
-	for i := 0; i < int(u)/0xFF; i++ {
-		buf.WriteByte(0xFF)
+type DigestError struct {
+	Path     string
+	Alg      string
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("%s: %s %v, %x != %x", e.Path, e.Alg, codec.ErrDigestCheckFailed, e.Expected, e.Actual)
+}
+
+func (e *DigestError) Is(target error) bool {
+	return target == codec.ErrDigestCheckFailed
+}
+
+var (
+	// encodeSHA256 stores a SHA-256 digest next to the CRC32 of encoded files.
+	encodeSHA256 bool
+	// encodeXXH64 stores an XXH64 digest, a cheap check that is far less
+	// likely to miss a corruption than CRC32.
+	encodeXXH64 bool
+	// headerLen is how many leading bytes of encoded files go into the
+	// header, large values are stored as codec.XorRecords.
+	headerLen = codec.DefaultHeaderLen
+	// encodeEncryptMeta encrypts the CRC32 and other metadata of encoded files.
+	encodeEncryptMeta bool
+	// encodeStealth replaces the magic number of encoded files with one
+	// derived from key.
+	encodeStealth bool
+	// encodeKeyed gives encoded files their own random key, kept in a slot
+	// of the header wrapped with key, see codec.KeySlot.
+	encodeKeyed bool
+	// encodeNoChecksum skips the checksum pass, the header of encoded files
+	// records that they have none.
+	encodeNoChecksum bool
+	// encodeHint stores nameHint of the original filename.
+	encodeHint bool
+	// encodeHeaderCRC stores a checksum of the header, so a damaged one is
+	// found before decoding starts.
+	encodeHeaderCRC = true
+	// hashOutput fills in Result.OutputSHA256 of encoded files.
+	hashOutput bool
+	// noVerify decodes without checking the content against its checksums.
+	noVerify bool
+	// key is the key material from -password or -keyfile, with it stealth
+	// files are recognized too.
+	key []byte
+)
+
+// nameHint keeps the first two characters and the extension of name, enough
+// to recognize a file without giving its name away.
+func nameHint(name string) string {
+	ext := path.Ext(name)
+	if len(ext) > 8 {
+		ext = ""
+	}
+	stem := []rune(strings.TrimSuffix(name, ext))
+	if len(stem) > 2 {
+		stem = stem[:2]
 	}
-	buf.WriteByte(byte(u % 0xFF))
-	return buf.Bytes()
+	return string(stem) + "…" + ext
 }
 
-func decodeVUint(p []byte) (res uint, surplus []byte) {
-	for idx, v := range p {
-		if v == 0xFF {
-			res += 0xFF
-			continue
-		}
-		res += uint(v)
-		surplus = p[idx+1:]
-		break
-	}
-	return
-}
-
-func writeContentWithXorEnc(buf *bytes.Buffer, content, key []byte) {
-	buf.WriteByte(XorEnc)
-	buf.Write(encodeVUint(uint(len(key))))
-	buf.Write(key)
-	buf.Write(encodeVUint(uint(len(content))))
-	dst := make([]byte, len(content))
-	NewXorStream(key).XORKeyStream(dst, content)
-	buf.Write(dst)
-}
-
-func loadContextWithXorEnc(p []byte) (content, surplus []byte) {
-	var (
-		keyLen, contentLen uint
-		key, secContent    []byte
-	)
-	keyLen, surplus = decodeVUint(p)
-	key, surplus = surplus[:keyLen], surplus[keyLen:]
-	contentLen, surplus = decodeVUint(surplus)
-	secContent, surplus = surplus[:contentLen], surplus[contentLen:]
-	content = make([]byte, contentLen)
-	NewXorStream(key).XORKeyStream(content, secContent)
-	return
-}
-
-func (h NeoHeader) Marshall() ([]byte, error) {
-	if h.Version != VersionV1 {
-		return nil, ErrBadVersion
-	}
-
-	buf := new(bytes.Buffer)
-
-	var flag byte = 0
-	flag |= h.Version & FlagVersion
-	buf.WriteByte(flag)
-
-	// encode originalHeader
-	switch h.OriginalHeaderEncMethod {
-	case XorEnc:
-		key := make([]byte, 4)
-		if _, err := rand.Reader.Read(key); err != nil {
-			return nil, err
+// outputExts is the pool the extension of encoded files is picked from.
+var outputExts = []string{".neo"}
+
+// nameScheme decides how encoded files are named: "random" or "hash", the
+// latter names them after the SHA-256 of their own content.
+var nameScheme = "random"
+
+func outputName(exts []string) string {
+	return RandStringRunes(8) + randomExt(exts)
+}
+
+func randomExt(exts []string) string {
+	return exts[rand.Intn(len(exts))]
+}
+
+func hashName(sum []byte, ext string) string {
+	return hex.EncodeToString(sum[:8]) + ext
+}
+
+// parseExts parses a comma separated extension list, the leading dot is
+// optional.
+func parseExts(s string) ([]string, error) {
+	var exts []string
+	for _, ext := range strings.Split(s, ",") {
+		ext = strings.TrimPrefix(strings.TrimSpace(ext), ".")
+		if ext == "" || strings.ContainsAny(ext, `/\`) {
+			return nil, fmt.Errorf("bad extension: %q", ext)
 		}
-		writeContentWithXorEnc(buf, h.OriginalHeader, key)
-	default:
-		return nil, ErrUnknownCryptoMethod
+		exts = append(exts, "."+ext)
 	}
+	return exts, nil
+}
 
-	switch h.OriginalFilenameEncMethod {
-	case XorEnc:
-		key := make([]byte, 4)
-		if _, err := rand.Reader.Read(key); err != nil {
-			return nil, err
+// parseSize parses a byte count with an optional K, M or G suffix, which
+// are powers of 1024.
+func parseSize(s string) (int, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	shift := uint(0)
+	switch {
+	case strings.HasSuffix(num, "K"):
+		shift = 10
+	case strings.HasSuffix(num, "M"):
+		shift = 20
+	case strings.HasSuffix(num, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 32)
+	if err != nil || n < 0 || n > math.MaxInt32>>shift {
+		return 0, fmt.Errorf("bad size: %q", s)
+	}
+	return int(n) << shift, nil
+}
+
+// bufferSize is the copy buffer for file content, 0 picks one from the
+// storages involved, see bufferFor.
+var bufferSize int
+
+// bufferFor returns the copy buffer size for moving content between sts:
+// local disks read large blocks best, network storages favour smaller ones.
+func bufferFor(sts ...Storage) int {
+	if bufferSize > 0 {
+		return bufferSize
+	}
+	for _, st := range sts {
+		if _, ok := st.(LocalStorage); !ok {
+			return 64 << 10
 		}
-		writeContentWithXorEnc(buf, []byte(h.OriginalFilename), key)
-	default:
-		return nil, ErrUnknownCryptoMethod
 	}
+	return 1 << 20
+}
 
-	crc := make([]byte, 4)
-	binary.BigEndian.PutUint32(crc, h.Crc32)
-	buf.Write(crc)
+// copyBuffer is io.Copy with a buffer of size bytes.
+func copyBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	// hiding ReaderFrom keeps os.File from copying with its own 32 KiB buffer
+	return io.CopyBuffer(struct{ io.Writer }{dst}, src, make([]byte, size))
+}
 
-	contentLenVint := encodeVUint(uint(buf.Len()))
-	res := make([]byte, 4+len(contentLenVint)+buf.Len())
-	copy(res[:4], NeoMagicNumber)
-	copy(res[4:], contentLenVint)
-	copy(res[4+len(contentLenVint):], buf.Bytes())
-	return res, nil
+// ctxReader fails reads once ctx is done, so a file given up on stops at its
+// next read instead of running on in the background.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
 }
 
-func (h *NeoHeader) UnMarshall(p []byte) error {
-	if len(p) <= 4 {
-		return ErrNotNEOHeader
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
 	}
-	var (
-		neoHdrlen uint
-		flag      byte = 0
-	)
-	neoHdrlen, p = decodeVUint(p[4:])
-	if uint(len(p)) != neoHdrlen {
-		panic("len not equal")
+	return r.r.Read(p)
+}
+
+// fileOptions are the settings of one encode or decode that differ from file
+// to file: those of the .neorc policy and the output name of the plugin.
+type fileOptions struct {
+	ctx context.Context
+	encodeSettings
+	// name is the output name the plugin gave, empty keeps the default
+	name string
+}
+
+// defaultOptions takes the settings of the flags.
+func defaultOptions() *fileOptions {
+	return &fileOptions{ctx: context.Background(), encodeSettings: currentSettings()}
+}
+
+// strictParse rejects NEO headers with anomalies instead of warning about them.
+var strictParse bool
+
+func newNeoReader(r io.Reader) *codec.NeoReader {
+	rd := codec.NewNeoReader(r)
+	if key != nil {
+		rd.SetKeyFunc(keyFunc(key))
 	}
-	flag, p = p[0], p[1:]
-	h.Version = flag & FlagVersion
-	if h.Version != VersionV1 {
-		return ErrBadVersion
+	rd.Strict = strictParse
+	return rd
+}
+
+// hashSet feeds every selected digest from a single pass over the content.
+type hashSet struct {
+	io.Writer
+	crc32  hash.Hash32
+	sha256 hash.Hash
+	xxh64  hash.Hash64
+}
+
+func newHashSet(withSHA256, withXXH64 bool) *hashSet {
+	s := &hashSet{crc32: crc32.NewIEEE()}
+	ws := []io.Writer{s.crc32}
+	if withSHA256 {
+		s.sha256 = sha256.New()
+		ws = append(ws, s.sha256)
 	}
-	h.OriginalHeaderEncMethod, p = p[0], p[1:]
-	switch h.OriginalHeaderEncMethod {
-	case XorEnc:
-		h.OriginalHeader, p = loadContextWithXorEnc(p)
-	default:
-		return ErrUnknownCryptoMethod
+	if withXXH64 {
+		s.xxh64 = codec.NewXXH64()
+		ws = append(ws, s.xxh64)
 	}
+	s.Writer = io.MultiWriter(ws...)
+	return s
+}
 
-	h.OriginalFilenameEncMethod, p = p[0], p[1:]
-	switch h.OriginalFilenameEncMethod {
-	case XorEnc:
-		var filename []byte
-		filename, p = loadContextWithXorEnc(p)
-		h.OriginalFilename = string(filename)
-	default:
-		return ErrUnknownCryptoMethod
+func (s *hashSet) sumSHA256() []byte {
+	if s.sha256 == nil {
+		return nil
 	}
+	return s.sha256.Sum(nil)
+}
 
-	var crc32 []byte
-	crc32, p = p[:4], p[4:]
-	h.Crc32 = binary.BigEndian.Uint32(crc32)
+func (s *hashSet) sumXXH64() []byte {
+	if s.xxh64 == nil {
+		return nil
+	}
+	return s.xxh64.Sum(nil)
+}
 
-	return nil
+func hashFile(ctx context.Context, st Storage, name string, hs *hashSet) error {
+	fromFd, err := st.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fromFd.Close()
+	_, err = copyBuffer(hs, ctxReader{ctx, fromFd}, bufferFor(st))
+	return err
 }
 
-type NeoWriter struct {
-	originHdrLen    int
-	hdr             *NeoHeader
-	w               io.Writer
-	buf             *bytes.Buffer
-	isNewHdrWritten bool
+type Action string
+
+const (
+	ActionEncode Action = "encode"
+	ActionDecode Action = "decode"
+	ActionVerify Action = "verify"
+)
+
+type Result struct {
+	Action       Action        `json:"action"`
+	Input        string        `json:"input"`
+	Output       string        `json:"output,omitempty"`
+	OriginalName string        `json:"original_name,omitempty"`
+	Bytes        int64         `json:"bytes"`
+	Duration     time.Duration `json:"duration"`
+	Checksum     uint32        `json:"crc32"`
+	SHA256       string        `json:"sha256,omitempty"`
+	XXH64        string        `json:"xxh64,omitempty"`
+	OutputSHA256 string        `json:"output_sha256,omitempty"`
+	Quarantined  string        `json:"quarantined,omitempty"`
+	Warnings     []string      `json:"warnings,omitempty"`
+	Error        string        `json:"error,omitempty"`
 }
 
-func NewNeoWriter(w io.Writer, hdrLen int, filename string, crc32 uint32) io.Writer {
-	return &NeoWriter{
-		originHdrLen: hdrLen,
-		hdr: &NeoHeader{
-			Version:                   VersionV1,
-			OriginalHeaderEncMethod:   XorEnc,
-			OriginalHeader:            nil,
-			OriginalFilenameEncMethod: XorEnc,
-			OriginalFilename:          filename,
-			Crc32:                     crc32,
-		},
-		w:               w,
-		buf:             new(bytes.Buffer),
-		isNewHdrWritten: false,
+// altWarning is added to the result of a file whose header only checks out
+// with the other XOR stream, see codec.NeoHeader.Alternate.
+const altWarning = "header read with the alternate XOR stream"
+
+func DecodeFile(src Storage, name string, dst Storage) (Result, error) {
+	return decodeFileWith(defaultOptions(), src, name, dst)
+}
+
+func decodeFileWith(opts *fileOptions, src Storage, name string, dst Storage) (Result, error) {
+	res, hasAlt, err := decodeFile(opts, src, name, dst, false)
+	if hasAlt && isChecksumError(err) {
+		res, _, err = decodeFile(opts, src, name, dst, true)
 	}
+	return res, err
 }
 
-func (w *NeoWriter) Write(p []byte) (n int, err error) {
-	if w.isNewHdrWritten {
-		return w.w.Write(p)
+// decodeFile decodes with the Alternate reading of the header when alt is
+// set, hasAlt reports whether the header has one.
+func decodeFile(opts *fileOptions, src Storage, name string, dst Storage, alt bool) (res Result, hasAlt bool, err error) {
+	start := time.Now()
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+	res = Result{Action: ActionDecode, Input: displayPath(src, name)}
+	fromFd, err := src.Open(name)
+	if err != nil {
+		return res, hasAlt, &OpError{Op: "open", Path: res.Input, Err: err}
 	}
-	if w.buf.Len() < w.originHdrLen {
-		if len(p) <= w.originHdrLen {
-			return w.buf.Write(p)
+	defer fromFd.Close()
+	success := false
+	toName := name + ".decoding"
+	toFilename := displayPath(dst, toName)
+	if sandboxed {
+		// a link left in the way would be written through
+		dst.Delete(toName)
+	}
+	toFd, err := createOutput(dst, toName)
+	if err != nil {
+		return res, hasAlt, err
+	}
+	defer func() {
+		toFd.Close()
+		if !success {
+			dst.Delete(toName)
 		}
-		if n, err := w.buf.Write(p[:w.originHdrLen]); err != nil {
-			return n, err
+	}()
+	size := bufferFor(src, dst)
+	neoRd := newNeoReader(ctxReader{opts.ctx, fromFd})
+	neoRd.SetBufferSize(size)
+	hdr, err := neoRd.Header()
+	if err != nil {
+		return res, hasAlt, &OpError{Op: "header", Path: res.Input, Err: err}
+	}
+	hasAlt = hdr.Alternate() != nil
+	if alt && neoRd.UseAlternate() {
+		hdr = neoRd.NeoHeader
+		hdr.Warnings = append(hdr.Warnings, altWarning)
+	}
+	res.OriginalName = hdr.OriginalFilename
+	res.Checksum = hdr.Crc32
+	res.Warnings = hdr.Warnings
+	check := !noVerify && !hdr.NoChecksum
+	hs := newHashSet(hdr.SHA256 != nil, hdr.XXH64 != nil)
+	var body io.Reader = metricsReader{neoRd}
+	if check {
+		body = io.TeeReader(body, hs)
+	}
+	origName := hdr.OriginalFilename
+	if sandboxed {
+		if origName = sanitizeName(origName); origName != "" && origName != hdr.OriginalFilename {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("original filename %q sanitized to %s", hdr.OriginalFilename, origName))
 		}
 	}
-	// got enough bytes
-	w.hdr.OriginalHeader = w.buf.Bytes()
-	hdr, err := w.hdr.Marshall()
+	if opts.name != "" {
+		origName = opts.name
+	}
+	var head *headWriter
+	if !usableName(origName) {
+		head = new(headWriter)
+		body = io.TeeReader(body, head)
+	}
+	res.Bytes, err = copyBuffer(toFd, body, size)
 	if err != nil {
-		return
+		return res, hasAlt, &OpError{Op: "write", Path: toFilename, Err: err}
 	}
-	if _, err := w.w.Write(hdr); err != nil {
-		return 0, err
+	if err := toFd.Close(); err != nil {
+		return res, hasAlt, &OpError{Op: "write", Path: toFilename, Err: err}
+	}
+	setOutputMode(src, name, dst, toName)
+	restoreOwner(dst, toName, hdr.Owner)
+	if check {
+		if err := verifyChecksums(&res, hdr, hs); err != nil {
+			return res, hasAlt, err
+		}
+	}
+	if head != nil {
+		origName = strings.TrimSuffix(name, path.Ext(name)) + sniffExt(head.buf)
+		res.Warnings = append(res.Warnings, fmt.Sprintf("original filename %q is not usable, restored as %s", neoRd.NeoHeader.OriginalFilename, origName))
 	}
-	w.isNewHdrWritten = true
-	n, err = w.w.Write(p[w.originHdrLen:])
-	n += w.originHdrLen
-	return
+	outName, err := outputFor(dst, origName)
+	if err != nil {
+		return res, hasAlt, &OpError{Op: "exists", Path: displayPath(dst, origName), Err: err}
+	}
+	success = true
+	if err := dst.Rename(toName, outName); err != nil {
+		res.Output = toFilename
+		return res, hasAlt, &OpError{Op: "rename", Path: res.Input, Err: err}
+	}
+	res.Output = displayPath(dst, outName)
+	return res, hasAlt, nil
 }
 
-type NeoReader struct {
-	n         int
-	rd        *bufio.Reader
-	NeoHeader *NeoHeader
-	buf       []byte
-}
+// Policies for a decoded file whose original filename is taken.
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictRename    = "rename"
+	ConflictSkip      = "skip"
+)
+
+// decodeConflict is the policy decoding follows.
+var decodeConflict = ConflictRename
 
-func NewNeoReader(r io.Reader) *NeoReader {
-	return &NeoReader{
-		rd:  bufio.NewReader(r),
-		buf: make([]byte, 1024),
+func checkConflict(policy string) error {
+	switch policy {
+	case ConflictRename, ConflictOverwrite, ConflictSkip:
+		return nil
 	}
+	return fmt.Errorf("unknown conflict policy: %s", policy)
 }
 
-func (r *NeoReader) Read(p []byte) (n int, err error) {
-	if len(p) == 0 {
-		return
-	}
-	if r.NeoHeader != nil {
-		if r.n < len(r.NeoHeader.OriginalHeader) {
-			n = copy(p, r.NeoHeader.OriginalHeader[r.n:])
-			r.n += n
-			n_, err_ := r.Read(p[r.n:])
-			return n_ + n, err_
+var ErrOutputExists = errors.New("output already exists")
+
+// outputFor returns the name a file decoded as name is saved under in dst,
+// with ConflictRename a taken name gets a " (n)" suffix. So does a name
+// only taken by a file whose name differs in case, on a case-insensitive
+// file system that is another file whatever the policy.
+func outputFor(dst Storage, name string) (string, error) {
+	if !storageHas(dst, name) {
+		return name, nil
+	}
+	if !caseCollision(dst, name) {
+		switch decodeConflict {
+		case ConflictOverwrite:
+			return name, nil
+		case ConflictSkip:
+			return "", ErrOutputExists
 		}
-		return r.rd.Read(p)
-	}
-	if _, err := r.rd.Read(r.buf[:len(NeoMagicNumber)]); err != nil {
-		return 0, nil
 	}
-	if !bytes.Equal(r.buf[:len(NeoMagicNumber)], NeoMagicNumber) {
-		return 0, ErrNotNEOHeader
-	}
-	n_ := 0
-	hdrLen := 0
-	for {
-		v, err := r.rd.ReadByte()
-		if err != nil {
-			return 0, err
+	ext := path.Ext(name)
+	for i := 1; ; i++ {
+		alt := fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+		if !storageHas(dst, alt) {
+			return alt, nil
 		}
-		hdrLen += int(v)
-		n_++
-		if v != 0xFF {
-			break
-		}
-	}
-	var hdr []byte
-	if len(r.buf) >= len(NeoMagicNumber)+n_+hdrLen {
-		hdr = r.buf[:len(NeoMagicNumber)+n_+hdrLen]
-	} else {
-		hdr = make([]byte, len(NeoMagicNumber)+n+hdrLen)
 	}
-	copy(hdr, NeoMagicNumber)
-	copy(hdr[len(NeoMagicNumber):], encodeVUint(uint(hdrLen)))
-	if _, err := r.rd.Read(hdr[len(NeoMagicNumber)+n_:]); err != nil {
-		return 0, err
-	}
-	r.NeoHeader = new(NeoHeader)
-	if err := r.NeoHeader.UnMarshall(hdr); err != nil {
-		return 0, nil
+}
+
+// VerifyFile decodes name without writing the result and checks it against
+// the checksums in the header.
+func VerifyFile(src Storage, name string) (Result, error) {
+	res, hasAlt, err := verifyFile(src, name, false)
+	if hasAlt && isChecksumError(err) {
+		res, _, err = verifyFile(src, name, true)
 	}
-	return r.Read(p)
+	return res, err
 }
 
-func crc32ofFile(filename string) (uint32, error) {
-	h := crc32.NewIEEE()
-	fromFd, err := os.Open(filename)
+func verifyFile(src Storage, name string, alt bool) (res Result, hasAlt bool, err error) {
+	start := time.Now()
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+	res = Result{Action: ActionVerify, Input: displayPath(src, name)}
+	fromFd, err := src.Open(name)
 	if err != nil {
-		return 0, err
+		return res, hasAlt, &OpError{Op: "open", Path: res.Input, Err: err}
 	}
 	defer fromFd.Close()
-	if _, err := io.Copy(h, fromFd); err != nil {
-		return 0, err
+	size := bufferFor(src)
+	neoRd := newNeoReader(fromFd)
+	neoRd.SetBufferSize(size)
+	hdr, err := neoRd.Header()
+	if err != nil {
+		return res, hasAlt, &OpError{Op: "header", Path: res.Input, Err: err}
+	}
+	hasAlt = hdr.Alternate() != nil
+	if alt && neoRd.UseAlternate() {
+		hdr = neoRd.NeoHeader
+		hdr.Warnings = append(hdr.Warnings, altWarning)
+	}
+	res.OriginalName = hdr.OriginalFilename
+	res.Checksum = hdr.Crc32
+	res.Warnings = hdr.Warnings
+	if hdr.NoChecksum {
+		res.Warnings = append(res.Warnings, "no checksum stored, the content cannot be verified")
+	}
+	hs := newHashSet(hdr.SHA256 != nil, hdr.XXH64 != nil)
+	if res.Bytes, err = copyBuffer(hs, metricsReader{neoRd}, size); err != nil {
+		return res, hasAlt, &OpError{Op: "read", Path: res.Input, Err: err}
 	}
-	return h.Sum32(), nil
+	return res, hasAlt, verifyChecksums(&res, hdr, hs)
 }
 
-func decodeFile(filename string) {
-	fromFd, err := os.Open(filename)
-	if err != nil {
-		log.Printf("无法打开文件：%s，错误：%v", filename, err)
-		return
+func verifyChecksums(res *Result, hdr *codec.NeoHeader, hs *hashSet) error {
+	if hdr.NoChecksum {
+		return nil
 	}
-	success := false
-	toFilename := filename + ".decoding"
-	toFd, err := os.OpenFile(toFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
-	if err != nil {
-		log.Printf("无法打开文件：%s，错误：%v", filename, err)
-		return
+	if crc32_ := hs.crc32.Sum32(); crc32_ != hdr.Crc32 {
+		return &CRCError{Path: res.Input, Expected: hdr.Crc32, Actual: crc32_}
 	}
-	defer func() {
-		toFd.Close()
-		if !success {
-			os.Remove(toFilename)
+	if sum := hs.sumSHA256(); sum != nil {
+		if !bytes.Equal(sum, hdr.SHA256) {
+			return &DigestError{Path: res.Input, Alg: "sha256", Expected: hdr.SHA256, Actual: sum}
 		}
-	}()
-	h := crc32.NewIEEE()
-	neoRd := NewNeoReader(fromFd)
-	if _, err := io.Copy(toFd, io.TeeReader(neoRd, h)); err != nil {
-		log.Printf("写入文件：%s，错误：%v", toFilename, err)
-		return
+		res.SHA256 = hex.EncodeToString(sum)
 	}
-	toFd.Close()
-	if crc32_ := h.Sum32(); crc32_ != neoRd.NeoHeader.Crc32 {
-		log.Printf("文件：%s CRC校验失败 %d != %d, 文件损毁", filename, neoRd.NeoHeader.Crc32, crc32_)
-		return
-	}
-	success = true
-	originPath := filepath.Join(filepath.Dir(filename), neoRd.NeoHeader.OriginalFilename)
-	if err := os.Rename(toFilename, originPath); err != nil {
-		log.Printf("重命名文件 %s 失败", filename)
+	if sum := hs.sumXXH64(); sum != nil {
+		if !bytes.Equal(sum, hdr.XXH64) {
+			return &DigestError{Path: res.Input, Alg: "xxh64", Expected: hdr.XXH64, Actual: sum}
+		}
+		res.XXH64 = hex.EncodeToString(sum)
 	}
+	return nil
 }
 
-func encodeFile(filename string) {
-	crc32_, err := crc32ofFile(filename)
-	if err != nil {
-		log.Printf("无法计算文件：%s CRC32，错误：%v", filename, err)
-		return
+func EncodeFile(src Storage, name string, dst Storage) (Result, error) {
+	return encodeFileWith(defaultOptions(), src, name, dst)
+}
+
+func encodeFileWith(opts *fileOptions, src Storage, name string, dst Storage) (res Result, err error) {
+	start := time.Now()
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+	res = Result{Action: ActionEncode, Input: displayPath(src, name), OriginalName: name}
+	var magic, master, kdfSalt []byte
+	if encodeStealth || encodeKeyed {
+		if key == nil {
+			return res, ErrNoKey
+		}
+		k := writeKey(key)
+		if encodeStealth {
+			magic = codec.StealthMagic(k)
+		}
+		if encodeKeyed {
+			master = k
+		}
+		kdfSalt = writeSalt
 	}
-	fromFd, err := os.Open(filename)
+	// content is read from rd, a remote file is downloaded once for both
+	// passes
+	rd := src
+	if _, ok := src.(*HTTPStorage); ok && !encodeNoChecksum {
+		staged, cleanup, err := stageRemote(opts.ctx, src, name)
+		if err != nil {
+			return res, &OpError{Op: "open", Path: res.Input, Err: err}
+		}
+		defer cleanup()
+		rd = staged
+	}
+	hs := newHashSet(opts.sha256 && !encodeNoChecksum, opts.xxh64 && !encodeNoChecksum)
+	if !encodeNoChecksum {
+		if err := hashFile(opts.ctx, rd, name, hs); err != nil {
+			return res, &OpError{Op: "checksum", Path: res.Input, Err: err}
+		}
+		res.Checksum = hs.crc32.Sum32()
+		if sum := hs.sumSHA256(); sum != nil {
+			res.SHA256 = hex.EncodeToString(sum)
+		}
+		if sum := hs.sumXXH64(); sum != nil {
+			res.XXH64 = hex.EncodeToString(sum)
+		}
+	}
+	fromFd, err := rd.Open(name)
 	if err != nil {
-		log.Printf("无法打开文件：%s，错误：%v", filename, err)
-		return
+		return res, &OpError{Op: "open", Path: res.Input, Err: err}
 	}
 	defer fromFd.Close()
-	toFilename := filepath.Join(filepath.Dir(filename), RandStringRunes(8)+".neo")
-	toFd, err := os.OpenFile(toFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
+	toName := outputName(opts.outputExts)
+	if opts.name != "" {
+		if toName, err = outputFor(dst, opts.name); err != nil {
+			return res, &OpError{Op: "exists", Path: displayPath(dst, opts.name), Err: err}
+		}
+	}
+	toFilename := displayPath(dst, toName)
+	toFd, err := createOutput(dst, toName)
 	if err != nil {
-		log.Printf("无法打开文件：%s，错误：%v", filename, err)
-		return
+		return res, err
+	}
+	var out io.Writer = toFd
+	outHash := sha256.New()
+	if opts.nameScheme == "hash" || hashOutput {
+		out = io.MultiWriter(toFd, outHash)
+	}
+	owner := recordOwner(src, name)
+	var hint string
+	if encodeHint {
+		hint = nameHint(name)
+	}
+	var media *codec.Media
+	if dir, ok := src.(LocalStorage); ok && encodeMedia {
+		media = probeMedia(dir.path(name))
+	}
+	w := codec.NewNeoWriterWithHeader(out, opts.headerLen, &codec.NeoHeader{
+		Version:                   codec.VersionV1,
+		OriginalHeaderEncMethod:   codec.XorEnc,
+		OriginalFilenameEncMethod: codec.XorEnc,
+		OriginalFilename:          name,
+		Crc32:                     res.Checksum,
+		SHA256:                    hs.sumSHA256(),
+		XXH64:                     hs.sumXXH64(),
+		EncryptedMeta:             opts.encryptMeta,
+		NoChecksum:                encodeNoChecksum,
+		Magic:                     magic,
+		Owner:                     owner,
+		Hint:                      hint,
+		Media:                     media,
+		HeaderCRC:                 encodeHeaderCRC,
+		MasterKey:                 master,
+		KDFSalt:                   kdfSalt,
+		KDFCost:                   codec.DefaultKDFCost,
+	})
+	res.Bytes, err = copyBuffer(w, metricsReader{ctxReader{opts.ctx, fromFd}}, bufferFor(src, dst))
+	if err == nil {
+		err = w.Close()
 	}
-	defer toFd.Close()
-	w := NewNeoWriter(toFd, 8, filepath.Base(filename), crc32_)
-	if _, err := io.Copy(w, fromFd); err != nil {
-		log.Printf("写入文件：%s，错误：%v", toFilename, err)
-		return
+	if err != nil {
+		toFd.Close()
+		dst.Delete(toName)
+		return res, &OpError{Op: "write", Path: toFilename, Err: err}
+	}
+	if err := toFd.Close(); err != nil {
+		dst.Delete(toName)
+		return res, &OpError{Op: "write", Path: toFilename, Err: err}
+	}
+	setOutputMode(src, name, dst, toName)
+	restoreOwner(dst, toName, owner)
+	res.Output = toFilename
+	if hashOutput {
+		res.OutputSHA256 = hex.EncodeToString(outHash.Sum(nil))
+	}
+	if opts.nameScheme == "hash" && opts.name == "" {
+		name := hashName(outHash.Sum(nil), path.Ext(toName))
+		if err := dst.Rename(toName, name); err != nil {
+			return res, &OpError{Op: "rename", Path: toFilename, Err: err}
+		}
+		res.Output = displayPath(dst, name)
 	}
+	return res, nil
 }
 
-func IsNeoFile(filename string) (bool, error) {
-	fromFd, err := os.Open(filename)
+func IsNeoFile(st Storage, name string) (bool, error) {
+	fromFd, err := st.Open(name)
 	if err != nil {
 		return false, err
 	}
 	defer fromFd.Close()
-	magicNum := make([]byte, len(NeoMagicNumber))
-	if _, err := fromFd.Read(magicNum); err != nil {
+	magicNum := make([]byte, len(codec.NeoMagicNumber))
+	if _, err := io.ReadFull(fromFd, magicNum); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
 		return false, err
 	}
-	return bytes.Equal(magicNum, NeoMagicNumber), nil
-}
-
-func parseFile(filename string) {
-	isNeoFile, err := IsNeoFile(filename)
-	if err != nil {
-		log.Printf("判断文件：%s 类型失败，错误：%v", filename, err)
-		return
-	}
-	if isNeoFile {
-		decodeFile(filename)
-	} else {
-		encodeFile(filename)
-	}
-}
-
-func main() {
-	for _, item := range os.Args[1:] {
-		fInfo, err := os.Stat(item)
-		switch err {
-		case nil:
-		case os.ErrNotExist:
-			log.Printf("文件：%s 不存在", item)
-			continue
-		default:
-			log.Printf("获取文件：%s 信息失败，错误：%v", item, err)
-			continue
-		}
-		if !fInfo.Mode().IsRegular() {
-			log.Printf("%s 不是一个普通文件，跳过", item)
-			continue
-		}
-		parseFile(item)
+	if bytes.Equal(magicNum, codec.NeoMagicNumber) {
+		return true, nil
 	}
-
-	if runtime.GOOS == "windows" {
-		fmt.Println("Press the Enter Key to stop anytime")
-		fmt.Scanln()
+	if key == nil {
+		return false, nil
+	}
+	s, err := codec.ReadStealth(io.MultiReader(bytes.NewReader(magicNum), fromFd))
+	switch {
+	case err == codec.ErrNotNEOHeader || err == io.EOF || err == io.ErrUnexpectedEOF:
+		return false, nil
+	case err != nil:
+		return false, err
 	}
+	return s.Match(keyFunc(key)), nil
 }
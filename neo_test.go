@@ -3,88 +3,786 @@ package main
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash/crc32"
-	"io"
-	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
 )
 
-func TestVint(t *testing.T) {
-	for i := 0; i <= 1<<16; i++ {
-		v := encodeVUint(uint(i))
-		ui, p := decodeVUint(v)
-		if len(p) != 0 {
-			t.Fatal("len(p) != 0")
+func findNeoFile(t *testing.T, st Storage) string {
+	entries, err := st.List(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name, ".neo") {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+func TestEncodeDecode_MemStorage(t *testing.T) {
+	content := make([]byte, 4096)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	st := NewMemStorage()
+	st.WriteFile("data.bin", content)
+	if _, err := EncodeFile(st, "data.bin", st); err != nil {
+		t.Fatal(err)
+	}
+	neoName := findNeoFile(t, st)
+	if neoName == "" {
+		t.Fatal("no .neo file written")
+	}
+	if err := st.Delete("data.bin"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := DecodeFile(st, neoName, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Output != "mem:/data.bin" {
+		t.Fatalf("unexpected output %s", res.Output)
+	}
+	b, ok := st.ReadFile("data.bin")
+	if !ok {
+		t.Fatal("data.bin not restored")
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatal("content mismatch")
+	}
+}
+
+func TestEncodeDecode_Short(t *testing.T) {
+	for _, content := range []string{"", "neo", "12345678"} {
+		st := NewMemStorage()
+		st.WriteFile("a.txt", []byte(content))
+		if _, err := EncodeFile(st, "a.txt", st); err != nil {
+			t.Fatal(err)
+		}
+		neoName := findNeoFile(t, st)
+		st.Delete("a.txt")
+		if _, err := DecodeFile(st, neoName, st); err != nil {
+			t.Fatalf("%q: %v", content, err)
+		}
+		if b, _ := st.ReadFile("a.txt"); string(b) != content {
+			t.Fatalf("except %q, but %q", content, b)
+		}
+	}
+}
+
+func TestEncodeFile_NoSpace(t *testing.T) {
+	st := NewMemStorage()
+	st.WriteFile("data.bin", make([]byte, 4096))
+	st.Quota = 4096 + 100
+	if _, err := EncodeFile(st, "data.bin", st); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("except ErrNoSpace, but %v", err)
+	}
+	if name := findNeoFile(t, st); name != "" {
+		t.Fatalf("partial output %s left behind", name)
+	}
+}
+
+func TestEncodeFile_PermissionDenied(t *testing.T) {
+	st := NewMemStorage()
+	st.WriteFile("data.bin", make([]byte, 4096))
+	st.FailOn = func(op, name string) error {
+		if op == "create" {
+			return os.ErrPermission
 		}
-		if ui != uint(i) {
-			t.Fatalf("except %d, but %d", i, ui)
+		return nil
+	}
+	if _, err := EncodeFile(st, "data.bin", st); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("except ErrPermission, but %v", err)
+	}
+	if entries, _ := st.List("."); len(entries) != 1 {
+		t.Fatalf("except only the source file, but %d entries", len(entries))
+	}
+}
+
+func TestDecodeFile_RenameFailure(t *testing.T) {
+	content := make([]byte, 4096)
+	st := NewMemStorage()
+	st.WriteFile("data.bin", content)
+	EncodeFile(st, "data.bin", st)
+	neoName := findNeoFile(t, st)
+	st.Delete("data.bin")
+	st.FailOn = func(op, name string) error {
+		if op == "rename" {
+			return os.ErrPermission
 		}
+		return nil
+	}
+	res, err := DecodeFile(st, neoName, st)
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != "rename" {
+		t.Fatalf("except rename error, but %v", err)
+	}
+	if res.Output != "mem:/"+neoName+".decoding" {
+		t.Fatalf("unexpected output %s", res.Output)
+	}
+	if _, ok := st.ReadFile("data.bin"); ok {
+		t.Fatal("data.bin should not exist")
+	}
+	if b, ok := st.ReadFile(neoName + ".decoding"); !ok || !bytes.Equal(b, content) {
+		t.Fatal("decoded content should be kept when rename fails")
 	}
 }
 
-func TestNeoHeader_Marshall(t *testing.T) {
-	hdr := &NeoHeader{
-		Version:                   VersionV1,
-		OriginalHeaderEncMethod:   XorEnc,
-		OriginalHeader:            []byte{0x52, 0x61, 0x71, 0x21, 0x1a, 0x07, 0x01, 0x00},
-		OriginalFilenameEncMethod: XorEnc,
-		OriginalFilename:          "这是压缩文件❤️.rar",
-		Crc32:                     6655,
+func TestDecodeFile_CRCError(t *testing.T) {
+	st := NewMemStorage()
+	st.WriteFile("data.bin", make([]byte, 4096))
+	EncodeFile(st, "data.bin", st)
+	neoName := findNeoFile(t, st)
+	b, _ := st.ReadFile(neoName)
+	b[len(b)-1] ^= 0xFF
+	if _, err := DecodeFile(st, neoName, st); !errors.Is(err, codec.ErrCRCCheckFailed) {
+		t.Fatalf("except ErrCRCCheckFailed, but %v", err)
+	}
+	if _, ok := st.ReadFile(neoName + ".decoding"); ok {
+		t.Fatal("temporary file should be removed")
 	}
-	b, err := hdr.Marshall()
+}
+
+func TestEncodeDecode_SHA256(t *testing.T) {
+	encodeSHA256 = true
+	defer func() { encodeSHA256 = false }()
+	content := make([]byte, 4096)
+	rand.Read(content)
+	st := NewMemStorage()
+	st.WriteFile("data.bin", content)
+	res, err := EncodeFile(st, "data.bin", st)
 	if err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("%x", b)
-	hdr_ := new(NeoHeader)
-	if err := hdr_.UnMarshall(b); err != nil {
+	if res.SHA256 == "" {
+		t.Fatal("no sha256 in result")
+	}
+	neoName := findNeoFile(t, st)
+	st.Delete("data.bin")
+	dec, err := DecodeFile(st, neoName, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.SHA256 != res.SHA256 {
+		t.Fatalf("except %s, but %s", res.SHA256, dec.SHA256)
+	}
+
+	// corrupt the stored digest, the header checksum catches it
+	st.Delete("data.bin")
+	b, _ := st.ReadFile(neoName)
+	i := bytes.Index(b, hexDecode(t, res.SHA256))
+	b[i] ^= 0xFF
+	if _, err := DecodeFile(st, neoName, st); !errors.Is(err, codec.ErrHeaderCorrupt) {
+		t.Fatalf("except ErrHeaderCorrupt, but %v", err)
+	}
+
+	// without it the CRC32 still matches
+	encodeHeaderCRC = false
+	defer func() { encodeHeaderCRC = true }()
+	st.WriteFile("data.bin", content)
+	if _, err := EncodeFile(st, "data.bin", st); err != nil {
 		t.Fatal(err)
 	}
-	t.Logf("%+#v", hdr_)
+	st.Delete(neoName)
+	neoName = findNeoFile(t, st)
+	st.Delete("data.bin")
+	b, _ = st.ReadFile(neoName)
+	i = bytes.Index(b, hexDecode(t, res.SHA256))
+	b[i] ^= 0xFF
+	var dgErr *DigestError
+	if _, err := DecodeFile(st, neoName, st); !errors.As(err, &dgErr) || !errors.Is(err, codec.ErrDigestCheckFailed) {
+		t.Fatalf("except DigestError, but %v", err)
+	}
 }
 
-func TestNewNeoWriter(t *testing.T) {
-	testFilename := path.Join(t.TempDir(), "test.bin")
-	var crc32_ uint32
-	func() {
-		fd, err := os.OpenFile(testFilename, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0777)
+func TestEncodeDecode_XXH64(t *testing.T) {
+	encodeXXH64, encodeHeaderCRC = true, false
+	defer func() { encodeXXH64, encodeHeaderCRC = false, true }()
+	content := make([]byte, 4096)
+	rand.Read(content)
+	st := NewMemStorage()
+	st.WriteFile("data.bin", content)
+	res, err := EncodeFile(st, "data.bin", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.XXH64 == "" || res.SHA256 != "" {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	neoName := findNeoFile(t, st)
+	st.Delete("data.bin")
+	dec, err := DecodeFile(st, neoName, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec.XXH64 != res.XXH64 {
+		t.Fatalf("except %s, but %s", res.XXH64, dec.XXH64)
+	}
+
+	st.Delete("data.bin")
+	b, _ := st.ReadFile(neoName)
+	i := bytes.Index(b, hexDecode(t, res.XXH64))
+	b[i] ^= 0xFF
+	if _, err := DecodeFile(st, neoName, st); !errors.Is(err, codec.ErrDigestCheckFailed) {
+		t.Fatalf("except ErrDigestCheckFailed, but %v", err)
+	}
+}
+
+func TestEncodeDecode_Stealth(t *testing.T) {
+	key = []byte("secret")
+	encodeStealth = true
+	defer func() { key, encodeStealth = nil, false }()
+	st := NewMemStorage()
+	st.WriteFile("data.bin", make([]byte, 4096))
+	if _, err := EncodeFile(st, "data.bin", st); err != nil {
+		t.Fatal(err)
+	}
+	neoName := findNeoFile(t, st)
+	st.Delete("data.bin")
+	if ok, err := IsNeoFile(st, neoName); !ok || err != nil {
+		t.Fatalf("stealth file not detected with key: %v", err)
+	}
+	key = nil
+	if ok, _ := IsNeoFile(st, neoName); ok {
+		t.Fatal("stealth file detected without key")
+	}
+	key = []byte("secret")
+	if _, err := DecodeFile(st, neoName, st); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	dir := t.TempDir()
+	right, _ := loadKey("right", "")
+	wrong, _ := loadKey("wrong", "")
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content"), 0644)
+	key, encodeStealth = right, true
+	res, err := EncodeFile(LocalStorage(dir), "a.txt", LocalStorage(dir))
+	key, encodeStealth = nil, false
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "b.neo"), append(append([]byte(nil), codec.NeoMagicNumber...), 1, 2, 3), 0644)
+	files := []string{res.Output, filepath.Join(dir, "b.neo"), filepath.Join(dir, "a.txt")}
+	match := matchesAny(stealthHeaders(files))
+	if !match(right) {
+		t.Error("right key rejected")
+	}
+	if match(wrong) {
+		t.Error("wrong key accepted")
+	}
+	if !matchesAny(stealthHeaders(files[1:]))(wrong) {
+		t.Error("key rejected without stealth files")
+	}
+}
+
+func TestEncodeFile_ExtPool(t *testing.T) {
+	outputExts = []string{".dat", ".bak"}
+	defer func() { outputExts = []string{".neo"} }()
+	st := NewMemStorage()
+	st.WriteFile("data.bin", make([]byte, 100))
+	res, err := EncodeFile(st, "data.bin", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(res.Output, ".dat") && !strings.HasSuffix(res.Output, ".bak") {
+		t.Fatalf("unexpected output %s", res.Output)
+	}
+}
+
+func TestParseExts(t *testing.T) {
+	exts, err := parseExts(".dat, bin,.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(exts, " ") != ".dat .bin .tar.gz" {
+		t.Fatalf("unexpected %v", exts)
+	}
+	for _, s := range []string{"", ".dat,", "a/b", `a\b`} {
+		if _, err := parseExts(s); err == nil {
+			t.Fatalf("%q: except error", s)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int{"8": 8, "64k": 64 << 10, "100M": 100 << 20, "1G": 1 << 30} {
+		if n, err := parseSize(s); err != nil || n != want {
+			t.Fatalf("%q: except %d, but %d %v", s, want, n, err)
+		}
+	}
+	for _, s := range []string{"", "-1", "1T", "2G", "k"} {
+		if _, err := parseSize(s); err == nil {
+			t.Fatalf("%q: except error", s)
+		}
+	}
+}
+
+func TestEncodeDecode_HeaderLen(t *testing.T) {
+	headerLen = 3 << 20
+	defer func() { headerLen = codec.DefaultHeaderLen }()
+	for _, n := range []int{100, 4 << 20} {
+		content := make([]byte, n)
+		rand.Read(content)
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "data.bin"), content, 0644)
+		res, err := EncodeFile(LocalStorage(dir), "data.bin", LocalStorage(dir))
 		if err != nil {
 			t.Fatal(err)
 		}
-		defer fd.Close()
-		h := crc32.NewIEEE()
-		if _, err := io.CopyN(fd, io.TeeReader(rand.Reader, h), 128); err != nil {
+		os.Remove(filepath.Join(dir, "data.bin"))
+		if _, err := DecodeFile(LocalStorage(dir), filepath.Base(res.Output), LocalStorage(dir)); err != nil {
 			t.Fatal(err)
 		}
-		crc32_ = h.Sum32()
-	}()
-	buf := new(bytes.Buffer)
-	func() {
-		fd, err := os.Open(testFilename)
+		if b, _ := os.ReadFile(filepath.Join(dir, "data.bin")); !bytes.Equal(b, content) {
+			t.Fatalf("len %d: content mismatch", n)
+		}
+	}
+}
+
+func TestEncodeDecode_BufferSize(t *testing.T) {
+	if n := bufferFor(LocalStorage("."), NewMemStorage()); n != 64<<10 {
+		t.Fatalf("except 64K for a non-local storage, but %d", n)
+	}
+	bufferSize = 100
+	defer func() { bufferSize = 0 }()
+	content := make([]byte, 10000)
+	rand.Read(content)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "data.bin"), content, 0644)
+	res, err := EncodeFile(LocalStorage(dir), "data.bin", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "data.bin"))
+	if _, err := DecodeFile(LocalStorage(dir), filepath.Base(res.Output), LocalStorage(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "data.bin")); !bytes.Equal(b, content) {
+		t.Fatal("content mismatch")
+	}
+}
+
+func hexDecode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeFile_BadHeader(t *testing.T) {
+	st := NewMemStorage()
+	st.WriteFile("empty.neo", nil)
+	st.WriteFile("short.neo", codec.NeoMagicNumber)
+	for _, name := range []string{"empty.neo", "short.neo"} {
+		if _, err := DecodeFile(st, name, st); err == nil {
+			t.Fatalf("%s: except error", name)
+		}
+	}
+}
+
+func TestEncodeFile_HashScheme(t *testing.T) {
+	nameScheme = "hash"
+	defer func() { nameScheme = "random" }()
+	st := NewMemStorage()
+	st.WriteFile("data.bin", make([]byte, 100))
+	res, err := EncodeFile(st, "data.bin", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimPrefix(res.Output, "mem:/")
+	b, ok := st.ReadFile(name)
+	if !ok {
+		t.Fatalf("%s not found", name)
+	}
+	sum := sha256.Sum256(b)
+	if name != hex.EncodeToString(sum[:8])+".neo" {
+		t.Fatalf("unexpected name %s", name)
+	}
+	if entries, _ := st.List("."); len(entries) != 2 {
+		t.Fatalf("except 2 entries, but %d", len(entries))
+	}
+}
+
+func TestProcessFile_TimeoutAndPanic(t *testing.T) {
+	dir := t.TempDir()
+	file := dir + "/data.bin"
+	if err := os.WriteFile(file, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st := NewMemStorage()
+	st.FailOn = func(op, name string) error {
+		panic("boom")
+	}
+	outStorage = st
+	defer func() { outStorage, fileTimeout = nil, 0 }()
+	if _, err := processFile(file, ActionEncode); !errors.Is(err, ErrPanic) {
+		t.Fatalf("except ErrPanic, but %v", err)
+	}
+
+	// the stuck encode must be released before the globals are reset
+	block, released := make(chan struct{}), make(chan struct{})
+	st = NewMemStorage()
+	st.FailOn = func(op, name string) error {
+		<-block
+		close(released)
+		return errors.New("released")
+	}
+	outStorage = st
+	fileTimeout = 50 * time.Millisecond
+	_, err := processFile(file, ActionEncode)
+	close(block)
+	<-released
+	if !errors.Is(err, ErrFileTimeout) {
+		t.Fatalf("except ErrFileTimeout, but %v", err)
+	}
+}
+
+func TestProcessFile_TimeoutKeepsSettings(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := t.TempDir()
+	slow, fast := filepath.Join(dir, "slow", "a.txt"), filepath.Join(dir, "fast", "b.txt")
+	for _, file := range []string{slow, fast} {
+		os.MkdirAll(filepath.Dir(file), 0755)
+		os.WriteFile(file, []byte(file), 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "slow", policyFile), []byte("ext = slow\n"), 0644)
+	plugin = `if [ "$NEO_ORIGINAL_NAME" = b.txt ]; then echo name b.neo; fi`
+	block, cleaned := make(chan struct{}), make(chan struct{})
+	st := NewMemStorage()
+	st.FailOn = func(op, name string) error {
+		switch {
+		case op == "create" && strings.HasSuffix(name, ".slow"):
+			<-block
+		case op == "delete" && strings.HasSuffix(name, ".slow"):
+			close(cleaned)
+		}
+		return nil
+	}
+	outStorage, fileTimeout = st, 50*time.Millisecond
+	defer func() { outStorage, fileTimeout, plugin = nil, 0, "" }()
+
+	if _, err := processFile(slow, ActionEncode); !errors.Is(err, ErrFileTimeout) {
+		t.Fatalf("except ErrFileTimeout, but %v", err)
+	}
+	// the next file runs while the first is still stuck, with time to
+	// run the plugin on a busy machine
+	fileTimeout = 10 * time.Second
+	res, err := processFile(fast, ActionEncode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Output != displayPath(st, "b.neo") {
+		t.Fatalf("encoded to %s", res.Output)
+	}
+	close(block)
+	select {
+	case <-cleaned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("except the timed out encode to be cancelled")
+	}
+	entries, _ := st.List(".")
+	if len(entries) != 1 {
+		t.Fatalf("except the cancelled output to be removed, but %d entries", len(entries))
+	}
+}
+
+func TestRunProcess_FailFast(t *testing.T) {
+	dir := t.TempDir()
+	missing, a, b := filepath.Join(dir, "missing.txt"), filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("a"), 0644)
+	os.WriteFile(b, []byte("b"), 0644)
+	encode := findCommand("encode")
+	if err := encode.run(encode, []string{"-fail-fast", a, missing, b}); !errors.Is(err, ErrFailFast) {
+		t.Fatalf("except ErrFailFast, but %v", err)
+	}
+	if _, err := os.Stat(a); err != nil {
+		t.Fatal("except a.txt to be left alone")
+	}
+	if outputs, _ := filepath.Glob(filepath.Join(dir, "*.neo")); len(outputs) != 0 {
+		t.Fatalf("except nothing encoded after the missing file, but %v", outputs)
+	}
+	// the default goes on, but still fails the run
+	if err := encode.run(encode, []string{a, missing, b}); !errors.Is(err, ErrFilesFailed) {
+		t.Fatalf("except ErrFilesFailed, but %v", err)
+	}
+	if outputs, _ := filepath.Glob(filepath.Join(dir, "*.neo")); len(outputs) != 2 {
+		t.Fatalf("except 2 outputs, but %v", outputs)
+	}
+	os.WriteFile(a, []byte("a"), 0644)
+	if err := encode.run(encode, []string{a}); err != nil {
+		t.Fatalf("except a run without failures to succeed, but %v", err)
+	}
+}
+
+func TestDecodeFile_Strict(t *testing.T) {
+	// the header checksum would not let the extensions below through
+	encodeHeaderCRC = false
+	defer func() { encodeHeaderCRC = true }()
+	content := []byte("content of a file with an odd header")
+	st := NewMemStorage()
+	st.WriteFile("data.bin", content)
+	if _, err := EncodeFile(st, "data.bin", st); err != nil {
+		t.Fatal(err)
+	}
+	neoName := findNeoFile(t, st)
+	b, _ := st.ReadFile(neoName)
+	// append an unknown extension twice to the header
+	end := 5 + int(b[4])
+	b = append(append(append([]byte(nil), b[:end]...), 0x7F, 1, 0, 0x7F, 1, 0), b[end:]...)
+	b[4] += 6
+	st.WriteFile(neoName, b)
+
+	res, err := VerifyFile(st, neoName)
+	if err != nil || len(res.Warnings) != 1 {
+		t.Fatalf("except 1 warning, but %v %v", res.Warnings, err)
+	}
+	strictParse = true
+	defer func() { strictParse = false }()
+	if _, err := VerifyFile(st, neoName); !errors.Is(err, codec.ErrMalformed) {
+		t.Fatalf("except ErrMalformed, but %v", err)
+	}
+}
+
+func TestDecodeFile_AlternateXor(t *testing.T) {
+	// a header without FlagXorStream whose blobs use the cycling key, the
+	// legacy reading of its filename looks fine and only the CRC32 tells
+	key := []byte{0x40, 0x41, 0x42, 0x43}
+	xor := func(p []byte) []byte {
+		out := make([]byte, len(p))
+		for i := range p {
+			out[i] = p[i] ^ key[i%len(key)]
+		}
+		return out
+	}
+	content := []byte("ABCDEFGH and the rest of the content")
+	body := []byte{codec.VersionV1, codec.XorEnc, 4}
+	body = append(append(append(body, key...), 8), xor(content[:8])...)
+	body = append(append(append(body, codec.XorEnc, 4), key...), 8)
+	body = append(body, xor([]byte("data.txt"))...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(content))
+	body = append(body, crc...)
+	file := append(append(append([]byte{}, codec.NeoMagicNumber...), byte(len(body))), body...)
+	file = append(file, content[8:]...)
+
+	st := NewMemStorage()
+	st.WriteFile("old.neo", file)
+	res, err := DecodeFile(st, "old.neo", st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := st.ReadFile("data.txt")
+	if !bytes.Equal(b, content) || len(res.Warnings) != 1 {
+		t.Fatalf("unexpected result %q %v", b, res.Warnings)
+	}
+}
+
+func TestEncodeDecode_NoChecksum(t *testing.T) {
+	content := []byte("content encoded without a checksum pass")
+	st := NewMemStorage()
+	st.WriteFile("data.bin", content)
+	encodeNoChecksum = true
+	res, err := EncodeFile(st, "data.bin", st)
+	encodeNoChecksum = false
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Checksum != 0 {
+		t.Fatalf("except no checksum, but %x", res.Checksum)
+	}
+	neoName := findNeoFile(t, st)
+	if res, err := VerifyFile(st, neoName); err != nil || len(res.Warnings) != 1 {
+		t.Fatalf("except a warning, but %v %v", res.Warnings, err)
+	}
+	st.Delete("data.bin")
+	if _, err := DecodeFile(st, neoName, st); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := st.ReadFile("data.bin"); !bytes.Equal(b, content) {
+		t.Fatalf("unexpected content %q", b)
+	}
+}
+
+func TestDecodeFile_Conflict(t *testing.T) {
+	st := NewMemStorage()
+	var names []string
+	for _, content := range []string{"first", "second", "third"} {
+		st.WriteFile("a.txt", []byte(content))
+		res, err := EncodeFile(st, "a.txt", st)
 		if err != nil {
 			t.Fatal(err)
 		}
-		defer fd.Close()
-		w := NewNeoWriter(buf, 32, path.Base(testFilename), crc32_)
-		if _, err := io.Copy(w, fd); err != nil {
-			t.Fatal(err)
+		names = append(names, path.Base(res.Output))
+	}
+	st.Delete("a.txt")
+	defer func() { decodeConflict = ConflictRename }()
+
+	decodeConflict = ConflictRename
+	for i, want := range []string{"a.txt", "a (1).txt"} {
+		res, err := DecodeFile(st, names[i], st)
+		if err != nil || path.Base(res.Output) != want {
+			t.Fatalf("except %s, but %s %v", want, res.Output, err)
 		}
-		t.Logf("%x", buf.Bytes())
-	}()
+	}
+	decodeConflict = ConflictSkip
+	if _, err := DecodeFile(st, names[2], st); !errors.Is(err, ErrOutputExists) {
+		t.Fatalf("except ErrOutputExists, but %v", err)
+	}
+	if b, _ := st.ReadFile("a.txt"); string(b) != "first" {
+		t.Fatalf("a.txt overwritten with %q", b)
+	}
+}
 
-	func() {
-		rd := NewNeoReader(buf)
-		b, err := ioutil.ReadAll(rd)
+func TestNameHint(t *testing.T) {
+	for name, want := range map[string]string{
+		"report.pdf":       "re….pdf",
+		"这是压缩文件.rar":       "这是….rar",
+		"a":                "a…",
+		"archive.verylong": "ar…",
+	} {
+		if got := nameHint(name); got != want {
+			t.Fatalf("%s: except %s, but %s", name, want, got)
+		}
+	}
+}
+
+func TestDecodeFile_UnusableName(t *testing.T) {
+	st := NewMemStorage()
+	for name, content := range map[string]string{"../evil": "%PDF-1.7 ...", "": "just some text\n"} {
+		var buf bytes.Buffer
+		w := codec.NewNeoWriterWithHeader(&buf, headerLen, &codec.NeoHeader{
+			Version:                   codec.VersionV1,
+			OriginalHeaderEncMethod:   codec.XorEnc,
+			OriginalFilenameEncMethod: codec.XorEnc,
+			OriginalFilename:          name,
+			Crc32:                     crc32.ChecksumIEEE([]byte(content)),
+		})
+		w.Write([]byte(content))
+		w.Close()
+		neoName := fmt.Sprintf("file%d.neo", len(name))
+		st.WriteFile(neoName, buf.Bytes())
+		res, err := DecodeFile(st, neoName, st)
 		if err != nil {
+			t.Fatalf("%q: %v", name, err)
+		}
+		want := strings.TrimSuffix(neoName, ".neo") + ".pdf"
+		if name == "" {
+			want = strings.TrimSuffix(neoName, ".neo") + ".txt"
+		}
+		if path.Base(res.Output) != want || len(res.Warnings) == 0 {
+			t.Fatalf("%q decoded to %s, warnings %v", name, res.Output, res.Warnings)
+		}
+	}
+	if !usableName("report.pdf") || usableName("a/b") || usableName("..") || usableName("a\x00b") {
+		t.Error("usableName")
+	}
+}
+
+func TestDecodeFile_Sandbox(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs symlinks")
+	}
+	dir, box, outside := t.TempDir(), t.TempDir(), t.TempDir()
+	var buf bytes.Buffer
+	w := codec.NewNeoWriterWithHeader(&buf, headerLen, &codec.NeoHeader{
+		Version:                   codec.VersionV1,
+		OriginalHeaderEncMethod:   codec.XorEnc,
+		OriginalFilenameEncMethod: codec.XorEnc,
+		OriginalFilename:          "../../etc/pass:wd",
+		Crc32:                     crc32.ChecksumIEEE([]byte("secret")),
+	})
+	w.Write([]byte("secret"))
+	w.Close()
+	os.WriteFile(filepath.Join(dir, "x.neo"), buf.Bytes(), 0644)
+	// a link planted where the temporary file goes must not be written through
+	target := filepath.Join(outside, "target")
+	os.WriteFile(target, []byte("keep"), 0644)
+	os.Symlink(target, filepath.Join(box, "x.neo.decoding"))
+
+	sandboxed = true
+	defer func() { sandboxed = false }()
+	res, err := DecodeFile(LocalStorage(dir), "x.neo", LocalStorage(box))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Output != filepath.Join(box, "pass_wd") {
+		t.Fatalf("decoded to %s", res.Output)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "keep" {
+		t.Fatalf("link target overwritten with %q", b)
+	}
+	for name, want := range map[string]string{`a\b\c.txt`: "c.txt", "..": "", "CON": "_CON", "x. ": "x", "a\x01b": "a_b"} {
+		if got := sanitizeName(name); got != want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSortFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	var files []string
+	for i, size := range []int{30, 10, 20} {
+		file := filepath.Join(dir, fmt.Sprintf("%c.txt", 'a'+i))
+		os.WriteFile(file, make([]byte, size), 0644)
+		os.Chtimes(file, now, now.Add(time.Duration(size)*time.Second))
+		files = append(files, file)
+	}
+	files = append(files, "https://example.com/x.neo")
+	for order, want := range map[string]string{"size": "bcax", "mtime": "bcax", "name": "abcx"} {
+		got := append([]string(nil), files...)
+		if err := sortFiles(got, order); err != nil {
 			t.Fatal(err)
 		}
-		crc32_ := crc32.ChecksumIEEE(b)
-		if crc32_ != rd.NeoHeader.Crc32 {
-			t.Fatalf("crc check failed, except: %d but: %d", rd.NeoHeader.Crc32, crc32_)
+		var names string
+		for _, f := range got {
+			names += path.Base(filepath.ToSlash(f))[:1]
 		}
-		t.Logf("%#v", rd.NeoHeader)
-	}()
+		if names != want {
+			t.Errorf("%s: except %s, but %s", order, want, names)
+		}
+	}
+	if err := sortFiles(nil, "random"); err == nil {
+		t.Error("unknown order accepted")
+	}
+}
 
+func TestWaitFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	os.WriteFile(file, []byte("a"), 0644)
+	if _, err := freeSpace(dir); err != nil {
+		t.Skip(err)
+	}
+	defer func() { minFree = 0 }()
+	minFree = 1
+	done := make(chan struct{})
+	go func() {
+		waitFreeSpace(file)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("waited with free space left")
+	}
 }
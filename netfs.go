@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// netRenameTries is how many times a rename on a network filesystem is
+// tried before giving up.
+const netRenameTries = 5
+
+var netDirs = struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+// onNetworkFS reports whether dir is on an SMB or NFS mount, where writes
+// may only fail on close and a rename may fail after it took place.
+func onNetworkFS(dir string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	netDirs.mu.Lock()
+	defer netDirs.mu.Unlock()
+	net, ok := netDirs.dirs[dir]
+	if !ok {
+		net = isNetworkMount(dir)
+		netDirs.dirs[dir] = net
+	}
+	return net
+}
+
+// syncedFile flushes on Close, so a write the server turns down is
+// reported instead of lost.
+type syncedFile struct {
+	*os.File
+}
+
+func (f syncedFile) Close() error {
+	err := f.File.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// renameVerified renames oldpath to newpath and checks newpath got all of
+// it, trying again while it did not. A rename that failed but did take
+// place, as happens when the reply of the server is lost, is a success.
+func renameVerified(oldpath, newpath string) error {
+	old, err := os.Stat(oldpath)
+	if err != nil {
+		return err
+	}
+	for i := 1; ; i++ {
+		err = os.Rename(oldpath, newpath)
+		if fInfo, serr := os.Stat(newpath); serr == nil && fInfo.Size() == old.Size() {
+			if _, serr := os.Stat(oldpath); os.IsNotExist(serr) {
+				return nil
+			}
+		}
+		if err == nil {
+			err = fmt.Errorf("rename %s %s: not done", oldpath, newpath)
+		}
+		if i == netRenameTries {
+			return err
+		}
+		time.Sleep(time.Duration(i) * 200 * time.Millisecond)
+	}
+}
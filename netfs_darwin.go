@@ -0,0 +1,22 @@
+package main
+
+import "syscall"
+
+func isNetworkMount(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	var name []byte
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	switch string(name) {
+	case "nfs", "smbfs", "afpfs", "webdav":
+		return true
+	}
+	return false
+}
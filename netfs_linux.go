@@ -0,0 +1,23 @@
+package main
+
+import "syscall"
+
+// Magic numbers of the network filesystems from statfs(2).
+const (
+	nfsSuperMagic  = 0x6969
+	smbSuperMagic  = 0x517b
+	cifsSuperMagic = 0xff534d42
+	smb2SuperMagic = 0xfe534d42
+)
+
+func isNetworkMount(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	switch uint32(st.Type) {
+	case nfsSuperMagic, smbSuperMagic, cifsSuperMagic, smb2SuperMagic:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+func isNetworkMount(dir string) bool {
+	return false
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const driveRemote = 4
+
+var procGetDriveType = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDriveTypeW")
+
+func isNetworkMount(dir string) bool {
+	vol := filepath.VolumeName(dir)
+	if strings.HasPrefix(vol, `\\`) {
+		return true
+	}
+	p, err := syscall.UTF16PtrFromString(vol + `\`)
+	if err != nil {
+		return false
+	}
+	t, _, _ := procGetDriveType.Call(uintptr(unsafe.Pointer(p)))
+	return t == driveRemote
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTelegramSink(t *testing.T) {
+	var path, chat, text string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, chat, text = r.URL.Path, r.FormValue("chat_id"), r.FormValue("text")
+	}))
+	defer srv.Close()
+	telegramAPI = srv.URL
+	defer func() { telegramAPI = "https://api.telegram.org" }()
+
+	sink, err := parseSink("telegram://123:ABC/-10042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.send("title", "msg"); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot123:ABC/sendMessage" || chat != "-10042" || text != "title\nmsg" {
+		t.Fatalf("unexpected request %s %s %q", path, chat, text)
+	}
+}
+
+func TestParseSink(t *testing.T) {
+	for _, s := range []string{
+		"smtp://u:p@mail.example.com/?from=a@example.com&to=b@example.com",
+		"smtps://mail.example.com/?from=a@example.com&to=b@example.com,c@example.com",
+		"https://example.com/hook",
+	} {
+		if _, err := parseSink(s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+	for _, s := range []string{"telegram://token", "smtp://mail.example.com/", "ftp://example.com"} {
+		if _, err := parseSink(s); err == nil {
+			t.Fatalf("%s: except error", s)
+		}
+	}
+}
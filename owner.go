@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// preserveOwner records the owner of encoded files in the header and gives
+// it to decoded files, and to encoded outputs too. Changing the owner needs
+// root on most systems.
+var preserveOwner bool
+
+func recordOwner(src Storage, name string) *codec.Owner {
+	local, ok := src.(LocalStorage)
+	if !preserveOwner || !ok {
+		return nil
+	}
+	return fileOwner(local.path(name))
+}
+
+func restoreOwner(dst Storage, name string, owner *codec.Owner) {
+	local, ok := dst.(LocalStorage)
+	if !preserveOwner || !ok || owner == nil {
+		return
+	}
+	if err := os.Chown(local.path(name), int(owner.UID), int(owner.GID)); err != nil {
+		log.Printf("设置文件：%s 属主失败，错误：%v", local.path(name), err)
+	}
+}
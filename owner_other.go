@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+func fileOwner(path string) *codec.Owner {
+	fInfo, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	st, ok := fInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &codec.Owner{UID: uint32(st.Uid), GID: uint32(st.Gid)}
+}
@@ -0,0 +1,8 @@
+package main
+
+import "github.com/hr3lxphr6j/neo/codec"
+
+// fileOwner has nothing to give, Windows owners are SIDs.
+func fileOwner(path string) *codec.Owner {
+	return nil
+}
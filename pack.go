@@ -0,0 +1,167 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// packIndexName is the last entry of a pack, a JSON list of packEntry.
+	packIndexName = ".neo-pack.json"
+	// packSegment is the size a pack is closed at.
+	packSegment = 64 << 20
+)
+
+// packSize is set by -pack: local files up to this size are put together
+// in tar archives, encoded as one NEO file each, 0 encodes every file alone.
+var packSize int64
+
+// packEntry is a file of a pack, Offset is where its content starts in the
+// tar archive.
+type packEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+}
+
+// splitPack takes the files -pack puts together out of files, those a
+// .neorc excludes are left to parseFile.
+func splitPack(files []string) (small, rest []string) {
+	for _, file := range files {
+		if !isRemote(file) {
+			pol, err := policyFor(filepath.Dir(file))
+			if err != nil || !pol.allows(file) {
+				rest = append(rest, file)
+				continue
+			}
+			if fInfo, err := os.Stat(file); err == nil && fInfo.Size() <= packSize {
+				small = append(small, file)
+				continue
+			}
+		}
+		rest = append(rest, file)
+	}
+	return small, rest
+}
+
+// packFiles encodes files into packs of up to packSegment bytes, written to
+// dst or next to the first file of each.
+func packFiles(files []string, dst Storage, sum *summary) {
+	tmp, err := os.MkdirTemp("", "neo-pack")
+	if err != nil {
+		sum.add(Result{Action: ActionEncode}, &OpError{Op: "open", Path: os.TempDir(), Err: err})
+		return
+	}
+	defer os.RemoveAll(tmp)
+	stamp := time.Now().Format("20060102-150405")
+	for n := 1; len(files) > 0; n++ {
+		name := fmt.Sprintf("pack-%s-%d.tar", stamp, n)
+		var packed []string
+		res, err := writePack(files, tmp, name, dst, &packed)
+		files = files[len(packed):]
+		sum.add(res, err)
+		if err != nil {
+			// the files of a failed pack are lost for this run, the next
+			// packs still go on
+			sum.failed += len(packed) - 1
+			continue
+		}
+		log.Printf("打包：%d 个文件 → %s", len(packed), res.Output)
+		if removeSource {
+			for _, file := range packed {
+				if err := os.Remove(file); err != nil {
+					log.Printf("删除原文件：%s 失败，错误：%v", file, err)
+				}
+			}
+		}
+	}
+}
+
+// countWriter counts what goes through it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writePack archives the leading files that fit into a segment to tmp, adds
+// them to packed and encodes the archive as name.
+func writePack(files []string, tmp, name string, dst Storage, packed *[]string) (Result, error) {
+	res := Result{Action: ActionEncode, Input: name}
+	archive := filepath.Join(tmp, name)
+	f, err := os.Create(archive)
+	if err != nil {
+		return res, &OpError{Op: "open", Path: archive, Err: err}
+	}
+	defer os.Remove(archive)
+	cw := &countWriter{w: f}
+	tw := tar.NewWriter(cw)
+	var index []packEntry
+	for _, file := range files {
+		if cw.n > 0 && cw.n >= packSegment {
+			break
+		}
+		*packed = append(*packed, file)
+		e, err := addToPack(tw, cw, file)
+		if err != nil {
+			f.Close()
+			return res, err
+		}
+		index = append(index, e)
+	}
+	b, _ := json.Marshal(index)
+	err = tw.WriteHeader(&tar.Header{Name: packIndexName, Mode: 0644, Size: int64(len(b)), ModTime: time.Now()})
+	if err == nil {
+		_, err = tw.Write(b)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, &OpError{Op: "write", Path: archive, Err: err}
+	}
+	if dst == nil {
+		dst = LocalStorage(filepath.Dir((*packed)[0]))
+	}
+	res, err = EncodeFile(LocalStorage(tmp), name, dst)
+	res.Input = name
+	return res, err
+}
+
+func addToPack(tw *tar.Writer, cw *countWriter, file string) (packEntry, error) {
+	e := packEntry{Name: path.Clean("/" + filepath.ToSlash(file))[1:]}
+	in, err := os.Open(file)
+	if err != nil {
+		return e, &OpError{Op: "open", Path: file, Err: err}
+	}
+	defer in.Close()
+	fInfo, err := in.Stat()
+	if err != nil {
+		return e, &OpError{Op: "open", Path: file, Err: err}
+	}
+	e.Size = fInfo.Size()
+	err = tw.WriteHeader(&tar.Header{Name: e.Name, Mode: int64(fInfo.Mode().Perm()), Size: e.Size, ModTime: fInfo.ModTime()})
+	if err != nil {
+		return e, &OpError{Op: "write", Path: e.Name, Err: err}
+	}
+	e.Offset = cw.n
+	if _, err := io.CopyN(tw, in, e.Size); err != nil {
+		return e, &OpError{Op: "read", Path: file, Err: err}
+	}
+	return e, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackFiles(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	os.Mkdir(out, 0755)
+	packSize = 100
+	defer func() { packSize = 0 }()
+	var files []string
+	for name, size := range map[string]int{"a.txt": 10, "b.txt": 100, "big.bin": 101} {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, bytes.Repeat([]byte(name[:1]), size), 0644)
+		files = append(files, file)
+	}
+	small, rest := splitPack(files)
+	if len(small) != 2 || len(rest) != 1 || filepath.Base(rest[0]) != "big.bin" {
+		t.Fatalf("small %v, rest %v", small, rest)
+	}
+	sum := new(summary)
+	packFiles(small, LocalStorage(out), sum)
+	if sum.encoded != 1 || sum.failed != 0 {
+		t.Fatalf("encoded %d, failed %d", sum.encoded, sum.failed)
+	}
+	items, _ := os.ReadDir(out)
+	if len(items) != 1 {
+		t.Fatalf("except 1 pack, but %d files", len(items))
+	}
+	res, err := DecodeFile(LocalStorage(out), items[0].Name(), LocalStorage(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, _ := os.ReadFile(res.Output)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	contents := make(map[string][]byte)
+	var index []packEntry
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		if hdr.Name == packIndexName {
+			json.Unmarshal(b, &index)
+			continue
+		}
+		contents[hdr.Name] = b
+	}
+	if len(index) != 2 || len(contents) != 2 {
+		t.Fatalf("index %+v, %d entries", index, len(contents))
+	}
+	for _, e := range index {
+		b := contents[e.Name]
+		if int64(len(b)) != e.Size || !bytes.Equal(archive[e.Offset:e.Offset+e.Size], b) {
+			t.Errorf("entry %+v does not match the archive", e)
+		}
+	}
+}
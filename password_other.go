@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var errNoTerminal = errors.New("standard input is not a terminal")
+
+// readPassword prints prompt to stderr and reads a line from the terminal
+// with echo turned off by stty.
+func readPassword(prompt string) (string, error) {
+	if fInfo, err := os.Stdin.Stat(); err != nil || fInfo.Mode()&os.ModeCharDevice == 0 {
+		return "", errNoTerminal
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if err := stty("-echo"); err != nil {
+		return "", err
+	}
+	line, err := readLine(os.Stdin)
+	stty("echo")
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return line, nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// fdReader reads a descriptor the caller owns, unlike an *os.File it never
+// closes it.
+type fdReader int
+
+func (r fdReader) Read(p []byte) (int, error) {
+	n, err := syscall.Read(int(r), p)
+	if n <= 0 && err == nil {
+		return 0, io.EOF
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
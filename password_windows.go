@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+const enableEchoInput = 0x4
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// readPassword prints prompt to stderr and reads a line from the console
+// with echo turned off.
+func readPassword(prompt string) (string, error) {
+	h := syscall.Handle(os.Stdin.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return "", fmt.Errorf("standard input is not a console: %w", err)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode&^enableEchoInput)); r == 0 {
+		return "", err
+	}
+	line, err := readLine(os.Stdin)
+	procSetConsoleMode.Call(uintptr(h), uintptr(mode))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return line, nil
+}
+
+// fdReader reads a handle the caller owns, unlike an *os.File it never
+// closes it.
+type fdReader int
+
+func (r fdReader) Read(p []byte) (int, error) {
+	n, err := syscall.Read(syscall.Handle(r), p)
+	if n <= 0 && err == nil {
+		return 0, io.EOF
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
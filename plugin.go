@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// plugin is a command asked about every file before it is processed, it
+// can veto the file and name the output.
+var plugin string
+
+// pluginVerdict is what the plugin answered for a file.
+type pluginVerdict struct {
+	skip   bool
+	reason string
+	name   string
+}
+
+// askPlugin runs the plugin for the file described by res, with the action,
+// input, original name and size in NEO_* variables. Each line it prints is
+// an instruction: "skip [reason]" keeps the file from being processed,
+// "name NAME" names the output. Printing nothing keeps the defaults, exiting
+// with an error fails the file.
+func askPlugin(res Result, size int64) (*pluginVerdict, error) {
+	cmd := shellCommand(plugin)
+	cmd.Env = append(os.Environ(),
+		"NEO_ACTION="+string(res.Action),
+		"NEO_INPUT="+res.Input,
+		"NEO_ORIGINAL_NAME="+res.OriginalName,
+		fmt.Sprintf("NEO_SIZE=%d", size),
+	)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	v := new(pluginVerdict)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		word, arg := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			word, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		switch word {
+		case "":
+		case "skip":
+			v.skip, v.reason = true, arg
+		case "name":
+			if arg == "" || arg == "." || arg == ".." || strings.ContainsAny(arg, `/\`) || filepath.Base(arg) != arg {
+				return nil, fmt.Errorf("bad output name from plugin: %q", arg)
+			}
+			v.name = arg
+		default:
+			return nil, fmt.Errorf("unknown plugin instruction: %q", line)
+		}
+	}
+	return v, nil
+}
+
+// pluginQuery fills in the original name of a local file to decode and
+// returns the size of the input, -1 when it is not known.
+func pluginQuery(res *Result) int64 {
+	if isRemote(res.Input) {
+		return -1
+	}
+	fInfo, err := os.Stat(res.Input)
+	if err != nil {
+		return -1
+	}
+	if res.Action == ActionDecode {
+		if hdr, err := headers.header(res.Input); err == nil && hdr != nil {
+			res.OriginalName = hdr.OriginalFilename
+		}
+	}
+	return fInfo.Size()
+}
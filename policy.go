@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// policyFile is the name of the per-directory policy files. A .neorc holds
+// "name = value" lines with the names of encode flags: header-len, scheme,
+// ext, hash and encrypt-meta, and applies them to the files under its
+// directory. include and exclude take comma separated patterns, matched
+// against the file name, or the path relative to the .neorc when they hold
+// a slash, and decide which files are encoded. Deeper files override what
+// those above them set, "root = true" stops the lookup.
+const policyFile = ".neorc"
+
+// ErrExcluded is returned for files a .neorc or the plugin keeps from being
+// processed.
+var ErrExcluded = errors.New("excluded")
+
+// encodeSettings are the encode settings a policy can change.
+type encodeSettings struct {
+	headerLen   int
+	nameScheme  string
+	outputExts  []string
+	sha256      bool
+	xxh64       bool
+	encryptMeta bool
+}
+
+func currentSettings() encodeSettings {
+	return encodeSettings{headerLen, nameScheme, outputExts, encodeSHA256, encodeXXH64, encodeEncryptMeta}
+}
+
+func (s encodeSettings) use() {
+	headerLen, nameScheme, outputExts = s.headerLen, s.nameScheme, s.outputExts
+	encodeSHA256, encodeXXH64, encodeEncryptMeta = s.sha256, s.xxh64, s.encryptMeta
+}
+
+// setters sets the settings by flag name.
+func (s *encodeSettings) setters() *flag.FlagSet {
+	fs := flag.NewFlagSet(policyFile, flag.ContinueOnError)
+	fs.Func("header-len", "", func(v string) (err error) {
+		s.headerLen, err = parseSize(v)
+		return err
+	})
+	fs.Func("scheme", "", func(v string) error {
+		s.nameScheme = v
+		return checkScheme(v)
+	})
+	fs.Func("ext", "", func(v string) (err error) {
+		s.outputExts, err = parseExts(v)
+		return err
+	})
+	fs.Func("hash", "", func(v string) (err error) {
+		s.sha256, s.xxh64, err = parseHashes(v)
+		return err
+	})
+	fs.Func("encrypt-meta", "", func(v string) (err error) {
+		s.encryptMeta, err = strconv.ParseBool(v)
+		return err
+	})
+	return fs
+}
+
+// parseHashes parses the -hash list, crc32 is always written.
+func parseHashes(s string) (sha256, xxh64 bool, err error) {
+	for _, alg := range strings.Split(s, ",") {
+		switch strings.TrimSpace(alg) {
+		case "crc32":
+		case "sha256":
+			sha256 = true
+		case "xxh64":
+			xxh64 = true
+		default:
+			return false, false, fmt.Errorf("unknown hash: %s", alg)
+		}
+	}
+	return sha256, xxh64, nil
+}
+
+// policyEntry is a parsed .neorc.
+type policyEntry struct {
+	modTime  time.Time
+	settings [][2]string
+	include  []string
+	exclude  []string
+	root     bool
+}
+
+// policy is what the .neorc files above a directory add up to.
+type policy struct {
+	settings [][2]string
+	// include and exclude are the patterns of the deepest .neorc setting
+	// them, made relative to dir
+	include, exclude []string
+	incDir, excDir   string
+}
+
+var policies = struct {
+	mu    sync.Mutex
+	files map[string]*policyEntry
+}{files: make(map[string]*policyEntry)}
+
+// readPolicy returns the .neorc of dir, nil if there is none. Files are
+// parsed again when they change.
+func readPolicy(dir string) (*policyEntry, error) {
+	file := filepath.Join(dir, policyFile)
+	fInfo, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	policies.mu.Lock()
+	e := policies.files[file]
+	policies.mu.Unlock()
+	if e != nil && e.modTime.Equal(fInfo.ModTime()) {
+		return e, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if e, err = parsePolicy(b); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	e.modTime = fInfo.ModTime()
+	policies.mu.Lock()
+	policies.files[file] = e
+	policies.mu.Unlock()
+	return e, nil
+}
+
+func parsePolicy(b []byte) (*policyEntry, error) {
+	e := new(policyEntry)
+	var check encodeSettings
+	setters := check.setters()
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing =", n)
+		}
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		var err error
+		switch name {
+		case "include":
+			e.include, err = parsePatterns(value)
+		case "exclude":
+			e.exclude, err = parsePatterns(value)
+		case "root":
+			e.root, err = strconv.ParseBool(value)
+		default:
+			if setters.Lookup(name) == nil {
+				return nil, fmt.Errorf("line %d: unknown setting %q", n, name)
+			}
+			err = setters.Set(name, value)
+			e.settings = append(e.settings, [2]string{name, value})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return e, sc.Err()
+}
+
+func parsePatterns(s string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(s, ",") {
+		p = filepath.ToSlash(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// policyFor merges the .neorc files of dir and the directories above it.
+func policyFor(dir string) (*policy, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*policyEntry
+	var dirs []string
+	for {
+		e, err := readPolicy(dir)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			chain = append(chain, e)
+			dirs = append(dirs, dir)
+			if e.root {
+				break
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	p := new(policy)
+	for i := len(chain) - 1; i >= 0; i-- {
+		e := chain[i]
+		p.settings = append(p.settings, e.settings...)
+		if e.include != nil {
+			p.include, p.incDir = e.include, dirs[i]
+		}
+		if e.exclude != nil {
+			p.exclude, p.excDir = e.exclude, dirs[i]
+		}
+	}
+	return p, nil
+}
+
+// over returns s with the settings of the policy applied, the globals are
+// left alone so files in flight keep their own.
+func (p *policy) over(s encodeSettings) encodeSettings {
+	setters := s.setters()
+	for _, kv := range p.settings {
+		setters.Set(kv[0], kv[1])
+	}
+	return s
+}
+
+// allows reports whether file may be encoded.
+func (p *policy) allows(file string) bool {
+	if len(p.exclude) > 0 && matchPattern(p.exclude, p.excDir, file) {
+		return false
+	}
+	return len(p.include) == 0 || matchPattern(p.include, p.incDir, file)
+}
+
+func matchPattern(patterns []string, dir, file string) bool {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		name := filepath.Base(abs)
+		if strings.Contains(p, "/") {
+			name = rel
+		}
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
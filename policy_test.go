@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolicy(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	os.MkdirAll(filepath.Join(sub, "raw"), 0755)
+	os.WriteFile(filepath.Join(dir, policyFile), []byte("root = true\n# top\nheader-len = 1K\nexclude = *.txt\n"), 0644)
+	os.WriteFile(filepath.Join(sub, policyFile), []byte("scheme = hash\nexclude = raw/*\n"), 0644)
+
+	pol, err := policyFor(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := pol.over(currentSettings())
+	if s.headerLen != 1024 || s.nameScheme != "hash" {
+		t.Errorf("headerLen = %d, nameScheme = %s", s.headerLen, s.nameScheme)
+	}
+	if headerLen == 1024 || nameScheme != "random" {
+		t.Errorf("globals changed: headerLen = %d, nameScheme = %s", headerLen, nameScheme)
+	}
+	for file, want := range map[string]bool{
+		filepath.Join(sub, "a.txt"):        true,
+		filepath.Join(sub, "raw", "b.jpg"): false,
+		filepath.Join(sub, "c.jpg"):        true,
+	} {
+		if got := pol.allows(file); got != want {
+			t.Errorf("allows(%s) = %v, want %v", file, got, want)
+		}
+	}
+	top, _ := policyFor(dir)
+	if top.allows(filepath.Join(dir, "a.txt")) {
+		t.Error("a.txt allowed at the top")
+	}
+
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, []byte("hello"), 0644)
+	if _, err := parseFile(file, ""); !errors.Is(err, ErrExcluded) {
+		t.Fatalf("except ErrExcluded, but %v", err)
+	}
+	if files := collectFiles([]string{dir}, true, new(summary)); len(files) != 1 || files[0] != file {
+		t.Errorf("collected %v", files)
+	}
+
+	os.WriteFile(filepath.Join(sub, policyFile), []byte("cipher = aes\n"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(sub, policyFile), later, later)
+	if _, err := policyFor(sub); err == nil {
+		t.Error("unknown setting accepted")
+	}
+}
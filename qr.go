@@ -0,0 +1,447 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrQRTooLong is returned by encodeQR for data that does not fit the
+// largest version it makes.
+var ErrQRTooLong = errors.New("too long for a QR code")
+
+// qrBlocks is the error correction of a version at level M: the ecc
+// codewords per block and the data codewords of each block.
+type qrBlocks struct {
+	ecc  int
+	data []int
+}
+
+// qrVersions are the versions 1 to 10 at level M, enough for keys and share
+// strings while the code still fits a terminal.
+var qrVersions = []qrBlocks{
+	{10, []int{16}},
+	{16, []int{28}},
+	{26, []int{44}},
+	{18, []int{32, 32}},
+	{24, []int{43, 43}},
+	{16, []int{27, 27, 27, 27}},
+	{18, []int{31, 31, 31, 31}},
+	{22, []int{38, 38, 39, 39}},
+	{22, []int{36, 36, 36, 37, 37}},
+	{26, []int{43, 43, 43, 43, 44}},
+}
+
+var qrAlignment = [][]int{
+	nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+}
+
+// qrCode is a QR code in byte mode, modules[y][x] is true for dark modules.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// encodeQR makes the smallest QR code at error correction level M that
+// holds data.
+func encodeQR(data []byte) (*qrCode, error) {
+	for i, v := range qrVersions {
+		version := i + 1
+		capacity := 0
+		for _, n := range v.data {
+			capacity += n
+		}
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) > 8*capacity {
+			continue
+		}
+		var bits qrBits
+		bits.append(0b0100, 4)
+		bits.append(len(data), countBits)
+		for _, b := range data {
+			bits.append(int(b), 8)
+		}
+		for i := 0; i < 4 && len(bits) < 8*capacity; i++ {
+			bits = append(bits, false)
+		}
+		for len(bits)%8 != 0 {
+			bits = append(bits, false)
+		}
+		for pad := 0xEC; len(bits) < 8*capacity; pad ^= 0xEC ^ 0x11 {
+			bits.append(pad, 8)
+		}
+		q := newQRCode(version)
+		q.drawCodewords(v.interleave(bits.bytes()))
+		q.applyBestMask()
+		return q, nil
+	}
+	return nil, ErrQRTooLong
+}
+
+type qrBits []bool
+
+func (b *qrBits) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, v>>i&1 == 1)
+	}
+}
+
+func (b qrBits) bytes() []byte {
+	res := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			res[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return res
+}
+
+// interleave splits data into the blocks of the version, adds their ecc
+// codewords and interleaves them.
+func (v qrBlocks) interleave(data []byte) []byte {
+	divisor := rsDivisor(v.ecc)
+	var blocks, eccs [][]byte
+	for _, n := range v.data {
+		blocks = append(blocks, data[:n])
+		eccs = append(eccs, rsRemainder(data[:n], divisor))
+		data = data[n:]
+	}
+	var res []byte
+	for i := 0; i < v.data[len(v.data)-1]; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				res = append(res, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecc; i++ {
+		for _, ecc := range eccs {
+			res = append(res, ecc[i])
+		}
+	}
+	return res
+}
+
+func gfMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ z>>7*0x1D
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// rsDivisor is the Reed-Solomon generator polynomial of degree n, without
+// its leading term.
+func rsDivisor(n int) []byte {
+	res := make([]byte, n)
+	res[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range res {
+			res[j] = gfMul(res[j], root)
+			if j+1 < n {
+				res[j] ^= res[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return res
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	res := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ res[0]
+		copy(res, res[1:])
+		res[len(res)-1] = 0
+		for i, d := range divisor {
+			res[i] ^= gfMul(d, factor)
+		}
+	}
+	return res
+}
+
+// newQRCode draws the function patterns of a version, the format bits are
+// drawn again once the mask is known.
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range q.modules {
+		q.modules[y] = make([]bool, size)
+		q.function[y] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+				d := maxAbs(dx, dy)
+				q.set(x, y, d != 2 && d != 4)
+			}
+		}
+	}
+	pos := qrAlignment[version-1]
+	for i, x := range pos {
+		for j, y := range pos {
+			last := len(pos) - 1
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(x+dx, y+dy, maxAbs(dx, dy) != 1)
+				}
+			}
+		}
+	}
+	q.drawFormat(0)
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ rem>>11*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			q.set(a, b, bits>>i&1 == 1)
+			q.set(b, a, bits>>i&1 == 1)
+		}
+	}
+	return q
+}
+
+func maxAbs(a, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// set draws a function module.
+func (q *qrCode) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// drawFormat draws both copies of the format bits of level M and the mask.
+func (q *qrCode) drawFormat(mask int) {
+	rem := mask
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ rem>>9*0x537
+	}
+	bits := (mask<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// drawCodewords fills the modules that are not function modules in the
+// zigzag order of the standard.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(data)*8 {
+					q.modules[y][x] = data[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func qrMask(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyMask XORs a mask onto the data modules, applying it twice undoes it.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if !q.function[y][x] && qrMask(mask, x, y) {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyBestMask() {
+	best, min := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); min < 0 || p < min {
+			best, min = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+}
+
+// penalty scores how hard the code is to scan: long runs, 2x2 blocks,
+// patterns looking like finders and an unbalanced number of dark modules.
+func (q *qrCode) penalty() int {
+	res, dark := 0, 0
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			run := 0
+			for x := 0; x < q.size; x++ {
+				if x > 0 && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+				} else {
+					run = 1
+				}
+				if run == 5 {
+					res += 3
+				} else if run > 5 {
+					res++
+				}
+				if x+7 > q.size {
+					continue
+				}
+				match := true
+				for i, want := range finder {
+					if at(x+i, y, vertical) != want {
+						match = false
+						break
+					}
+				}
+				if match && (q.light(x-4, x, y, vertical, at) || q.light(x+7, x+11, y, vertical, at)) {
+					res += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			c := q.modules[y][x]
+			if c {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size && c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+				res += 3
+			}
+		}
+	}
+	total := q.size * q.size
+	k := (abs(dark*20-total*10) + total - 1) / total
+	return res + (k-1)*10
+}
+
+// light reports whether the modules from..to of a line are light, those
+// outside the code count as light.
+func (q *qrCode) light(from, to, y int, vertical bool, at func(x, y int, vertical bool) bool) bool {
+	for x := from; x < to; x++ {
+		if x >= 0 && x < q.size && at(x, y, vertical) {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// writeTerminal prints the code with half blocks, two rows of modules per
+// line and a quiet zone around it. Dark modules are left blank, which suits
+// terminals with a dark background, invert suits light ones.
+func (q *qrCode) writeTerminal(w io.Writer, invert bool) error {
+	const quiet = 4
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= q.size || y >= q.size {
+			return !invert
+		}
+		return q.modules[y][x] == invert
+	}
+	var sb strings.Builder
+	for y := -quiet; y < q.size+quiet; y += 2 {
+		for x := -quiet; x < q.size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			if y+1 >= q.size+quiet {
+				bottom = false
+			}
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// 1-M "HELLO WORLD" from the worked example of the standard
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Fatalf("rsRemainder() = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeQR(t *testing.T) {
+	q, err := encodeQR([]byte("hello"))
+	if err != nil || q.size != 21 {
+		t.Fatalf("encodeQR() = %v, %v, want version 1", q, err)
+	}
+	var format []bool
+	for i := 0; i < 15; i++ {
+		format = append(format, q.modules[8][q.size-1-i])
+	}
+	// the format bits of level M are 10 followed by the mask and its BCH
+	// code, masked with 101010000010010
+	if !format[14] || format[13] {
+		t.Errorf("unexpected format bits %v", format)
+	}
+
+	q, err = encodeQR(bytes.Repeat([]byte("x"), 150))
+	if err != nil || q.size != 17+4*8 {
+		t.Fatalf("encodeQR() = %v, %v, want version 8", q, err)
+	}
+	// the version info of version 8 in the table of the standard
+	var version int
+	for i := 17; i >= 0; i-- {
+		version <<= 1
+		if q.modules[i/3][q.size-11+i%3] {
+			version |= 1
+		}
+	}
+	if version != 0x085BC {
+		t.Errorf("version info = %#x, want 0x085bc", version)
+	}
+	if _, err := encodeQR(make([]byte, 300)); err != ErrQRTooLong {
+		t.Errorf("except ErrQRTooLong, but %v", err)
+	}
+
+	var sb strings.Builder
+	q.writeTerminal(&sb, false)
+	if lines := strings.Count(sb.String(), "\n"); lines != (q.size+8+1)/2 {
+		t.Errorf("except %d lines, but %d", (q.size+8+1)/2, lines)
+	}
+}
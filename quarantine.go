@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// quarantineDir receives NEO files that fail to decode, empty disables it.
+var quarantineDir string
+
+type quarantineReport struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Result Result    `json:"result"`
+}
+
+// shouldQuarantine reports whether err means the file itself is broken, as
+// opposed to a problem with the destination.
+func shouldQuarantine(err error) bool {
+	var opErr *OpError
+	return isChecksumError(err) ||
+		errors.Is(err, codec.ErrNotNEOHeader) ||
+		errors.Is(err, codec.ErrBadVersion) ||
+		errors.Is(err, codec.ErrUnknownCryptoMethod) ||
+		(errors.As(err, &opErr) && opErr.Op == "header")
+}
+
+// quarantine moves file into quarantineDir next to a JSON report and returns
+// its new path.
+func quarantine(file string, res Result) (string, error) {
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(quarantineDir, filepath.Base(file))
+	if _, err := os.Lstat(dst); err == nil {
+		dst = filepath.Join(quarantineDir, time.Now().Format("20060102-150405.000-")+filepath.Base(file))
+	}
+	if err := moveFile(file, dst); err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(&quarantineReport{Time: time.Now(), Source: file, Result: res}, "", "  ")
+	if err != nil {
+		return dst, err
+	}
+	return dst, os.WriteFile(dst+".report.json", b, 0644)
+}
+
+// inQuarantine reports whether file lies inside quarantineDir.
+func inQuarantine(file string) bool {
+	if quarantineDir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(quarantineDir, file)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// moveFile renames src to dst, copying when they are on different devices.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("move %s: %w", src, err)
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// quarantineFailed quarantines a local file whose decode failed because the
+// file is broken.
+func quarantineFailed(file string, res *Result, err error) {
+	if quarantineDir == "" || err == nil || isRemote(file) || !shouldQuarantine(err) {
+		return
+	}
+	report := *res
+	report.Error = err.Error()
+	dst, qerr := quarantine(file, report)
+	if qerr != nil {
+		log.Printf("隔离文件：%s 失败，错误：%v", file, qerr)
+		return
+	}
+	res.Quarantined = dst
+	log.Printf("已将损坏的文件：%s 移至：%s", file, dst)
+}
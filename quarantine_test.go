@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	quarantineDir = filepath.Join(dir, "quarantine")
+	defer func() { quarantineDir = "" }()
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := EncodeFile(LocalStorage(dir), "data.bin", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(enc.Output)
+	b[len(b)-1] ^= 0xFF
+	os.WriteFile(enc.Output, b, 0644)
+
+	res, err := parseFile(enc.Output, ActionDecode)
+	if err == nil {
+		t.Fatal("except error")
+	}
+	want := filepath.Join(quarantineDir, filepath.Base(enc.Output))
+	if res.Quarantined != want {
+		t.Fatalf("except %s, but %s", want, res.Quarantined)
+	}
+	if _, err := os.Stat(enc.Output); !os.IsNotExist(err) {
+		t.Fatal("broken file left in place")
+	}
+	report := new(quarantineReport)
+	b, err = os.ReadFile(want + ".report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Source != enc.Output || report.Result.Error == "" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if !inQuarantine(want) || inQuarantine(enc.Output) {
+		t.Fatal("inQuarantine mismatch")
+	}
+}
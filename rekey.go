@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"log"
+	"path/filepath"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// errNotKeyed is returned by rekeyFile for files encoded without -keyed.
+var errNotKeyed = errors.New("not encoded with -keyed")
+
+// rekeyPlan is what rekeyFile changes in the key slots of a file. Keys
+// are secrets as loadKey returns them, the key of every file is derived
+// with its own salt.
+type rekeyPlan struct {
+	add    [][]byte
+	remove [][]byte
+	// replace takes the place of key: its slot is removed and stealth
+	// files made with key get the magic of replace
+	replace []byte
+}
+
+// runRekey changes the key slots of files encoded with -keyed, only their
+// headers are rewritten.
+func runRekey(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	recursive := fs.Bool("r", false, "递归处理目录")
+	password := fs.String("password", "", "可以读取文件的密码")
+	keyfile := fs.String("keyfile", "", "可以读取文件的密钥文件，可代替 -password")
+	newPassword := fs.String("new-password", "", "以此密码代替 -password 或 -keyfile")
+	newKeyfile := fs.String("new-keyfile", "", "以此密钥文件代替 -password 或 -keyfile")
+	addPassword := fs.String("add-password", "", "另外允许以此密码读取文件")
+	addKeyfile := fs.String("add-keyfile", "", "另外允许以此密钥文件读取文件")
+	var removes stringList
+	fs.Var(&removes, "remove", "不再允许指纹为此值的密码或密钥文件读取文件，可多次指定，各文件中的指纹见 neo key show")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return cmd.usageError(fs, "no file to rekey")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	if key == nil {
+		return cmd.usageError(fs, "rekey needs -password or -keyfile")
+	}
+	var plan rekeyPlan
+	newKey, err := loadKey(*newPassword, *newKeyfile)
+	if err != nil {
+		return err
+	}
+	if newKey != nil {
+		plan.add = append(plan.add, newKey)
+		plan.replace = newKey
+	}
+	addKey, err := loadKey(*addPassword, *addKeyfile)
+	if err != nil {
+		return err
+	}
+	if addKey != nil {
+		plan.add = append(plan.add, addKey)
+	}
+	for _, s := range removes {
+		id, err := hex.DecodeString(s)
+		if err != nil {
+			return cmd.usageError(fs, "bad key id: %q", s)
+		}
+		plan.remove = append(plan.remove, id)
+	}
+	if len(plan.add) == 0 && len(plan.remove) == 0 {
+		return cmd.usageError(fs, "rekey needs -new-*, -add-* or -remove")
+	}
+
+	sum := new(summary)
+	rekeyed, skipped := 0, 0
+	for _, file := range collectFiles(fs.Args(), *recursive, sum) {
+		if isRemote(file) {
+			continue
+		}
+		dir, name := filepath.Split(file)
+		if ok, err := IsNeoFile(LocalStorage(dir), name); err != nil || !ok {
+			continue
+		}
+		err := rekeyFile(file, plan)
+		switch {
+		case errors.Is(err, errNotKeyed):
+			skipped++
+		case err != nil:
+			log.Printf("更换密钥：%s 失败，错误：%v", file, err)
+			sum.failed++
+		default:
+			log.Printf("更换密钥：%s", file)
+			rekeyed++
+		}
+	}
+	log.Printf("完成：更换 %d 个，跳过 %d 个，失败 %d 个", rekeyed, skipped, sum.failed)
+	return sum.err()
+}
+
+// rekeyFile rewrites the header of file, read with key, with the slots of
+// plan added and removed. The file key and the content stay the same.
+func rekeyFile(file string, plan rekeyPlan) error {
+	hdr, err := readNeoHeader(file)
+	if err != nil {
+		return &OpError{Op: "header", Path: file, Err: err}
+	}
+	if hdr.KeySlots == nil {
+		return errNotKeyed
+	}
+	derive := func(secret []byte) []byte {
+		return deriveKey(secret, hdr.KDFSalt, hdr.KDFCost)
+	}
+	remove := plan.remove
+	if plan.replace != nil {
+		remove = append(remove[:len(remove):len(remove)], codec.KeyID(derive(key)))
+	}
+	stealth := plan.replace != nil && bytes.Equal(hdr.Magic, codec.StealthMagic(derive(key)))
+	apply := func(hdr *codec.NeoHeader) error {
+		for _, k := range plan.add {
+			if err := hdr.AddKeySlot(derive(k)); err != nil {
+				return err
+			}
+		}
+		for _, id := range remove {
+			if _, err := hdr.RemoveKeySlot(id); err != nil {
+				return err
+			}
+		}
+		if stealth {
+			hdr.Magic = codec.StealthMagic(derive(plan.replace))
+		}
+		return nil
+	}
+	// the header as read is a copy to try the plan on
+	if err := apply(hdr); err != nil {
+		return err
+	}
+	return rewriteHeader(file, func(hdr *codec.NeoHeader) {
+		apply(hdr)
+	})
+}
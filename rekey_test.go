@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+func TestRekeyFile(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("keyed content "), 100)
+	os.WriteFile(filepath.Join(dir, "a.txt"), content, 0644)
+	old, _ := loadKey("old", "")
+	newKey, _ := loadKey("new", "")
+	key, encodeKeyed, encodeStealth = old, true, true
+	defer func() { key, encodeKeyed, encodeStealth = nil, false, false }()
+	res, err := EncodeFile(LocalStorage(dir), "a.txt", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "a.txt"))
+	encodeKeyed, encodeStealth = false, false
+
+	hdr, err := readNeoHeader(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// key ids are derived with the salt of the file
+	id := func(k []byte) []byte {
+		return codec.KeyID(deriveKey(k, hdr.KDFSalt, hdr.KDFCost))
+	}
+	if err := rekeyFile(res.Output, rekeyPlan{add: [][]byte{newKey}, replace: newKey}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readNeoHeader(res.Output); err == nil {
+		t.Fatal("except the old key to fail after rekey")
+	}
+	key = newKey
+	hdr, err = readNeoHeader(res.Output)
+	if err != nil || hdr.OriginalFilename != "a.txt" {
+		t.Fatalf("unexpected header %+v %v", hdr, err)
+	}
+	// a slot for the old key again, then the new one is the last to remove
+	if err := rekeyFile(res.Output, rekeyPlan{add: [][]byte{old}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rekeyFile(res.Output, rekeyPlan{remove: [][]byte{id(old), id(newKey)}}); !errors.Is(err, codec.ErrLastKeySlot) {
+		t.Fatalf("except ErrLastKeySlot, but %v", err)
+	}
+	if hdr, err := readNeoHeader(res.Output); err != nil || len(hdr.KeySlots) != 2 {
+		t.Fatalf("unexpected header %+v %v", hdr, err)
+	}
+	if _, err := DecodeFile(LocalStorage(dir), filepath.Base(res.Output), LocalStorage(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); !bytes.Equal(b, content) {
+		t.Fatal("content changed")
+	}
+
+	key = nil
+	plain := filepath.Join(dir, "b.neo")
+	b, _ := (&codec.NeoHeader{Version: codec.VersionV1, OriginalHeaderEncMethod: codec.XorEnc,
+		OriginalFilenameEncMethod: codec.XorEnc, OriginalFilename: "b.txt"}).Marshall()
+	os.WriteFile(plain, b, 0644)
+	if err := rekeyFile(plain, rekeyPlan{add: [][]byte{newKey}}); !errors.Is(err, errNotKeyed) {
+		t.Fatalf("except errNotKeyed, but %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const remoteMaxRetries = 5
+
+var ErrRangeNotSupported = errors.New("server does not support range requests")
+
+// ErrRemoteChanged is returned when a transfer cannot be resumed because the
+// remote file changed since it started, or the server gives nothing to tell.
+var ErrRemoteChanged = errors.New("remote file changed during the transfer")
+
+func isRemote(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// HTTPReader streams a remote file and transparently resumes the transfer
+// with a Range request when the connection breaks. The request carries the
+// ETag or Last-Modified of the first response in If-Range, so a file that
+// changed in between is not spliced onto what was read.
+type HTTPReader struct {
+	url     string
+	client  *http.Client
+	body    io.ReadCloser
+	offset  int64
+	retries int
+	// validator is the If-Range of resumed requests, empty when the first
+	// response had neither a strong ETag nor a Last-Modified
+	validator string
+}
+
+func NewHTTPReader(rawURL string) (*HTTPReader, error) {
+	r := &HTTPReader{
+		url:    rawURL,
+		client: http.DefaultClient,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *HTTPReader) open() error {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	if r.offset > 0 {
+		if r.validator == "" {
+			return ErrRemoteChanged
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+		req.Header.Set("If-Range", r.validator)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case r.offset == 0 && resp.StatusCode == http.StatusOK:
+		r.validator = resp.Header.Get("ETag")
+		if r.validator == "" || strings.HasPrefix(r.validator, "W/") {
+			r.validator = resp.Header.Get("Last-Modified")
+		}
+	case r.offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", r.offset)) {
+			resp.Body.Close()
+			return fmt.Errorf("GET %s: unexpected Content-Range %q", r.url, resp.Header.Get("Content-Range"))
+		}
+	case r.offset > 0 && resp.StatusCode == http.StatusOK:
+		// the whole file again: If-Range did not match or ranges are
+		// not supported, either way it cannot be resumed
+		resp.Body.Close()
+		if resp.Header.Get("Accept-Ranges") == "bytes" {
+			return ErrRemoteChanged
+		}
+		return ErrRangeNotSupported
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("GET %s: %s", r.url, resp.Status)
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *HTTPReader) Read(p []byte) (n int, err error) {
+	for {
+		n, err = r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if r.retries >= remoteMaxRetries {
+			return n, err
+		}
+		r.retries++
+		r.body.Close()
+		time.Sleep(time.Duration(r.retries) * time.Second)
+		if err := r.open(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *HTTPReader) Close() error {
+	return r.body.Close()
+}
+
+// stageRemote downloads name from src into a temporary directory, so the
+// checksum pass and the encode read the same bytes from a single download.
+// The returned function removes the copy.
+func stageRemote(ctx context.Context, src Storage, name string) (Storage, func(), error) {
+	dir, err := os.MkdirTemp("", "neo-remote")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	staged := LocalStorage(dir)
+	in, err := src.Open(name)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	defer in.Close()
+	out, err := staged.Create(name)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	_, err = copyBuffer(out, ctxReader{ctx, in}, bufferFor(src))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return staged, cleanup, nil
+}
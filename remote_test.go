@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPReader_Resume(t *testing.T) {
+	content := make([]byte, 64<<10)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", etag)
+		if requests == 1 {
+			w.Header().Set("Content-Length", "65536")
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:1000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "test.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	rd, err := NewHTTPReader(srv.URL + "/test.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	b, err := ioutil.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatal("content mismatch after resume")
+	}
+	if requests != 2 {
+		t.Fatalf("except 2 requests, but %d", requests)
+	}
+
+	// a file replaced in between is not spliced on
+	requests = 0
+	rd, err = NewHTTPReader(srv.URL + "/test.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	etag = `"v2"`
+	if _, err := ioutil.ReadAll(rd); !errors.Is(err, ErrRemoteChanged) {
+		t.Fatalf("except ErrRemoteChanged, but %v", err)
+	}
+}
+
+func TestEncodeFile_RemoteOnce(t *testing.T) {
+	content := make([]byte, 64<<10)
+	rand.Read(content)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.ServeContent(w, r, "test.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	src, name := splitSource(srv.URL + "/test.bin")
+	res, err := EncodeFile(src, name, LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Fatalf("except 1 request, but %d", requests)
+	}
+	dec, err := DecodeFile(LocalStorage(dir), filepath.Base(res.Output), LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dec.Output); !bytes.Equal(b, content) {
+		t.Fatal("content mismatch")
+	}
+}
+
+func TestSplitSource_Query(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dir/test.bin" || r.URL.Query().Get("X-Amz-Signature") != "abc" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "content")
+	}))
+	defer srv.Close()
+
+	src, name := splitSource(srv.URL + "/dir/test.bin?X-Amz-Signature=abc")
+	if name != "test.bin" {
+		t.Fatalf("except test.bin, but %s", name)
+	}
+	if s := src.String(); strings.Contains(s, "abc") {
+		t.Fatalf("except no query in %s", s)
+	}
+	rd, err := src.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	if b, err := ioutil.ReadAll(rd); err != nil || string(b) != "content" {
+		t.Fatalf("except content, but %q, %v", b, err)
+	}
+}
+
+func TestS3Storage_Multipart(t *testing.T) {
+	defer func(n int) { uploadPartSize = n }(uploadPartSize)
+	uploadPartSize = 10 << 10
+	content := make([]byte, 25<<10)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string][]byte)
+	failed := false
+	var completed []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>id1</UploadId></InitiateMultipartUploadResult>")
+		case r.Method == http.MethodPut && q.Get("uploadId") == "id1":
+			n := q.Get("partNumber")
+			if n == "2" && !failed {
+				failed = true
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			parts[n], _ = ioutil.ReadAll(r.Body)
+			w.Header().Set("ETag", `"etag`+n+`"`)
+		case r.Method == http.MethodPost && q.Get("uploadId") == "id1":
+			completed, _ = ioutil.ReadAll(r.Body)
+			fmt.Fprint(w, "<CompleteMultipartUploadResult/>")
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	b, err := NewS3Storage("bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := b.Create("test.neo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := append(append(parts["1"], parts["2"]...), parts["3"]...)
+	if len(parts) != 3 || !bytes.Equal(got, content) {
+		t.Fatalf("parts mismatch, %d parts", len(parts))
+	}
+	if !bytes.Contains(completed, []byte(`<PartNumber>3</PartNumber><ETag>&#34;etag3&#34;</ETag>`)) {
+		t.Fatalf("unexpected complete request: %s", completed)
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	defer func(n int) { uploadRate = n }(uploadRate)
+	uploadRate = 100 << 10
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, throttledReader{bytes.NewReader(make([]byte, 50<<10))})
+	if err != nil || n != 50<<10 {
+		t.Fatal(n, err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Fatalf("50K at 100K/s took only %v", d)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// remoteLogQueue bounds the records kept for a remote log that cannot be
+// reached, the oldest are dropped beyond it.
+const remoteLogQueue = 1000
+
+// logRecord is what -audit-log sends for every file. Hash is the SHA-256
+// of Prev and the record in JSON with Hash empty, so the records of a run
+// form a chain that shows when one is changed, dropped or reordered. The
+// first record of a run has Seq 0, Action "start" and no Prev.
+type logRecord struct {
+	Seq          int64     `json:"seq"`
+	Time         time.Time `json:"time"`
+	Host         string    `json:"host"`
+	Action       Action    `json:"action"`
+	Status       string    `json:"status,omitempty"`
+	Input        string    `json:"input,omitempty"`
+	Output       string    `json:"output,omitempty"`
+	OriginalName string    `json:"original_name,omitempty"`
+	Bytes        int64     `json:"bytes,omitempty"`
+	CRC32        string    `json:"crc32,omitempty"`
+	SHA256       string    `json:"sha256,omitempty"`
+	XXH64        string    `json:"xxh64,omitempty"`
+	OutputSHA256 string    `json:"output_sha256,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Prev         string    `json:"prev,omitempty"`
+	Hash         string    `json:"hash"`
+}
+
+// seal fills in Hash.
+func (r *logRecord) seal() {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	h := sha256.New()
+	h.Write([]byte(r.Prev))
+	h.Write(b)
+	r.Hash = hex.EncodeToString(h.Sum(nil))
+}
+
+// remoteLog appends records to an HTTP endpoint, one POST of JSON each, or
+// to syslog over UDP or TCP.
+type remoteLog struct {
+	target string
+	send   func([]byte) error
+
+	mu      sync.Mutex
+	seq     int64
+	prev    string
+	pending [][]byte
+}
+
+// newRemoteLog parses the -audit-log target: an http(s) URL, or
+// syslog://host[:port] for UDP and syslog+tcp://host[:port] for TCP.
+func newRemoteLog(target string) (*remoteLog, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	l := &remoteLog{target: target}
+	switch u.Scheme {
+	case "http", "https":
+		l.send = func(b []byte) error {
+			resp, err := webhookClient.Post(target, "application/json", bytes.NewReader(b))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("POST %s: %s", target, resp.Status)
+			}
+			return nil
+		}
+	case "syslog", "syslog+tcp":
+		network, port := "udp", "514"
+		if u.Scheme == "syslog+tcp" {
+			network, port = "tcp", "601"
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+		l.send = func(b []byte) error {
+			return sendSyslog(network, addr, b)
+		}
+	default:
+		return nil, fmt.Errorf("unknown audit log: %s", target)
+	}
+	l.add(logRecord{Action: "start"})
+	return l, nil
+}
+
+// record adds the outcome of a file.
+func (l *remoteLog) record(res Result, err error) {
+	r := logRecord{
+		Action:       res.Action,
+		Status:       "success",
+		Input:        res.Input,
+		Output:       res.Output,
+		OriginalName: res.OriginalName,
+		Bytes:        res.Bytes,
+		SHA256:       res.SHA256,
+		XXH64:        res.XXH64,
+		OutputSHA256: res.OutputSHA256,
+		Error:        res.Error,
+	}
+	if res.Checksum != 0 {
+		r.CRC32 = fmt.Sprintf("%08x", res.Checksum)
+	}
+	if err != nil {
+		r.Status = "failure"
+	}
+	l.add(r)
+}
+
+// add chains r to the records before it and sends it, after those that
+// could not be sent yet.
+func (l *remoteLog) add(r logRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Seq, r.Prev = l.seq, l.prev
+	r.Time = time.Now().UTC()
+	r.Host, _ = os.Hostname()
+	r.seal()
+	l.seq, l.prev = l.seq+1, r.Hash
+	b, _ := json.Marshal(r)
+	if l.pending = append(l.pending, b); len(l.pending) > remoteLogQueue {
+		log.Printf("审计日志：%s 无法送达，丢弃最早的 %d 条记录", l.target, len(l.pending)-remoteLogQueue)
+		l.pending = l.pending[len(l.pending)-remoteLogQueue:]
+	}
+	for len(l.pending) > 0 {
+		if err := l.send(l.pending[0]); err != nil {
+			log.Printf("发送审计日志：%s 失败，%d 条记录稍后重试，错误：%v", l.target, len(l.pending), err)
+			return
+		}
+		l.pending = l.pending[1:]
+	}
+}
+
+// sendSyslog sends msg as one RFC 5424 message of facility local0 and
+// severity notice, a TCP connection is ended after it with a newline.
+func sendSyslog(network, addr string, msg []byte) error {
+	conn, err := net.DialTimeout(network, addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	line := fmt.Sprintf("<%d>1 %s %s neo %d - - %s", 16*8+5, time.Now().UTC().Format(time.RFC3339), host, os.Getpid(), msg)
+	if network == "tcp" {
+		line += "\n"
+	}
+	_, err = conn.Write([]byte(line))
+	return err
+}
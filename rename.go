@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+func checkScheme(scheme string) error {
+	switch scheme {
+	case "random", "hash":
+		return nil
+	}
+	return fmt.Errorf("unknown naming scheme: %s", scheme)
+}
+
+func runRename(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	scheme := fs.String("scheme", "hash", "新的命名方式：random 随机，hash 取文件内容的 SHA-256")
+	exts := fs.String("ext", "", "新的扩展名，以逗号分隔时随机选取，默认保留原扩展名")
+	recursive := fs.Bool("r", false, "递归处理目录")
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if err := checkScheme(*scheme); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if fs.NArg() == 0 {
+		return cmd.usageError(fs, "no directory to rename")
+	}
+	var err error
+	if *exts != "" {
+		if outputExts, err = parseExts(*exts); err != nil {
+			return cmd.usageError(fs, "%v", err)
+		}
+	}
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+
+	sum := new(summary)
+	renamed := 0
+	for _, file := range collectFiles(fs.Args(), *recursive, sum) {
+		newName, err := renameNeoFile(file, *scheme, *exts != "")
+		switch {
+		case err != nil:
+			log.Printf("重命名文件 %s 失败，错误：%v", file, err)
+			sum.failed++
+		case newName != "":
+			log.Printf("重命名：%s → %s", file, newName)
+			renamed++
+		}
+	}
+	log.Printf("完成：重命名 %d 个，失败 %d 个", renamed, sum.failed)
+	return sum.err()
+}
+
+// renameNeoFile gives the encoded file a new name and returns it, or "" when
+// file is not a NEO file or already has the name.
+func renameNeoFile(file, scheme string, newExt bool) (string, error) {
+	st, name := splitSource(file)
+	if ok, err := IsNeoFile(st, name); err != nil || !ok {
+		return "", err
+	}
+	ext := filepath.Ext(name)
+	if newExt {
+		ext = randomExt(outputExts)
+	}
+	var newName string
+	switch scheme {
+	case "hash":
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		newName = hashName(h.Sum(nil), ext)
+	default:
+		newName = RandStringRunes(8) + ext
+	}
+	if newName == name {
+		return "", nil
+	}
+	newPath := filepath.Join(filepath.Dir(file), newName)
+	if _, err := os.Lstat(newPath); err == nil {
+		return "", fmt.Errorf("%s already exists", newPath)
+	}
+	if err := os.Rename(file, newPath); err != nil {
+		return "", err
+	}
+	return newPath, nil
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingS3Credentials = errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	ErrS3Option             = errors.New("bad s3 option")
+)
+
+// S3Storage talks to S3 with AWS signature v4, credentials are taken from
+// the standard AWS_* environment variables. AWS_ENDPOINT_URL may point to an
+// S3 compatible service, which is then addressed in path style.
+type S3Storage struct {
+	bucket       string
+	prefix       string
+	region       string
+	endpoint     *url.URL
+	pathStyle    bool
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+
+	// sse is the server side encryption of new objects, AES256 or aws:kms,
+	// kmsKeyID the KMS key for aws:kms, empty for the bucket default.
+	sse          string
+	kmsKeyID     string
+	storageClass string
+}
+
+func NewS3Storage(bucket, prefix string) (*S3Storage, error) {
+	b := &S3Storage{
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       http.DefaultClient,
+	}
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, ErrMissingS3Credentials
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, b.region)
+	} else {
+		b.pathStyle = true
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	b.endpoint = u
+	return b, nil
+}
+
+// setOptions takes the settings for new objects from the query of an s3://
+// URL: sse=AES256 or sse=aws:kms with an optional kms-key-id, and
+// storage-class.
+func (b *S3Storage) setOptions(q url.Values) error {
+	for k, v := range q {
+		val := v[len(v)-1]
+		switch k {
+		case "sse":
+			if val != "AES256" && val != "aws:kms" {
+				return fmt.Errorf("%w: sse=%s", ErrS3Option, val)
+			}
+			b.sse = val
+		case "kms-key-id":
+			b.kmsKeyID = val
+		case "storage-class":
+			b.storageClass = val
+		default:
+			return fmt.Errorf("%w: %s", ErrS3Option, k)
+		}
+	}
+	if b.kmsKeyID != "" && b.sse != "aws:kms" {
+		return fmt.Errorf("%w: kms-key-id needs sse=aws:kms", ErrS3Option)
+	}
+	return nil
+}
+
+// setObjectHeaders adds the settings for new objects to req, which creates
+// one.
+func (b *S3Storage) setObjectHeaders(req *http.Request) {
+	if b.sse != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", b.sse)
+	}
+	if b.kmsKeyID != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", b.kmsKeyID)
+	}
+	if b.storageClass != "" {
+		req.Header.Set("X-Amz-Storage-Class", b.storageClass)
+	}
+}
+
+func (b *S3Storage) objectURL(key string) *url.URL {
+	u := *b.endpoint
+	if b.pathStyle {
+		u.Path = path.Join("/", u.Path, b.bucket, key)
+	} else {
+		u.Path = path.Join("/", u.Path, key)
+	}
+	// send the path the way it is signed
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+// s3EscapePath URI-encodes each segment of p as the canonical URI of
+// signature v4 wants it, only unreserved characters are kept.
+func s3EscapePath(p string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+func (b *S3Storage) Create(name string) (io.WriteCloser, error) {
+	tmp, err := ioutil.TempFile("", "neo-s3-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{
+		storage: b,
+		key:     path.Join(b.prefix, name),
+		tmp:     tmp,
+		h:       sha256.New(),
+	}, nil
+}
+
+func (b *S3Storage) Open(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, b.objectURL(path.Join(b.prefix, name)).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(req, hexSHA256(nil))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (b *S3Storage) List(dir string) ([]Entry, error) {
+	prefix := path.Join(b.prefix, dir)
+	if prefix != "" && prefix != "." {
+		prefix += "/"
+	} else {
+		prefix = ""
+	}
+	var (
+		entries []Entry
+		token   string
+	)
+	for {
+		u := b.objectURL("")
+		if !b.pathStyle {
+			u.Path = "/"
+		}
+		q := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := b.do(req, hexSHA256(nil))
+		if err != nil {
+			return nil, err
+		}
+		var res listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range res.CommonPrefixes {
+			entries = append(entries, Entry{Name: path.Base(p.Prefix), IsDir: true})
+		}
+		for _, c := range res.Contents {
+			entries = append(entries, Entry{Name: path.Base(c.Key), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !res.IsTruncated {
+			return entries, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// Rename copies the object server side and deletes the original, S3 has no
+// native rename.
+func (b *S3Storage) Rename(oldname, newname string) error {
+	req, err := http.NewRequest(http.MethodPut, b.objectURL(path.Join(b.prefix, newname)).String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", s3EscapePath(b.bucket+"/"+path.Join(b.prefix, oldname)))
+	// a copy takes the bucket defaults unless told otherwise
+	b.setObjectHeaders(req)
+	resp, err := b.do(req, hexSHA256(nil))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return b.Delete(oldname)
+}
+
+func (b *S3Storage) Delete(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, b.objectURL(path.Join(b.prefix, name)).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.do(req, hexSHA256(nil))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (b *S3Storage) String() string {
+	return "s3://" + path.Join(b.bucket, b.prefix)
+}
+
+func (b *S3Storage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	b.sign(req, payloadHash, time.Now().UTC())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+func (b *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.sessionToken)
+	}
+
+	var names []string
+	for k := range req.Header {
+		names = append(names, strings.ToLower(k))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+b.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(p []byte) string {
+	sum := sha256.Sum256(p)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Writer spools the object to a temporary file, since a PUT needs the
+// content length and payload hash up front.
+type s3Writer struct {
+	storage *S3Storage
+	key     string
+	tmp     *os.File
+	h       hash.Hash
+	size    int64
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.h.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *s3Writer) Close() error {
+	defer func() {
+		w.tmp.Close()
+		os.Remove(w.tmp.Name())
+	}()
+	if w.size > int64(uploadPartSize) {
+		return w.storage.uploadParts(w.key, w.tmp, w.size)
+	}
+	sum := hex.EncodeToString(w.h.Sum(nil))
+	return withRetry(w.key, func() error {
+		return w.storage.put(w.storage.objectURL(w.key), io.NewSectionReader(w.tmp, 0, w.size), w.size, sum)
+	})
+}
+
+// put sends size bytes of body to u.
+func (b *S3Storage) put(u *url.URL, body io.Reader, size int64, payloadHash string) error {
+	req, err := http.NewRequest(http.MethodPut, u.String(), ioutil.NopCloser(throttledReader{body}))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	b.setObjectHeaders(req)
+	resp, err := b.do(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadParts sends the size bytes of f as a multipart upload of
+// uploadPartSize parts, a part that fails is sent again without starting
+// over. The upload is aborted when a part cannot be sent.
+func (b *S3Storage) uploadParts(key string, f *os.File, size int64) error {
+	u := b.objectURL(key)
+	u.RawQuery = "uploads="
+	req, err := http.NewRequest(http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	b.setObjectHeaders(req)
+	resp, err := b.do(req, hexSHA256(nil))
+	if err != nil {
+		return err
+	}
+	var initiated initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var parts []s3Part
+	for off, num := int64(0), 1; off < size; off, num = off+int64(uploadPartSize), num+1 {
+		n := int64(uploadPartSize)
+		if off+n > size {
+			n = size - off
+		}
+		part := io.NewSectionReader(f, off, n)
+		h := sha256.New()
+		if _, err := io.Copy(h, part); err != nil {
+			b.abortUpload(key, initiated.UploadID)
+			return err
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		pu := b.objectURL(key)
+		pu.RawQuery = url.Values{"partNumber": {fmt.Sprint(num)}, "uploadId": {initiated.UploadID}}.Encode()
+		var etag string
+		err := withRetry(fmt.Sprintf("%s 第 %d 段", key, num), func() error {
+			req, err := http.NewRequest(http.MethodPut, pu.String(), ioutil.NopCloser(throttledReader{io.NewSectionReader(f, off, part.Size())}))
+			if err != nil {
+				return err
+			}
+			req.ContentLength = part.Size()
+			resp, err := b.do(req, sum)
+			if err != nil {
+				return err
+			}
+			etag = resp.Header.Get("ETag")
+			return resp.Body.Close()
+		})
+		if err != nil {
+			b.abortUpload(key, initiated.UploadID)
+			return err
+		}
+		parts = append(parts, s3Part{PartNumber: num, ETag: etag})
+	}
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	u = b.objectURL(key)
+	u.RawQuery = url.Values{"uploadId": {initiated.UploadID}}.Encode()
+	err = withRetry(key, func() error {
+		resp, err := b.post(u, body)
+		if err != nil {
+			return err
+		}
+		// errors after the 200 status are reported in the body
+		msg, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && bytes.Contains(msg, []byte("<Error>")) {
+			err = fmt.Errorf("POST %s: %s", u, msg)
+		}
+		return err
+	})
+	if err != nil {
+		b.abortUpload(key, initiated.UploadID)
+	}
+	return err
+}
+
+func (b *S3Storage) post(u *url.URL, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return b.do(req, hexSHA256(body))
+}
+
+func (b *S3Storage) abortUpload(key, uploadID string) {
+	u := b.objectURL(key)
+	u.RawQuery = url.Values{"uploadId": {uploadID}}.Encode()
+	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return
+	}
+	if resp, err := b.do(req, hexSHA256(nil)); err == nil {
+		resp.Body.Close()
+	}
+}
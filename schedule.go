@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// taskResult is what a scheduled task reports for one watched directory.
+type taskResult struct {
+	Task     string        `json:"task"`
+	Dir      string        `json:"dir"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Checked  int           `json:"checked"`
+	Failed   int           `json:"failed"`
+	Error    string        `json:"error,omitempty"`
+}
+
+var watchTasks = map[string]func(w *watcher, dir string, res *taskResult) error{
+	"verify":  (*watcher).verifyTask,
+	"audit":   (*watcher).auditTask,
+	"process": (*watcher).processTask,
+}
+
+func (w *watcher) schedule(sched *cronSchedule, tasks []string) {
+	for {
+		next := sched.next(time.Now())
+		if next.IsZero() {
+			log.Printf("定时任务不会再执行")
+			return
+		}
+		time.Sleep(time.Until(next))
+		if w.isPaused() {
+			continue
+		}
+		w.runTasks(tasks)
+	}
+}
+
+func (w *watcher) runTasks(tasks []string) {
+	var report []string
+	for _, task := range tasks {
+		for _, dir := range w.dirs {
+			res := &taskResult{Task: task, Dir: dir, Start: time.Now()}
+			err := watchTasks[task](w, dir, res)
+			res.Duration = time.Since(res.Start)
+			if err != nil {
+				res.Error = err.Error()
+				log.Printf("定时任务：%s %s 失败，错误：%v", task, dir, err)
+			} else {
+				log.Printf("定时任务：%s %s 完成，检查 %d 个，失败 %d 个", task, dir, res.Checked, res.Failed)
+			}
+			if w.json != nil {
+				w.json.Encode(res)
+			}
+			line := fmt.Sprintf("%s %s：检查 %d 个，失败 %d 个", task, dir, res.Checked, res.Failed)
+			if res.Error != "" {
+				line += "，错误：" + res.Error
+			}
+			report = append(report, line)
+		}
+	}
+	w.alert("NEO 定时任务完成", strings.Join(report, "\n"))
+}
+
+// verifyTask decodes every NEO file in dir without writing it out.
+func (w *watcher) verifyTask(dir string, res *taskResult) error {
+	w.run.Lock()
+	defer w.run.Unlock()
+	// the key is the one of this watch
+	defer w.useProfile()()
+	sum := &summary{json: w.json}
+	for _, file := range collectFiles([]string{dir}, w.recursive, sum) {
+		st, name := splitSource(file)
+		if ok, err := IsNeoFile(st, name); err != nil || !ok {
+			continue
+		}
+		res.Checked++
+		r, err := VerifyFile(st, name)
+		if err != nil {
+			res.Failed++
+			if isChecksumError(err) {
+				w.alert("NEO 发现文件损毁", fmt.Sprintf("%s：%v", r.Input, err))
+			}
+			if w.notify {
+				desktopNotify("NEO 校验失败", r.Input)
+			}
+		}
+		sum.add(r, err)
+	}
+	return nil
+}
+
+// auditTask runs audit check when dir has an audit database.
+func (w *watcher) auditTask(dir string, res *taskResult) error {
+	db := filepath.Join(dir, auditDBName)
+	if _, err := os.Stat(db); os.IsNotExist(err) {
+		return nil
+	}
+	w.run.Lock()
+	defer w.run.Unlock()
+	checked, problems, err := auditCheck(dir, db)
+	res.Checked, res.Failed = checked, problems
+	if err == ErrAuditFailed {
+		w.alert("NEO 完整性检查发现问题", fmt.Sprintf("%s：%d 个文件异常", dir, problems))
+		if w.notify {
+			desktopNotify("NEO 完整性检查发现问题", dir)
+		}
+		return nil
+	}
+	return err
+}
+
+// processTask handles the new files in dir, files first seen now are
+// looked at again after -settle, the next scheduled run may be far away.
+func (w *watcher) processTask(dir string, res *taskResult) error {
+	sum := w.scanDirs([]string{dir}, true)
+	if w.settle > 0 {
+		time.Sleep(w.settle)
+		more := w.scanDirs([]string{dir}, true)
+		sum.encoded, sum.decoded, sum.failed = sum.encoded+more.encoded, sum.decoded+more.decoded, sum.failed+more.failed
+	}
+	res.Checked = sum.encoded + sum.decoded + sum.failed
+	res.Failed = sum.failed
+	return nil
+}
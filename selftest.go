@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+var ErrSelftestFailed = errors.New("self test failed")
+
+// selftestSizes are the sizes of the files neo selftest round trips, around
+// the default header length and the size of an XorRecord.
+var selftestSizes = []int{0, 1, codec.DefaultHeaderLen - 1, codec.DefaultHeaderLen, 4096, 65537, 1<<20 + 3, 3<<20 + 7}
+
+// selftestCase is a set of encode settings neo selftest goes through.
+type selftestCase struct {
+	name string
+	set  func()
+}
+
+var selftestCases = []selftestCase{
+	{"crc32", func() {}},
+	{"sha256,xxh64", func() { encodeSHA256, encodeXXH64 = true, true }},
+	{"no-checksum", func() { encodeNoChecksum = true }},
+	{"encrypt-meta", func() { encodeEncryptMeta, encodeSHA256 = true, true }},
+	{"header-len 2M", func() { headerLen = 2 << 20 }},
+	{"stealth", func() {
+		sum := sha256.Sum256([]byte("neo selftest"))
+		key, encodeStealth = sum[:], true
+	}},
+	{"scheme hash", func() { nameScheme = "hash" }},
+}
+
+func runSelftest(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	keep := fs.Bool("keep", false, "保留测试用的临时目录")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "neo-selftest-")
+	if err != nil {
+		return err
+	}
+	if *keep {
+		log.Printf("临时目录：%s", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	log.Printf("自检：%s %s/%s，%d 种设置，每种 %d 个文件", version(), runtime.GOOS, runtime.GOARCH, len(selftestCases), len(selftestSizes))
+	failed := 0
+	for i, c := range selftestCases {
+		if err := selftest(filepath.Join(dir, fmt.Sprint(i)), c); err != nil {
+			log.Printf("失败：%s，错误：%v", c.name, err)
+			failed++
+			continue
+		}
+		log.Printf("通过：%s", c.name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrSelftestFailed, failed, len(selftestCases))
+	}
+	log.Printf("完成：全部通过")
+	return nil
+}
+
+// selftest encodes and decodes files of every selftestSizes with the
+// settings of c, which are undone afterwards.
+func selftest(dir string, c selftestCase) error {
+	saved := currentSettings()
+	savedKey, savedStealth, savedNoChecksum := key, encodeStealth, encodeNoChecksum
+	defer func() {
+		saved.use()
+		key, encodeStealth, encodeNoChecksum = savedKey, savedStealth, savedNoChecksum
+	}()
+	c.set()
+
+	plain, encoded, decoded := filepath.Join(dir, "plain"), filepath.Join(dir, "encoded"), filepath.Join(dir, "decoded")
+	for _, d := range []string{plain, encoded, decoded} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return err
+		}
+	}
+	for _, size := range selftestSizes {
+		content := make([]byte, size)
+		if _, err := rand.Read(content); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%d.bin", size)
+		if err := os.WriteFile(filepath.Join(plain, name), content, 0600); err != nil {
+			return err
+		}
+		enc, err := EncodeFile(LocalStorage(plain), name, LocalStorage(encoded))
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", name, err)
+		}
+		encName := filepath.Base(enc.Output)
+		if ok, err := IsNeoFile(LocalStorage(encoded), encName); err != nil || !ok {
+			return fmt.Errorf("%s: not recognized as a NEO file: %v", encName, err)
+		}
+		dec, err := DecodeFile(LocalStorage(encoded), encName, LocalStorage(decoded))
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", name, err)
+		}
+		if filepath.Base(dec.Output) != name {
+			return fmt.Errorf("%s: decoded as %s", name, filepath.Base(dec.Output))
+		}
+		got, err := os.ReadFile(dec.Output)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, content) {
+			return fmt.Errorf("%s: decoded content differs", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSelftest(t *testing.T) {
+	dir := t.TempDir()
+	for i, c := range selftestCases {
+		if err := selftest(filepath.Join(dir, c.name), c); err != nil {
+			t.Errorf("%d %s: %v", i, c.name, err)
+		}
+	}
+	if encodeStealth || key != nil || nameScheme != "random" {
+		t.Fatal("settings not restored")
+	}
+}
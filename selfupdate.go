@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var releasesURL = "https://api.github.com/repos/hr3lxphr6j/NEO/releases/latest"
+
+var (
+	ErrNoReleaseAsset   = errors.New("no release asset for this platform")
+	ErrNoChecksum       = errors.New("release has no checksum for asset")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrDevBuild         = errors.New("running a development build, use -force to replace it")
+)
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func runSelfUpdate(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	check := fs.Bool("check", false, "只检查是否有新版本")
+	force := fs.Bool("force", false, "即使版本相同、本地版本更新或为开发版本也安装最新发布")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+
+	rel, err := latestRelease()
+	if err != nil {
+		return err
+	}
+	latest, ok := parseSemver(rel.TagName)
+	if !ok {
+		return fmt.Errorf("release has no semantic version: %q", rel.TagName)
+	}
+	current, ok := parseSemver(version())
+	switch {
+	case !ok:
+		log.Printf("最新发布：%s，当前为开发版本：%s", rel.TagName, version())
+		if *check {
+			return nil
+		}
+		if !*force {
+			return ErrDevBuild
+		}
+	case !*force && compareSemver(latest, current) <= 0:
+		log.Printf("当前已是最新版本：%s，最新发布：%s", version(), rel.TagName)
+		return nil
+	default:
+		log.Printf("发现新版本：%s，当前版本：%s", rel.TagName, version())
+		if *check {
+			return nil
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if err := applyRelease(rel, exe); err != nil {
+		return err
+	}
+	log.Printf("已更新至：%s", rel.TagName)
+	return nil
+}
+
+// semver is a parsed semantic version, build metadata is dropped.
+type semver struct {
+	num [3]int
+	pre []string
+}
+
+// parseSemver parses v with or without a leading "v".
+func parseSemver(v string) (semver, bool) {
+	var sv semver
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		sv.pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return sv, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return sv, false
+		}
+		sv.num[i] = n
+	}
+	return sv, true
+}
+
+// compareSemver returns -1, 0 or 1 as a is older than, the same as or newer
+// than b, following the precedence rules of semantic versioning.
+func compareSemver(a, b semver) int {
+	for i := range a.num {
+		if a.num[i] != b.num[i] {
+			return cmpInt(a.num[i], b.num[i])
+		}
+	}
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		x, errX := strconv.Atoi(a.pre[i])
+		y, errY := strconv.Atoi(b.pre[i])
+		switch {
+		case errX == nil && errY == nil:
+			if x != y {
+				return cmpInt(x, y)
+			}
+		case errX == nil:
+			// numeric identifiers sort before alphanumeric ones
+			return -1
+		case errY == nil:
+			return 1
+		case a.pre[i] != b.pre[i]:
+			if a.pre[i] < b.pre[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return cmpInt(len(a.pre), len(b.pre))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func latestRelease() (*release, error) {
+	resp, err := http.Get(releasesURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", releasesURL, resp.Status)
+	}
+	rel := new(release)
+	if err := json.NewDecoder(resp.Body).Decode(rel); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+func download(u string) ([]byte, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// applyRelease downloads the asset for the running platform, verifies it
+// against the checksum file published with the release and replaces exe.
+// The checksum comes from the same release, it catches broken downloads but
+// proves nothing about who published the release.
+func applyRelease(rel *release, exe string) error {
+	var assetName, assetURL, sumsURL string
+	for _, a := range rel.Assets {
+		name := strings.ToLower(a.Name)
+		switch {
+		case strings.Contains(name, "checksums") || strings.HasSuffix(name, "sha256sums"):
+			sumsURL = a.URL
+		case assetMatches(name, runtime.GOOS, runtime.GOARCH):
+			assetName, assetURL = a.Name, a.URL
+		}
+	}
+	if assetURL == "" {
+		return fmt.Errorf("%w: %s/%s", ErrNoReleaseAsset, runtime.GOOS, runtime.GOARCH)
+	}
+	if sumsURL == "" {
+		return fmt.Errorf("%w: %s", ErrNoChecksum, assetName)
+	}
+	sums, err := download(sumsURL)
+	if err != nil {
+		return err
+	}
+	expected := lookupChecksum(sums, assetName)
+	if expected == "" {
+		return fmt.Errorf("%w: %s", ErrNoChecksum, assetName)
+	}
+	asset, err := download(assetURL)
+	if err != nil {
+		return err
+	}
+	if actual := hexSHA256(asset); actual != expected {
+		return fmt.Errorf("%w: %s != %s", ErrChecksumMismatch, actual, expected)
+	}
+	bin, err := extractBinary(assetName, asset)
+	if err != nil {
+		return err
+	}
+	return replaceExecutable(exe, bin)
+}
+
+// assetMatches reports whether the name of an asset, split at "_", "-" and
+// ".", has goos and goarch as tokens of their own, so arm does not match
+// arm64 assets.
+func assetMatches(name, goos, goarch string) bool {
+	var hasOS, hasArch bool
+	for _, tok := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+		hasOS = hasOS || tok == goos
+		hasArch = hasArch || tok == goarch
+	}
+	return hasOS && hasArch
+}
+
+// lookupChecksum finds name in a sha256sum style file.
+func lookupChecksum(sums []byte, name string) string {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0])
+		}
+	}
+	return ""
+}
+
+func isBinaryName(name string) bool {
+	base := strings.TrimSuffix(filepath.Base(name), ".exe")
+	return base == "neo" || base == "NEO"
+}
+
+func extractBinary(name string, data []byte) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				if err == io.EOF {
+					return nil, fmt.Errorf("%w: %s contains no binary", ErrNoReleaseAsset, name)
+				}
+				return nil, err
+			}
+			if hdr.Typeflag == tar.TypeReg && isBinaryName(hdr.Name) {
+				return io.ReadAll(tr)
+			}
+		}
+	case strings.HasSuffix(name, ".zip"):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range zr.File {
+			if isBinaryName(f.Name) {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer rc.Close()
+				return io.ReadAll(rc)
+			}
+		}
+		return nil, fmt.Errorf("%w: %s contains no binary", ErrNoReleaseAsset, name)
+	default:
+		return data, nil
+	}
+}
+
+// replaceExecutable moves the running binary aside before putting the new
+// one in place, Windows refuses to overwrite a running executable but allows
+// renaming it.
+func replaceExecutable(exe string, bin []byte) error {
+	newPath, oldPath := exe+".new", exe+".old"
+	if err := os.WriteFile(newPath, bin, 0755); err != nil {
+		return err
+	}
+	os.Remove(oldPath)
+	if err := os.Rename(exe, oldPath); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+	if err := os.Rename(newPath, exe); err != nil {
+		os.Rename(oldPath, exe)
+		return err
+	}
+	// fails on Windows while we are still running, the next update retries
+	os.Remove(oldPath)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestApplyRelease(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, _ := zw.Create("neo")
+	w.Write([]byte("new binary"))
+	zw.Close()
+	asset := buf.Bytes()
+	assetName := fmt.Sprintf("neo_%s_%s.zip", runtime.GOOS, runtime.GOARCH)
+
+	sum := hexSHA256(asset)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/checksums.txt":
+			fmt.Fprintf(w, "%s  %s\n", sum, assetName)
+		case "/" + assetName:
+			w.Write(asset)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	rel := &release{
+		TagName: "v9.9.9",
+		Assets: []releaseAsset{
+			{assetName, srv.URL + "/" + assetName},
+			{"checksums.txt", srv.URL + "/checksums.txt"},
+		},
+	}
+
+	exe := filepath.Join(t.TempDir(), "neo")
+	if err := os.WriteFile(exe, []byte("old binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRelease(rel, exe); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(exe); string(b) != "new binary" {
+		t.Fatalf("binary not replaced: %q", b)
+	}
+
+	sum = hexSHA256([]byte("tampered"))
+	if err := applyRelease(rel, exe); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("except ErrChecksumMismatch, but %v", err)
+	}
+}
+
+func TestAssetMatches(t *testing.T) {
+	for _, c := range []struct {
+		name, goos, goarch string
+		want               bool
+	}{
+		{"neo_linux_arm64.tar.gz", "linux", "arm64", true},
+		{"neo_linux_arm64.tar.gz", "linux", "arm", false},
+		{"neo-linux-arm.tar.gz", "linux", "arm", true},
+		{"neo_linux_amd64p32.tar.gz", "linux", "amd64", false},
+		{"neo_windows_amd64.zip", "windows", "amd64", true},
+		{"neo_darwin_amd64.zip", "windows", "amd64", false},
+	} {
+		if got := assetMatches(c.name, c.goos, c.goarch); got != c.want {
+			t.Errorf("assetMatches(%q, %q, %q) = %v, want %v", c.name, c.goos, c.goarch, got, c.want)
+		}
+	}
+}
+
+func TestCompareSemver(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "1.2.3", 0},
+		{"v1.2.3", "v1.2.4", -1},
+		{"v1.10.0", "v1.9.9", 1},
+		{"v2.0.0", "v2.0.0-rc.1", 1},
+		{"v2.0.0-rc.2", "v2.0.0-rc.10", -1},
+		{"v2.0.0-alpha", "v2.0.0-alpha.1", -1},
+		{"v2.0.0-1", "v2.0.0-alpha", -1},
+		{"v1.0.0+build.5", "v1.0.0", 0},
+	} {
+		a, okA := parseSemver(c.a)
+		b, okB := parseSemver(c.b)
+		if !okA || !okB {
+			t.Fatalf("%s or %s not parsed", c.a, c.b)
+		}
+		if got := compareSemver(a, b); got != c.want {
+			t.Errorf("compare %s %s: except %d, but %d", c.a, c.b, c.want, got)
+		}
+	}
+	for _, v := range []string{"dev", "v1.2", "latest", "v1.x.0"} {
+		if _, ok := parseSemver(v); ok {
+			t.Errorf("except %s not to parse", v)
+		}
+	}
+}
+
+func TestRunSelfUpdate_Dev(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tag_name": "v9.9.9"}`)
+	}))
+	defer srv.Close()
+	defer func(u, v string) { releasesURL, Version = u, v }(releasesURL, Version)
+	releasesURL, Version = srv.URL, ""
+
+	cmd := findCommand("self-update")
+	if err := cmd.run(cmd, nil); !errors.Is(err, ErrDevBuild) {
+		t.Fatalf("except ErrDevBuild, but %v", err)
+	}
+	// a newer local build is left alone
+	Version = "v10.0.0"
+	if err := cmd.run(cmd, nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func runService(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	name := fs.String("name", "neo-watch", "服务名称")
+	user := fs.Bool("user", false, "安装为当前用户的服务而非系统服务（仅 systemd）")
+	ops := map[string]bool{"install": true, "uninstall": true, "start": true, "stop": true, "status": true}
+	if len(args) == 0 || !ops[args[0]] {
+		if err := cmd.parse(fs, args); err != nil {
+			return err
+		}
+		return cmd.usageError(fs, "service needs install, uninstall, start, stop or status")
+	}
+	op := args[0]
+	if err := cmd.parse(fs, args[1:]); err != nil {
+		return err
+	}
+	if op != "install" {
+		return serviceControl(*name, *user, op)
+	}
+	if fs.NArg() == 0 {
+		return cmd.usageError(fs, "install needs the command to run, e.g. watch -r DIR")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return serviceInstall(*name, *user, wd, append([]string{exe}, fs.Args()...))
+}
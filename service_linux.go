@@ -0,0 +1,113 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const systemdUnit = `[Unit]
+Description=NEO %s
+After=network.target
+
+[Service]
+ExecStart=%s
+WorkingDirectory=%s
+Restart=on-failure
+
+[Install]
+WantedBy=%s
+`
+
+func systemdUnitPath(name string, user bool) (string, error) {
+	if !user {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", name+".service"), nil
+}
+
+// systemdQuote quotes s for ExecStart, systemd expands % specifiers and $
+// variables even inside quotes.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s)
+	return `"` + s + `"`
+}
+
+func systemctl(user bool, args ...string) error {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v %s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+func serviceInstall(name string, user bool, wd string, argv []string) error {
+	unitPath, err := systemdUnitPath(name, user)
+	if err != nil {
+		return err
+	}
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = systemdQuote(arg)
+	}
+	wantedBy := "multi-user.target"
+	if user {
+		wantedBy = "default.target"
+	}
+	unit := fmt.Sprintf(systemdUnit, strings.Join(argv[1:], " "), strings.Join(quoted, " "), systemdQuote(wd), wantedBy)
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return err
+	}
+	if err := systemctl(user, "daemon-reload"); err != nil {
+		return err
+	}
+	if err := systemctl(user, "enable", "--now", name); err != nil {
+		return err
+	}
+	log.Printf("已安装并启动服务：%s，日志可通过 journalctl -u %s 查看", name, name)
+	return nil
+}
+
+func serviceControl(name string, user bool, op string) error {
+	switch op {
+	case "uninstall":
+		systemctl(user, "disable", "--now", name)
+		unitPath, err := systemdUnitPath(name, user)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(unitPath); err != nil {
+			return err
+		}
+		log.Printf("已移除服务：%s", name)
+		return systemctl(user, "daemon-reload")
+	case "status":
+		args := []string{"status", "--no-pager", name}
+		if user {
+			args = append([]string{"--user"}, args...)
+		}
+		c := exec.Command("systemctl", args...)
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		// systemctl status exits non-zero for stopped services
+		c.Run()
+		return nil
+	default:
+		return systemctl(user, op, name)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import "errors"
+
+var ErrServiceNotSupported = errors.New("service installation is only supported with systemd and on Windows")
+
+func serviceInstall(name string, user bool, wd string, argv []string) error {
+	return ErrServiceNotSupported
+}
+
+func serviceControl(name string, user bool, op string) error {
+	return ErrServiceNotSupported
+}
@@ -0,0 +1,64 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Services are registered as scheduled tasks that run as SYSTEM at boot, a
+// real Windows service would need to talk to the service control manager.
+
+func schtasks(args ...string) error {
+	out, err := exec.Command("schtasks", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("schtasks %s: %v %s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+func serviceInstall(name string, user bool, wd string, argv []string) error {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = syscall.EscapeArg(arg)
+	}
+	// the task starts in System32, relative paths are resolved against wd
+	cmdline := fmt.Sprintf(`cmd /c cd /d %s && %s`, syscall.EscapeArg(wd), strings.Join(quoted, " "))
+	if len(cmdline) > 261 {
+		return fmt.Errorf("command line too long for a scheduled task: %d > 261", len(cmdline))
+	}
+	if err := schtasks("/Create", "/TN", name, "/TR", cmdline, "/SC", "ONSTART", "/RU", "SYSTEM", "/RL", "HIGHEST", "/F"); err != nil {
+		return err
+	}
+	if err := schtasks("/Run", "/TN", name); err != nil {
+		return err
+	}
+	log.Printf("已安装并启动服务：%s，可使用 watch -log 将日志写入文件", name)
+	return nil
+}
+
+func serviceControl(name string, user bool, op string) error {
+	switch op {
+	case "uninstall":
+		schtasks("/End", "/TN", name)
+		if err := schtasks("/Delete", "/TN", name, "/F"); err != nil {
+			return err
+		}
+		log.Printf("已移除服务：%s", name)
+		return nil
+	case "start":
+		return schtasks("/Run", "/TN", name)
+	case "stop":
+		return schtasks("/End", "/TN", name)
+	default:
+		c := exec.Command("schtasks", "/Query", "/TN", name, "/V", "/FO", "LIST")
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		return c.Run()
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// SFTPStorage works through the system ssh client, so no ssh implementation
+// needs to be linked into the binary.
+type SFTPStorage struct {
+	host string
+	port string
+	dir  string
+}
+
+func NewSFTPStorage(u *url.URL) *SFTPStorage {
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	return &SFTPStorage{host: host, port: u.Port(), dir: u.Path}
+}
+
+func (s *SFTPStorage) path(name string) string {
+	return shellQuote(path.Join(s.dir, name))
+}
+
+func (s *SFTPStorage) command(remoteCmd string) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if s.port != "" {
+		args = append(args, "-p", s.port)
+	}
+	args = append(args, s.host, remoteCmd)
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+func (s *SFTPStorage) Open(name string) (io.ReadCloser, error) {
+	cmd := s.command("cat " + s.path(name))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &sshReader{stdout: stdout, cmd: cmd}, nil
+}
+
+func (s *SFTPStorage) Create(name string) (io.WriteCloser, error) {
+	tmp, err := ioutil.TempFile("", "neo-sftp-")
+	if err != nil {
+		return nil, err
+	}
+	return &sftpWriter{storage: s, name: name, tmp: tmp}, nil
+}
+
+// upload sends size bytes of f to name. After a failed attempt the upload
+// goes on from what already got to the remote file.
+func (s *SFTPStorage) upload(name string, f *os.File, size int64) error {
+	first := true
+	return withRetry(s.String()+"/"+name, func() error {
+		var off int64
+		redirect := " > "
+		if !first {
+			out, err := s.command("wc -c < " + s.path(name)).Output()
+			if err != nil {
+				return err
+			}
+			if off, err = strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err != nil || off > size {
+				return fmt.Errorf("unexpected remote size: %q", out)
+			}
+			redirect = " >> "
+		}
+		first = false
+		cmd := s.command("cat" + redirect + s.path(name))
+		cmd.Stdin = throttledReader{io.NewSectionReader(f, off, size-off)}
+		return cmd.Run()
+	})
+}
+
+func (s *SFTPStorage) List(dir string) ([]Entry, error) {
+	out, err := s.command("ls -1Ap " + s.path(dir)).Output()
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		name := sc.Text()
+		entries = append(entries, Entry{
+			Name:  strings.TrimSuffix(name, "/"),
+			IsDir: strings.HasSuffix(name, "/"),
+		})
+	}
+	return entries, sc.Err()
+}
+
+func (s *SFTPStorage) Rename(oldname, newname string) error {
+	return s.command("mv -f " + s.path(oldname) + " " + s.path(newname)).Run()
+}
+
+func (s *SFTPStorage) Delete(name string) error {
+	return s.command("rm -f " + s.path(name)).Run()
+}
+
+func (s *SFTPStorage) String() string {
+	return "sftp://" + s.host + s.dir
+}
+
+type sshReader struct {
+	stdout io.ReadCloser
+	cmd    *exec.Cmd
+}
+
+func (r *sshReader) Read(p []byte) (int, error) {
+	return r.stdout.Read(p)
+}
+
+func (r *sshReader) Close() error {
+	r.stdout.Close()
+	return r.cmd.Wait()
+}
+
+// sftpWriter spools the file to a temporary file, so a dropped connection
+// can be resumed without the caller writing it again.
+type sftpWriter struct {
+	storage *SFTPStorage
+	name    string
+	tmp     *os.File
+	size    int64
+}
+
+func (w *sftpWriter) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *sftpWriter) Close() error {
+	defer func() {
+		w.tmp.Close()
+		os.Remove(w.tmp.Name())
+	}()
+	return w.storage.upload(w.name, w.tmp, w.size)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// ErrNoClipboard is returned by copyToClipboard when no clipboard tool is
+// found.
+var ErrNoClipboard = errors.New("no clipboard tool found")
+
+// sharePrefix starts share strings, the rest is the query of a URL.
+const sharePrefix = "neo:share?"
+
+// runShare makes a share string telling another person which file to
+// decode and which key it needs, without the key or the original filename.
+func runShare(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	qr := fs.Bool("qr", false, "以二维码显示在终端中，不复制到剪贴板")
+	invert := fs.Bool("invert", false, "反色显示二维码，适用于浅色背景的终端")
+	printOnly := fs.Bool("print", false, "输出到标准输出，不复制到剪贴板")
+	password := fs.String("password", "", "密码，用于读取 -stealth 编码的文件，并显示其指纹")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return cmd.usageError(fs, "share needs one file")
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	s, err := shareString(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	switch {
+	case *qr:
+		q, err := encodeQR([]byte(s))
+		if err != nil {
+			return err
+		}
+		return q.writeTerminal(os.Stdout, *invert)
+	case *printOnly:
+		fmt.Println(s)
+		return nil
+	}
+	if err := copyToClipboard(s); err != nil {
+		log.Printf("复制到剪贴板失败，错误：%v", err)
+		fmt.Println(s)
+		return nil
+	}
+	log.Printf("已复制到剪贴板：%s", s)
+	return nil
+}
+
+// shareString describes a local NEO file: the hint or the name of the file,
+// its size and SHA-256 to check a copy against, and the key ids of keyed
+// files or the key id of the key a stealth file was read with.
+func shareString(file string) (string, error) {
+	dir, name := filepath.Split(file)
+	if ok, err := IsNeoFile(LocalStorage(dir), name); err != nil {
+		return "", err
+	} else if !ok {
+		return "", &OpError{Op: "header", Path: file, Err: ErrKeyMismatch}
+	}
+	v := url.Values{}
+	v.Set("name", name)
+	hdr, err := readNeoHeader(file)
+	var idErr *codec.KeyIDError
+	switch {
+	case errors.As(err, &idErr):
+		v.Set("key", keyIDList(idErr.KeyIDs))
+	case err != nil:
+		return "", &OpError{Op: "header", Path: file, Err: err}
+	default:
+		if hdr.Hint != "" {
+			v.Set("name", hdr.Hint)
+		}
+		var ids [][]byte
+		for _, slot := range hdr.KeySlots {
+			ids = append(ids, slot.KeyID)
+		}
+		switch {
+		case ids != nil:
+			v.Set("key", keyIDList(ids))
+		case headerKeyID(hdr, key) != "":
+			v.Set("key", headerKeyID(hdr, key))
+		}
+	}
+	e, err := auditFile(file)
+	if err != nil {
+		return "", err
+	}
+	v.Set("size", strconv.FormatInt(e.Size, 10))
+	v.Set("sha256", e.SHA256)
+	return sharePrefix + v.Encode(), nil
+}
+
+// copyToClipboard puts s on the clipboard with the first clipboard tool of
+// the platform that works.
+func copyToClipboard(s string) error {
+	var tools [][]string
+	switch runtime.GOOS {
+	case "windows":
+		tools = [][]string{{"clip"}}
+	case "darwin":
+		tools = [][]string{{"pbcopy"}}
+	default:
+		tools = [][]string{{"wl-copy"}, {"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	}
+	err := ErrNoClipboard
+	for _, tool := range tools {
+		if _, lookErr := exec.LookPath(tool[0]); lookErr != nil {
+			continue
+		}
+		c := exec.Command(tool[0], tool[1:]...)
+		c.Stdin = strings.NewReader(s)
+		if err = c.Run(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestShareString(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "secret name.txt"), []byte("shared content"), 0644)
+	master, _ := loadKey("shared", "")
+	key, encodeKeyed = master, true
+	res, err := EncodeFile(LocalStorage(dir), "secret name.txt", LocalStorage(dir))
+	encodeKeyed = false
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := readNeoHeader(res.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := headerKeyID(hdr, master)
+
+	for _, k := range [][]byte{master, nil} {
+		key = k
+		s, err := shareString(res.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(s, sharePrefix) || strings.Contains(s, "secret") {
+			t.Fatalf("unexpected share string %q", s)
+		}
+		v, _ := url.ParseQuery(strings.TrimPrefix(s, sharePrefix))
+		info, _ := os.Stat(res.Output)
+		if v.Get("name") != filepath.Base(res.Output) || v.Get("size") != strconv.FormatInt(info.Size(), 10) ||
+			len(v.Get("sha256")) != 64 || v.Get("key") != want {
+			t.Errorf("unexpected share string %q", s)
+		}
+	}
+	if _, err := shareString(filepath.Join(dir, "missing.neo")); err == nil {
+		t.Error("except an error for a missing file")
+	}
+}
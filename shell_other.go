@@ -0,0 +1,22 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package main
+
+import "errors"
+
+var ErrShellNotSupported = errors.New("shell integration is only supported on Windows and macOS")
+
+func installShell(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	return ErrShellNotSupported
+}
+
+func uninstallShell(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	return ErrShellNotSupported
+}
@@ -0,0 +1,79 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+const shellKeyPrefix = `HKCU\Software\Classes\`
+
+type shellEntry struct {
+	key     string
+	title   string
+	command string
+}
+
+func shellEntries(exe string) []shellEntry {
+	return []shellEntry{
+		{`*\shell\NEOEncode`, "NEO Encode", fmt.Sprintf(`"%s" encode "%%1"`, exe)},
+		{`*\shell\NEODecode`, "NEO Decode", fmt.Sprintf(`"%s" decode "%%1"`, exe)},
+		{`Directory\shell\NEOEncode`, "NEO Encode", fmt.Sprintf(`"%s" encode -r "%%1"`, exe)},
+		{`Directory\shell\NEODecode`, "NEO Decode", fmt.Sprintf(`"%s" decode -r "%%1"`, exe)},
+	}
+}
+
+func regAdd(key, name, value string) error {
+	args := []string{"add", shellKeyPrefix + key, "/f", "/t", "REG_SZ", "/d", value}
+	if name == "" {
+		args = append(args, "/ve")
+	} else {
+		args = append(args, "/v", name)
+	}
+	out, err := exec.Command("reg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("reg add %s: %v: %s", key, err, out)
+	}
+	return nil
+}
+
+func installShell(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	for _, e := range shellEntries(exe) {
+		if err := regAdd(e.key, "", e.title); err != nil {
+			return err
+		}
+		if err := regAdd(e.key, "Icon", exe); err != nil {
+			return err
+		}
+		if err := regAdd(e.key+`\command`, "", e.command); err != nil {
+			return err
+		}
+	}
+	log.Printf("已添加右键菜单")
+	return nil
+}
+
+func uninstallShell(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	for _, e := range shellEntries("") {
+		out, err := exec.Command("reg", "delete", shellKeyPrefix+e.key, "/f").CombinedOutput()
+		if err != nil {
+			log.Printf("删除注册表项：%s 失败，错误：%v %s", e.key, err, out)
+		}
+	}
+	log.Printf("已移除右键菜单")
+	return nil
+}
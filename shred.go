@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// shredPasses is how many times removed inputs are overwritten first.
+var shredPasses int
+
+// shredFile overwrites path passes times with random data, renames and
+// removes it. On SSDs and copy-on-write or journaling file systems the old
+// blocks may survive elsewhere, so this is best effort.
+func shredFile(path string, passes int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	fInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	buf := make([]byte, 1<<20)
+	for i := 0; i < passes; i++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		for left := fInfo.Size(); left > 0; {
+			n := int64(len(buf))
+			if n > left {
+				n = left
+			}
+			rand.Read(buf[:n])
+			if _, err := f.Write(buf[:n]); err != nil {
+				f.Close()
+				return err
+			}
+			left -= n
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	f.Truncate(0)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// the name goes too
+	tmp := filepath.Join(filepath.Dir(path), RandStringRunes(16))
+	if _, err := os.Lstat(tmp); os.IsNotExist(err) && os.Rename(path, tmp) == nil {
+		path = tmp
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShredFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	os.WriteFile(file, make([]byte, 3<<20), 0644)
+	if err := shredFile(file, 2); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := os.ReadDir(dir); len(items) != 0 {
+		t.Fatalf("except nothing left, but %v", items)
+	}
+}
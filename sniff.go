@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+)
+
+// sandboxed is set by -sandbox: decoded files only go into outStorage,
+// their names are sanitized and nothing already in the way is written
+// through.
+var sandboxed bool
+
+// sniffLen is how much of the decoded content sniffExt looks at.
+const sniffLen = 512
+
+// ErrUnusableName is returned where a decoded file cannot be saved under
+// another name than the one in its header.
+var ErrUnusableName = errors.New("original filename is not usable")
+
+// usableName reports whether name can be used as the name of the decoded
+// file: a single valid path element without control characters, and on
+// Windows none of the characters and device names it reserves.
+func usableName(name string) bool {
+	if name == "" || name == "." || name == ".." || !utf8.ValidString(name) || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7F {
+			return false
+		}
+	}
+	return runtime.GOOS != "windows" || !reservedOnWindows(name)
+}
+
+func reservedOnWindows(name string) bool {
+	if strings.ContainsAny(name, `<>:"|?*`) || strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return true
+	}
+	stem := strings.ToUpper(strings.TrimSuffix(name, path.Ext(name)))
+	switch stem {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	return len(stem) == 4 && (strings.HasPrefix(stem, "COM") || strings.HasPrefix(stem, "LPT")) && stem[3] >= '1' && stem[3] <= '9'
+}
+
+// sanitizeName makes name usable as a file name on any system where it
+// can: only the last path element is kept and reserved characters are
+// replaced. It returns "" when nothing usable is left.
+func sanitizeName(name string) string {
+	name = strings.ToValidUTF8(name, "_")
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7F || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	if name != "" && reservedOnWindows(name) {
+		// a device name
+		name = "_" + name
+	}
+	if !usableName(name) {
+		return ""
+	}
+	return name
+}
+
+// magics maps the leading bytes of common formats to their extension, at
+// is where the bytes start.
+var magics = []struct {
+	at    int
+	magic string
+	ext   string
+}{
+	{0, "%PDF-", ".pdf"},
+	{0, "\x89PNG\r\n\x1a\n", ".png"},
+	{0, "\xFF\xD8\xFF", ".jpg"},
+	{0, "GIF8", ".gif"},
+	{8, "WEBP", ".webp"},
+	{0, "BM", ".bmp"},
+	{0, "PK\x03\x04", ".zip"},
+	{0, "Rar!\x1a\x07", ".rar"},
+	{0, "7z\xBC\xAF\x27\x1C", ".7z"},
+	{0, "\x1F\x8B", ".gz"},
+	{0, "BZh", ".bz2"},
+	{0, "\xFD7zXZ\x00", ".xz"},
+	{0, "\x28\xB5\x2F\xFD", ".zst"},
+	{257, "ustar", ".tar"},
+	{4, "ftypqt", ".mov"},
+	{4, "ftyp", ".mp4"},
+	{0, "\x1A\x45\xDF\xA3", ".mkv"},
+	{8, "AVI ", ".avi"},
+	{8, "WAVE", ".wav"},
+	{0, "ID3", ".mp3"},
+	{0, "fLaC", ".flac"},
+	{0, "OggS", ".ogg"},
+	{0, "MZ", ".exe"},
+	{0, "\x7FELF", ".elf"},
+	{0, "SQLite format 3\x00", ".sqlite"},
+	{0, "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1", ".doc"},
+}
+
+// sniffExt guesses the extension of content from its first bytes, ".txt"
+// for what looks like text and ".bin" for anything else.
+func sniffExt(head []byte) string {
+	for _, m := range magics {
+		if len(head) >= m.at+len(m.magic) && string(head[m.at:m.at+len(m.magic)]) == m.magic {
+			return m.ext
+		}
+	}
+	if len(head) > 0 && looksLikeText(head) {
+		return ".txt"
+	}
+	return ".bin"
+}
+
+// looksLikeText reports whether b is UTF-8 without NUL bytes, the last rune
+// may be cut off.
+func looksLikeText(b []byte) bool {
+	if bytes.IndexByte(b, 0) >= 0 {
+		return false
+	}
+	for i := 0; i < utf8.UTFMax && len(b) > 0; i++ {
+		if utf8.Valid(b) {
+			return true
+		}
+		b = b[:len(b)-1]
+	}
+	return false
+}
+
+// headWriter keeps the first sniffLen bytes written to it.
+type headWriter struct {
+	buf []byte
+}
+
+func (w *headWriter) Write(p []byte) (int, error) {
+	if n := sniffLen - len(w.buf); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		w.buf = append(w.buf, p[:n]...)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// corpusEntry is one file of a golden corpus directory, listed in its
+// corpus.json with what it must decode to.
+type corpusEntry struct {
+	File     string `json:"file"`
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256"`
+}
+
+func runSpec(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	corpus := fs.String("corpus", "", "将测试向量加入此目录下当前格式版本的兼容性语料，已有的文件不会被覆盖")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	spec, err := codec.FormatSpec()
+	if err != nil {
+		return err
+	}
+	if *corpus != "" {
+		return extendCorpus(filepath.Join(*corpus, fmt.Sprintf("v%d", spec.Version)), spec.Vectors)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(spec)
+}
+
+// extendCorpus adds the vectors whose bytes are not in dir yet, files already
+// in the corpus were made by an earlier release and must stay as they are. New
+// files are named after the vector and their hash, so a vector whose encoding
+// changed is added next to the old file.
+func extendCorpus(dir string, vectors []codec.Vector) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	manifest := filepath.Join(dir, "corpus.json")
+	var entries []corpusEntry
+	if b, err := os.ReadFile(manifest); err == nil {
+		if err := json.Unmarshal(b, &entries); err != nil {
+			return fmt.Errorf("%s: %w", manifest, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	known := make(map[[sha256.Size]byte]bool)
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.File))
+		if err != nil {
+			return err
+		}
+		known[sha256.Sum256(b)] = true
+	}
+	added := 0
+	for _, v := range vectors {
+		encoded, err := hex.DecodeString(v.Encoded)
+		if err != nil {
+			return err
+		}
+		id := sha256.Sum256(encoded)
+		if known[id] {
+			continue
+		}
+		known[id] = true
+		file := fmt.Sprintf("%s-%x.neo", v.Name, id[:4])
+		content, err := hex.DecodeString(v.Content)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), encoded, 0644); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		entries = append(entries, corpusEntry{File: file, Filename: v.Filename, SHA256: hex.EncodeToString(sum[:])})
+		log.Printf("已添加：%s", filepath.Join(dir, file))
+		added++
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(manifest, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	log.Printf("完成：添加 %d 个文件，语料共 %d 个文件", added, len(entries))
+	return nil
+}
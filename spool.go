@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync"
+)
+
+// spoolQueue is how many encoded files may wait in the spool directory for
+// their move, encoding waits when it is full so the spool does not fill up.
+const spoolQueue = 2
+
+// errSpooled is returned by parseFile for a file left to the spooler, whose
+// result is reported once it reached the destination.
+var errSpooled = errors.New("spooled")
+
+// spool is set by -spool: files are encoded into it and moved to their
+// destination in the background while the next file is encoded.
+var spool *spooler
+
+type spoolMove struct {
+	res  Result
+	name string
+	src  Storage
+	// srcName is the input, removed after the move with -remove-source
+	srcName string
+	dst     Storage
+}
+
+type spooler struct {
+	dir LocalStorage
+	// done receives the result of every file once it was moved
+	done  func(Result, error)
+	moves chan spoolMove
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newSpooler(dir string, done func(Result, error)) (*spooler, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &spooler{dir: LocalStorage(dir), done: done, moves: make(chan spoolMove, spoolQueue)}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for m := range s.moves {
+			s.finish(m)
+		}
+	}()
+	return s, nil
+}
+
+// send queues the move of the file encoded into the spool directory.
+// Files sent after wait, such as those of a timed out encode, are moved
+// right away.
+func (s *spooler) send(m spoolMove) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		s.finish(m)
+		return
+	}
+	s.moves <- m
+}
+
+// wait returns when all files sent have been moved.
+func (s *spooler) wait() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.moves)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *spooler) finish(m spoolMove) {
+	m.res.Output = displayPath(m.dst, m.name)
+	if err := s.move(m.name, m.dst); err != nil {
+		m.res.Output = ""
+		s.done(m.res, &OpError{Op: "write", Path: displayPath(m.dst, m.name), Err: err})
+		return
+	}
+	if removeSource {
+		if err := removeInput(m.src, m.srcName); err != nil {
+			log.Printf("删除原文件：%s 失败，错误：%v", m.res.Input, err)
+		}
+	}
+	s.done(m.res, nil)
+}
+
+// move takes name from the spool directory to dst, a local destination gets
+// it whole or not at all.
+func (s *spooler) move(name string, dst Storage) error {
+	from := s.dir.path(name)
+	local, ok := dst.(LocalStorage)
+	if ok && os.Rename(from, local.path(name)) == nil {
+		return nil
+	}
+	toName := name
+	if ok {
+		toName = name + ".spooling"
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := dst.Create(toName)
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffer(out, in, bufferFor(dst))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && toName != name {
+		if fInfo, serr := in.Stat(); serr == nil {
+			os.Chmod(local.path(toName), fInfo.Mode().Perm())
+		}
+		err = dst.Rename(toName, name)
+	}
+	if err != nil {
+		dst.Delete(toName)
+		return err
+	}
+	in.Close()
+	return os.Remove(from)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	os.Mkdir(out, 0755)
+	outStorage = LocalStorage(out)
+	removeSource = true
+	defer func() { outStorage, removeSource, spool = nil, false, nil }()
+	sum := new(summary)
+	var err error
+	if spool, err = newSpooler(filepath.Join(dir, "spool"), sum.add); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, []byte(name), 0644)
+		res, err := parseFile(file, ActionEncode)
+		if err != errSpooled {
+			t.Fatalf("%s: except errSpooled, but %v", name, err)
+		}
+		sum.add(res, err)
+	}
+	spool.wait()
+	if sum.encoded != 3 || sum.failed != 0 {
+		t.Fatalf("encoded %d, failed %d", sum.encoded, sum.failed)
+	}
+	if items, _ := os.ReadDir(out); len(items) != 3 {
+		t.Fatalf("except 3 files in %s, but %d", out, len(items))
+	}
+	if items, _ := os.ReadDir(filepath.Join(dir, "spool")); len(items) != 0 {
+		t.Fatalf("%d files left in the spool", len(items))
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatal("source kept after the move")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownStorage = errors.New("unknown storage")
+	ErrNotSupported   = errors.New("operation not supported")
+)
+
+type Entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// Storage is where files are read from and written to. Names are slash
+// separated and relative to the root of the storage.
+type Storage interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	List(dir string) ([]Entry, error)
+	Rename(oldname, newname string) error
+	Delete(name string) error
+	String() string
+}
+
+func NewStorage(target string) (Storage, error) {
+	if !strings.Contains(target, "://") {
+		return LocalStorage(target), nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return LocalStorage(u.Path), nil
+	case "http", "https":
+		return NewHTTPStorage(u), nil
+	case "s3":
+		b, err := NewS3Storage(u.Host, strings.Trim(u.Path, "/"))
+		if err != nil {
+			return nil, err
+		}
+		if err := b.setOptions(u.Query()); err != nil {
+			return nil, err
+		}
+		return b, nil
+	case "sftp":
+		return NewSFTPStorage(u), nil
+	case "webdav", "webdavs":
+		return NewWebDAVStorage(u), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStorage, u.Scheme)
+	}
+}
+
+// splitSource returns the storage holding name and the name within it.
+func splitSource(name string) (Storage, string) {
+	if isRemote(name) {
+		u, err := url.Parse(name)
+		if err == nil {
+			base := path.Base(u.Path)
+			// the query stays, presigned URLs carry their signature there
+			u.Path = path.Dir(u.Path)
+			return NewHTTPStorage(u), base
+		}
+	}
+	return LocalStorage(filepath.Dir(name)), filepath.Base(name)
+}
+
+func displayPath(st Storage, name string) string {
+	if local, ok := st.(LocalStorage); ok {
+		return filepath.Join(string(local), filepath.FromSlash(name))
+	}
+	return strings.TrimSuffix(st.String(), "/") + "/" + name
+}
+
+// storageHas reports whether name exists in st.
+func storageHas(st Storage, name string) bool {
+	if local, ok := st.(LocalStorage); ok {
+		_, err := os.Lstat(local.path(name))
+		return err == nil
+	}
+	f, err := st.Open(name)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// caseCollision reports whether name exists in the local st only because
+// the file system ignores case, no entry has exactly that name.
+func caseCollision(st Storage, name string) bool {
+	local, ok := st.(LocalStorage)
+	if !ok {
+		return false
+	}
+	p := local.path(name)
+	if _, err := os.Lstat(p); err != nil {
+		return false
+	}
+	items, err := os.ReadDir(filepath.Dir(p))
+	if err != nil {
+		return false
+	}
+	base := filepath.Base(p)
+	for _, item := range items {
+		if item.Name() == base {
+			return false
+		}
+	}
+	return true
+}
+
+// outputMode is the permissions of outputs, 0 takes those of the input.
+var outputMode os.FileMode
+
+// createOutput creates name in dst, a destination without write permission
+// is reported as a "create" error on dst rather than on the file.
+func createOutput(dst Storage, name string) (io.WriteCloser, error) {
+	fd, err := dst.Create(name)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, &OpError{Op: "create", Path: dst.String(), Err: err}
+	}
+	if err != nil {
+		return nil, &OpError{Op: "open", Path: displayPath(dst, name), Err: err}
+	}
+	return fd, nil
+}
+
+// setOutputMode gives a local output outputMode, or the permissions of a
+// local input with write access for the owner kept so the output can be
+// replaced later. It is best effort, some file systems have no modes.
+func setOutputMode(src Storage, name string, dst Storage, toName string) {
+	out, ok := dst.(LocalStorage)
+	if !ok {
+		return
+	}
+	mode := outputMode
+	if mode == 0 {
+		in, ok := src.(LocalStorage)
+		if !ok {
+			return
+		}
+		fInfo, err := os.Stat(in.path(name))
+		if err != nil {
+			return
+		}
+		mode = fInfo.Mode().Perm() | 0200
+	}
+	os.Chmod(out.path(toName), mode)
+}
+
+type LocalStorage string
+
+func (s LocalStorage) path(name string) string {
+	return filepath.Join(string(s), filepath.FromSlash(name))
+}
+
+func (s LocalStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+func (s LocalStorage) Create(name string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(s.path(name), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if onNetworkFS(filepath.Dir(f.Name())) {
+		return syncedFile{f}, nil
+	}
+	return f, nil
+}
+
+func (s LocalStorage) List(dir string) ([]Entry, error) {
+	items, err := os.ReadDir(s.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(items))
+	for _, item := range items {
+		info, err := item.Info()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Name:    item.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   item.IsDir(),
+		})
+	}
+	return entries, nil
+}
+
+func (s LocalStorage) Rename(oldname, newname string) error {
+	if onNetworkFS(filepath.Dir(s.path(newname))) {
+		return renameVerified(s.path(oldname), s.path(newname))
+	}
+	return os.Rename(s.path(oldname), s.path(newname))
+}
+
+func (s LocalStorage) Delete(name string) error {
+	return os.Remove(s.path(name))
+}
+
+func (s LocalStorage) String() string {
+	return string(s)
+}
+
+// HTTPStorage is a read-only storage on top of a plain web server. The query
+// of the base URL is sent with every request but not shown.
+type HTTPStorage struct {
+	base *url.URL
+}
+
+func NewHTTPStorage(u *url.URL) *HTTPStorage {
+	return &HTTPStorage{base: u}
+}
+
+func (s *HTTPStorage) url(name string) string {
+	u := *s.base
+	u.Path = path.Join("/", u.Path, name)
+	return u.String()
+}
+
+func (s *HTTPStorage) Open(name string) (io.ReadCloser, error) {
+	return NewHTTPReader(s.url(name))
+}
+
+func (s *HTTPStorage) Create(string) (io.WriteCloser, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *HTTPStorage) List(string) ([]Entry, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *HTTPStorage) Rename(string, string) error {
+	return ErrNotSupported
+}
+
+func (s *HTTPStorage) Delete(string) error {
+	return ErrNotSupported
+}
+
+func (s *HTTPStorage) String() string {
+	u := *s.base
+	u.RawQuery = ""
+	return u.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sumsFile is a SHA256SUMS file that gets a line for every encoded file, in
+// the format sha256sum -c reads. Paths are relative to the directory of the
+// file where possible.
+type sumsFile struct {
+	path string
+	dir  string
+	f    *os.File
+}
+
+// openSums opens path for appending, so runs into the same archive add to
+// one file.
+func openSums(path string) (*sumsFile, error) {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &sumsFile{path: path, dir: dir, f: f}, nil
+}
+
+func (s *sumsFile) add(res Result) error {
+	name := res.Output
+	if !isRemote(name) {
+		if abs, err := filepath.Abs(name); err == nil {
+			if rel, err := filepath.Rel(s.dir, abs); err == nil {
+				name = rel
+			}
+		}
+		name = filepath.ToSlash(name)
+	}
+	_, err := fmt.Fprintln(s.f, sumsLine(res.OutputSHA256, name))
+	return err
+}
+
+func (s *sumsFile) Close() error {
+	return s.f.Close()
+}
+
+// sumsLine formats a line the way GNU coreutils does, names holding a
+// backslash or a newline are escaped and the line starts with a backslash.
+func sumsLine(sum, name string) string {
+	if !strings.ContainsAny(name, "\\\n\r") {
+		return sum + "  " + name
+	}
+	name = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r").Replace(name)
+	return "\\" + sum + "  " + name
+}
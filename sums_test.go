@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteSums(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "data", "a.txt")
+	os.MkdirAll(filepath.Dir(file), 0755)
+	os.WriteFile(file, []byte("hello"), 0644)
+	hashOutput = true
+	defer func() { hashOutput = false }()
+	sums, err := openSums(filepath.Join(dir, "SHA256SUMS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := &summary{sums: sums}
+	sum.add(parseFile(file, ActionEncode))
+	sums.Close()
+
+	b, _ := os.ReadFile(filepath.Join(dir, "SHA256SUMS"))
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "data/") {
+		t.Fatalf("unexpected sums file %q", b)
+	}
+	enc, _ := os.ReadFile(filepath.Join(dir, filepath.FromSlash(fields[1])))
+	want := sha256.Sum256(enc)
+	if fields[0] != hex.EncodeToString(want[:]) {
+		t.Fatalf("sum %s, want %x", fields[0], want)
+	}
+	if got := sumsLine("00", "a\\b"); got != `\00  a\\b` {
+		t.Errorf("escaped line %q", got)
+	}
+}
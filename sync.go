@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+const syncDBName = ".neo-sync.json"
+
+// syncEntry remembers a source file and the file it was mirrored to, encoded
+// or, with -decode, decoded.
+type syncEntry struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	Output string `json:"output"`
+}
+
+type syncDB struct {
+	Files   map[string]*syncEntry `json:"files"`
+	Journal *journalMark          `json:"journal,omitempty"`
+
+	// changes are what the change journal saw since Journal, nil without
+	// one
+	changes *journalChanges
+}
+
+type syncStats struct {
+	skipped, moved, deleted int
+}
+
+func runSync(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	decode := fs.Bool("decode", false, "反向同步：将源目录中的 NEO 文件还原至目标目录")
+	del := fs.Bool("delete", false, "删除源目录中已不存在的文件对应的同步结果")
+	jsonOut := fs.Bool("json", false, "以 JSON 格式向标准输出打印每个文件的处理结果")
+	fs.BoolVar(&encodeEncryptMeta, "encrypt-meta", false, "编码时同时加密 CRC32 等元数据，旧版本将无法校验这些文件")
+	fs.BoolVar(&encodeStealth, "stealth", false, "编码时使用由密码或密钥文件加盐慢速派生的文件头标识，需要同样的密码才能识别")
+	password := fs.String("password", "", "密码，用于 -stealth 编码及识别此类文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	exts := fs.String("ext", ".neo", "编码结果的扩展名，以逗号分隔时随机选取")
+	fs.StringVar(&nameScheme, "scheme", "random", "编码结果的命名方式：random 随机，hash 取结果内容的 SHA-256")
+	journal := fs.Bool("journal", false, "借助文件系统的变更日志（Windows NTFS 的 USN 日志，需要管理员权限）跳过未变化的文件，不再计算它们的 SHA-256")
+	fs.StringVar(&headerCacheFile, "header-cache", "", "反向同步时将解析过的文件头缓存至此文件，目录较大时可加快之后的同步")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return cmd.usageError(fs, "sync needs a source and a destination directory")
+	}
+	var err error
+	if outputExts, err = parseExts(*exts); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if err := checkScheme(nameScheme); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	if encodeStealth && key == nil {
+		return cmd.usageError(fs, "-stealth needs -password or -keyfile")
+	}
+
+	sum := new(summary)
+	if *jsonOut {
+		sum.json = json.NewEncoder(os.Stdout)
+	}
+	syncJournal = *journal && !*decode
+	if *decode {
+		// a changed NEO file replaces its decoded copy
+		decodeConflict = ConflictOverwrite
+	}
+	st, err := syncDirs(fs.Arg(0), fs.Arg(1), *decode, *del, sum)
+	if *decode {
+		log.Printf("完成：解码 %d 个，跳过 %d 个，删除 %d 个，失败 %d 个", sum.decoded, st.skipped, st.deleted, sum.failed)
+	} else {
+		log.Printf("完成：编码 %d 个，移动 %d 个，跳过 %d 个，删除 %d 个，失败 %d 个", sum.encoded, st.moved, st.skipped, st.deleted, sum.failed)
+	}
+	if err != nil {
+		return err
+	}
+	return sum.err()
+}
+
+func loadSyncDB(path string) (*syncDB, error) {
+	db := &syncDB{Files: make(map[string]*syncEntry)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, db); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if db.Files == nil {
+		db.Files = make(map[string]*syncEntry)
+	}
+	return db, nil
+}
+
+func (db *syncDB) save(path string) error {
+	b, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// syncJournal makes syncDirs trust the change journal of the source.
+var syncJournal bool
+
+// syncDirs mirrors the plain files under src as encoded files under dst,
+// files whose size and SHA-256 are unchanged since the last run are skipped.
+// With decode the NEO files under src are mirrored as decoded files instead.
+func syncDirs(src, dst string, decode, del bool, sum *summary) (syncStats, error) {
+	var st syncStats
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return st, err
+	}
+	dbPath := filepath.Join(dst, syncDBName)
+	db, err := loadSyncDB(dbPath)
+	if err != nil {
+		return st, err
+	}
+	if decode && headerCacheFile != "" {
+		if err := headers.load(headerCacheFile); err != nil {
+			log.Printf("读取文件头缓存：%s 失败，错误：%v", headerCacheFile, err)
+		}
+	}
+	var mark *journalMark
+	if syncJournal {
+		if db.changes, mark, err = readJournal(src, db.Journal); err != nil {
+			log.Printf("读取变更日志失败，将检查所有文件，错误：%v", err)
+		}
+	}
+	failed := sum.failed
+	absDst, _ := filepath.Abs(dst)
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("读取目录：%s 失败，错误：%v", path, err)
+			sum.failed++
+			return nil
+		}
+		if d.IsDir() {
+			// a destination inside the source must not be mirrored into itself
+			if abs, _ := filepath.Abs(path); abs == absDst {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+		sync := syncFile
+		if decode {
+			sync = syncDecodeFile
+		}
+		sync(src, dst, rel, db, sum, &st)
+		return nil
+	})
+	if err == nil && del {
+		st.deleted = syncDelete(dst, db, seen, sum)
+	}
+	if mark != nil && err == nil && sum.failed == failed {
+		// after failures the next sync must see the same changes again
+		db.Journal = mark
+	}
+	if saveErr := db.save(dbPath); err == nil {
+		err = saveErr
+	}
+	if decode && headerCacheFile != "" {
+		if err := headers.save(headerCacheFile); err != nil {
+			log.Printf("保存文件头缓存：%s 失败，错误：%v", headerCacheFile, err)
+		}
+	}
+	return st, err
+}
+
+// syncFile encodes src/rel into the matching directory of dst unless the
+// recorded entry is still current. A file that was only renamed or moved
+// keeps its encoded content, just the header and location are updated.
+func syncFile(src, dst, rel string, db *syncDB, sum *summary, st *syncStats) {
+	path := filepath.Join(src, filepath.FromSlash(rel))
+	old := db.Files[rel]
+	if old != nil && db.changes != nil && !db.changes.changed(path) {
+		fInfo, err := os.Stat(path)
+		if err == nil && fInfo.Size() == old.Size {
+			if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(old.Output))); err == nil {
+				st.skipped++
+				return
+			}
+		}
+	}
+	cur, err := auditFile(path)
+	if err != nil {
+		sum.add(Result{Action: ActionEncode, Input: path}, &OpError{Op: "checksum", Path: path, Err: err})
+		return
+	}
+	if old != nil && old.Size == cur.Size && old.SHA256 == cur.SHA256 {
+		if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(old.Output))); err == nil {
+			st.skipped++
+			return
+		}
+	}
+	outDir := filepath.Dir(filepath.Join(dst, filepath.FromSlash(rel)))
+	if err := os.MkdirAll(outDir, 0777); err != nil {
+		sum.add(Result{Action: ActionEncode, Input: path}, &OpError{Op: "open", Path: outDir, Err: err})
+		return
+	}
+	if old == nil && syncMove(src, dst, rel, cur, db) {
+		st.moved++
+		return
+	}
+	res, err := EncodeFile(LocalStorage(filepath.Dir(path)), filepath.Base(path), LocalStorage(outDir))
+	sum.add(res, err)
+	if err != nil {
+		return
+	}
+	output, err := filepath.Rel(dst, res.Output)
+	if err != nil {
+		return
+	}
+	output = filepath.ToSlash(output)
+	if old != nil && old.Output != output {
+		os.Remove(filepath.Join(dst, filepath.FromSlash(old.Output)))
+	}
+	db.Files[rel] = &syncEntry{Size: cur.Size, SHA256: cur.SHA256, Output: output}
+}
+
+// syncMove looks for a recorded file with the same content whose source is
+// gone and reuses its encoded file for rel by rewriting the filename in the
+// header, it reports whether such a file was found.
+func syncMove(src, dst, rel string, cur *auditEntry, db *syncDB) bool {
+	var from string
+	for other, e := range db.Files {
+		if e.Size != cur.Size || e.SHA256 != cur.SHA256 {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(src, filepath.FromSlash(other))); os.IsNotExist(err) {
+			from = other
+			break
+		}
+	}
+	if from == "" {
+		return false
+	}
+	oldPath := filepath.Join(dst, filepath.FromSlash(db.Files[from].Output))
+	newPath := filepath.Join(filepath.Dir(filepath.Join(dst, filepath.FromSlash(rel))), filepath.Base(oldPath))
+	if oldPath != newPath {
+		if _, err := os.Lstat(newPath); err == nil {
+			return false
+		}
+	}
+	name := path.Base(rel)
+	err := rewriteHeader(oldPath, func(hdr *codec.NeoHeader) {
+		hdr.OriginalFilename = name
+	})
+	if err != nil {
+		log.Printf("更新文件：%s 头部失败，将重新编码，错误：%v", oldPath, err)
+		return false
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		log.Printf("移动文件：%s 失败，将重新编码，错误：%v", oldPath, err)
+		return false
+	}
+	if nameScheme == "hash" {
+		// the content changed with the header, so does its hash
+		if p, err := renameNeoFile(newPath, "hash", false); err == nil && p != "" {
+			newPath = p
+		}
+	}
+	output, _ := filepath.Rel(dst, newPath)
+	delete(db.Files, from)
+	db.Files[rel] = &syncEntry{Size: cur.Size, SHA256: cur.SHA256, Output: filepath.ToSlash(output)}
+	log.Printf("移动：%s → %s", from, rel)
+	return true
+}
+
+// syncDecodeFile decodes the NEO file src/rel into the matching directory of
+// dst unless a decoded copy matching the CRC32 in its header exists.
+func syncDecodeFile(src, dst, rel string, db *syncDB, sum *summary, st *syncStats) {
+	path := filepath.Join(src, filepath.FromSlash(rel))
+	srcDir, name := filepath.Split(path)
+	hdr, err := headers.header(path)
+	if err != nil {
+		sum.add(Result{Action: ActionDecode, Input: path}, &OpError{Op: "header", Path: path, Err: err})
+		return
+	}
+	if hdr == nil {
+		return
+	}
+	if !usableName(hdr.OriginalFilename) {
+		// the name comes from the file, it must not lead out of dst
+		sum.add(Result{Action: ActionDecode, Input: path}, &OpError{Op: "header", Path: path, Err: fmt.Errorf("%w: %q", ErrUnusableName, hdr.OriginalFilename)})
+		return
+	}
+	outDir := filepath.Dir(filepath.Join(dst, filepath.FromSlash(rel)))
+	old := db.Files[rel]
+	hs := newHashSet(false, false)
+	if err := hashFile(context.Background(), LocalStorage(outDir), hdr.OriginalFilename, hs); err == nil && !hdr.NoChecksum && hs.crc32.Sum32() == hdr.Crc32 {
+		// a copy decoded by hand is adopted so that -delete can remove it later
+		if old == nil {
+			output := filepath.Join(outDir, hdr.OriginalFilename)
+			if fInfo, err := os.Stat(output); err == nil {
+				output, _ = filepath.Rel(dst, output)
+				db.Files[rel] = &syncEntry{Size: fInfo.Size(), Output: filepath.ToSlash(output)}
+			}
+		}
+		st.skipped++
+		return
+	}
+	if err := os.MkdirAll(outDir, 0777); err != nil {
+		sum.add(Result{Action: ActionDecode, Input: path}, &OpError{Op: "open", Path: outDir, Err: err})
+		return
+	}
+	res, err := DecodeFile(LocalStorage(srcDir), name, LocalStorage(outDir))
+	sum.add(res, err)
+	if err != nil {
+		return
+	}
+	output, err := filepath.Rel(dst, res.Output)
+	if err != nil {
+		return
+	}
+	output = filepath.ToSlash(output)
+	if old != nil && old.Output != output {
+		os.Remove(filepath.Join(dst, filepath.FromSlash(old.Output)))
+	}
+	db.Files[rel] = &syncEntry{Size: res.Bytes, SHA256: res.SHA256, Output: output}
+}
+
+func readNeoHeader(path string) (*codec.NeoHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return newNeoReader(f).Header()
+}
+
+// syncDelete removes the encoded files whose source is gone.
+func syncDelete(dst string, db *syncDB, seen map[string]bool, sum *summary) int {
+	var gone []string
+	for rel := range db.Files {
+		if !seen[rel] {
+			gone = append(gone, rel)
+		}
+	}
+	sort.Strings(gone)
+	deleted := 0
+	for _, rel := range gone {
+		out := filepath.FromSlash(db.Files[rel].Output)
+		if out == "" || filepath.IsAbs(out) || out == ".." || strings.HasPrefix(out, ".."+string(filepath.Separator)) {
+			log.Printf("删除文件：%s 失败，错误：不在目标目录中", out)
+			sum.failed++
+			continue
+		}
+		output := filepath.Join(dst, out)
+		if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+			log.Printf("删除文件：%s 失败，错误：%v", output, err)
+			sum.failed++
+			continue
+		}
+		log.Printf("删除：%s（源文件 %s 已不存在）", output, rel)
+		delete(db.Files, rel)
+		deleted++
+	}
+	return deleted
+}
+
+// rewriteHeader applies update to the header of the NEO file at path without
+// touching the content. A header of the same size is written in place, else
+// the content has to be copied behind the new header.
+func rewriteHeader(path string, update func(hdr *codec.NeoHeader)) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	neoRd := newNeoReader(f)
+	hdr, err := neoRd.Header()
+	if err != nil {
+		return &OpError{Op: "header", Path: path, Err: err}
+	}
+	size := int64(neoRd.HeaderSize())
+	if len(hdr.OriginalHeader) != neoRd.OriginalHeaderLen() {
+		return &OpError{Op: "header", Path: path, Err: errors.New("original header too large to rewrite")}
+	}
+	update(hdr)
+	b, err := hdr.Marshall()
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) == size {
+		if _, err := f.WriteAt(b, 0); err != nil {
+			return &OpError{Op: "write", Path: path, Err: err}
+		}
+		return f.Close()
+	}
+
+	tmp := path + ".rewriting"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return &OpError{Op: "open", Path: tmp, Err: err}
+	}
+	_, err = out.Write(b)
+	if err == nil {
+		_, err = io.Copy(out, io.NewSectionReader(f, size, 1<<63-1-size))
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return &OpError{Op: "write", Path: tmp, Err: err}
+	}
+	f.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return &OpError{Op: "rename", Path: tmp, Err: err}
+	}
+	return nil
+}
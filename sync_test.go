@@ -0,0 +1,252 @@
+package main
+
+import (
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+func TestSyncDirs(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(src, "sub"), 0777)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(name)), []byte("content of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sync := func(del bool) (*summary, syncStats) {
+		sum := new(summary)
+		st, err := syncDirs(src, dst, false, del, sum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum, st
+	}
+	if sum, _ := sync(false); sum.encoded != 2 || sum.failed != 0 {
+		t.Fatalf("except 2 encoded, but %+v", sum)
+	}
+	if sum, st := sync(false); sum.encoded != 0 || st.skipped != 2 {
+		t.Fatalf("except 2 skipped, but %+v %+v", sum, st)
+	}
+
+	db, _ := loadSyncDB(filepath.Join(dst, syncDBName))
+	oldB := filepath.Join(dst, filepath.FromSlash(db.Files["sub/b.txt"].Output))
+	os.WriteFile(filepath.Join(src, "sub", "b.txt"), []byte("changed"), 0644)
+	if sum, st := sync(false); sum.encoded != 1 || st.skipped != 1 {
+		t.Fatalf("except 1 encoded, but %+v %+v", sum, st)
+	}
+	if _, err := os.Stat(oldB); !os.IsNotExist(err) {
+		t.Fatalf("old output of a changed file should be removed, but %v", err)
+	}
+
+	os.Remove(filepath.Join(src, "a.txt"))
+	if _, st := sync(false); st.deleted != 0 {
+		t.Fatalf("except nothing deleted without -delete, but %d", st.deleted)
+	}
+	if _, st := sync(true); st.deleted != 1 {
+		t.Fatalf("except 1 deleted, but %d", st.deleted)
+	}
+	db, _ = loadSyncDB(filepath.Join(dst, syncDBName))
+	if len(db.Files) != 1 || db.Files["sub/b.txt"] == nil {
+		t.Fatalf("unexpected sync db: %+v", db.Files)
+	}
+}
+
+func TestSyncFile_Journal(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	file := filepath.Join(src, "a.txt")
+	os.WriteFile(file, []byte("content"), 0644)
+	db := &syncDB{Files: make(map[string]*syncEntry)}
+	sum, st := new(summary), new(syncStats)
+	syncFile(src, dst, "a.txt", db, sum, st)
+	if sum.encoded != 1 {
+		t.Fatalf("except 1 encoded, but %+v", sum)
+	}
+
+	// a change of the same size is only seen through the journal
+	os.WriteFile(file, []byte("CONTENT"), 0644)
+	db.changes = &journalChanges{names: map[string]bool{"b.txt": true}}
+	syncFile(src, dst, "a.txt", db, sum, st)
+	if sum.encoded != 1 || st.skipped != 1 {
+		t.Fatalf("except skipped, but %+v %+v", sum, st)
+	}
+	db.changes.names["a.txt"] = true
+	syncFile(src, dst, "a.txt", db, sum, st)
+	if sum.encoded != 2 {
+		t.Fatalf("except encoded again, but %+v", sum)
+	}
+}
+
+func TestSyncDirs_Move(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(src, "sub"), 0777)
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("content longer than the moved header"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncDirs(src, dst, false, false, new(summary)); err != nil {
+		t.Fatal(err)
+	}
+	// the first name keeps the header size, the second one does not
+	for _, name := range []string{"sub/b.txt", "sub/much-longer-name.txt"} {
+		db, _ := loadSyncDB(filepath.Join(dst, syncDBName))
+		var from string
+		for rel := range db.Files {
+			from = rel
+		}
+		os.Rename(filepath.Join(src, filepath.FromSlash(from)), filepath.Join(src, filepath.FromSlash(name)))
+		sum := new(summary)
+		st, err := syncDirs(src, dst, false, true, sum)
+		if err != nil || sum.encoded != 0 || st.moved != 1 || st.deleted != 0 {
+			t.Fatalf("except 1 moved, but %+v %+v %v", sum, st, err)
+		}
+		db, _ = loadSyncDB(filepath.Join(dst, syncDBName))
+		output := filepath.Join(dst, filepath.FromSlash(db.Files[name].Output))
+		hdr, err := readNeoHeader(output)
+		if err != nil || hdr.OriginalFilename != filepath.Base(name) {
+			t.Fatalf("unexpected header %+v %v", hdr, err)
+		}
+		if _, err := VerifyFile(LocalStorage(filepath.Dir(output)), filepath.Base(output)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncDirs_Decode(t *testing.T) {
+	plain, encoded, decoded := t.TempDir(), t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(plain, "sub"), 0777)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if err := os.WriteFile(filepath.Join(plain, filepath.FromSlash(name)), []byte("content of "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := syncDirs(plain, encoded, false, false, new(summary)); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := new(summary)
+	if _, err := syncDirs(encoded, decoded, true, false, sum); err != nil || sum.decoded != 2 {
+		t.Fatalf("except 2 decoded, but %+v %v", sum, err)
+	}
+	b, err := os.ReadFile(filepath.Join(decoded, "sub", "b.txt"))
+	if err != nil || string(b) != "content of sub/b.txt" {
+		t.Fatalf("unexpected decoded content %q %v", b, err)
+	}
+	sum = new(summary)
+	if st, err := syncDirs(encoded, decoded, true, false, sum); err != nil || sum.decoded != 0 || st.skipped != 2 {
+		t.Fatalf("except 2 skipped, but %+v %+v %v", sum, st, err)
+	}
+
+	// a damaged decoded copy no longer matches the CRC32 and is decoded again,
+	// over it as runSync sets
+	defer func() { decodeConflict = ConflictRename }()
+	decodeConflict = ConflictOverwrite
+	os.WriteFile(filepath.Join(decoded, "a.txt"), []byte("damaged"), 0644)
+	sum = new(summary)
+	if _, err := syncDirs(encoded, decoded, true, false, sum); err != nil || sum.decoded != 1 {
+		t.Fatalf("except 1 decoded, but %+v %v", sum, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(decoded, "a.txt")); string(b) != "content of a.txt" {
+		t.Fatalf("except a.txt decoded again, but %q", b)
+	}
+
+	os.Remove(filepath.Join(plain, "a.txt"))
+	if _, err := syncDirs(plain, encoded, false, true, new(summary)); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := syncDirs(encoded, decoded, true, true, new(summary)); err != nil || st.deleted != 1 {
+		t.Fatalf("except 1 deleted, but %+v %v", st, err)
+	}
+	if _, err := os.Stat(filepath.Join(decoded, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("a.txt should be deleted, but %v", err)
+	}
+}
+
+func TestHeaderCache(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "a.txt")
+	os.WriteFile(plain, []byte("not a NEO file"), 0644)
+	res, err := EncodeFile(LocalStorage(dir), "a.txt", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newHeaderCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if hdr, err := c.header(res.Output); err != nil || hdr == nil || hdr.OriginalFilename != "a.txt" || hdr.OriginalHeader != nil {
+				t.Errorf("unexpected header %+v %v", hdr, err)
+			}
+			if hdr, err := c.header(plain); err != nil || hdr != nil {
+				t.Errorf("except no header, but %+v %v", hdr, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	file := filepath.Join(dir, "cache.json")
+	if err := c.save(file); err != nil {
+		t.Fatal(err)
+	}
+	c = newHeaderCache()
+	if err := c.load(file); err != nil || len(c.files) != 2 {
+		t.Fatalf("except 2 entries, but %d %v", len(c.files), err)
+	}
+	abs, _ := filepath.Abs(res.Output)
+	c.files[abs].Header.OriginalFilename = "cached"
+	if hdr, _ := c.header(res.Output); hdr.OriginalFilename != "cached" {
+		t.Fatalf("except the cached header, but %q", hdr.OriginalFilename)
+	}
+
+	// a changed file is parsed again
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(res.Output, later, later)
+	if hdr, _ := c.header(res.Output); hdr.OriginalFilename != "a.txt" {
+		t.Fatalf("except a.txt, but %q", hdr.OriginalFilename)
+	}
+}
+
+func TestSyncDirs_DecodeUnusableName(t *testing.T) {
+	root := t.TempDir()
+	encoded, decoded := filepath.Join(root, "enc"), filepath.Join(root, "a", "dec")
+	os.MkdirAll(encoded, 0777)
+	os.MkdirAll(decoded, 0777)
+	// a file decoded by hand next to the destination, which the crafted
+	// name below points at
+	victim := filepath.Join(root, "a", "victim.txt")
+	content := []byte("not in the destination")
+	os.WriteFile(victim, content, 0644)
+	f, err := os.Create(filepath.Join(encoded, "x.neo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := codec.NewNeoWriter(f, codec.DefaultHeaderLen, "../victim.txt", crc32.ChecksumIEEE(content))
+	w.Write(content)
+	w.Close()
+	f.Close()
+
+	sum := new(summary)
+	if _, err := syncDirs(encoded, decoded, true, false, sum); err != nil || sum.failed != 1 {
+		t.Fatalf("except the crafted name to fail, but %+v %v", sum, err)
+	}
+	db, err := loadSyncDB(filepath.Join(decoded, syncDBName))
+	if err != nil || len(db.Files) != 0 {
+		t.Fatalf("except nothing recorded, but %+v %v", db, err)
+	}
+
+	// an entry of an old database pointing out of dst is not deleted
+	db.Files["gone.neo"] = &syncEntry{Output: "../victim.txt"}
+	if n := syncDelete(decoded, db, map[string]bool{}, sum); n != 0 {
+		t.Fatalf("except nothing deleted, but %d", n)
+	}
+	if _, err := os.Stat(victim); err != nil {
+		t.Fatal("except the file out of dst to be left alone")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// openTarInput opens the tar stream named on the command line, - is stdin.
+func openTarInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
+
+// encodeTar encodes every regular file of the tar stream r into dst. Local
+// destinations get the directories of the archive, other storages receive
+// all files at their root.
+func encodeTar(r io.Reader, dst Storage, sum *summary) error {
+	tmp, err := os.MkdirTemp("", "neo-tar")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		sum.add(encodeTarEntry(hdr, tr, tmp, dst))
+	}
+}
+
+// encodeTarEntry spools the entry to tmp first since the header of a NEO
+// file holds the checksum of the whole content.
+func encodeTarEntry(hdr *tar.Header, r io.Reader, tmp string, dst Storage) (Result, error) {
+	name := path.Clean("/" + hdr.Name)[1:]
+	base := path.Base(name)
+	spool := filepath.Join(tmp, base)
+	res := Result{Action: ActionEncode, Input: hdr.Name}
+	f, err := os.Create(spool)
+	if err != nil {
+		return res, &OpError{Op: "open", Path: spool, Err: err}
+	}
+	defer os.Remove(spool)
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, &OpError{Op: "read", Path: hdr.Name, Err: err}
+	}
+	if local, ok := dst.(LocalStorage); ok {
+		dir := filepath.Join(string(local), filepath.FromSlash(path.Dir(name)))
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return res, &OpError{Op: "open", Path: dir, Err: err}
+		}
+		dst = LocalStorage(dir)
+	}
+	res, err = EncodeFile(LocalStorage(tmp), base, dst)
+	res.Input = hdr.Name
+	return res, err
+}
+
+// decodeTar writes the decoded content of the NEO files among args to w as a
+// tar archive, files found in a directory keep their path below it. Other
+// files are skipped.
+func decodeTar(w io.Writer, args []string, recursive bool, sum *summary) error {
+	tw := tar.NewWriter(w)
+	for _, item := range args {
+		fInfo, err := os.Stat(item)
+		if err != nil {
+			log.Printf("获取文件：%s 信息失败，错误：%v", item, err)
+			sum.failed++
+			continue
+		}
+		files, root := []string{item}, filepath.Dir(item)
+		if fInfo.IsDir() {
+			files, root = collectDir(item, recursive, sum), item
+		}
+		for _, file := range files {
+			if ok, err := IsNeoFile(LocalStorage(filepath.Dir(file)), filepath.Base(file)); err != nil || !ok {
+				if err != nil {
+					sum.add(Result{Action: ActionDecode, Input: file}, &OpError{Op: "detect", Path: file, Err: err})
+				}
+				continue
+			}
+			res, err := decodeTarEntry(tw, file, root)
+			sum.add(res, err)
+			// the archive is broken once an entry is cut short
+			var opErr *OpError
+			if errors.As(err, &opErr) && opErr.Op == "write" {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// decodeTarEntry adds the decoded file to tw. The entry is written while
+// decoding, so a checksum error is only found after the content is in the
+// archive, a write error means the entry is incomplete.
+func decodeTarEntry(tw *tar.Writer, file, root string) (res Result, err error) {
+	start := time.Now()
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+	res = Result{Action: ActionDecode, Input: file}
+	f, err := os.Open(file)
+	if err != nil {
+		return res, &OpError{Op: "open", Path: file, Err: err}
+	}
+	defer f.Close()
+	fInfo, err := f.Stat()
+	if err != nil {
+		return res, &OpError{Op: "open", Path: file, Err: err}
+	}
+	neoRd := newNeoReader(f)
+	hdr, err := neoRd.Header()
+	if err != nil {
+		return res, &OpError{Op: "header", Path: file, Err: err}
+	}
+	res.OriginalName = hdr.OriginalFilename
+	res.Checksum = hdr.Crc32
+	res.Warnings = hdr.Warnings
+	rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(file), hdr.OriginalFilename))
+	if err != nil {
+		return res, err
+	}
+	res.Output = filepath.ToSlash(rel)
+	size := fInfo.Size() - int64(neoRd.HeaderSize()) + int64(neoRd.OriginalHeaderLen())
+	err = tw.WriteHeader(&tar.Header{
+		Name:    res.Output,
+		Mode:    0644,
+		Size:    size,
+		ModTime: fInfo.ModTime(),
+	})
+	if err != nil {
+		return res, &OpError{Op: "write", Path: res.Output, Err: err}
+	}
+	hs := newHashSet(hdr.SHA256 != nil, hdr.XXH64 != nil)
+	var body io.Reader = neoRd
+	if !noVerify {
+		body = io.TeeReader(neoRd, hs)
+	}
+	res.Bytes, err = io.Copy(tw, body)
+	if err == nil && res.Bytes != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return res, &OpError{Op: "write", Path: res.Output, Err: err}
+	}
+	if noVerify {
+		return res, nil
+	}
+	return res, verifyChecksums(&res, hdr, hs)
+}
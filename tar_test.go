@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestTar(t *testing.T, files map[string]string) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestEncodeTar(t *testing.T) {
+	files := map[string]string{
+		"a.txt":     "content of the first file",
+		"sub/b.txt": "content of the second file",
+	}
+	dir := t.TempDir()
+	sum := new(summary)
+	if err := encodeTar(writeTestTar(t, files), LocalStorage(dir), sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum.encoded != 2 || sum.failed != 0 {
+		t.Fatalf("except 2 encoded, but %+v", sum)
+	}
+	for name, content := range files {
+		st := LocalStorage(filepath.Join(dir, filepath.Dir(filepath.FromSlash(name))))
+		neoName := findNeoFile(t, st)
+		if _, err := DecodeFile(st, neoName, st); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || string(b) != content {
+			t.Fatalf("unexpected content of %s: %q %v", name, b, err)
+		}
+	}
+}
+
+func TestDecodeTar(t *testing.T) {
+	files := map[string]string{
+		"a.txt":     "content of the first file",
+		"sub/b.txt": "content of the second file",
+	}
+	dir := t.TempDir()
+	if err := encodeTar(writeTestTar(t, files), LocalStorage(dir), new(summary)); err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	sum := new(summary)
+	if err := decodeTar(buf, []string{dir}, true, sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum.decoded != 2 || sum.failed != 0 {
+		t.Fatalf("except 2 decoded, but %+v", sum)
+	}
+	tr := tar.NewReader(buf)
+	got := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.Name] = string(b)
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Fatalf("except %v, but %v", files, got)
+	}
+}
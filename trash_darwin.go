@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// moveToTrash asks Finder to move path to the Trash, which keeps "Put Back"
+// working.
+func moveToTrash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("osascript", "-e",
+		fmt.Sprintf("tell application \"Finder\" to delete POSIX file %q", abs)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("trash %s: %v: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
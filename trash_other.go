@@ -0,0 +1,104 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// moveToTrash moves path to the trash as the freedesktop.org specification
+// lays it out: the home trash when path is on the same file system, else
+// .Trash-UID at the top of the file system path is on.
+func moveToTrash(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dev, err := deviceOf(abs)
+	if err != nil {
+		return err
+	}
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	trash := filepath.Join(data, "Trash")
+	if err := os.MkdirAll(trash, 0700); err != nil {
+		return err
+	}
+	if d, err := deviceOf(trash); err == nil && d == dev {
+		return trashInto(trash, abs, abs)
+	}
+	top := filepath.Dir(abs)
+	for {
+		parent := filepath.Dir(top)
+		if d, err := deviceOf(parent); parent == top || err != nil || d != dev {
+			break
+		}
+		top = parent
+	}
+	rel, err := filepath.Rel(top, abs)
+	if err != nil {
+		return err
+	}
+	return trashInto(filepath.Join(top, fmt.Sprintf(".Trash-%d", os.Getuid())), abs, rel)
+}
+
+func deviceOf(path string) (uint64, error) {
+	fInfo, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("%s: no device number", path)
+	}
+	return uint64(st.Dev), nil
+}
+
+// trashInto moves abs into trash, infoPath is what its .trashinfo records.
+func trashInto(trash, abs, infoPath string) error {
+	files, info := filepath.Join(trash, "files"), filepath.Join(trash, "info")
+	for _, dir := range []string{files, info} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	base := filepath.Base(abs)
+	ext := filepath.Ext(base)
+	name := base
+	for i := 2; ; i++ {
+		// the info file is created first and exclusively, it reserves the name
+		f, err := os.OpenFile(filepath.Join(info, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			name = fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), i, ext)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(f, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+			(&url.URL{Path: filepath.ToSlash(infoPath)}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(abs, filepath.Join(files, name))
+		}
+		if err != nil {
+			os.Remove(filepath.Join(info, name+".trashinfo"))
+		}
+		return err
+	}
+}
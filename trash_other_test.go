@@ -0,0 +1,62 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMoveToTrash(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	for i := 0; i < 2; i++ {
+		file := filepath.Join(dir, "a b.txt")
+		os.WriteFile(file, []byte("content"), 0644)
+		if err := moveToTrash(file); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(file); !os.IsNotExist(err) {
+			t.Fatalf("except %s gone, but %v", file, err)
+		}
+	}
+	trash := filepath.Join(dir, "data", "Trash")
+	for _, name := range []string{"a b.txt", "a b.2.txt"} {
+		if b, err := os.ReadFile(filepath.Join(trash, "files", name)); err != nil || string(b) != "content" {
+			t.Fatalf("%s: unexpected content %q %v", name, b, err)
+		}
+		info, err := os.ReadFile(filepath.Join(trash, "info", name+".trashinfo"))
+		if err != nil || !strings.Contains(string(info), "Path="+filepath.ToSlash(dir)+"/a%20b.txt\n") {
+			t.Fatalf("%s: unexpected info %q %v", name, info, err)
+		}
+	}
+}
+
+func TestParseFile_RemoveSource(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	removeSource, useTrash = true, true
+	defer func() { removeSource, useTrash = false, false }()
+	file := filepath.Join(dir, "a.txt")
+	os.WriteFile(file, []byte("content"), 0644)
+	res, err := parseFile(file, ActionEncode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(file); !os.IsNotExist(err) {
+		t.Fatalf("except the source removed, but %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "data", "Trash", "files", "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	useTrash = false
+	if _, err := parseFile(res.Output, ActionDecode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(res.Output); !os.IsNotExist(err) {
+		t.Fatalf("except the NEO file removed, but %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// moveToTrash sends path to the recycle bin.
+func moveToTrash(path string) error {
+	script := fmt.Sprintf(`Add-Type -AssemblyName Microsoft.VisualBasic;`+
+		`[Microsoft.VisualBasic.FileIO.FileSystem]::DeleteFile(%s, 'OnlyErrorDialogs', 'SendToRecycleBin')`, psQuote(path))
+	out, err := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("recycle %s: %v: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrNoTray is returned by neo tray where no program to show the icon is
+// found.
+var ErrNoTray = errors.New("no notification area helper found, use neo watch -control instead")
+
+// runTray runs neo watch with an icon in the notification area whose menu
+// pauses and resumes it. The icon is shown by a helper talking to the
+// control endpoint of the watcher, quitting it stops neo tray.
+func runTray(cmd *command, args []string) error {
+	for _, arg := range args {
+		if name := strings.TrimLeft(arg, "-"); arg != name && (name == "control" || strings.HasPrefix(name, "control=")) {
+			return cmd.usageError(cmd.flagSet(), "tray sets -control itself")
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	helper, err := trayHelper("http://" + l.Addr().String())
+	if err != nil {
+		return err
+	}
+	watchDone := make(chan error, 1)
+	go func() {
+		watchDone <- runWatchOn(cmd, args, l)
+	}()
+	if err := helper.Start(); err != nil {
+		return err
+	}
+	helperDone := make(chan error, 1)
+	go func() { helperDone <- helper.Wait() }()
+	select {
+	case err := <-watchDone:
+		helper.Process.Kill()
+		return err
+	case err := <-helperDone:
+		if err != nil {
+			log.Printf("托盘图标退出，错误：%v", err)
+		}
+		return nil
+	}
+}
+
+// trayHelper returns the command showing the icon of neo tray for the
+// control endpoint at url.
+func trayHelper(url string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "windows":
+		script := fmt.Sprintf(`Add-Type -AssemblyName System.Windows.Forms;`+
+			`$url = %s;`+
+			`$n = New-Object System.Windows.Forms.NotifyIcon;`+
+			`$n.Icon = [System.Drawing.SystemIcons]::Shield;`+
+			`$n.Text = 'NEO：监视中';`+
+			`$m = New-Object System.Windows.Forms.ContextMenuStrip;`+
+			`$m.Items.Add('暂停').add_Click({ try { Invoke-RestMethod "$url/pause" | Out-Null; $n.Text = 'NEO：已暂停' } catch {} });`+
+			`$m.Items.Add('继续').add_Click({ try { Invoke-RestMethod "$url/resume" | Out-Null; $n.Text = 'NEO：监视中' } catch {} });`+
+			`$m.Items.Add('退出').add_Click({ $n.Visible = $false; [System.Windows.Forms.Application]::Exit() });`+
+			`$n.ContextMenuStrip = $m;`+
+			`$n.Visible = $true;`+
+			`[System.Windows.Forms.Application]::Run();`+
+			`$n.Dispose()`, psQuote(url))
+		return exec.Command("powershell", "-NoProfile", "-WindowStyle", "Hidden", "-Command", script), nil
+	case "darwin":
+		return nil, ErrNoTray
+	}
+	// yad runs the commands of the menu, quitting it ends the helper
+	for _, tool := range []string{"yad", "curl"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNoTray, tool)
+		}
+	}
+	menu := fmt.Sprintf("暂停!curl -s -o /dev/null %[1]s/pause|继续!curl -s -o /dev/null %[1]s/resume|退出!quit", url)
+	return exec.Command("yad", "--notification", "--image=dialog-password", "--text=NEO", "--command=", "--menu="+menu), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTrayHelper(t *testing.T) {
+	url := "http://127.0.0.1:1"
+	c, err := trayHelper(url)
+	if errors.Is(err, ErrNoTray) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.Join(c.Args, " "); !strings.Contains(args, url+"/pause") || !strings.Contains(args, url+"/resume") {
+		t.Fatalf("helper does not reach the control endpoint: %s", args)
+	}
+}
+
+func TestRunTray_Control(t *testing.T) {
+	tray := findCommand("tray")
+	if err := tray.run(tray, []string{"-control=:8080", "."}); !errors.Is(err, errUsage) {
+		t.Fatalf("except a usage error, but %v", err)
+	}
+}
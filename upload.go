@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// uploadRate caps the bytes per second sent by all uploads to remote
+	// storage together, 0 for no limit.
+	uploadRate int
+	// uploadPartSize is the size of the parts large S3 uploads are split
+	// into, each part is retried on its own.
+	uploadPartSize = 64 << 20
+)
+
+var throttle struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// throttledReader paces reads to uploadRate.
+type throttledReader struct {
+	r io.Reader
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if uploadRate <= 0 {
+		return t.r.Read(p)
+	}
+	// small reads keep the pace even
+	if max := uploadRate/10 + 1; len(p) > max {
+		p = p[:max]
+	}
+	n, err := t.r.Read(p)
+	throttle.mu.Lock()
+	now := time.Now()
+	if throttle.next.Before(now.Add(-time.Second)) {
+		// idle time does not build up a burst
+		throttle.next = now
+	}
+	throttle.next = throttle.next.Add(time.Duration(n) * time.Second / time.Duration(uploadRate))
+	wait := throttle.next.Sub(now)
+	throttle.mu.Unlock()
+	time.Sleep(wait)
+	return n, err
+}
+
+// withRetry runs upload until it succeeds, trying again up to
+// remoteMaxRetries times with a growing pause. what names the upload in the
+// log.
+func withRetry(what string, upload func() error) error {
+	var err error
+	for i := 0; ; i++ {
+		if err = upload(); err == nil || i == remoteMaxRetries {
+			return err
+		}
+		log.Printf("上传：%s 失败，%d 秒后重试，错误：%v", what, i+1, err)
+		time.Sleep(time.Duration(i+1) * time.Second)
+	}
+}
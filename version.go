@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/hr3lxphr6j/neo/codec"
+)
+
+// set with -ldflags "-X main.Version=... -X main.GitCommit=... -X main.BuildDate=..."
+var (
+	Version   = ""
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+var (
+	supportedVersions = []uint8{codec.VersionV1}
+	encMethodNames    = map[uint8]string{
+		codec.XorEnc:     "xor",
+		codec.XorRecords: "xor-records",
+	}
+)
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+func runVersion(cmd *command, args []string) error {
+	if err := cmd.parse(cmd.flagSet(), args); err != nil {
+		return err
+	}
+	var versions, methods []string
+	for _, v := range supportedVersions {
+		versions = append(versions, fmt.Sprintf("v%d", v))
+	}
+	for id, name := range encMethodNames {
+		methods = append(methods, fmt.Sprintf("%s(%d)", name, id))
+	}
+	sort.Strings(methods)
+	fmt.Printf("neo %s\n", version())
+	fmt.Printf("commit:          %s\n", GitCommit)
+	fmt.Printf("build date:      %s\n", BuildDate)
+	fmt.Printf("go:              %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("header versions: %s\n", strings.Join(versions, ", "))
+	fmt.Printf("cipher methods:  %s\n", strings.Join(methods, ", "))
+	return nil
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// observation is when the watcher first saw a file in its current state.
+type observation struct {
+	state watchedFile
+	since time.Time
+}
+
+// watcher polls hot folders and processes files that appear in them, files
+// written by the watcher itself are remembered and never picked up again.
+type watcher struct {
+	dirs      []string
+	recursive bool
+	action    Action
+	interval  time.Duration
+	settle    time.Duration
+	notify    bool
+	sinks     []notifySink
+	json      *json.Encoder
+
+	// run serializes scans and scheduled tasks
+	run       sync.Mutex
+	mu        sync.Mutex
+	paused    bool
+	seen      map[string]watchedFile
+	changing  map[string]observation
+	state     watchState
+	stateFile string
+	// profile is set for the watches of the -config file
+	profile *watchProfile
+}
+
+func runWatch(cmd *command, args []string) error {
+	return runWatchOn(cmd, args, nil)
+}
+
+// runWatchOn runs neo watch with the control endpoint served on l, which
+// takes the place of -control when it is not nil.
+func runWatchOn(cmd *command, args []string, l net.Listener) error {
+	fs := cmd.flagSet()
+	recursive := fs.Bool("r", false, "递归监视子目录")
+	action := fs.String("action", "", "强制执行的操作：encode 或 decode，默认自动判断")
+	interval := fs.Duration("interval", 2*time.Second, "扫描间隔，为 0 时只按 -schedule 处理")
+	settle := fs.Duration("settle", 5*time.Second, "文件大小与修改时间需保持不变的时长，未到或文件正被使用时推迟处理")
+	notify := fs.Bool("notify", true, "处理完成后发送桌面通知")
+	control := fs.String("control", "", "控制接口监听地址，以 unix: 开头时监听该路径的 Unix 套接字，提供 /pause、/resume、/status、/queue、/metrics，使用 -config 时另有 /watches")
+	controlToken := fs.String("control-token", "", "通过 TCP 增删 /watches 时须在 Authorization: Bearer 中提供的令牌，未设置时只能经 Unix 套接字增删")
+	stateFile := fs.String("state", "", "将处理队列与最近结果保存至此文件，重启后继续处理未完成的文件")
+	schedule := fs.String("schedule", "", "定时任务的 cron 表达式，如 \"0 3 * * *\"")
+	tasks := fs.String("tasks", "verify,audit", "定时执行的任务，以逗号分隔：verify 校验、audit 完整性检查、process 处理新文件")
+	jsonOut := fs.Bool("json", false, "以 JSON 格式向标准输出打印处理结果与任务结果")
+	logFile := fs.String("log", "", "将日志追加写入文件")
+	fs.StringVar(&quarantineDir, "quarantine", "", "将校验或解析失败的 .neo 文件连同报告移至此目录")
+	fs.StringVar(&decodeConflict, "conflict", ConflictRename, "还原后的文件名已被占用时：rename 加序号另存，overwrite 覆盖，skip 跳过")
+	fs.DurationVar(&fileTimeout, "timeout", 0, "单个文件的处理时限，超时的文件将被跳过，0 为不限制")
+	config := fs.String("config", "", "另行监视此 JSON 文件中定义的目录，每项可有各自的输出位置、命名方式与密钥文件，可通过控制接口的 /watches 增删，文件改动后自动重新加载")
+	addHookFlags(fs, true)
+	var sinks stringList
+	fs.Var(&sinks, "notify-sink", "定时任务完成或发现文件损毁时发送通知，可多次指定：smtp(s)://、telegram://BOT_TOKEN/CHAT_ID、http(s)://")
+	if err := cmd.parse(fs, args); err != nil {
+		return err
+	}
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+	if fs.NArg() == 0 && *config == "" {
+		return cmd.usageError(fs, "no directory to watch")
+	}
+	var sched *cronSchedule
+	if *schedule != "" {
+		var err error
+		if sched, err = parseCron(*schedule); err != nil {
+			return cmd.usageError(fs, "%v", err)
+		}
+	}
+	if err := checkConflict(decodeConflict); err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	taskList := strings.Split(*tasks, ",")
+	for _, task := range taskList {
+		if watchTasks[task] == nil {
+			return cmd.usageError(fs, "unknown task: %s", task)
+		}
+	}
+	if *interval <= 0 && sched == nil {
+		return cmd.usageError(fs, "-interval 0 needs -schedule")
+	}
+
+	w := &watcher{
+		dirs:      fs.Args(),
+		recursive: *recursive,
+		action:    Action(*action),
+		interval:  *interval,
+		settle:    *settle,
+		notify:    *notify,
+		seen:      make(map[string]watchedFile),
+		stateFile: *stateFile,
+	}
+	var unfinished []string
+	if w.stateFile != "" {
+		st, err := loadWatchState(w.stateFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", w.stateFile, err)
+		}
+		w.state.Recent = st.Recent
+		unfinished = st.unfinished()
+	}
+	if *jsonOut {
+		w.json = json.NewEncoder(os.Stdout)
+	}
+	for _, s := range sinks {
+		sink, err := parseSink(s)
+		if err != nil {
+			return cmd.usageError(fs, "%v", err)
+		}
+		w.sinks = append(w.sinks, sink)
+	}
+	var set *watchSet
+	if *config != "" {
+		set = newWatchSet(w, *config)
+		if _, err := set.reload(); err != nil {
+			return fmt.Errorf("%s: %w", *config, err)
+		}
+		go set.watchFile(2 * time.Second)
+	}
+	if l == nil && *control != "" {
+		network, addr := "tcp", *control
+		if strings.HasPrefix(addr, "unix:") {
+			network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+		}
+		var err error
+		if l, err = net.Listen(network, addr); err != nil {
+			return err
+		}
+	}
+	if l != nil {
+		handler := w.controlHandler()
+		if set != nil {
+			set.token = *controlToken
+			set.unixSocket = l.Addr().Network() == "unix"
+			mux := http.NewServeMux()
+			mux.Handle("/", handler)
+			mux.HandleFunc("/watches", set.serveWatches)
+			mux.HandleFunc("/watches/", set.serveWatches)
+			handler = mux
+		}
+		go func() {
+			if l.Addr().Network() == "unix" {
+				log.Printf("控制接口已启动：%s", l.Addr())
+			} else {
+				log.Printf("控制接口已启动：http://%s/", l.Addr())
+			}
+			if err := http.Serve(l, handler); err != nil {
+				log.Printf("控制接口退出，错误：%v", err)
+			}
+		}()
+	}
+	w.scan(false)
+	if len(unfinished) > 0 {
+		log.Printf("继续处理上次未完成的 %d 个文件", len(unfinished))
+		w.run.Lock()
+		w.process(unfinished, &summary{json: w.json})
+		w.run.Unlock()
+	}
+	if len(w.dirs) > 0 {
+		log.Printf("开始监视：%s", strings.Join(w.dirs, ", "))
+	}
+	if sched != nil {
+		go w.schedule(sched, taskList)
+	}
+	if w.interval <= 0 {
+		select {}
+	}
+	for range time.Tick(w.interval) {
+		w.scan(true)
+	}
+	return nil
+}
+
+func (w *watcher) isPaused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paused
+}
+
+func (w *watcher) setPaused(paused bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = paused
+}
+
+// scan lists the watched directories, when process is false files are only
+// recorded, so what is already there at startup is left alone.
+func (w *watcher) scan(process bool) {
+	if process && w.isPaused() {
+		return
+	}
+	if w.profile != nil && w.profile.sync != "" {
+		if process {
+			w.run.Lock()
+			defer w.run.Unlock()
+			defer w.profile.apply()()
+			w.syncScan()
+		}
+		return
+	}
+	w.scanDirs(w.dirs, process)
+}
+
+// loop scans every w.interval until stop is closed, scans are skipped
+// while paused reports true.
+func (w *watcher) loop(stop <-chan struct{}, paused func() bool) {
+	w.scan(false)
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if !paused() {
+				w.scan(true)
+			}
+		}
+	}
+}
+
+func (w *watcher) scanDirs(dirs []string, process bool) *summary {
+	w.run.Lock()
+	defer w.run.Unlock()
+	sum := &summary{json: w.json}
+	var pending []string
+	for _, file := range collectFiles(dirs, w.recursive, sum) {
+		if filepath.Base(file) == auditDBName || inQuarantine(file) || w.isStateFile(file) {
+			continue
+		}
+		fInfo, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		state := watchedFile{size: fInfo.Size(), modTime: fInfo.ModTime()}
+		if prev, ok := w.seen[file]; ok && prev == state {
+			continue
+		}
+		if process && w.busy(file, state) {
+			// not recorded, the next scan looks again
+			continue
+		}
+		w.seen[file] = state
+		if process {
+			pending = append(pending, file)
+		}
+	}
+	w.forgetGone()
+	w.process(pending, sum)
+	return sum
+}
+
+// useProfile switches to the profile of w, if any, and keeps the watches of
+// a config file from switching away until the returned function is called.
+func (w *watcher) useProfile() func() {
+	if w.profile != nil {
+		return w.profile.apply()
+	}
+	profileMu.Lock()
+	return profileMu.Unlock
+}
+
+// process handles files in order, the caller holds w.run.
+func (w *watcher) process(files []string, sum *summary) {
+	if len(files) == 0 {
+		return
+	}
+	defer w.useProfile()()
+	atomic.AddInt64(&metrics.queue, int64(len(files)))
+	w.setQueue(files)
+	for _, file := range files {
+		w.startFile(file)
+		res, err := processFile(file, w.action)
+		atomic.AddInt64(&metrics.queue, -1)
+		sum.add(res, err)
+		if err == nil {
+			w.remember(res.Output)
+		}
+		w.finishFile(res, err)
+		if !errors.Is(err, ErrExcluded) {
+			w.notifyResult(res, err)
+		}
+	}
+}
+
+// busy reports whether file may still be being written: it is in use, or
+// it has not been seen with the same size and modification time for
+// w.settle. The modification time alone is not enough since copy tools
+// may set it before writing the content.
+func (w *watcher) busy(file string, state watchedFile) bool {
+	if w.settle <= 0 {
+		return fileInUse(file)
+	}
+	if w.changing == nil {
+		w.changing = make(map[string]observation)
+	}
+	obs, ok := w.changing[file]
+	if !ok || obs.state != state {
+		w.changing[file] = observation{state: state, since: time.Now()}
+		return true
+	}
+	if time.Since(obs.since) < w.settle || time.Since(state.modTime) < w.settle || fileInUse(file) {
+		return true
+	}
+	delete(w.changing, file)
+	return false
+}
+
+// forgetGone drops the observations of files that no longer exist.
+func (w *watcher) forgetGone() {
+	for file := range w.changing {
+		if _, err := os.Lstat(file); os.IsNotExist(err) {
+			delete(w.changing, file)
+		}
+	}
+}
+
+func (w *watcher) isStateFile(file string) bool {
+	return w.stateFile != "" && filepath.Clean(file) == filepath.Clean(w.stateFile)
+}
+
+func (w *watcher) remember(output string) {
+	if fInfo, err := os.Stat(output); err == nil {
+		w.seen[filepath.Clean(output)] = watchedFile{size: fInfo.Size(), modTime: fInfo.ModTime()}
+	}
+}
+
+// alert reaches the configured sinks, it does not wait for them.
+func (w *watcher) alert(title, msg string) {
+	for _, sink := range w.sinks {
+		go func(sink notifySink) {
+			if err := sink.send(title, msg); err != nil {
+				log.Printf("发送通知失败，错误：%v", err)
+			}
+		}(sink)
+	}
+}
+
+func (w *watcher) notifyResult(res Result, err error) {
+	if isChecksumError(err) {
+		w.alert("NEO 发现文件损毁", fmt.Sprintf("%s：%v", res.Input, err))
+	}
+	if !w.notify {
+		return
+	}
+	switch {
+	case err != nil:
+		desktopNotify("NEO 处理失败", fmt.Sprintf("%s：%v", res.Input, err))
+	case res.Action == ActionEncode:
+		desktopNotify("NEO 已编码", fmt.Sprintf("%s → %s", res.OriginalName, filepath.Base(res.Output)))
+	default:
+		desktopNotify("NEO 已还原", fmt.Sprintf("%s → %s", filepath.Base(res.Input), res.OriginalName))
+	}
+}
+
+func (w *watcher) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", func(rw http.ResponseWriter, r *http.Request) {
+		w.setPaused(true)
+		log.Printf("监视已暂停")
+		fmt.Fprintln(rw, "paused")
+	})
+	mux.HandleFunc("/resume", func(rw http.ResponseWriter, r *http.Request) {
+		w.setPaused(false)
+		log.Printf("监视已恢复")
+		fmt.Fprintln(rw, "running")
+	})
+	mux.Handle("/metrics", &metrics)
+	mux.HandleFunc("/queue", w.serveQueue)
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, r *http.Request) {
+		if w.isPaused() {
+			fmt.Fprintln(rw, "paused")
+		} else {
+			fmt.Fprintln(rw, "running")
+		}
+	})
+	return mux
+}
+
+// desktopNotify shows a notification with the tools every desktop ships,
+// failures are only logged.
+func desktopNotify(title, msg string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		script := fmt.Sprintf(`Add-Type -AssemblyName System.Windows.Forms;`+
+			`$n = New-Object System.Windows.Forms.NotifyIcon;`+
+			`$n.Icon = [System.Drawing.SystemIcons]::Information;`+
+			`$n.Visible = $true;`+
+			`$n.ShowBalloonTip(5000, %s, %s, 'Info');`+
+			`Start-Sleep -Seconds 6; $n.Dispose()`, psQuote(title), psQuote(msg))
+		cmd = exec.Command("powershell", "-NoProfile", "-WindowStyle", "Hidden", "-Command", script)
+	case "darwin":
+		cmd = exec.Command("osascript", "-e",
+			fmt.Sprintf("display notification %q with title %q", msg, title))
+	default:
+		cmd = exec.Command("notify-send", title, msg)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("发送通知失败，错误：%v", err)
+		return
+	}
+	go cmd.Wait()
+}
+
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
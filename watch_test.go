@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher_Settle(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	os.WriteFile(file, []byte("being copied"), 0644)
+	// copy tools may give the old modification time before the content
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(file, old, old)
+	w := &watcher{dirs: []string{dir}, action: ActionEncode, settle: 50 * time.Millisecond, seen: make(map[string]watchedFile)}
+	if sum := w.scanDirs(w.dirs, true); sum.encoded != 0 {
+		t.Fatalf("except a file seen the first time to wait, but %+v", sum)
+	}
+	time.Sleep(60 * time.Millisecond)
+	os.WriteFile(file, []byte("being copied, more content"), 0644)
+	os.Chtimes(file, old, old)
+	if sum := w.scanDirs(w.dirs, true); sum.encoded != 0 {
+		t.Fatalf("except a growing file to wait, but %+v", sum)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if sum := w.scanDirs(w.dirs, true); sum.encoded != 1 {
+		t.Fatalf("except 1 encoded, but %+v", sum)
+	}
+	if len(w.changing) != 0 {
+		t.Fatalf("except no observation left, but %v", w.changing)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchConfig is the -config file of watch, every entry is a watch with its
+// own settings, running next to those given on the command line.
+type watchConfig struct {
+	Watches []*watchEntry `json:"watches"`
+}
+
+// watchEntry is one watch of the config file. With Sync the single
+// directory of Dirs is mirrored into it as sync does, otherwise new files
+// are processed as watch does, into Out when it is set. Settings take the
+// names and values of .neorc settings.
+type watchEntry struct {
+	Name      string            `json:"name"`
+	Dirs      []string          `json:"dirs"`
+	Recursive bool              `json:"recursive,omitempty"`
+	Action    Action            `json:"action,omitempty"`
+	Sync      string            `json:"sync,omitempty"`
+	Out       string            `json:"out,omitempty"`
+	Keyfile   string            `json:"keyfile,omitempty"`
+	Stealth   bool              `json:"stealth,omitempty"`
+	Interval  string            `json:"interval,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+// ErrWatchConfig is returned for watches of the config file that cannot run.
+var ErrWatchConfig = errors.New("bad watch config")
+
+// watchProfile is what a watchEntry switches the globals to while its files
+// are processed.
+type watchProfile struct {
+	settings encodeSettings
+	out      Storage
+	key      []byte
+	stealth  bool
+	sync     string
+}
+
+// profileMu is held while a profile is applied, the watches of a config
+// file take turns processing files.
+var profileMu sync.Mutex
+
+func loadWatchConfig(path string) (*watchConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(watchConfig)
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, e := range cfg.Watches {
+		if names[e.Name] {
+			return nil, fmt.Errorf("%w: duplicate watch %q", ErrWatchConfig, e.Name)
+		}
+		names[e.Name] = true
+	}
+	return cfg, nil
+}
+
+func (cfg *watchConfig) save(path string) error {
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// profile checks the entry and opens what it needs, base are the settings
+// its own are applied on.
+func (e *watchEntry) profile(base encodeSettings) (*watchProfile, error) {
+	switch {
+	case e.Name == "" || strings.ContainsAny(e.Name, "/\\"):
+		return nil, fmt.Errorf("%w: bad name %q", ErrWatchConfig, e.Name)
+	case len(e.Dirs) == 0:
+		return nil, fmt.Errorf("%w: %s: no directory to watch", ErrWatchConfig, e.Name)
+	case e.Sync != "" && (len(e.Dirs) != 1 || e.Out != "" || e.Action != ""):
+		return nil, fmt.Errorf("%w: %s: sync needs one directory and excludes out and action", ErrWatchConfig, e.Name)
+	}
+	switch e.Action {
+	case "", ActionEncode, ActionDecode:
+	default:
+		return nil, fmt.Errorf("%w: %s: unknown action %q", ErrWatchConfig, e.Name, e.Action)
+	}
+	if e.Interval != "" {
+		if d, err := time.ParseDuration(e.Interval); err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: %s: bad interval %q", ErrWatchConfig, e.Name, e.Interval)
+		}
+	}
+	p := &watchProfile{settings: base, stealth: e.Stealth, sync: e.Sync}
+	setters := p.settings.setters()
+	for name, value := range e.Settings {
+		if setters.Lookup(name) == nil {
+			return nil, fmt.Errorf("%w: %s: unknown setting %q", ErrWatchConfig, e.Name, name)
+		}
+		if err := setters.Set(name, value); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s: %v", ErrWatchConfig, e.Name, name, err)
+		}
+	}
+	var err error
+	if p.key, err = loadKey("", e.Keyfile); err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Name, err)
+	}
+	if p.stealth && p.key == nil {
+		return nil, fmt.Errorf("%w: %s: stealth needs a keyfile", ErrWatchConfig, e.Name)
+	}
+	if e.Out != "" {
+		if p.out, err = NewStorage(e.Out); err != nil {
+			return nil, fmt.Errorf("%s: output %s: %w", e.Name, e.Out, err)
+		}
+	}
+	return p, nil
+}
+
+// apply switches the globals to the profile and returns a function that
+// switches them back.
+func (p *watchProfile) apply() func() {
+	profileMu.Lock()
+	prev := currentSettings()
+	prevOut, prevKey, prevStealth := outStorage, key, encodeStealth
+	p.settings.use()
+	outStorage, key, encodeStealth = p.out, p.key, p.stealth
+	return func() {
+		prev.use()
+		outStorage, key, encodeStealth = prevOut, prevKey, prevStealth
+		profileMu.Unlock()
+	}
+}
+
+// syncScan mirrors the directory of a sync watch, the caller holds w.run.
+func (w *watcher) syncScan() {
+	sum := &summary{json: w.json}
+	st, err := syncDirs(w.dirs[0], w.profile.sync, false, false, sum)
+	if err != nil {
+		log.Printf("同步：%s → %s 失败，错误：%v", w.dirs[0], w.profile.sync, err)
+		return
+	}
+	if sum.encoded+st.moved+sum.failed > 0 {
+		log.Printf("同步：%s → %s，编码 %d 个，移动 %d 个，失败 %d 个", w.dirs[0], w.profile.sync, sum.encoded, st.moved, sum.failed)
+	}
+}
+
+// watchSet runs the watches of the config file, base holds the settings
+// they share with the watch of the command line, pausing it pauses them
+// too.
+type watchSet struct {
+	base     *watcher
+	defaults encodeSettings
+	file     string
+	// token is required to change the watches through the control endpoint,
+	// unless it listens on a unix socket only its owner can reach
+	token      string
+	unixSocket bool
+
+	mu      sync.Mutex
+	entries map[string]*watchEntry
+	running map[string]*runningWatch
+}
+
+type runningWatch struct {
+	w    *watcher
+	stop chan struct{}
+}
+
+func newWatchSet(base *watcher, file string) *watchSet {
+	return &watchSet{
+		base:     base,
+		defaults: currentSettings(),
+		file:     file,
+		entries:  make(map[string]*watchEntry),
+		running:  make(map[string]*runningWatch),
+	}
+}
+
+// start starts watching e with its profile, the caller holds s.mu.
+func (s *watchSet) start(e *watchEntry, p *watchProfile) {
+	w := &watcher{
+		dirs:      e.Dirs,
+		recursive: e.Recursive,
+		action:    e.Action,
+		interval:  s.base.interval,
+		settle:    s.base.settle,
+		notify:    s.base.notify,
+		sinks:     s.base.sinks,
+		json:      s.base.json,
+		seen:      make(map[string]watchedFile),
+		profile:   p,
+	}
+	if e.Interval != "" {
+		w.interval, _ = time.ParseDuration(e.Interval)
+	}
+	if w.interval <= 0 {
+		w.interval = 2 * time.Second
+	}
+	rw := &runningWatch{w: w, stop: make(chan struct{})}
+	s.entries[e.Name] = e
+	s.running[e.Name] = rw
+	go w.loop(rw.stop, s.base.isPaused)
+	log.Printf("开始监视：%s（%s）", strings.Join(w.dirs, ", "), e.Name)
+}
+
+// stop stops the watch name and waits for the file in progress, the
+// caller holds s.mu.
+func (s *watchSet) stop(name string) bool {
+	rw := s.running[name]
+	if rw == nil {
+		return false
+	}
+	close(rw.stop)
+	rw.w.run.Lock()
+	rw.w.run.Unlock()
+	delete(s.running, name)
+	delete(s.entries, name)
+	log.Printf("停止监视：%s", name)
+	return true
+}
+
+// put starts e, or with replace restarts the watch of the same name with
+// it. The config file is rewritten unless persist is false.
+func (s *watchSet) put(e *watchEntry, replace, persist bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.entries[e.Name]
+	if old != nil && !replace {
+		return fmt.Errorf("%w: watch %q exists", ErrWatchConfig, e.Name)
+	}
+	if old == nil && replace {
+		return fmt.Errorf("%w: no watch %q", ErrWatchConfig, e.Name)
+	}
+	p, err := e.profile(s.defaults)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		s.stop(e.Name)
+	}
+	s.start(e, p)
+	if persist {
+		return s.save()
+	}
+	return nil
+}
+
+// reload applies the config file: new watches are started, changed ones
+// restarted and those no longer there stopped. A file with any watch that
+// cannot run changes nothing. It returns how many watches changed.
+func (s *watchSet) reload() (int, error) {
+	cfg, err := loadWatchConfig(s.file)
+	if err != nil {
+		return 0, err
+	}
+	profiles := make([]*watchProfile, len(cfg.Watches))
+	for i, e := range cfg.Watches {
+		if profiles[i], err = e.profile(s.defaults); err != nil {
+			return 0, err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := 0
+	names := make(map[string]bool)
+	for i, e := range cfg.Watches {
+		names[e.Name] = true
+		old := s.entries[e.Name]
+		if old != nil && reflect.DeepEqual(old, e) {
+			continue
+		}
+		if old != nil {
+			s.stop(e.Name)
+		}
+		s.start(e, profiles[i])
+		changed++
+	}
+	for name := range s.entries {
+		if !names[name] {
+			s.stop(name)
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// watchFile reloads the config file when it changes, a bad one is reported
+// and the watches keep running as they are.
+func (s *watchSet) watchFile(interval time.Duration) {
+	var last time.Time
+	if fInfo, err := os.Stat(s.file); err == nil {
+		last = fInfo.ModTime()
+	}
+	for range time.Tick(interval) {
+		fInfo, err := os.Stat(s.file)
+		if err != nil || fInfo.ModTime().Equal(last) {
+			continue
+		}
+		last = fInfo.ModTime()
+		n, err := s.reload()
+		if err != nil {
+			log.Printf("重新加载配置文件：%s 失败，继续使用之前的配置，错误：%v", s.file, err)
+			s.base.alert("NEO 配置文件有误", fmt.Sprintf("%s：%v", s.file, err))
+			continue
+		}
+		if n > 0 {
+			log.Printf("已重新加载配置文件：%s，%d 项监视有变化", s.file, n)
+		}
+	}
+}
+
+func (s *watchSet) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.stop(name) {
+		return fmt.Errorf("%w: no watch %q", ErrWatchConfig, name)
+	}
+	return s.save()
+}
+
+// list returns the watches sorted by name.
+func (s *watchSet) list() []*watchEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []*watchEntry
+	for _, e := range s.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// save writes the watches back to the config file, the caller holds s.mu.
+func (s *watchSet) save() error {
+	if s.file == "" {
+		return nil
+	}
+	cfg := new(watchConfig)
+	for _, e := range s.entries {
+		cfg.Watches = append(cfg.Watches, e)
+	}
+	sort.Slice(cfg.Watches, func(i, j int) bool { return cfg.Watches[i].Name < cfg.Watches[j].Name })
+	return cfg.save(s.file)
+}
+
+// serveWatches is the REST API of the watch set: GET /watches lists them,
+// POST /watches adds one, PUT /watches/NAME replaces one and DELETE
+// /watches/NAME removes one.
+// authorized reports whether r may change the watches.
+func (s *watchSet) authorized(r *http.Request) bool {
+	if s.unixSocket {
+		return true
+	}
+	if s.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) == 1
+}
+
+// checkRemote refuses what only the config file may set: outputs that are
+// not local directories and key files no watch uses yet.
+func (s *watchSet) checkRemote(e *watchEntry) error {
+	if strings.Contains(e.Out, "://") && !strings.HasPrefix(e.Out, "file://") {
+		return fmt.Errorf("%w: %s: remote output %s can only be set in the config file", ErrWatchConfig, e.Name, e.Out)
+	}
+	if e.Keyfile == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.entries {
+		if other.Keyfile == e.Keyfile {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s: key file %s is not used by the config file", ErrWatchConfig, e.Name, e.Keyfile)
+}
+
+func (s *watchSet) serveWatches(rw http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/watches"), "/")
+	var err error
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(s.list())
+		return
+	case !s.authorized(r):
+		http.Error(rw, "forbidden", http.StatusForbidden)
+		return
+	case r.Method == http.MethodPost && name == "", r.Method == http.MethodPut && name != "":
+		// browsers send no JSON across sites without asking first
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+			http.Error(rw, "content type must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		e := new(watchEntry)
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name != "" {
+			e.Name = name
+		}
+		if err = s.checkRemote(e); err == nil {
+			err = s.put(e, r.Method == http.MethodPut, true)
+		}
+	case r.Method == http.MethodDelete && name != "":
+		err = s.remove(name)
+	default:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case errors.Is(err, ErrWatchConfig):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+	default:
+		fmt.Fprintln(rw, "ok")
+	}
+}
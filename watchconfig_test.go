@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchSet(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	os.MkdirAll(in, 0755)
+	os.MkdirAll(out, 0755)
+	config := filepath.Join(dir, "watches.json")
+	set := newWatchSet(&watcher{}, config)
+
+	for _, e := range []*watchEntry{
+		{Name: "", Dirs: []string{in}},
+		{Name: "a", Dirs: []string{in}, Settings: map[string]string{"cipher": "x"}},
+		{Name: "a", Dirs: []string{in, out}, Sync: out},
+		{Name: "a", Dirs: []string{in}, Stealth: true},
+	} {
+		if err := set.put(e, false, true); !errors.Is(err, ErrWatchConfig) {
+			t.Errorf("put(%+v) = %v, want ErrWatchConfig", e, err)
+		}
+	}
+
+	e := &watchEntry{Name: "photos", Dirs: []string{in}, Action: ActionEncode, Out: out, Interval: "10ms",
+		Settings: map[string]string{"ext": ".dat"}}
+	if err := set.put(e, false, true); err != nil {
+		t.Fatal(err)
+	}
+	defer set.remove("photos")
+	if err := set.put(e, false, true); !errors.Is(err, ErrWatchConfig) {
+		t.Errorf("except a second watch of the same name to fail, but %v", err)
+	}
+	if cfg, err := loadWatchConfig(config); err != nil || len(cfg.Watches) != 1 || cfg.Watches[0].Name != "photos" {
+		t.Fatalf("unexpected config %+v %v", cfg, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	os.WriteFile(filepath.Join(in, "a.txt"), []byte("hello"), 0644)
+	var outputs []string
+	for i := 0; i < 100 && len(outputs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		outputs, _ = filepath.Glob(filepath.Join(out, "*.dat"))
+	}
+	if len(outputs) != 1 {
+		t.Fatalf("except 1 output in %s, but %v", out, outputs)
+	}
+
+	if err := set.remove("photos"); err != nil {
+		t.Fatal(err)
+	}
+	if outputExts[0] != ".neo" || outStorage != nil {
+		t.Errorf("settings not restored: %v %v", outputExts, outStorage)
+	}
+	if cfg, err := loadWatchConfig(config); err != nil || len(cfg.Watches) != 0 {
+		t.Fatalf("unexpected config %+v %v", cfg, err)
+	}
+}
+
+func TestWatchSet_Reload(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "watches.json")
+	set := newWatchSet(&watcher{}, config)
+	write := func(s string) {
+		if err := os.WriteFile(config, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"watches": [{"name": "a", "dirs": ["` + filepath.ToSlash(dir) + `"]}]}`)
+	if n, err := set.reload(); err != nil || n != 1 {
+		t.Fatalf("reload() = %d, %v, want 1 change", n, err)
+	}
+	if n, err := set.reload(); err != nil || n != 0 {
+		t.Fatalf("reload() = %d, %v, want no change", n, err)
+	}
+
+	write(`{"watches": [{"name": "b", "dirs": ["` + filepath.ToSlash(dir) + `"], "settings": {"scheme": "nope"}}]}`)
+	if _, err := set.reload(); !errors.Is(err, ErrWatchConfig) {
+		t.Fatalf("except ErrWatchConfig, but %v", err)
+	}
+	if list := set.list(); len(list) != 1 || list[0].Name != "a" {
+		t.Fatalf("except a bad config to keep watch a, but %+v", list)
+	}
+
+	write(`{"watches": [{"name": "b", "dirs": ["` + filepath.ToSlash(dir) + `"], "recursive": true}]}`)
+	if n, err := set.reload(); err != nil || n != 2 {
+		t.Fatalf("reload() = %d, %v, want 2 changes", n, err)
+	}
+	if list := set.list(); len(list) != 1 || list[0].Name != "b" || !list[0].Recursive {
+		t.Fatalf("unexpected watches %+v", list)
+	}
+	set.remove("b")
+}
+
+func TestWatchSet_Serve(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "watches.json")
+	keyfile := filepath.Join(dir, "key")
+	os.WriteFile(keyfile, []byte("secret"), 0600)
+	os.WriteFile(config, []byte(`{"watches": [{"name": "a", "dirs": ["`+filepath.ToSlash(dir)+`"], "keyfile": "`+filepath.ToSlash(keyfile)+`"}]}`), 0600)
+	set := newWatchSet(&watcher{}, config)
+	if _, err := set.reload(); err != nil {
+		t.Fatal(err)
+	}
+	defer set.remove("a")
+	srv := httptest.NewServer(http.HandlerFunc(set.serveWatches))
+	defer srv.Close()
+
+	post := func(auth, contentType, body string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/watches", strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	entry := func(extra string) string {
+		return `{"name": "b", "dirs": ["` + filepath.ToSlash(dir) + `"]` + extra + `}`
+	}
+	// without a token nothing can be changed over TCP
+	if code := post("", "application/json", entry("")); code != http.StatusForbidden {
+		t.Fatalf("except 403 without a token, but %d", code)
+	}
+	set.token = "token"
+	for _, c := range []struct {
+		auth, contentType, body string
+		want                    int
+	}{
+		{"wrong", "application/json", entry(""), http.StatusForbidden},
+		{"token", "text/plain", entry(""), http.StatusUnsupportedMediaType},
+		{"token", "application/x-www-form-urlencoded", entry(""), http.StatusUnsupportedMediaType},
+		{"token", "application/json", entry(`, "out": "s3://bucket/dir"`), http.StatusBadRequest},
+		{"token", "application/json", entry(`, "keyfile": "/etc/passwd"`), http.StatusBadRequest},
+		{"token", "application/json; charset=utf-8", entry(`, "keyfile": "` + filepath.ToSlash(keyfile) + `"`), http.StatusOK},
+	} {
+		if code := post(c.auth, c.contentType, c.body); code != c.want {
+			t.Errorf("%s %s %s: except %d, but %d", c.auth, c.contentType, c.body, c.want, code)
+		}
+	}
+	set.remove("b")
+
+	// the owner of a unix socket needs no token
+	set.token, set.unixSocket = "", true
+	if code := post("", "application/json", entry("")); code != http.StatusOK {
+		t.Fatalf("except 200 on a unix socket, but %d", code)
+	}
+	set.remove("b")
+}
+
+func TestVerifyTask_Profile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0644)
+	profileKey, _ := loadKey("profile password", "")
+	defer func(k []byte, s bool) { key, encodeStealth = k, s }(key, encodeStealth)
+	key, encodeStealth = profileKey, true
+	res, err := EncodeFile(LocalStorage(dir), "a.txt", LocalStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "a.txt"))
+	key, encodeStealth = nil, false
+
+	// the stealth file is only found with the key of the watch
+	w := &watcher{dirs: []string{dir}, profile: &watchProfile{settings: currentSettings(), key: profileKey, stealth: true}}
+	task := &taskResult{}
+	if err := w.verifyTask(dir, task); err != nil || task.Checked != 1 || task.Failed != 0 {
+		t.Fatalf("except %s checked, but %+v %v", res.Output, task, err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// recentResults is how many finished files watchState keeps.
+const recentResults = 50
+
+// watchState is what the watcher is doing, served under /queue and with
+// -state kept across restarts.
+type watchState struct {
+	Queue   []string      `json:"queue"`
+	Current *fileProgress `json:"current,omitempty"`
+	Recent  []Result      `json:"recent"`
+}
+
+// fileProgress is the file being processed. Done counts content bytes,
+// Total is the size of the input, which for decoding is a little more
+// than the content.
+type fileProgress struct {
+	File  string    `json:"file"`
+	Start time.Time `json:"start"`
+	Done  int64     `json:"done"`
+	Total int64     `json:"total"`
+
+	read int64
+}
+
+func loadWatchState(path string) (*watchState, error) {
+	st := new(watchState)
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// unfinished returns the files that were queued or in progress when the
+// state was saved and still exist.
+func (st *watchState) unfinished() []string {
+	files := st.Queue
+	if st.Current != nil {
+		files = append([]string{st.Current.File}, files...)
+	}
+	var left []string
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			left = append(left, file)
+		}
+	}
+	return left
+}
+
+// setQueue replaces the files waiting to be processed.
+func (w *watcher) setQueue(files []string) {
+	w.mu.Lock()
+	w.state.Queue = append([]string(nil), files...)
+	w.mu.Unlock()
+	w.saveState()
+}
+
+// startFile moves file from the queue to the current one.
+func (w *watcher) startFile(file string) {
+	p := &fileProgress{File: file, Start: time.Now(), read: atomic.LoadInt64(&metrics.read)}
+	if fInfo, err := os.Stat(file); err == nil {
+		p.Total = fInfo.Size()
+	}
+	w.mu.Lock()
+	if len(w.state.Queue) > 0 && w.state.Queue[0] == file {
+		w.state.Queue = w.state.Queue[1:]
+	}
+	w.state.Current = p
+	w.mu.Unlock()
+	w.saveState()
+}
+
+func (w *watcher) finishFile(res Result, err error) {
+	if err != nil {
+		res.Error = err.Error()
+	}
+	w.mu.Lock()
+	w.state.Current = nil
+	w.state.Recent = append(w.state.Recent, res)
+	if n := len(w.state.Recent); n > recentResults {
+		w.state.Recent = append([]Result(nil), w.state.Recent[n-recentResults:]...)
+	}
+	w.mu.Unlock()
+	w.saveState()
+}
+
+// snapshot copies the state with the progress of the current file filled in.
+func (w *watcher) snapshot() *watchState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := &watchState{
+		Queue:  append([]string(nil), w.state.Queue...),
+		Recent: append([]Result(nil), w.state.Recent...),
+	}
+	if cur := w.state.Current; cur != nil {
+		p := *cur
+		p.Done = atomic.LoadInt64(&metrics.read) - cur.read
+		st.Current = &p
+	}
+	return st
+}
+
+func (w *watcher) saveState() {
+	if w.stateFile == "" {
+		return
+	}
+	b, err := json.MarshalIndent(w.snapshot(), "", "  ")
+	if err == nil {
+		err = os.WriteFile(w.stateFile, b, 0644)
+	}
+	if err != nil {
+		log.Printf("保存状态文件：%s 失败，错误：%v", w.stateFile, err)
+	}
+}
+
+func (w *watcher) serveQueue(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(w.snapshot())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchState(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state.json")
+	var files []string
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file := filepath.Join(dir, name)
+		os.WriteFile(file, []byte("content of "+name), 0644)
+		files = append(files, file)
+	}
+	w := &watcher{dirs: []string{dir}, action: ActionEncode, seen: make(map[string]watchedFile), stateFile: stateFile}
+	w.process(files[:1], new(summary))
+
+	st, err := loadWatchState(stateFile)
+	if err != nil || len(st.Queue) != 0 || st.Current != nil || len(st.Recent) != 1 || st.Recent[0].Error != "" {
+		t.Fatalf("unexpected state %+v %v", st, err)
+	}
+
+	// a file still queued when the watcher stopped is picked up again
+	w.setQueue(files[1:])
+	st, _ = loadWatchState(stateFile)
+	if left := st.unfinished(); len(left) != 1 || left[0] != files[1] {
+		t.Fatalf("except %s unfinished, but %v", files[1], left)
+	}
+	w.startFile(files[1])
+	if cur := w.snapshot().Current; cur == nil || cur.File != files[1] || cur.Total != int64(len("content of b.txt")) {
+		t.Fatalf("unexpected progress %+v", cur)
+	}
+	st, _ = loadWatchState(stateFile)
+	if left := st.unfinished(); len(left) != 1 || left[0] != files[1] {
+		t.Fatalf("except %s in progress, but %v", files[1], left)
+	}
+}
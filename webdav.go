@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+type WebDAVStorage struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func NewWebDAVStorage(u *url.URL) *WebDAVStorage {
+	base := *u
+	if base.Scheme == "webdavs" {
+		base.Scheme = "https"
+	} else {
+		base.Scheme = "http"
+	}
+	return &WebDAVStorage{base: &base, client: http.DefaultClient}
+}
+
+func (s *WebDAVStorage) url(name string) string {
+	u := *s.base
+	u.User = nil
+	u.Path = path.Join("/", u.Path, name)
+	return u.String()
+}
+
+func (s *WebDAVStorage) newRequest(method, name string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, s.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.base.User != nil {
+		password, _ := s.base.User.Password()
+		req.SetBasicAuth(s.base.User.Username(), password)
+	}
+	return req, nil
+}
+
+func (s *WebDAVStorage) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *WebDAVStorage) Open(name string) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *WebDAVStorage) Create(name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	req, err := s.newRequest(http.MethodPut, name, pr)
+	if err != nil {
+		return nil, err
+	}
+	w := &webDAVWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := s.do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w, nil
+}
+
+type propfindResponse struct {
+	Responses []struct {
+		Href string `xml:"href"`
+		Prop struct {
+			ContentLength int64  `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+			ResourceType  struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"propstat>prop"`
+	} `xml:"response"`
+}
+
+func (s *WebDAVStorage) List(dir string) ([]Entry, error) {
+	req, err := s.newRequest("PROPFIND", dir, strings.NewReader(
+		`<?xml version="1.0"?><propfind xmlns="DAV:"><allprop/></propfind>`))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var ms propfindResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	self := strings.TrimSuffix(req.URL.Path, "/")
+	var entries []Entry
+	for _, r := range ms.Responses {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			href = r.Href
+		}
+		if u, err := url.Parse(href); err == nil && u.IsAbs() {
+			href = u.Path
+		}
+		href = strings.TrimSuffix(href, "/")
+		if href == self {
+			continue
+		}
+		modTime, _ := time.Parse(http.TimeFormat, r.Prop.LastModified)
+		entries = append(entries, Entry{
+			Name:    path.Base(href),
+			Size:    r.Prop.ContentLength,
+			ModTime: modTime,
+			IsDir:   r.Prop.ResourceType.Collection != nil,
+		})
+	}
+	return entries, nil
+}
+
+func (s *WebDAVStorage) Rename(oldname, newname string) error {
+	req, err := s.newRequest("MOVE", oldname, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", s.url(newname))
+	req.Header.Set("Overwrite", "T")
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *WebDAVStorage) Delete(name string) error {
+	req, err := s.newRequest(http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *WebDAVStorage) String() string {
+	return s.url("")
+}
+
+type webDAVWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *webDAVWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *webDAVWriter) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrZipEncrypted = errors.New("zip entry is encrypted, use -zip-password")
+	ErrZipPassword  = errors.New("wrong zip password")
+)
+
+// runZip converts packs to zip archives for people without NEO and zip
+// archives to packs.
+func runZip(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	password := fs.String("password", "", "密码，用于识别 -stealth 编码的文件")
+	keyfile := fs.String("keyfile", "", "密钥文件，可代替 -password")
+	zipPassword := fs.String("zip-password", "", "zip 文件的密码，导出时以传统 ZipCrypto 加密，只能防止随手打开，导入时用于解密")
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		if err := cmd.parse(fs, args); err != nil {
+			return err
+		}
+		return cmd.usageError(fs, "zip needs export or import")
+	}
+	sub := args[0]
+	if err := cmd.parse(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return cmd.usageError(fs, "zip %s needs an input and an output", sub)
+	}
+	var err error
+	if key, err = loadKey(*password, *keyfile); err != nil {
+		return err
+	}
+	var zipKey []byte
+	if *zipPassword != "" {
+		zipKey = []byte(*zipPassword)
+	}
+	if sub == "export" {
+		n, err := zipExport(fs.Arg(0), fs.Arg(1), zipKey)
+		if err != nil {
+			return err
+		}
+		log.Printf("导出：%d 个文件 → %s", n, fs.Arg(1))
+		return nil
+	}
+	dst, err := NewStorage(fs.Arg(1))
+	if err != nil {
+		return cmd.usageError(fs, "%v", err)
+	}
+	res, n, err := zipImport(fs.Arg(0), dst, zipKey)
+	if err != nil {
+		return err
+	}
+	log.Printf("导入：%d 个文件 → %s", n, res.Output)
+	return nil
+}
+
+// zipExport decodes the NEO file in and writes its files to the zip archive
+// out, a pack gives one entry per packed file, anything else one entry.
+func zipExport(in, out string, password []byte) (int, error) {
+	tmp, err := os.MkdirTemp("", "neo-zip")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmp)
+	res, err := DecodeFile(LocalStorage(filepath.Dir(in)), filepath.Base(in), LocalStorage(tmp))
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return 0, err
+	}
+	zw := zip.NewWriter(f)
+	n, err := writeZipEntries(zw, res.Output, password)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return 0, &OpError{Op: "write", Path: out, Err: err}
+	}
+	return n, nil
+}
+
+func writeZipEntries(zw *zip.Writer, decoded string, password []byte) (int, error) {
+	f, err := os.Open(decoded)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fInfo, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !isPack(f) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return 1, addZipEntry(zw, filepath.Base(decoded), fInfo.ModTime(), f, password)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n := 0
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if hdr.Name == packIndexName || (hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		if err := addZipEntry(zw, hdr.Name, hdr.ModTime, tr, password); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// isPack reports whether r is a tar archive with the index of a pack.
+func isPack(r io.Reader) bool {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return false
+		}
+		if hdr.Name == packIndexName {
+			return true
+		}
+	}
+}
+
+// addZipEntry deflates r into zw as name. With a password the entry is
+// encrypted with ZipCrypto, whose header needs the CRC-32 of the content
+// first, so the deflated content is spooled to a temporary file.
+func addZipEntry(zw *zip.Writer, name string, modTime time.Time, r io.Reader, password []byte) error {
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime}
+	if password == nil {
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+	spool, err := os.CreateTemp("", "neo-zip-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	fw, _ := flate.NewWriter(spool, flate.DefaultCompression)
+	crc := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(fw, crc), r)
+	if err == nil {
+		err = fw.Close()
+	}
+	if err != nil {
+		return err
+	}
+	compressed, err := spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	fh.Flags |= 0x1
+	// CreateRaw takes the MS-DOS time as it is and ignores Modified
+	fh.SetModTime(modTime)
+	fh.CRC32 = crc.Sum32()
+	fh.UncompressedSize64 = uint64(size)
+	fh.CompressedSize64 = uint64(compressed) + zipCryptoHeaderSize
+	w, err := zw.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	zc := newZipCrypto(password)
+	head := make([]byte, zipCryptoHeaderSize)
+	if _, err := rand.Read(head); err != nil {
+		return err
+	}
+	head[zipCryptoHeaderSize-1] = byte(fh.CRC32 >> 24)
+	zc.encrypt(head)
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, &zipEncryptReader{zc: zc, r: spool})
+	return err
+}
+
+// zipImport puts the files of the zip archive in into a pack encoded to
+// dst and returns its result and how many files it holds.
+func zipImport(in string, dst Storage, password []byte) (Result, int, error) {
+	res := Result{Action: ActionEncode, Input: in}
+	zr, err := zip.OpenReader(in)
+	if err != nil {
+		return res, 0, &OpError{Op: "open", Path: in, Err: err}
+	}
+	defer zr.Close()
+	tmp, err := os.MkdirTemp("", "neo-zip")
+	if err != nil {
+		return res, 0, err
+	}
+	defer os.RemoveAll(tmp)
+	name := strings.TrimSuffix(filepath.Base(in), filepath.Ext(in)) + ".tar"
+	archive := filepath.Join(tmp, name)
+	f, err := os.Create(archive)
+	if err != nil {
+		return res, 0, &OpError{Op: "open", Path: archive, Err: err}
+	}
+	cw := &countWriter{w: f}
+	tw := tar.NewWriter(cw)
+	var index []packEntry
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		e, err := addZipToPack(tw, cw, zf, password)
+		if err != nil {
+			f.Close()
+			return res, 0, &OpError{Op: "read", Path: in + ":" + zf.Name, Err: err}
+		}
+		index = append(index, e)
+	}
+	b, _ := json.Marshal(index)
+	err = tw.WriteHeader(&tar.Header{Name: packIndexName, Mode: 0644, Size: int64(len(b)), ModTime: time.Now()})
+	if err == nil {
+		_, err = tw.Write(b)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, 0, &OpError{Op: "write", Path: archive, Err: err}
+	}
+	res, err = EncodeFile(LocalStorage(tmp), name, dst)
+	res.Input = in
+	return res, len(index), err
+}
+
+func addZipToPack(tw *tar.Writer, cw *countWriter, zf *zip.File, password []byte) (packEntry, error) {
+	e := packEntry{Name: path.Clean("/" + strings.ReplaceAll(zf.Name, "\\", "/"))[1:], Size: int64(zf.UncompressedSize64)}
+	rc, err := openZipEntry(zf, password)
+	if err != nil {
+		return e, err
+	}
+	defer rc.Close()
+	mode := int64(zf.Mode().Perm())
+	if mode == 0 {
+		mode = 0644
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: e.Name, Mode: mode, Size: e.Size, ModTime: zf.Modified}); err != nil {
+		return e, err
+	}
+	e.Offset = cw.n
+	if _, err := io.CopyN(tw, rc, e.Size); err != nil {
+		return e, err
+	}
+	// the checksum is checked at the end of the entry
+	_, err = io.Copy(io.Discard, rc)
+	return e, err
+}
+
+// openZipEntry opens zf, decrypting ZipCrypto entries with password.
+func openZipEntry(zf *zip.File, password []byte) (io.ReadCloser, error) {
+	if zf.Flags&0x1 == 0 {
+		return zf.Open()
+	}
+	if password == nil {
+		return nil, ErrZipEncrypted
+	}
+	raw, err := zf.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	zc := newZipCrypto(password)
+	head := make([]byte, zipCryptoHeaderSize)
+	if _, err := io.ReadFull(raw, head); err != nil {
+		return nil, err
+	}
+	zc.decrypt(head)
+	// entries with a data descriptor check against the modification time
+	check := byte(zf.CRC32 >> 24)
+	if zf.Flags&0x8 != 0 {
+		check = byte(zf.ModifiedTime >> 8)
+	}
+	if head[zipCryptoHeaderSize-1] != check {
+		return nil, ErrZipPassword
+	}
+	var rd io.Reader = &zipDecryptReader{zc: zc, r: raw}
+	switch zf.Method {
+	case zip.Store:
+	case zip.Deflate:
+		rd = flate.NewReader(rd)
+	default:
+		return nil, zip.ErrAlgorithm
+	}
+	return &zipChecksumReader{r: rd, crc: crc32.NewIEEE(), want: zf.CRC32}, nil
+}
+
+// zipChecksumReader checks the CRC-32 of an entry at its end, as the reader
+// of archive/zip does for the entries it can open.
+type zipChecksumReader struct {
+	r    io.Reader
+	crc  hash.Hash32
+	want uint32
+}
+
+func (z *zipChecksumReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.crc.Write(p[:n])
+	if err == io.EOF && z.crc.Sum32() != z.want {
+		err = zip.ErrChecksum
+	}
+	return n, err
+}
+
+func (z *zipChecksumReader) Close() error {
+	if c, ok := z.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+const zipCryptoHeaderSize = 12
+
+// zipCrypto is the traditional PKWARE encryption of zip entries. It is weak
+// but every unzip tool reads it.
+type zipCrypto struct {
+	k0, k1, k2 uint32
+}
+
+func newZipCrypto(password []byte) *zipCrypto {
+	z := &zipCrypto{k0: 0x12345678, k1: 0x23456789, k2: 0x34567890}
+	for _, b := range password {
+		z.update(b)
+	}
+	return z
+}
+
+func (z *zipCrypto) update(b byte) {
+	z.k0 = crc32.IEEETable[byte(z.k0)^b] ^ z.k0>>8
+	z.k1 = (z.k1+z.k0&0xFF)*134775813 + 1
+	z.k2 = crc32.IEEETable[byte(z.k2)^byte(z.k1>>24)] ^ z.k2>>8
+}
+
+func (z *zipCrypto) stream() byte {
+	t := z.k2 | 2
+	return byte(t * (t ^ 1) >> 8)
+}
+
+func (z *zipCrypto) encrypt(p []byte) {
+	for i, b := range p {
+		p[i] = b ^ z.stream()
+		z.update(b)
+	}
+}
+
+func (z *zipCrypto) decrypt(p []byte) {
+	for i, b := range p {
+		p[i] = b ^ z.stream()
+		z.update(p[i])
+	}
+}
+
+// zipDecryptReader decrypts what it reads from r.
+type zipDecryptReader struct {
+	zc *zipCrypto
+	r  io.Reader
+}
+
+func (z *zipDecryptReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.zc.decrypt(p[:n])
+	return n, err
+}
+
+// zipEncryptReader encrypts what it reads from r.
+type zipEncryptReader struct {
+	zc *zipCrypto
+	r  io.Reader
+}
+
+func (z *zipEncryptReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.zc.encrypt(p[:n])
+	return n, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZipRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"a.txt": "hello", "sub/b.txt": "world"}
+	in := filepath.Join(dir, "in.zip")
+	f, _ := os.Create(in)
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	f.Close()
+
+	res, n, err := zipImport(in, LocalStorage(dir), nil)
+	if err != nil || n != 2 {
+		t.Fatalf("except 2 files imported, but %d %v", n, err)
+	}
+	out := filepath.Join(dir, "out.zip")
+	if n, err := zipExport(res.Output, out, []byte("secret")); err != nil || n != 2 {
+		t.Fatalf("except 2 files exported, but %d %v", n, err)
+	}
+
+	zr, err := zip.OpenReader(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 2 {
+		t.Fatalf("except 2 entries, but %d", len(zr.File))
+	}
+	for _, zf := range zr.File {
+		if _, err := openZipEntry(zf, nil); !errors.Is(err, ErrZipEncrypted) {
+			t.Errorf("%s: except ErrZipEncrypted, but %v", zf.Name, err)
+		}
+		// one in 256 wrong passwords passes the check byte, the checksum
+		// or inflating catches those
+		if rc, err := openZipEntry(zf, []byte("wrong")); err == nil {
+			_, err = io.ReadAll(rc)
+			rc.Close()
+			if err == nil {
+				t.Errorf("%s: except a wrong password to fail", zf.Name)
+			}
+		} else if !errors.Is(err, ErrZipPassword) {
+			t.Errorf("%s: except ErrZipPassword, but %v", zf.Name, err)
+		}
+		rc, err := openZipEntry(zf, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(b) != files[zf.Name] {
+			t.Errorf("%s: except %q, but %q %v", zf.Name, files[zf.Name], b, err)
+		}
+	}
+
+	// an encrypted archive imports back with its password
+	if _, n, err := zipImport(out, LocalStorage(dir), []byte("secret")); err != nil || n != 2 {
+		t.Fatalf("except 2 files imported, but %d %v", n, err)
+	}
+}